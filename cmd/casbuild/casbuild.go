@@ -0,0 +1,163 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"buf.build/go/app"
+	"buf.build/go/app/appcmd"
+	"buf.build/go/app/appext"
+	"github.com/bufbuild/buf/private/pkg/slogapp"
+	"github.com/bufbuild/modules/internal/bufcasbuild"
+	"github.com/bufbuild/modules/private/bufpkg/bufstate"
+	"github.com/spf13/pflag"
+)
+
+const (
+	rootSyncDirFlagName = "root-sync-dir"
+	allFlagName         = "all"
+	lintFlagName        = "lint"
+)
+
+func newCommand(name string) *appcmd.Command {
+	builder := appext.NewBuilder(
+		name,
+		appext.BuilderWithLoggerProvider(slogapp.LoggerProvider),
+	)
+	flags := newFlags()
+	return &appcmd.Command{
+		Use:   name + " <owner>/<repo> [<ref>...]",
+		Short: "Build synced module references from their CAS directory.",
+		Long: `Build synced module references from their CAS directory, without cloning their upstream repository.
+
+Each <ref> is either a reference name in the module state file, or a manifest digest. Without any
+<ref>, the latest reference in the global state file is built.
+
+Dependencies in the buf.yaml of a reference are resolved against the other managed modules, at their
+pinned reference if the dependency has one, otherwise at their latest reference in the global state
+file. Failed references are printed with their errors, and the command exits with 1 if any failed.`,
+		Args:      appcmd.MinimumNArgs(1),
+		BindFlags: flags.bind,
+		Run: builder.NewRunFunc(
+			func(ctx context.Context, container appext.Container) error {
+				return run(ctx, container, flags)
+			},
+		),
+	}
+}
+
+type flags struct {
+	rootSyncDir string
+	all         bool
+	lint        bool
+}
+
+func newFlags() *flags {
+	return &flags{}
+}
+
+func (f *flags) bind(flagSet *pflag.FlagSet) {
+	flagSet.StringVar(
+		&f.rootSyncDir,
+		rootSyncDirFlagName,
+		bufstate.SyncRoot,
+		`The root sync directory where all the managed modules live`,
+	)
+	flagSet.BoolVar(
+		&f.all,
+		allFlagName,
+		false,
+		`Build all the references in the module state file`,
+	)
+	flagSet.BoolVar(
+		&f.lint,
+		lintFlagName,
+		false,
+		`Also lint the references with the lint configuration of their buf.yaml`,
+	)
+}
+
+func run(
+	ctx context.Context,
+	container appext.Container,
+	flags *flags,
+) error {
+	moduleName := container.Arg(0)
+	if owner, repo, ok := strings.Cut(moduleName, "/"); !ok || owner == "" || repo == "" || strings.Contains(repo, "/") {
+		return appcmd.NewInvalidArgumentErrorf("invalid module name %q, expected <owner>/<repo>", moduleName)
+	}
+	refs := app.Args(container)[1:]
+	if flags.all && len(refs) > 0 {
+		return appcmd.NewInvalidArgumentErrorf("cannot set both --%s and refs", allFlagName)
+	}
+	builder, err := bufcasbuild.NewBuilder(container.Logger(), flags.rootSyncDir)
+	if err != nil {
+		return err
+	}
+	switch {
+	case flags.all:
+		refs, err = moduleReferences(filepath.Join(flags.rootSyncDir, moduleName))
+		if err != nil {
+			return err
+		}
+	case len(refs) == 0:
+		latestRef, ok := builder.LatestReference(moduleName)
+		if !ok {
+			return fmt.Errorf("module %s not found in the global state file", moduleName)
+		}
+		refs = []string{latestRef}
+	}
+	var failed int
+	for _, ref := range refs {
+		if flags.lint {
+			err = builder.Lint(ctx, moduleName, ref)
+		} else {
+			_, err = builder.Build(ctx, moduleName, ref)
+		}
+		if err != nil {
+			failed++
+			_, _ = fmt.Fprintf(container.Stdout(), "%s@%s: failed\n%v\n", moduleName, ref, err)
+			continue
+		}
+		_, _ = fmt.Fprintf(container.Stdout(), "%s@%s: ok\n", moduleName, ref)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d references failed", failed, len(refs))
+	}
+	return nil
+}
+
+// moduleReferences returns the names of all references in the module state file.
+func moduleReferences(moduleDirPath string) ([]string, error) {
+	moduleStateFile, err := os.Open(filepath.Join(moduleDirPath, bufstate.ModStateFileName))
+	if err != nil {
+		return nil, fmt.Errorf("open module state file: %w", err)
+	}
+	stateRW, err := bufstate.NewReadWriter()
+	if err != nil {
+		_ = moduleStateFile.Close()
+		return nil, fmt.Errorf("new state read writer: %w", err)
+	}
+	moduleState, err := stateRW.ReadModStateFile(moduleStateFile)
+	if err != nil {
+		return nil, fmt.Errorf("read module state file: %w", err)
+	}
+	return bufstate.NewModuleHistory(moduleState).Names(), nil
+}
@@ -16,15 +16,21 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 
+	"buf.build/go/app"
 	"buf.build/go/app/appcmd"
 	"buf.build/go/app/appext"
 	"buf.build/go/standard/xslices"
 	"github.com/bufbuild/buf/private/pkg/slogapp"
 	"github.com/bufbuild/modules/internal/bufcasdiff"
+	"github.com/bufbuild/modules/private/bufpkg/bufstate"
 	"github.com/spf13/pflag"
 )
 
@@ -32,20 +38,43 @@ import (
 type format int
 
 const (
-	formatFlagName      = "format"
-	formatFlagShortName = "f"
+	formatFlagName            = "format"
+	formatFlagShortName       = "f"
+	findRenamesFlagName       = "find-renames"
+	findRenamesFlagShortName  = "M"
+	includeFlagName           = "include"
+	excludeFlagName           = "exclude"
+	pathFlagName              = "path"
+	contextLinesFlagName      = "context-lines"
+	contextLinesFlagShortName = "U"
+	againstDirFlagName        = "against-dir"
+	maxFileSizeFlagName       = "max-file-size"
+	exitCodeFlagName          = "exit-code"
+	quietFlagName             = "quiet"
+	quietFlagShortName        = "q"
+	nameOnlyFlagName          = "name-only"
+	nameStatusFlagName        = "name-status"
+)
+
+const (
+	// exitCodeDiff is the exit code with --exit-code when the manifests differ.
+	exitCodeDiff = 1
+	// exitCodeError is the exit code with --exit-code on any error, so it is not mistaken for a diff.
+	exitCodeError = 2
 )
 
 const (
 	formatText format = iota + 1
 	formatMarkdown
+	formatMarkdownHTML
 )
 
 //nolint:gochecknoglobals // treated as consts
 var (
 	formatsValuesToNames = map[format]string{
-		formatText:     "text",
-		formatMarkdown: "markdown",
+		formatText:         "text",
+		formatMarkdown:     "markdown",
+		formatMarkdownHTML: "markdown-html",
 	}
 	formatsNamesToValues, _ = xslices.ToUniqueValuesMap(
 		xslices.MapKeysToSlice(formatsValuesToNames),
@@ -68,20 +97,58 @@ func newCommand(name string) *appcmd.Command {
 	)
 	flags := newFlags()
 	return &appcmd.Command{
-		Use:       name + " <from> <to>",
-		Short:     "Run a CAS diff.",
-		Args:      appcmd.ExactArgs(2),
+		Use:   name + " <from> <to>",
+		Short: "Run a CAS diff.",
+		Long: fmt.Sprintf(
+			`Run a CAS diff in between two references, or from a reference to a local directory with --%s.
+
+References are resolved against the module in the current directory. A reference in another
+module, e.g. to compare modules synced from the same upstream, is written as <owner>/<repo>@<ref>,
+resolved against the %s directory found in the current directory or any of its parents, and
+it is an error if it has no such module directory. Without a %s directory, the whole argument is
+a reference of the current module.
+
+With --%s, the single <from> reference is optional and defaults to the latest reference in the
+module state file.`,
+			againstDirFlagName,
+			bufstate.SyncRoot,
+			bufstate.SyncRoot,
+			againstDirFlagName,
+		),
+		Args:      appcmd.RangeArgs(0, 2),
 		BindFlags: flags.bind,
 		Run: builder.NewRunFunc(
 			func(ctx context.Context, container appext.Container) error {
-				return run(ctx, container, flags)
+				hasDiff, err := run(ctx, container, flags)
+				if !flags.exitCode && !flags.quiet {
+					return err
+				}
+				if err != nil {
+					return app.WrapError(exitCodeError, err)
+				}
+				if hasDiff {
+					// the diff, if any, is already printed
+					return app.NewError(exitCodeDiff, "")
+				}
+				return nil
 			},
 		),
 	}
 }
 
 type flags struct {
-	format string
+	format       string
+	findRenames  int
+	include      []string
+	exclude      []string
+	paths        []string
+	contextLines int
+	againstDir   string
+	maxFileSize  int
+	exitCode     bool
+	quiet        bool
+	nameOnly     bool
+	nameStatus   bool
 }
 
 func newFlags() *flags {
@@ -96,29 +163,209 @@ func (f *flags) bind(flagSet *pflag.FlagSet) {
 		formatText.String(),
 		fmt.Sprintf(`The out format to use. Must be one of %s`, allFormatsString),
 	)
+	flagSet.IntVarP(
+		&f.findRenames,
+		findRenamesFlagName,
+		findRenamesFlagShortName,
+		bufcasdiff.DefaultRenameThreshold,
+		`The minimum content similarity percentage, from 1 to 100, for a removed and an added file to be reported as renamed with changes. Use 100 to only report exact renames`,
+	)
+	flagSet.StringSliceVar(
+		&f.include,
+		includeFlagName,
+		nil,
+		`Only diff files matching this glob, where "**" matches any number of directories. Can be repeated`,
+	)
+	flagSet.StringSliceVar(
+		&f.exclude,
+		excludeFlagName,
+		nil,
+		`Do not diff files matching this glob, with the same syntax as --include. Can be repeated`,
+	)
+	flagSet.StringSliceVar(
+		&f.paths,
+		pathFlagName,
+		nil,
+		`Only diff files equal to or contained in this path. Can be repeated`,
+	)
+	flagSet.IntVarP(
+		&f.contextLines,
+		contextLinesFlagName,
+		contextLinesFlagShortName,
+		bufcasdiff.DefaultContextLines,
+		`The number of unchanged lines shown around each change in content diffs`,
+	)
+	flagSet.IntVar(
+		&f.maxFileSize,
+		maxFileSizeFlagName,
+		bufcasdiff.DefaultMaxFileSize,
+		`The maximum size in bytes of files diffed by content. Larger changed files, and binary files, are reported with their sizes and digests only`,
+	)
+	flagSet.BoolVar(
+		&f.exitCode,
+		exitCodeFlagName,
+		false,
+		fmt.Sprintf(`Exit with %d if the manifests differ and 0 if they do not. Errors exit with %d`, exitCodeDiff, exitCodeError),
+	)
+	flagSet.BoolVarP(
+		&f.quiet,
+		quietFlagName,
+		quietFlagShortName,
+		false,
+		fmt.Sprintf(`Do not print the diff. Implies --%s`, exitCodeFlagName),
+	)
+	flagSet.BoolVar(
+		&f.nameOnly,
+		nameOnlyFlagName,
+		false,
+		`Only print the paths of changed files, one per line. Renamed files are printed by their new path`,
+	)
+	flagSet.BoolVar(
+		&f.nameStatus,
+		nameStatusFlagName,
+		false,
+		`Only print the change kind and paths of changed files, one per line: D (removed), R<similarity> (renamed), A (added), or M (changed content)`,
+	)
+	flagSet.StringVar(
+		&f.againstDir,
+		againstDirFlagName,
+		"",
+		`Diff the <from> reference against the files in this local directory, as if they were synced as a new reference. No blobs are written`,
+	)
 }
 
+// run runs the diff and prints it, and returns true if the manifests differ.
 func run(
 	ctx context.Context,
 	container appext.Container,
 	flags *flags,
-) error {
+) (bool, error) {
 	f, ok := formatsNamesToValues[flags.format]
 	if !ok {
-		return fmt.Errorf("unsupported format %s", flags.format)
+		return false, fmt.Errorf("unsupported format %s", flags.format)
+	}
+	if flags.contextLines < 0 {
+		return false, fmt.Errorf("--%s must not be negative, got %d", contextLinesFlagName, flags.contextLines)
+	}
+	if flags.maxFileSize < 0 {
+		return false, fmt.Errorf("--%s must not be negative, got %d", maxFileSizeFlagName, flags.maxFileSize)
+	}
+	if flags.findRenames < 1 || flags.findRenames > 100 {
+		return false, fmt.Errorf("--%s must be between 1 and 100, got %d", findRenamesFlagName, flags.findRenames)
+	}
+	if xslices.Count([]bool{flags.quiet, flags.nameOnly, flags.nameStatus}, func(set bool) bool { return set }) > 1 {
+		return false, fmt.Errorf("at most one of --%s, --%s and --%s can be set", quietFlagName, nameOnlyFlagName, nameStatusFlagName)
+	}
+	diffOptions := []bufcasdiff.DiffOption{
+		bufcasdiff.DiffWithRenameThreshold(flags.findRenames),
+		bufcasdiff.DiffWithPathPrefixes(flags.paths...),
+		bufcasdiff.DiffWithIncludeGlobs(flags.include...),
+		bufcasdiff.DiffWithExcludeGlobs(flags.exclude...),
+		bufcasdiff.DiffWithContextLines(flags.contextLines),
+		bufcasdiff.DiffWithMaxFileSize(flags.maxFileSize),
+	}
+	workDirPath, err := os.Getwd()
+	if err != nil {
+		return false, fmt.Errorf("get working directory: %w", err)
+	}
+	var mdiff *bufcasdiff.ManifestDiff
+	if flags.againstDir != "" {
+		if container.NumArgs() > 1 {
+			return false, fmt.Errorf("at most one <from> argument is accepted with --%s", againstDirFlagName)
+		}
+		from := moduleRef{dirPath: "."}
+		if container.NumArgs() == 1 {
+			if from, err = parseModuleRef(container.Arg(0), workDirPath); err != nil {
+				return false, fmt.Errorf("from: %w", err)
+			}
+		}
+		mdiff, err = bufcasdiff.DiffModuleDirectoryAgainstDirectory(ctx, from.dirPath, from.ref, flags.againstDir, diffOptions...)
+	} else {
+		if container.NumArgs() != 2 {
+			return false, fmt.Errorf("<from> and <to> arguments are required, or --%s", againstDirFlagName)
+		}
+		var from, to moduleRef
+		if from, err = parseModuleRef(container.Arg(0), workDirPath); err != nil {
+			return false, fmt.Errorf("from: %w", err)
+		}
+		if to, err = parseModuleRef(container.Arg(1), workDirPath); err != nil {
+			return false, fmt.Errorf("to: %w", err)
+		}
+		if from.dirPath == to.dirPath {
+			mdiff, err = bufcasdiff.DiffModuleDirectory(ctx, from.dirPath, from.ref, to.ref, diffOptions...)
+		} else {
+			mdiff, err = bufcasdiff.DiffModuleDirectories(ctx, from.dirPath, from.ref, to.dirPath, to.ref, diffOptions...)
+		}
 	}
-	from, to := container.Arg(0), container.Arg(1)
-	mdiff, err := bufcasdiff.DiffModuleDirectory(ctx, ".", from, to)
 	if err != nil {
-		return fmt.Errorf("calculate diff: %w", err)
+		return false, fmt.Errorf("calculate diff: %w", err)
 	}
-	switch f {
-	case formatText:
-		fmt.Fprint(os.Stdout, mdiff.String(bufcasdiff.ManifestDiffOutputFormatText))
-	case formatMarkdown:
-		fmt.Fprint(os.Stdout, mdiff.String(bufcasdiff.ManifestDiffOutputFormatMarkdown))
+	switch {
+	case flags.quiet:
+	case flags.nameOnly:
+		fmt.Fprint(container.Stdout(), mdiff.NameOnly())
+	case flags.nameStatus:
+		fmt.Fprint(container.Stdout(), mdiff.NameStatus())
+	case f == formatText:
+		fmt.Fprint(container.Stdout(), mdiff.String(bufcasdiff.ManifestDiffOutputFormatText))
+	case f == formatMarkdown:
+		fmt.Fprint(container.Stdout(), mdiff.String(bufcasdiff.ManifestDiffOutputFormatMarkdown))
+	case f == formatMarkdownHTML:
+		fmt.Fprint(container.Stdout(), mdiff.String(bufcasdiff.ManifestDiffOutputFormatMarkdownHTML))
 	default:
-		return fmt.Errorf("format %s not supported", f.String())
+		return false, fmt.Errorf("format %s not supported", f.String())
+	}
+	return !mdiff.IsEmpty(), nil
+}
+
+// moduleRef is a reference in a module directory.
+type moduleRef struct {
+	dirPath string
+	ref     string
+}
+
+// parseModuleRef parses a <from> or <to> argument. An <owner>/<repo>@<ref> argument is resolved
+// against the module directory in the sync root found from workDirPath, and the module directory
+// must exist. Without a sync root, the whole argument is a reference of the module in the current
+// directory.
+func parseModuleRef(arg string, workDirPath string) (moduleRef, error) {
+	plainModuleRef := moduleRef{dirPath: ".", ref: arg}
+	moduleName, ref, ok := strings.Cut(arg, "@")
+	if !ok || ref == "" {
+		return plainModuleRef, nil
+	}
+	owner, repo, ok := strings.Cut(moduleName, "/")
+	if !ok || !fs.ValidPath(moduleName) || strings.Contains(repo, "/") {
+		return plainModuleRef, nil
+	}
+	syncRootPath, ok := findSyncRoot(workDirPath)
+	if !ok {
+		return plainModuleRef, nil
+	}
+	moduleDirPath := filepath.Join(syncRootPath, owner, repo)
+	info, err := os.Stat(moduleDirPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return moduleRef{}, fmt.Errorf("module %s not found in %s", moduleName, syncRootPath)
+		}
+		return moduleRef{}, fmt.Errorf("stat module %s: %w", moduleName, err)
+	}
+	if !info.IsDir() {
+		return moduleRef{}, fmt.Errorf("module %s in %s is not a directory", moduleName, syncRootPath)
+	}
+	return moduleRef{dirPath: moduleDirPath, ref: ref}, nil
+}
+
+// findSyncRoot returns the sync root directory in dirPath or its closest parent. Returns false if
+// there is none.
+func findSyncRoot(dirPath string) (string, bool) {
+	for ; ; dirPath = filepath.Dir(dirPath) {
+		syncRootPath := filepath.Join(dirPath, filepath.FromSlash(bufstate.SyncRoot))
+		if info, err := os.Stat(syncRootPath); err == nil && info.IsDir() {
+			return syncRootPath, true
+		}
+		if filepath.Dir(dirPath) == dirPath {
+			return "", false
+		}
 	}
-	return nil
 }
@@ -0,0 +1,245 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"buf.build/go/app"
+	"buf.build/go/app/appcmd"
+	"github.com/bufbuild/buf/private/pkg/cas"
+	"github.com/bufbuild/buf/private/pkg/storage/storagemem"
+	"github.com/bufbuild/modules/private/bufpkg/bufcas"
+	"github.com/bufbuild/modules/private/bufpkg/bufstate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseModuleRef(t *testing.T) {
+	t.Parallel()
+	rootDirPath := t.TempDir()
+	syncRootPath := filepath.Join(rootDirPath, filepath.FromSlash(bufstate.SyncRoot))
+	require.NoError(t, os.MkdirAll(filepath.Join(syncRootPath, "acme", "app"), 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(syncRootPath, "acme", "file"), nil, 0600))
+	noSyncRootDirPath := t.TempDir()
+	type testCase struct {
+		name        string
+		arg         string
+		workDirPath string
+		want        moduleRef
+		wantErr     string
+	}
+	testCases := []testCase{
+		{
+			name:        "plain_ref",
+			arg:         "v1.0.0",
+			workDirPath: rootDirPath,
+			want:        moduleRef{dirPath: ".", ref: "v1.0.0"},
+		},
+		{
+			name:        "module_ref",
+			arg:         "acme/app@v1.0.0",
+			workDirPath: rootDirPath,
+			want:        moduleRef{dirPath: filepath.Join(syncRootPath, "acme", "app"), ref: "v1.0.0"},
+		},
+		{
+			name:        "module_ref_from_sync_root",
+			arg:         "acme/app@v1.0.0",
+			workDirPath: filepath.Join(syncRootPath, "acme", "app"),
+			want:        moduleRef{dirPath: filepath.Join(syncRootPath, "acme", "app"), ref: "v1.0.0"},
+		},
+		{
+			name:        "missing_module",
+			arg:         "acme/missing@v1.0.0",
+			workDirPath: rootDirPath,
+			wantErr:     "module acme/missing not found in " + syncRootPath,
+		},
+		{
+			name:        "module_not_a_directory",
+			arg:         "acme/file@v1.0.0",
+			workDirPath: rootDirPath,
+			wantErr:     "module acme/file in " + syncRootPath + " is not a directory",
+		},
+		{
+			name:        "no_sync_root",
+			arg:         "acme/app@v1.0.0",
+			workDirPath: noSyncRootDirPath,
+			want:        moduleRef{dirPath: ".", ref: "acme/app@v1.0.0"},
+		},
+		{
+			name:        "empty_ref",
+			arg:         "acme/app@",
+			workDirPath: rootDirPath,
+			want:        moduleRef{dirPath: ".", ref: "acme/app@"},
+		},
+		{
+			name:        "not_a_module_name",
+			arg:         "acme/app/extra@v1.0.0",
+			workDirPath: rootDirPath,
+			want:        moduleRef{dirPath: ".", ref: "acme/app/extra@v1.0.0"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := parseModuleRef(tc.arg, tc.workDirPath)
+			if tc.wantErr != "" {
+				require.EqualError(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestFindSyncRoot(t *testing.T) {
+	t.Parallel()
+	rootDirPath := t.TempDir()
+	syncRootPath := filepath.Join(rootDirPath, filepath.FromSlash(bufstate.SyncRoot))
+	nestedDirPath := filepath.Join(rootDirPath, "a", "b")
+	require.NoError(t, os.MkdirAll(syncRootPath, 0700))
+	require.NoError(t, os.MkdirAll(nestedDirPath, 0700))
+	type testCase struct {
+		name    string
+		dirPath string
+		want    string
+		wantOK  bool
+	}
+	testCases := []testCase{
+		{
+			name:    "root",
+			dirPath: rootDirPath,
+			want:    syncRootPath,
+			wantOK:  true,
+		},
+		{
+			name:    "nested",
+			dirPath: nestedDirPath,
+			want:    syncRootPath,
+			wantOK:  true,
+		},
+		{
+			name:    "sync_root",
+			dirPath: syncRootPath,
+			want:    syncRootPath,
+			wantOK:  true,
+		},
+		{
+			name:    "none",
+			dirPath: t.TempDir(),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			got, ok := findSyncRoot(tc.dirPath)
+			assert.Equal(t, tc.wantOK, ok)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+// TestExitCode changes the working directory, so it does not run in parallel.
+func TestExitCode(t *testing.T) {
+	rootDirPath := t.TempDir()
+	writeModuleReference(t, rootDirPath, "v1.0.0", "syntax = \"proto3\";\npackage acme.app.v1;\n")
+	writeModuleReference(t, rootDirPath, "v1.1.0", "syntax = \"proto3\";\npackage acme.app.v1;\nmessage App {}\n")
+	t.Chdir(rootDirPath)
+	type testCase struct {
+		name         string
+		args         []string
+		wantExitCode int
+		wantOutput   bool
+	}
+	testCases := []testCase{
+		{
+			name:       "diff",
+			args:       []string{"acme/app@v1.0.0", "acme/app@v1.1.0"},
+			wantOutput: true,
+		},
+		{
+			name:         "diff_exit_code",
+			args:         []string{"--exit-code", "acme/app@v1.0.0", "acme/app@v1.1.0"},
+			wantExitCode: exitCodeDiff,
+			wantOutput:   true,
+		},
+		{
+			name:         "diff_quiet",
+			args:         []string{"--quiet", "acme/app@v1.0.0", "acme/app@v1.1.0"},
+			wantExitCode: exitCodeDiff,
+		},
+		{
+			name: "no_diff_quiet",
+			args: []string{"-q", "acme/app@v1.0.0", "acme/app@v1.0.0"},
+		},
+		{
+			name:         "error",
+			args:         []string{"acme/missing@v1.0.0", "acme/app@v1.1.0"},
+			wantExitCode: 1,
+		},
+		{
+			name:         "error_exit_code",
+			args:         []string{"--exit-code", "acme/missing@v1.0.0", "acme/app@v1.1.0"},
+			wantExitCode: exitCodeError,
+		},
+		{
+			name:         "error_quiet",
+			args:         []string{"--quiet", "acme/app@v1.0.0", "acme/app@unknown"},
+			wantExitCode: exitCodeError,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			container := app.NewContainer(nil, nil, &stdout, &stderr, append([]string{rootCmdName}, tc.args...)...)
+			err := appcmd.Run(t.Context(), container, newCommand(rootCmdName))
+			if tc.wantExitCode == 0 {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				assert.Equal(t, tc.wantExitCode, app.GetExitCode(err))
+			}
+			if tc.wantOutput {
+				assert.Contains(t, stdout.String(), "message App {}")
+			} else {
+				assert.Empty(t, stdout.String())
+			}
+		})
+	}
+}
+
+// writeModuleReference syncs a single a.proto file as a new reference of the acme/app module in the
+// sync root of rootDirPath.
+func writeModuleReference(t *testing.T, rootDirPath string, ref string, content string) {
+	t.Helper()
+	ctx := t.Context()
+	bucket, err := storagemem.NewReadBucket(map[string][]byte{"a.proto": []byte(content)})
+	require.NoError(t, err)
+	fileSet, err := cas.NewFileSetForBucket(ctx, bucket, cas.DigestTypeShake256)
+	require.NoError(t, err)
+	manifestBlob, err := cas.ManifestToBlob(fileSet.Manifest(), cas.DigestTypeShake256)
+	require.NoError(t, err)
+	rootSyncDirPath := filepath.Join(rootDirPath, filepath.FromSlash(bufstate.SyncRoot))
+	casDirPath := filepath.Join(rootSyncDirPath, "acme", "app", bufcas.CASDirName)
+	require.NoError(t, bufcas.WriteBlobs(ctx, casDirPath, bufcas.LayoutLoose, append([]cas.Blob{manifestBlob}, fileSet.BlobSet().Blobs()...)))
+	stateRW, err := bufstate.NewReadWriter()
+	require.NoError(t, err)
+	require.NoError(t, stateRW.AppendModuleReference(rootSyncDirPath, "acme", "app", ref, hex.EncodeToString(manifestBlob.Digest().Value())))
+}
@@ -0,0 +1,231 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"buf.build/go/app/appcmd"
+	"buf.build/go/app/appext"
+	"buf.build/go/standard/xslices"
+	"github.com/bufbuild/buf/private/pkg/cas"
+	"github.com/bufbuild/buf/private/pkg/slogapp"
+	"github.com/bufbuild/buf/private/pkg/storage"
+	"github.com/bufbuild/buf/private/pkg/storage/storagearchive"
+	"github.com/bufbuild/buf/private/pkg/storage/storagemem"
+	"github.com/bufbuild/buf/private/pkg/storage/storageos"
+	"github.com/bufbuild/modules/private/bufpkg/bufcas"
+	"github.com/bufbuild/modules/private/bufpkg/bufstate"
+	"github.com/spf13/pflag"
+)
+
+const (
+	rootSyncDirFlagName = "root-sync-dir"
+	outputFlagName      = "output"
+	outputFlagShortName = "o"
+	formatFlagName      = "format"
+	formatFlagShortName = "f"
+	// stdoutOutput is the output to write an archive to stdout.
+	stdoutOutput = "-"
+)
+
+// format is an export output format.
+type format string
+
+const (
+	formatDir   format = "dir"
+	formatTar   format = "tar"
+	formatTarGz format = "tar.gz"
+	formatZip   format = "zip"
+)
+
+//nolint:gochecknoglobals // treated as consts
+var allFormats = []format{formatDir, formatTar, formatTarGz, formatZip}
+
+func newCommand(name string) *appcmd.Command {
+	builder := appext.NewBuilder(
+		name,
+		appext.BuilderWithLoggerProvider(slogapp.LoggerProvider),
+	)
+	flags := newFlags()
+	return &appcmd.Command{
+		Use:   name + " <owner>/<repo> <ref>",
+		Short: "Export a synced module reference from its CAS directory.",
+		Long: `Export a synced module reference from its CAS directory to a directory, a tarball or a zip.
+
+The <ref> is either a reference name in the module state file, or a manifest digest. The digest of
+the manifest and of every file is verified against their content before anything is written.`,
+		Args:      appcmd.ExactArgs(2),
+		BindFlags: flags.bind,
+		Run: builder.NewRunFunc(
+			func(ctx context.Context, container appext.Container) error {
+				return run(ctx, container, flags)
+			},
+		),
+	}
+}
+
+type flags struct {
+	rootSyncDir string
+	output      string
+	format      string
+}
+
+func newFlags() *flags {
+	return &flags{}
+}
+
+func (f *flags) bind(flagSet *pflag.FlagSet) {
+	flagSet.StringVar(
+		&f.rootSyncDir,
+		rootSyncDirFlagName,
+		bufstate.SyncRoot,
+		`The root sync directory where all the managed modules live`,
+	)
+	flagSet.StringVarP(
+		&f.output,
+		outputFlagName,
+		outputFlagShortName,
+		"",
+		fmt.Sprintf(`The output directory or archive path, or %q to write an archive to stdout. Required`, stdoutOutput),
+	)
+	flagSet.StringVarP(
+		&f.format,
+		formatFlagName,
+		formatFlagShortName,
+		"",
+		fmt.Sprintf(
+			`The output format, one of %s. Defaults to the archive format of the output extension, or to %s, or to %s for stdout`,
+			xslices.Map(allFormats, func(f format) string { return string(f) }),
+			formatDir,
+			formatTar,
+		),
+	)
+}
+
+func run(
+	ctx context.Context,
+	container appext.Container,
+	flags *flags,
+) error {
+	if flags.output == "" {
+		return appcmd.NewInvalidArgumentErrorf("--%s is required", outputFlagName)
+	}
+	f, err := outputFormat(flags.output, flags.format)
+	if err != nil {
+		return err
+	}
+	owner, repo, ok := strings.Cut(container.Arg(0), "/")
+	if !ok || owner == "" || repo == "" || strings.Contains(repo, "/") {
+		return appcmd.NewInvalidArgumentErrorf("invalid module name %q, expected <owner>/<repo>", container.Arg(0))
+	}
+	fileSet, err := bufcas.ReadModuleReference(ctx, filepath.Join(flags.rootSyncDir, owner, repo), container.Arg(1))
+	if err != nil {
+		return fmt.Errorf("read %s reference %s: %w", container.Arg(0), container.Arg(1), err)
+	}
+	// All files are verified in memory before writing any output.
+	filesBucket := storagemem.NewReadWriteBucket()
+	if err := cas.PutFileSetToBucket(ctx, fileSet, filesBucket); err != nil {
+		return fmt.Errorf("put files: %w", err)
+	}
+	if f == formatDir {
+		return exportDir(ctx, filesBucket, flags.output)
+	}
+	if flags.output == stdoutOutput {
+		return exportArchive(ctx, filesBucket, container.Stdout(), f)
+	}
+	file, err := os.Create(flags.output)
+	if err != nil {
+		return fmt.Errorf("create output: %w", err)
+	}
+	if err := exportArchive(ctx, filesBucket, file, f); err != nil {
+		return errors.Join(err, file.Close())
+	}
+	return file.Close()
+}
+
+// outputFormat returns the format set in the flag, or the format for the output path.
+func outputFormat(output string, formatFlag string) (format, error) {
+	if formatFlag != "" {
+		for _, f := range allFormats {
+			if string(f) == formatFlag {
+				if f == formatDir && output == stdoutOutput {
+					return "", appcmd.NewInvalidArgumentErrorf("cannot export format %s to stdout", formatDir)
+				}
+				return f, nil
+			}
+		}
+		return "", appcmd.NewInvalidArgumentErrorf("unsupported format %s", formatFlag)
+	}
+	switch {
+	case output == stdoutOutput:
+		return formatTar, nil
+	case strings.HasSuffix(output, ".tar.gz"), strings.HasSuffix(output, ".tgz"):
+		return formatTarGz, nil
+	case strings.HasSuffix(output, ".tar"):
+		return formatTar, nil
+	case strings.HasSuffix(output, ".zip"):
+		return formatZip, nil
+	default:
+		return formatDir, nil
+	}
+}
+
+// exportDir writes all files to the directory, which must not exist or be empty.
+func exportDir(ctx context.Context, filesBucket storage.ReadBucket, dirPath string) error {
+	entries, err := os.ReadDir(dirPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("read output dir: %w", err)
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("output dir %s is not empty", dirPath)
+	}
+	if err := os.MkdirAll(dirPath, 0755); err != nil {
+		return fmt.Errorf("make output dir: %w", err)
+	}
+	dirBucket, err := storageos.NewProvider().NewReadWriteBucket(dirPath)
+	if err != nil {
+		return fmt.Errorf("new output dir bucket: %w", err)
+	}
+	if _, err := storage.Copy(ctx, filesBucket, dirBucket); err != nil {
+		return fmt.Errorf("copy files: %w", err)
+	}
+	return nil
+}
+
+// exportArchive writes all files to the writer as an archive in the given format.
+func exportArchive(ctx context.Context, filesBucket storage.ReadBucket, writer io.Writer, f format) (retErr error) {
+	switch f {
+	case formatTar:
+		return storagearchive.Tar(ctx, filesBucket, writer)
+	case formatTarGz:
+		gzipWriter := gzip.NewWriter(writer)
+		defer func() {
+			retErr = errors.Join(retErr, gzipWriter.Close())
+		}()
+		return storagearchive.Tar(ctx, filesBucket, gzipWriter)
+	case formatZip:
+		return storagearchive.Zip(ctx, filesBucket, writer, true)
+	default:
+		return fmt.Errorf("format %s is not an archive", f)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bufbuild/modules/private/bufpkg/bufcas"
+	"go.uber.org/multierr"
+)
+
+const (
+	rootSyncDirFlagName = "root-sync-dir"
+	layoutFlagName      = "layout"
+	ownerFlagName       = "owner"
+	repoFlagName        = "repo"
+)
+
+type command struct {
+	rootSyncDir string
+	layout      bufcas.Layout
+	owner       string
+	repo        string
+}
+
+func newCmd(
+	rootSyncDir string,
+	layout string,
+	owner string,
+	repo string,
+) (*command, error) {
+	var err error
+	if len(rootSyncDir) == 0 {
+		err = multierr.Append(err, fmt.Errorf("%s is required", rootSyncDirFlagName))
+	}
+	casLayout, parseErr := bufcas.ParseLayout(layout)
+	if parseErr != nil {
+		err = multierr.Append(err, fmt.Errorf("%s: %w", layoutFlagName, parseErr))
+	}
+	if len(repo) > 0 && len(owner) == 0 {
+		err = multierr.Append(err, fmt.Errorf("%s is required with %s", ownerFlagName, repoFlagName))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &command{
+		rootSyncDir: rootSyncDir,
+		layout:      casLayout,
+		owner:       owner,
+		repo:        repo,
+	}, nil
+}
+
+func main() {
+	var (
+		rootSyncDir = flag.String(rootSyncDirFlagName, "", "Root sync directory where all the managed modules live.")
+		layout      = flag.String(layoutFlagName, "", "CAS layout to migrate to, loose or packed.")
+		owner       = flag.String(ownerFlagName, "", "Only migrate the modules of this owner.")
+		repo        = flag.String(repoFlagName, "", "Only migrate the module of this repository, requires the owner.")
+	)
+	flag.Parse()
+	cmd, err := newCmd(
+		*rootSyncDir,
+		*layout,
+		*owner,
+		*repo,
+	)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "cannot run cas migrate: %v\n\nusage: casmigrate [flags]\n\n", err)
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	if err := cmd.run(); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "casmigrate failed: %v\n", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func (c *command) run() error {
+	ctx := context.Background()
+	owner, repo := c.owner, c.repo
+	if len(owner) == 0 {
+		owner = "*"
+	}
+	if len(repo) == 0 {
+		repo = "*"
+	}
+	casDirs, err := filepath.Glob(filepath.Join(c.rootSyncDir, owner, repo, bufcas.CASDirName))
+	if err != nil {
+		return fmt.Errorf("find module cas dirs: %w", err)
+	}
+	if len(casDirs) == 0 {
+		return fmt.Errorf("no module cas dirs found in %s", c.rootSyncDir)
+	}
+	for _, casDir := range casDirs {
+		if err := bufcas.Migrate(ctx, casDir, c.layout); err != nil {
+			return fmt.Errorf("migrate %s: %w", casDir, err)
+		}
+		_, _ = fmt.Fprintf(os.Stdout, "migrated %s to %s layout\n", casDir, c.layout)
+	}
+	return nil
+}
@@ -15,40 +15,55 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"fmt"
-	"os"
+	"io"
 	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/bufbuild/modules/internal/bufcasdiff"
+	"github.com/bufbuild/modules/private/bufpkg/bufstate"
 )
 
 // casDiffResult contains the result of running casdiff for a transition.
 type casDiffResult struct {
 	transition stateTransition
-	output     string // Markdown output from casdiff
+	mdiff      *bufcasdiff.ManifestDiff   // Set for digest transitions.
+	report     *bufcasdiff.ManifestReport // Set for new module transitions.
+	output     string                     // Markdown output from casdiff
 	err        error
 }
 
 // runCASDiff computes the CAS diff for a transition and returns its markdown output.
-func runCASDiff(ctx context.Context, transition stateTransition) casDiffResult {
+func runCASDiff(ctx context.Context, moduleReader *bufcasdiff.ModuleReader, transition stateTransition) casDiffResult {
 	result := casDiffResult{transition: transition}
 
-	repoRoot, err := os.Getwd()
-	if err != nil {
-		result.err = fmt.Errorf("get working directory: %w", err)
+	if transition.isNewModule {
+		report, err := moduleReader.Describe(ctx, transition.toRef)
+		if err != nil {
+			result.err = fmt.Errorf("calculate manifest report: %w", err)
+			return result
+		}
+		result.report = report
+		result.output = fmt.Sprintf(
+			"### New module\n\nInitial reference `%s`:\n\n%s",
+			transition.toRef,
+			report.String(bufcasdiff.ManifestDiffOutputFormatMarkdown),
+		)
 		return result
 	}
-	moduleDirPath := filepath.Join(repoRoot, transition.modulePath)
-	mdiff, err := bufcasdiff.DiffModuleDirectory(ctx, moduleDirPath, transition.fromRef, transition.toRef)
+	mdiff, err := moduleReader.Diff(ctx, transition.fromRef, transition.toRef)
 	if err != nil {
 		result.err = fmt.Errorf("calculate casdiff: %w", err)
 		return result
 	}
+	result.mdiff = mdiff
 
-	cmd := fmt.Sprintf("```sh\n$ casdiff %s \\\n          %s \\\n          --format=markdown\n```", transition.fromRef, transition.toRef)
-	diffOutput := mdiff.String(bufcasdiff.ManifestDiffOutputFormatMarkdown)
+	cmd := "```sh\n" + casDiffCommand(transition) + "\n```"
+	diffOutput := mdiff.String(bufcasdiff.ManifestDiffOutputFormatMarkdownHTML)
 	if transition.isOverallTransition {
 		result.output = "### Overall transition\n\n" + cmd + "\n\n" + diffOutput
 	} else {
@@ -62,17 +77,152 @@ func runCASDiff(ctx context.Context, transition stateTransition) casDiffResult {
 	return result
 }
 
-// runCASDiffs runs multiple casdiff commands concurrently.
-func runCASDiffs(ctx context.Context, transitions []stateTransition) []casDiffResult {
-	results := make([]casDiffResult, len(transitions))
-	var wg sync.WaitGroup
+// casDiffCommand returns the equivalent casdiff shell command for a transition, as shown in
+// comments and reports.
+func casDiffCommand(transition stateTransition) string {
+	return fmt.Sprintf("$ casdiff %s \\\n          %s \\\n          --format=markdown-html", transition.fromRef, transition.toRef)
+}
+
+// casDiffOptions configures how runCASDiffs schedules transitions.
+type casDiffOptions struct {
+	// concurrency is the maximum number of transitions computed at the same time. Values lower than 1
+	// are treated as 1.
+	concurrency int
+	// failFast cancels all pending and running transitions as soon as one of them fails.
+	failFast bool
+	// transitionTimeout is the maximum duration of a single transition, zero means no timeout.
+	transitionTimeout time.Duration
+	// progress receives one line per finished transition, can be nil.
+	progress io.Writer
+}
+
+// runCASDiffs runs casdiff for multiple transitions concurrently, with at most
+// options.concurrency transitions at a time. Transitions of the same module share a single module
+// reader, so manifests are read from disk only once, and recently read blobs are kept in a
+// size-bounded cache shared by all modules. Results are returned in the same order as transitions.
+func runCASDiffs(ctx context.Context, rootDirPath string, transitions []stateTransition, options casDiffOptions) []casDiffResult {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
 
+	var (
+		results       = make([]casDiffResult, len(transitions))
+		moduleReaders = newModuleReaders(rootDirPath)
+		semaphore     = make(chan struct{}, max(options.concurrency, 1))
+		progressLock  sync.Mutex
+		finished      int
+		wg            sync.WaitGroup
+	)
 	for i, transition := range transitions {
 		wg.Go(func() {
-			results[i] = runCASDiff(ctx, transition)
+			select {
+			case semaphore <- struct{}{}:
+				defer func() { <-semaphore }()
+			case <-ctx.Done():
+				results[i] = casDiffResult{transition: transition, err: context.Cause(ctx)}
+				return
+			}
+			result := runCASDiffWithTimeout(ctx, moduleReaders, transition, options.transitionTimeout)
+			if result.err != nil && options.failFast {
+				cancel(fmt.Errorf("canceled after %s %s->%s failed", transition.modulePath, transition.fromRef, transition.toRef))
+			}
+			results[i] = result
+			if options.progress == nil {
+				return
+			}
+			progressLock.Lock()
+			defer progressLock.Unlock()
+			finished++
+			status := "done"
+			if result.err != nil {
+				status = "failed"
+			}
+			fmt.Fprintf(
+				options.progress,
+				"[%d/%d] %s %s -> %s: %s\n",
+				finished,
+				len(transitions),
+				strings.TrimPrefix(transition.modulePath, bufstate.SyncRoot+"/"),
+				cmp.Or(transition.fromRef, "(new)"),
+				transition.toRef,
+				status,
+			)
 		})
 	}
-
 	wg.Wait()
 	return results
 }
+
+func runCASDiffWithTimeout(
+	ctx context.Context,
+	moduleReaders *moduleReaders,
+	transition stateTransition,
+	timeout time.Duration,
+) casDiffResult {
+	if err := ctx.Err(); err != nil {
+		return casDiffResult{transition: transition, err: context.Cause(ctx)}
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, timeout, fmt.Errorf("transition timed out after %s", timeout))
+		defer cancel()
+	}
+	moduleReader, err := moduleReaders.get(ctx, transition.modulePath)
+	if err != nil {
+		return casDiffResult{transition: transition, err: err}
+	}
+	result := runCASDiff(ctx, moduleReader, transition)
+	if result.err != nil && ctx.Err() != nil {
+		// Surface the reason of the cancellation, instead of the plain context error.
+		result.err = fmt.Errorf("%w: %w", context.Cause(ctx), result.err)
+	}
+	return result
+}
+
+// moduleReaders lazily creates and caches one module reader per module directory. All module
+// readers share a single blob cache, so the memory of the blobs they keep is bounded all together.
+type moduleReaders struct {
+	rootDirPath string
+	blobCache   *bufcasdiff.BlobCache
+
+	lock               sync.Mutex
+	modulePathToReader map[string]*lazyModuleReader
+}
+
+// lazyModuleReader is a module reader that is created once, on first use.
+type lazyModuleReader struct {
+	once         sync.Once
+	moduleReader *bufcasdiff.ModuleReader
+	err          error
+}
+
+func newModuleReaders(rootDirPath string) *moduleReaders {
+	return &moduleReaders{
+		rootDirPath:        rootDirPath,
+		blobCache:          bufcasdiff.NewBlobCache(bufcasdiff.DefaultBlobCacheSize),
+		modulePathToReader: make(map[string]*lazyModuleReader),
+	}
+}
+
+func (m *moduleReaders) get(ctx context.Context, modulePath string) (*bufcasdiff.ModuleReader, error) {
+	m.lock.Lock()
+	lazyReader, ok := m.modulePathToReader[modulePath]
+	if !ok {
+		lazyReader = &lazyModuleReader{}
+		m.modulePathToReader[modulePath] = lazyReader
+	}
+	m.lock.Unlock()
+	// Readers of different modules are created concurrently, outside of the lock. The reader is
+	// shared by all transitions of the module, so it is not created with the cancellation of the
+	// transition that happens to create it.
+	lazyReader.once.Do(func() {
+		lazyReader.moduleReader, lazyReader.err = bufcasdiff.NewModuleReader(
+			context.WithoutCancel(ctx),
+			filepath.Join(m.rootDirPath, modulePath),
+			bufcasdiff.ModuleReaderWithBlobCache(m.blobCache),
+		)
+	})
+	if lazyReader.err != nil {
+		return nil, fmt.Errorf("new module reader: %w", lazyReader.err)
+	}
+	return lazyReader.moduleReader, nil
+}
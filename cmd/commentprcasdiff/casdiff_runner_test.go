@@ -0,0 +1,176 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/buf/private/pkg/cas"
+	"github.com/bufbuild/buf/private/pkg/storage"
+	"github.com/bufbuild/buf/private/pkg/storage/storagemem"
+	"github.com/bufbuild/modules/internal/bufcasdiff"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunCASDiffs(t *testing.T) {
+	t.Parallel()
+	rootDirPath := newTestModulesDir(t)
+	transitions := []stateTransition{
+		{modulePath: "modules/sync/foo/bar", fromRef: "v1.0.0", toRef: "v1.1.0"},
+		{modulePath: "modules/sync/foo/bar", fromRef: "v1.1.0", toRef: "v1.2.0"},
+		{modulePath: "modules/sync/foo/bar", toRef: "v1.0.0", isNewModule: true},
+		{modulePath: "modules/sync/foo/missing", fromRef: "v1.0.0", toRef: "v1.1.0"},
+	}
+	t.Run("all", func(t *testing.T) {
+		t.Parallel()
+		var progress bytes.Buffer
+		results := runCASDiffs(t.Context(), rootDirPath, transitions, casDiffOptions{
+			concurrency: 2,
+			progress:    &progress,
+		})
+		require.Len(t, results, len(transitions))
+		for i, result := range results {
+			assert.Equal(t, transitions[i], result.transition)
+		}
+		require.NoError(t, results[0].err)
+		assert.Contains(t, results[0].output, "1 changed content")
+		require.NoError(t, results[1].err)
+		assert.Contains(t, results[1].output, "b.proto")
+		require.NoError(t, results[2].err)
+		assert.Contains(t, results[2].output, "### New module")
+		require.Error(t, results[3].err)
+
+		progressLines := strings.Split(strings.TrimSpace(progress.String()), "\n")
+		require.Len(t, progressLines, len(transitions))
+		for i, progressLine := range progressLines {
+			assert.True(t, strings.HasPrefix(progressLine, fmt.Sprintf("[%d/%d] foo/", i+1, len(transitions))), progressLine)
+		}
+		assert.Contains(t, progress.String(), "foo/bar (new) -> v1.0.0: done")
+		assert.Contains(t, progress.String(), "foo/missing v1.0.0 -> v1.1.0: failed")
+	})
+	t.Run("fail_fast", func(t *testing.T) {
+		t.Parallel()
+		// the failing transition goes first, but goroutines can start in any order, so transitions
+		// can either finish before the failure or be canceled by it.
+		failFastTransitions := append([]stateTransition{transitions[3]}, transitions[:3]...)
+		results := runCASDiffs(t.Context(), rootDirPath, failFastTransitions, casDiffOptions{
+			concurrency: 1,
+			failFast:    true,
+		})
+		require.Len(t, results, len(failFastTransitions))
+		require.Error(t, results[0].err)
+		assert.NotContains(t, results[0].err.Error(), "canceled after")
+		for _, result := range results[1:] {
+			if result.err != nil {
+				assert.Contains(t, result.err.Error(), "canceled after modules/sync/foo/missing v1.0.0->v1.1.0 failed")
+			}
+		}
+	})
+	t.Run("canceled", func(t *testing.T) {
+		t.Parallel()
+		ctx, cancel := context.WithCancel(t.Context())
+		cancel()
+		results := runCASDiffs(ctx, rootDirPath, transitions[:3], casDiffOptions{concurrency: 1})
+		for _, result := range results {
+			require.ErrorIs(t, result.err, context.Canceled)
+		}
+	})
+	t.Run("timeout", func(t *testing.T) {
+		t.Parallel()
+		results := runCASDiffs(t.Context(), rootDirPath, transitions[:2], casDiffOptions{
+			concurrency:       1,
+			transitionTimeout: time.Nanosecond,
+		})
+		for _, result := range results {
+			require.Error(t, result.err)
+			assert.Contains(t, result.err.Error(), "transition timed out after 1ns")
+		}
+	})
+	t.Run("module_readers", func(t *testing.T) {
+		t.Parallel()
+		moduleReaders := newModuleReaders(rootDirPath)
+		var (
+			wg                sync.WaitGroup
+			moduleReaderGroup [4]*bufcasdiff.ModuleReader
+		)
+		for i := range moduleReaderGroup {
+			wg.Go(func() {
+				moduleReader, err := moduleReaders.get(t.Context(), "modules/sync/foo/bar")
+				assert.NoError(t, err)
+				moduleReaderGroup[i] = moduleReader
+			})
+		}
+		wg.Wait()
+		for _, moduleReader := range moduleReaderGroup {
+			assert.Same(t, moduleReaderGroup[0], moduleReader)
+		}
+		_, err := moduleReaders.get(t.Context(), "modules/sync/foo/missing")
+		require.Error(t, err)
+	})
+}
+
+// newTestModulesDir writes a single module foo/bar with three references to a temporary sync
+// directory, and returns the root directory that contains it.
+func newTestModulesDir(t *testing.T) string {
+	t.Helper()
+	ctx := t.Context()
+	rootDirPath := t.TempDir()
+	moduleDirPath := filepath.Join(rootDirPath, "modules", "sync", "foo", "bar")
+	casDirPath := filepath.Join(moduleDirPath, "cas")
+	require.NoError(t, os.MkdirAll(casDirPath, 0700))
+	writeManifest := func(files map[string]string) string {
+		bucket := storagemem.NewReadWriteBucket()
+		for path, content := range files {
+			require.NoError(t, storage.PutPath(ctx, bucket, path, []byte(content)))
+		}
+		fileSet, err := cas.NewFileSetForBucket(ctx, bucket, cas.DigestTypeShake256)
+		require.NoError(t, err)
+		manifestBlob, err := cas.ManifestToBlob(fileSet.Manifest(), cas.DigestTypeShake256)
+		require.NoError(t, err)
+		for _, blob := range append(fileSet.BlobSet().Blobs(), manifestBlob) {
+			blobPath := filepath.Join(casDirPath, hex.EncodeToString(blob.Digest().Value()))
+			require.NoError(t, os.WriteFile(blobPath, blob.Content(), 0600))
+		}
+		return hex.EncodeToString(manifestBlob.Digest().Value())
+	}
+	digests := []string{
+		writeManifest(map[string]string{"a.proto": "syntax = \"proto3\";\npackage foo.v1;\n"}),
+		writeManifest(map[string]string{"a.proto": "syntax = \"proto3\";\npackage foo.v2;\n"}),
+		writeManifest(map[string]string{
+			"a.proto": "syntax = \"proto3\";\npackage foo.v2;\n",
+			"b.proto": "syntax = \"proto3\";\npackage foo.v2;\n",
+		}),
+	}
+	moduleState := fmt.Sprintf(`{
+  "references": [
+    {"name": "v1.0.0", "digest": %q},
+    {"name": "v1.1.0", "digest": %q},
+    {"name": "v1.2.0", "digest": %q}
+  ]
+}
+`, digests[0], digests[1], digests[2])
+	require.NoError(t, os.WriteFile(filepath.Join(moduleDirPath, "state.json"), []byte(moduleState), 0600))
+	return rootDirPath
+}
@@ -0,0 +1,172 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/fs"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/modules/internal/gitutil"
+	"github.com/bufbuild/modules/private/bufpkg/bufstate"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testModuleStatePath = "modules/sync/foo/bar/state.json"
+	testBaseModuleState = `{
+  "references": [
+    {
+      "name": "v1.0.0",
+      "digest": "aaa"
+    }
+  ]
+}
+`
+	testHeadModuleState = `{
+  "references": [
+    {
+      "name": "v1.0.0",
+      "digest": "aaa"
+    },
+    {
+      "name": "v1.1.0",
+      "digest": "aaa"
+    },
+    {
+      "name": "v1.2.0",
+      "digest": "bbb"
+    }
+  ]
+}
+`
+	testBaseGlobalState = `{
+  "modules": [
+    {
+      "module_name": "foo/bar",
+      "latest_reference": "v1.0.0"
+    }
+  ]
+}
+`
+	testHeadGlobalState = `{
+  "modules": [
+    {
+      "module_name": "foo/bar",
+      "latest_reference": "v1.2.0"
+    },
+    {
+      "module_name": "foo/baz",
+      "latest_reference": "v0.1.0"
+    }
+  ]
+}
+`
+)
+
+func TestGoGitRepository(t *testing.T) {
+	t.Parallel()
+	repo, baseRef, headRef := newTestGitRepository(t)
+	t.Run("read_file", func(t *testing.T) {
+		t.Parallel()
+		content, err := repo.ReadFile(t.Context(), baseRef, testModuleStatePath)
+		require.NoError(t, err)
+		assert.Equal(t, testBaseModuleState, string(content))
+		_, err = repo.ReadFile(t.Context(), baseRef, "does/not/exist.json")
+		require.ErrorIs(t, err, fs.ErrNotExist)
+	})
+	t.Run("changed_files", func(t *testing.T) {
+		t.Parallel()
+		changedFiles, err := repo.ChangedFiles(t.Context(), baseRef, headRef)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{globalStatePath, testModuleStatePath}, changedFiles)
+		moduleStatePaths, err := changedModuleStateFiles(t.Context(), repo, baseRef, headRef)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]struct{}{testModuleStatePath: {}}, moduleStatePaths)
+	})
+	t.Run("state_file_transitions", func(t *testing.T) {
+		t.Parallel()
+		stateRW, err := bufstate.NewReadWriter()
+		require.NoError(t, err)
+		transitions, err := getStateFileTransitions(t.Context(), stateRW, newRevisionSource(repo, baseRef), newRevisionSource(repo, headRef), testModuleStatePath)
+		require.NoError(t, err)
+		assert.Equal(t, []stateTransition{
+			{
+				modulePath: "modules/sync/foo/bar",
+				filePath:   testModuleStatePath,
+				fromRef:    "v1.1.0",
+				toRef:      "v1.2.0",
+				fromDigest: "aaa",
+				toDigest:   "bbb",
+				lineNumber: 13,
+			},
+		}, transitions)
+	})
+	t.Run("overall_transitions", func(t *testing.T) {
+		t.Parallel()
+		stateRW, err := bufstate.NewReadWriter()
+		require.NoError(t, err)
+		transitions, err := getOverallTransitions(t.Context(), stateRW, newRevisionSource(repo, baseRef), newRevisionSource(repo, headRef))
+		require.NoError(t, err)
+		assert.Equal(t, []stateTransition{
+			{
+				modulePath:          "modules/sync/foo/bar",
+				filePath:            globalStatePath,
+				fromRef:             "v1.0.0",
+				toRef:               "v1.2.0",
+				lineNumber:          5,
+				isOverallTransition: true,
+			},
+		}, transitions)
+	})
+}
+
+// newTestGitRepository creates an in-memory git repository with a base and a head commit, and
+// returns it with both commit hashes.
+func newTestGitRepository(t *testing.T) (*gitutil.GoGitRepository, string, string) {
+	t.Helper()
+	fs := memfs.New()
+	repo, err := git.Init(memory.NewStorage(), fs)
+	require.NoError(t, err)
+	worktree, err := repo.Worktree()
+	require.NoError(t, err)
+	commit := func(files map[string]string) string {
+		for path, content := range files {
+			require.NoError(t, util.WriteFile(fs, path, []byte(content), 0600))
+			_, err := worktree.Add(path)
+			require.NoError(t, err)
+		}
+		hash, err := worktree.Commit("commit", &git.CommitOptions{
+			Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Unix(0, 0)},
+		})
+		require.NoError(t, err)
+		return hash.String()
+	}
+	baseRef := commit(map[string]string{
+		globalStatePath:     testBaseGlobalState,
+		testModuleStatePath: testBaseModuleState,
+	})
+	headRef := commit(map[string]string{
+		globalStatePath:     testHeadGlobalState,
+		testModuleStatePath: testHeadModuleState,
+	})
+	return gitutil.NewGoGitRepository(repo), baseRef, headRef
+}
@@ -20,13 +20,16 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"runtime"
 	"strconv"
 	"strings"
+	"time"
 
 	"buf.build/go/app/appcmd"
 	"buf.build/go/app/appext"
 	"buf.build/go/standard/xslices"
 	"github.com/bufbuild/buf/private/pkg/slogapp"
+	"github.com/bufbuild/modules/internal/gitutil"
 	"github.com/bufbuild/modules/private/bufpkg/bufstate"
 	"github.com/spf13/pflag"
 )
@@ -55,8 +58,27 @@ func newCommand(name string) *appcmd.Command {
 	}
 }
 
+const (
+	baseRefFlagName           = "base-ref"
+	headRefFlagName           = "head-ref"
+	reportFlagName            = "report"
+	reportFormatFlagName      = "report-format"
+	execGitFlagName           = "exec-git"
+	concurrencyFlagName       = "concurrency"
+	failFastFlagName          = "fail-fast"
+	transitionTimeoutFlagName = "transition-timeout"
+)
+
 type flags struct {
-	dryRun bool
+	dryRun            bool
+	baseRef           string
+	headRef           string
+	report            string
+	reportFormat      string
+	execGit           bool
+	concurrency       int
+	failFast          bool
+	transitionTimeout time.Duration
 }
 
 func newFlags() *flags {
@@ -65,21 +87,52 @@ func newFlags() *flags {
 
 func (f *flags) bind(flagSet *pflag.FlagSet) {
 	flagSet.BoolVar(&f.dryRun, "dry-run", false, "print comments to stdout instead of posting to GitHub")
+	flagSet.StringVar(&f.baseRef, baseRefFlagName, "", "base git ref to compare from, defaults to the BASE_REF environment variable")
+	flagSet.StringVar(&f.headRef, headRefFlagName, "", "head git ref to compare to, defaults to the HEAD_REF environment variable")
+	flagSet.StringVar(
+		&f.report,
+		reportFlagName,
+		"",
+		"write all comments to a single report file at this path instead of posting to GitHub",
+	)
+	flagSet.StringVar(
+		&f.reportFormat,
+		reportFormatFlagName,
+		reportFormatMarkdown.String(),
+		fmt.Sprintf("the format of the report file. Must be one of %s", allReportFormatsString),
+	)
+	flagSet.BoolVar(&f.execGit, execGitFlagName, false, "shell out to the git binary instead of reading the repository in-process")
+	flagSet.IntVar(&f.concurrency, concurrencyFlagName, runtime.NumCPU(), "the maximum number of transitions diffed at the same time")
+	flagSet.BoolVar(&f.failFast, failFastFlagName, false, "cancel all remaining transitions as soon as one of them fails")
+	flagSet.DurationVar(
+		&f.transitionTimeout,
+		transitionTimeoutFlagName,
+		5*time.Minute,
+		"the maximum duration of a single transition diff, zero means no timeout",
+	)
 }
 
 func run(ctx context.Context, flags *flags) error {
-	baseRef := os.Getenv("BASE_REF")
-	headRef := os.Getenv("HEAD_REF")
+	baseRef := cmp.Or(flags.baseRef, os.Getenv("BASE_REF"))
+	headRef := cmp.Or(flags.headRef, os.Getenv("HEAD_REF"))
 	prNumberString := os.Getenv("PR_NUMBER")
 	var prNumber int
 
 	if baseRef == "" {
-		return errors.New("BASE_REF environment variable is required")
+		return fmt.Errorf("--%s flag or BASE_REF environment variable is required", baseRefFlagName)
 	}
 	if headRef == "" {
-		return errors.New("HEAD_REF environment variable is required")
+		return fmt.Errorf("--%s flag or HEAD_REF environment variable is required", headRefFlagName)
+	}
+	isLocalReport := flags.report != ""
+	reportFormat, ok := reportFormatsNamesToValues[flags.reportFormat]
+	if !ok {
+		return fmt.Errorf("unsupported report format %s", flags.reportFormat)
 	}
-	if !flags.dryRun {
+	if flags.concurrency < 1 {
+		return fmt.Errorf("--%s must be at least 1", concurrencyFlagName)
+	}
+	if !flags.dryRun && !isLocalReport {
 		if os.Getenv("GITHUB_TOKEN") == "" {
 			return errors.New("GITHUB_TOKEN environment variable is required when not a dry-run")
 		}
@@ -101,7 +154,18 @@ func run(ctx context.Context, flags *flags) error {
 		headRef,
 	)
 
-	moduleStatePaths, err := changedModuleStateFiles(ctx, baseRef, headRef)
+	var repo gitutil.Repository
+	if flags.execGit {
+		repo = gitutil.NewExecRepository(".")
+	} else {
+		goGitRepo, err := gitutil.OpenGoGitRepository(".")
+		if err != nil {
+			return err
+		}
+		repo = goGitRepo
+	}
+
+	moduleStatePaths, err := changedModuleStateFiles(ctx, repo, baseRef, headRef)
 	if err != nil {
 		return fmt.Errorf("find changed modules: %w", err)
 	}
@@ -113,6 +177,9 @@ func run(ctx context.Context, flags *flags) error {
 			baseRef,
 			headRef,
 		)
+		if isLocalReport {
+			return writeReportFile(flags.report, reportFormat, baseRef, headRef, nil)
+		}
 		return nil
 	}
 	moduleStatePathsSorted := xslices.MapKeysToSortedSlice(moduleStatePaths)
@@ -129,11 +196,13 @@ func run(ctx context.Context, flags *flags) error {
 		return fmt.Errorf("new state read writer: %w", err)
 	}
 
+	baseSource := gitutil.NewRevisionSource(repo, baseRef, bufstate.SyncRoot)
+	headSource := gitutil.NewRevisionSource(repo, headRef, bufstate.SyncRoot)
 	var allTransitions []stateTransition
 	for _, moduleStatePath := range moduleStatePathsSorted {
 		fmt.Fprintf(os.Stdout, "Analyzing %s...\n", moduleStatePath)
 
-		transitions, err := getStateFileTransitions(ctx, stateRW, moduleStatePath, baseRef, headRef)
+		transitions, err := getStateFileTransitions(ctx, stateRW, baseSource, headSource, moduleStatePath)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Warning: failed to analyze %s: %v\n", moduleStatePath, err)
 			continue
@@ -147,7 +216,7 @@ func run(ctx context.Context, flags *flags) error {
 		}
 	}
 
-	overallTransitions, err := getOverallTransitions(ctx, stateRW, baseRef, headRef)
+	overallTransitions, err := getOverallTransitions(ctx, stateRW, baseSource, headSource)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Warning: failed to get overall transitions from global state: %v\n", err)
 	} else if len(overallTransitions) > 0 {
@@ -160,11 +229,23 @@ func run(ctx context.Context, flags *flags) error {
 
 	if len(allTransitions) == 0 {
 		fmt.Fprintf(os.Stdout, "No digest transitions found\n")
+		if isLocalReport {
+			return writeReportFile(flags.report, reportFormat, baseRef, headRef, nil)
+		}
 		return nil
 	}
 
 	fmt.Fprintf(os.Stdout, "\nRunning casdiff for %d transition(s)...\n", len(allTransitions))
-	results := runCASDiffs(ctx, allTransitions)
+	repoRoot, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("get working directory: %w", err)
+	}
+	results := runCASDiffs(ctx, repoRoot, allTransitions, casDiffOptions{
+		concurrency:       flags.concurrency,
+		failFast:          flags.failFast,
+		transitionTimeout: flags.transitionTimeout,
+		progress:          os.Stdout,
+	})
 
 	var (
 		casResults   []casDiffResult
@@ -188,7 +269,14 @@ func run(ctx context.Context, flags *flags) error {
 		}
 	}
 
-	if len(casResults) > 0 {
+	if isLocalReport {
+		// The report includes failed results too, so they can be previewed before opening a PR.
+		if err := writeReportFile(flags.report, reportFormat, baseRef, headRef, results); err != nil {
+			errsToReturn = append(errsToReturn, fmt.Errorf("write report: %w", err))
+		} else {
+			fmt.Fprintf(os.Stdout, "\nWrote %d comment(s) to %s\n", len(results), flags.report)
+		}
+	} else if len(casResults) > 0 {
 		if flags.dryRun {
 			fmt.Fprintf(os.Stdout, "\n[dry-run] %d comment(s) would be posted:\n", len(casResults))
 			for _, result := range casResults {
@@ -222,3 +310,23 @@ func run(ctx context.Context, flags *flags) error {
 	fmt.Fprintf(os.Stdout, "\nDone!\n")
 	return nil
 }
+
+// writeReportFile renders the casdiff results to a report file in the given path.
+func writeReportFile(
+	path string,
+	format reportFormat,
+	baseRef string,
+	headRef string,
+	results []casDiffResult,
+) (retErr error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("create file: %w", err)
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			retErr = errors.Join(retErr, fmt.Errorf("close file: %w", err))
+		}
+	}()
+	return writeReport(file, format, baseRef, headRef, results)
+}
@@ -17,32 +17,28 @@ package main
 import (
 	"context"
 	"fmt"
-	"os/exec"
 	"strings"
+
+	"github.com/bufbuild/modules/internal/gitutil"
 )
 
 // changedModuleStateFiles returns modules' state files that had *any change* between the passed
 // base and head refs.
-func changedModuleStateFiles(ctx context.Context, baseRef string, headRef string) (map[string]struct{}, error) {
+func changedModuleStateFiles(ctx context.Context, repo gitutil.Repository, baseRef string, headRef string) (map[string]struct{}, error) {
 	// Get list of changed files in the PR
-	cmd := exec.CommandContext(ctx, "git", "diff", "--name-only", baseRef, headRef) //nolint:gosec
-	output, err := cmd.Output()
+	changedPaths, err := repo.ChangedFiles(ctx, baseRef, headRef)
 	if err != nil {
-		return nil, fmt.Errorf("git diff: %w", err)
+		return nil, fmt.Errorf("changed files: %w", err)
 	}
 
 	moduleStatePaths := make(map[string]struct{})
-	for line := range strings.SplitSeq(string(output), "\n") {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-		if line == "modules/sync/state.json" {
+	for _, changedPath := range changedPaths {
+		if changedPath == globalStatePath {
 			continue // exclude the global modules' state.json
 		}
 		// Look for "modules/sync/<owner>/<module>/state.json" files
-		if strings.HasPrefix(line, "modules/sync/") && strings.HasSuffix(line, "/state.json") {
-			moduleStatePaths[line] = struct{}{}
+		if strings.HasPrefix(changedPath, "modules/sync/") && strings.HasSuffix(changedPath, "/state.json") {
+			moduleStatePaths[changedPath] = struct{}{}
 		}
 	}
 
@@ -0,0 +1,240 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"html/template"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"buf.build/go/standard/xslices"
+	"github.com/bufbuild/modules/internal/bufcasdiff"
+)
+
+// reportFormat is a format to render a local review report.
+type reportFormat int
+
+const (
+	reportFormatMarkdown reportFormat = iota + 1
+	reportFormatHTML
+)
+
+//nolint:gochecknoglobals // treated as consts
+var (
+	reportFormatsValuesToNames = map[reportFormat]string{
+		reportFormatMarkdown: "markdown",
+		reportFormatHTML:     "html",
+	}
+	reportFormatsNamesToValues, _ = xslices.ToUniqueValuesMap(
+		xslices.MapKeysToSlice(reportFormatsValuesToNames),
+		func(f reportFormat) string { return reportFormatsValuesToNames[f] },
+	)
+	allReportFormatsString = xslices.MapKeysToSortedSlice(reportFormatsNamesToValues)
+)
+
+func (f reportFormat) String() string {
+	if n, ok := reportFormatsValuesToNames[f]; ok {
+		return n
+	}
+	return strconv.Itoa(int(f))
+}
+
+// reportFile groups all the results that would be commented on a single state file.
+type reportFile struct {
+	Path    string
+	Results []reportResult
+}
+
+// reportResult is a single result as rendered in a report, which would be a single PR comment.
+type reportResult struct {
+	LineNumber int
+	FromRef    string
+	ToRef      string
+	IsOverall  bool
+	IsNew      bool
+	Command    string
+	Summary    string
+	// Markdown is the exact PR comment body, used for markdown reports.
+	Markdown string
+	// Text is the plain text diff, used for HTML reports.
+	Text string
+	// Err is the casdiff error for this transition, if any.
+	Err string
+}
+
+// writeReport renders all casdiff results in a single self-contained report, grouped by the state
+// file they would be commented on. The global state file goes first, followed by module state files
+// sorted by path. Within a file, results are sorted by line number.
+func writeReport(
+	w io.Writer,
+	format reportFormat,
+	baseRef string,
+	headRef string,
+	results []casDiffResult,
+) error {
+	files := groupReportFiles(results)
+	switch format {
+	case reportFormatMarkdown:
+		return writeMarkdownReport(w, baseRef, headRef, len(results), files)
+	case reportFormatHTML:
+		return htmlReportTemplate.Execute(w, htmlReport{
+			BaseRef:         baseRef,
+			HeadRef:         headRef,
+			TransitionCount: len(results),
+			Files:           files,
+		})
+	default:
+		return fmt.Errorf("report format %s not supported", format)
+	}
+}
+
+func groupReportFiles(results []casDiffResult) []reportFile {
+	filePathToResults := make(map[string][]reportResult)
+	for _, result := range results {
+		r := reportResult{
+			LineNumber: result.transition.lineNumber,
+			FromRef:    result.transition.fromRef,
+			ToRef:      result.transition.toRef,
+			IsOverall:  result.transition.isOverallTransition,
+			IsNew:      result.transition.isNewModule,
+			Markdown:   result.output,
+		}
+		if !r.IsNew {
+			r.Command = casDiffCommand(result.transition)
+		}
+		if result.err != nil {
+			r.Err = result.err.Error()
+		}
+		if result.mdiff != nil {
+			r.Summary = result.mdiff.Summary()
+			r.Text = result.mdiff.String(bufcasdiff.ManifestDiffOutputFormatText)
+		}
+		if result.report != nil {
+			r.Summary = result.report.Summary()
+			r.Text = result.report.String(bufcasdiff.ManifestDiffOutputFormatText)
+		}
+		filePathToResults[result.transition.filePath] = append(filePathToResults[result.transition.filePath], r)
+	}
+	files := make([]reportFile, 0, len(filePathToResults))
+	for filePath, fileResults := range filePathToResults {
+		slices.SortStableFunc(fileResults, func(a, b reportResult) int {
+			return cmp.Compare(a.LineNumber, b.LineNumber)
+		})
+		files = append(files, reportFile{Path: filePath, Results: fileResults})
+	}
+	slices.SortFunc(files, func(a, b reportFile) int {
+		aIsGlobal, bIsGlobal := a.Path == globalStatePath, b.Path == globalStatePath
+		if aIsGlobal != bIsGlobal {
+			if aIsGlobal {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.Path, b.Path)
+	})
+	return files
+}
+
+func writeMarkdownReport(
+	w io.Writer,
+	baseRef string,
+	headRef string,
+	transitionCount int,
+	files []reportFile,
+) error {
+	var b strings.Builder
+	b.WriteString("# CAS diff report\n\n")
+	fmt.Fprintf(&b, "Comparing `%s`..`%s`: %d transition(s) in %d file(s).\n", baseRef, headRef, transitionCount, len(files))
+	for _, file := range files {
+		fmt.Fprintf(&b, "\n## `%s`\n", file.Path)
+		for _, result := range file.Results {
+			if result.IsNew {
+				fmt.Fprintf(&b, "\n---\n\n_Line %d: new module at `%s`_\n\n", result.LineNumber, result.ToRef)
+			} else {
+				fmt.Fprintf(&b, "\n---\n\n_Line %d: `%s` -> `%s`_\n\n", result.LineNumber, result.FromRef, result.ToRef)
+			}
+			if result.Err != "" {
+				fmt.Fprintf(&b, "> [!CAUTION]\n> casdiff failed: %s\n", result.Err)
+				continue
+			}
+			b.WriteString(result.Markdown + "\n")
+		}
+	}
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		return fmt.Errorf("write markdown report: %w", err)
+	}
+	return nil
+}
+
+type htmlReport struct {
+	BaseRef         string
+	HeadRef         string
+	TransitionCount int
+	Files           []reportFile
+}
+
+//nolint:gochecknoglobals // treated as const
+var htmlReportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>CAS diff report: {{ .BaseRef }}..{{ .HeadRef }}</title>
+<style>
+body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em auto; max-width: 1200px; padding: 0 1em; }
+pre { background: #f6f8fa; border-radius: 6px; overflow-x: auto; padding: 1em; }
+.comment { border: 1px solid #d0d7de; border-radius: 6px; margin: 1em 0; padding: 0 1em; }
+.location { color: #57606a; font-style: italic; }
+.error { color: #cf222e; }
+</style>
+</head>
+<body>
+<h1>CAS diff report</h1>
+<p>Comparing <code>{{ .BaseRef }}</code>..<code>{{ .HeadRef }}</code>: {{ .TransitionCount }} transition(s) in {{ len .Files }} file(s).</p>
+{{- range .Files }}
+<h2><code>{{ .Path }}</code></h2>
+{{- range .Results }}
+<div class="comment">
+{{- if .IsNew }}
+<p class="location">Line {{ .LineNumber }}: new module at <code>{{ .ToRef }}</code></p>
+{{- else }}
+<p class="location">Line {{ .LineNumber }}: <code>{{ .FromRef }}</code> -&gt; <code>{{ .ToRef }}</code></p>
+{{- end }}
+{{- if .Err }}
+<p class="error">casdiff failed: {{ .Err }}</p>
+{{- else if .IsNew }}
+<h3>New module</h3>
+<p>Initial reference <code>{{ .ToRef }}</code>:</p>
+<pre>{{ .Text }}</pre>
+{{- else if .IsOverall }}
+<h3>Overall transition</h3>
+<pre>{{ .Command }}</pre>
+<pre>{{ .Text }}</pre>
+{{- else }}
+<p><strong>Intermediate transition</strong></p>
+<pre>{{ .Command }}</pre>
+<details><summary>{{ .Summary }}</summary>
+<pre>{{ .Text }}</pre>
+</details>
+{{- end }}
+</div>
+{{- end }}
+{{- end }}
+</body>
+</html>
+`))
@@ -0,0 +1,101 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteReport(t *testing.T) {
+	t.Parallel()
+	results := []casDiffResult{
+		{
+			transition: stateTransition{
+				filePath:   "modules/sync/foo/bar/state.json",
+				fromRef:    "v1.1.0",
+				toRef:      "v1.2.0",
+				lineNumber: 20,
+			},
+			output: "intermediate foo/bar v1.2.0",
+		},
+		{
+			transition: stateTransition{
+				filePath:   "modules/sync/foo/bar/state.json",
+				fromRef:    "v1.0.0",
+				toRef:      "v1.1.0",
+				lineNumber: 10,
+			},
+			err: errors.New("some failure"),
+		},
+		{
+			transition: stateTransition{
+				filePath:   "modules/sync/aaa/bbb/state.json",
+				fromRef:    "c1",
+				toRef:      "c2",
+				lineNumber: 5,
+			},
+			output: "intermediate aaa/bbb c2",
+		},
+		{
+			transition: stateTransition{
+				filePath:            globalStatePath,
+				fromRef:             "v1.0.0",
+				toRef:               "v1.2.0",
+				lineNumber:          7,
+				isOverallTransition: true,
+			},
+			output: "overall foo/bar v1.2.0",
+		},
+	}
+	t.Run("grouping", func(t *testing.T) {
+		t.Parallel()
+		files := groupReportFiles(results)
+		require.Len(t, files, 3)
+		assert.Equal(t, globalStatePath, files[0].Path)
+		assert.Equal(t, "modules/sync/aaa/bbb/state.json", files[1].Path)
+		assert.Equal(t, "modules/sync/foo/bar/state.json", files[2].Path)
+		require.Len(t, files[2].Results, 2)
+		assert.Equal(t, 10, files[2].Results[0].LineNumber)
+		assert.Equal(t, "some failure", files[2].Results[0].Err)
+		assert.Equal(t, 20, files[2].Results[1].LineNumber)
+	})
+	t.Run("markdown", func(t *testing.T) {
+		t.Parallel()
+		var buf bytes.Buffer
+		require.NoError(t, writeReport(&buf, reportFormatMarkdown, "main", "fetch-modules", results))
+		got := buf.String()
+		assert.Contains(t, got, "Comparing `main`..`fetch-modules`: 4 transition(s) in 3 file(s).")
+		assert.Contains(t, got, "casdiff failed: some failure")
+		overallIndex := bytes.Index(buf.Bytes(), []byte("overall foo/bar v1.2.0"))
+		intermediateIndex := bytes.Index(buf.Bytes(), []byte("intermediate aaa/bbb c2"))
+		assert.Positive(t, overallIndex)
+		assert.Greater(t, intermediateIndex, overallIndex)
+	})
+	t.Run("html", func(t *testing.T) {
+		t.Parallel()
+		var buf bytes.Buffer
+		require.NoError(t, writeReport(&buf, reportFormatHTML, "main", "<head>", results))
+		got := buf.String()
+		assert.Contains(t, got, "<code>&lt;head&gt;</code>")
+		assert.Contains(t, got, "<h3>Overall transition</h3>")
+		assert.Contains(t, got, "<strong>Intermediate transition</strong>")
+		assert.Contains(t, got, "casdiff failed: some failure")
+	})
+}
@@ -15,19 +15,22 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"os/exec"
+	"io/fs"
 	"path/filepath"
 	"strings"
 
 	"github.com/bufbuild/modules/private/bufpkg/bufstate"
-	statev1alpha1 "github.com/bufbuild/modules/private/gen/modules/state/v1alpha1"
+	statev1beta1 "github.com/bufbuild/modules/private/gen/modules/state/v1beta1"
 )
 
+// globalStatePath is the path of the global state file, relative to the repository root.
+const globalStatePath = "modules/sync/state.json"
+
 // stateTransition represents a digest change in a module's state.json file.
 type stateTransition struct {
 	modulePath          string // e.g., "modules/sync/bufbuild/protovalidate"
@@ -38,23 +41,33 @@ type stateTransition struct {
 	toDigest            string // New digest
 	lineNumber          int    // Line in diff where the new reference or digest appears.
 	isOverallTransition bool   // True for overall transitions on the global state.json file.
+	isNewModule         bool   // True for the first reference of a module that is not present in base, it has no from ref/digest.
 }
 
-// getStateFileTransitions reads state.json from base and head branches, compares the JSON arrays to
-// find appended references, and detects digest transitions.
+// getStateFileTransitions reads state.json from the base and head sources, compares the JSON arrays
+// to find appended references, and detects digest transitions. If the state file does not exist in
+// base, the module is new and its first reference is returned as a new module transition. The file
+// path is relative to the repository root, and the sources are rooted at bufstate.SyncRoot.
 func getStateFileTransitions(
 	ctx context.Context,
 	stateRW *bufstate.ReadWriter,
+	baseSource bufstate.Source,
+	headSource bufstate.Source,
 	filePath string,
-	baseRef string,
-	headRef string,
 ) ([]stateTransition, error) {
-	// Read state.json from both branches
-	baseContent, err := readFileAtRef(ctx, filePath, baseRef)
+	syncPath, ok := strings.CutPrefix(filePath, bufstate.SyncRoot+"/")
+	if !ok {
+		return nil, fmt.Errorf("state file %s is not in %s", filePath, bufstate.SyncRoot)
+	}
+	// Read state.json from both sources
+	baseContent, err := baseSource.ReadFile(ctx, syncPath)
 	if err != nil {
-		return nil, fmt.Errorf("read base state: %w", err)
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("read base state: %w", err)
+		}
+		baseContent = []byte("{}") // new module
 	}
-	headContent, err := readFileAtRef(ctx, filePath, headRef)
+	headContent, err := headSource.ReadFile(ctx, syncPath)
 	if err != nil {
 		return nil, fmt.Errorf("read head state: %w", err)
 	}
@@ -79,44 +92,65 @@ func getStateFileTransitions(
 		return nil, nil
 	}
 
-	// Get line number mapping for the appended references
-	lineNumbers, err := getLineNumbersForAppendedRefs(ctx, filePath, baseRef, headRef, len(baseRefs), len(headRefs))
+	// Index the head file positions, appended references are always the last ones in it.
+	headSpans, err := bufstate.ModuleStateReferenceSpans(headContent)
 	if err != nil {
-		return nil, fmt.Errorf("get line numbers: %w", err)
+		return nil, fmt.Errorf("index head state positions: %w", err)
 	}
+	if len(headSpans) != len(headRefs) {
+		return nil, fmt.Errorf("indexed %d references positions, expected %d", len(headSpans), len(headRefs))
+	}
+	appendedSpans := headSpans[len(headSpans)-len(appendedRefs):]
 
 	// Detect digest transitions
 	var (
-		modulePath    = filepath.Dir(filePath)
-		currentRef    = current.GetName()
-		currentDigest = current.GetDigest()
-		transitions   []stateTransition
+		modulePath  = filepath.Dir(filePath)
+		transitions []stateTransition
 	)
-	for i, appendedRef := range appendedRefs {
-		if appendedRef.GetDigest() != currentDigest {
-			// Digest changed! Record transition
-			var lineNumber int
-			if i < len(lineNumbers) {
-				lineNumber = lineNumbers[i]
-			}
-			transitions = append(transitions, stateTransition{
-				modulePath:          modulePath,
-				filePath:            filePath,
-				fromRef:             currentRef,
-				toRef:               appendedRef.GetName(),
-				fromDigest:          currentDigest,
-				toDigest:            appendedRef.GetDigest(),
-				lineNumber:          lineNumber,
-				isOverallTransition: false,
-			})
-			currentDigest = appendedRef.GetDigest()
-		}
-		currentRef = appendedRef.GetName()
+	if len(baseRefs) == 0 {
+		// The current ref is the first ever for this module, there is nothing to diff it against.
+		transitions = append(transitions, stateTransition{
+			modulePath:  modulePath,
+			filePath:    filePath,
+			toRef:       current.GetName(),
+			toDigest:    current.GetDigest(),
+			lineNumber:  digestLineNumber(headSpans[0]),
+			isNewModule: true,
+		})
+	}
+	// The current ref is at index 0, followed by the appended refs, so every digest span after the
+	// first one starts at an appended ref whose digest changed from the ref before it.
+	history := bufstate.NewModuleHistory(statev1beta1.ModuleState_builder{
+		References: append([]*statev1beta1.ModuleReference{current}, appendedRefs...),
+	}.Build())
+	digestSpans := history.DigestSpans()
+	for i, digestSpan := range digestSpans[1:] {
+		fromRef := history.At(digestSpans[i].End)
+		toRef := history.At(digestSpan.Start)
+		transitions = append(transitions, stateTransition{
+			modulePath:          modulePath,
+			filePath:            filePath,
+			fromRef:             fromRef.GetName(),
+			toRef:               toRef.GetName(),
+			fromDigest:          fromRef.GetDigest(),
+			toDigest:            toRef.GetDigest(),
+			lineNumber:          digestLineNumber(appendedSpans[digestSpan.Start-1]),
+			isOverallTransition: false,
+		})
 	}
 
 	return transitions, nil
 }
 
+// digestLineNumber returns the line where the digest of a module reference is, or where the
+// reference starts if it has no digest.
+func digestLineNumber(referenceSpan bufstate.ElementSpan) int {
+	if digestSpan, ok := referenceSpan.FieldSpan("digest"); ok {
+		return digestSpan.Start.Line
+	}
+	return referenceSpan.Start.Line
+}
+
 // resolveAppendedRefs identifies the refs from headRefs that are new, considering new by
 // index-behavior, not its content. Meaning if base has 3 refs and head has 5, we assume the first 3
 // in head are the same 3 in base, and the latest 2 are "appended". That is the use case for the
@@ -126,9 +160,9 @@ func getStateFileTransitions(
 // If base is empty, the first ref in head is returned as the current, and all the rest are returned
 // as appended.
 func resolveAppendedRefs(
-	baseRefs []*statev1alpha1.ModuleReference,
-	headRefs []*statev1alpha1.ModuleReference,
-) (*statev1alpha1.ModuleReference, []*statev1alpha1.ModuleReference) {
+	baseRefs []*statev1beta1.ModuleReference,
+	headRefs []*statev1beta1.ModuleReference,
+) (*statev1beta1.ModuleReference, []*statev1beta1.ModuleReference) {
 	if len(baseRefs) == 0 && len(headRefs) == 0 {
 		// both empty
 		//   - current: none
@@ -153,22 +187,21 @@ func resolveAppendedRefs(
 	return baseRefs[len(baseRefs)-1], headRefs[len(baseRefs):]
 }
 
-// getOverallTransitions reads modules/sync/state.json from both base and head, compares the
+// getOverallTransitions reads the global state file from both base and head, compares the
 // two, and returns one stateTransition per module whose latest_reference changed. Modules that were
-// added or removed between base and head are ignored.
+// added or removed between base and head are ignored, new modules are reported in their own state
+// file instead.
 func getOverallTransitions(
 	ctx context.Context,
 	stateRW *bufstate.ReadWriter,
-	baseRef string,
-	headRef string,
+	baseSource bufstate.Source,
+	headSource bufstate.Source,
 ) ([]stateTransition, error) {
-	const globalStatePath = "modules/sync/state.json"
-
-	baseContent, err := readFileAtRef(ctx, globalStatePath, baseRef)
+	baseContent, err := baseSource.ReadFile(ctx, bufstate.GlobalStateFileName)
 	if err != nil {
 		return nil, fmt.Errorf("read base global state: %w", err)
 	}
-	headContent, err := readFileAtRef(ctx, globalStatePath, headRef)
+	headContent, err := headSource.ReadFile(ctx, bufstate.GlobalStateFileName)
 	if err != nil {
 		return nil, fmt.Errorf("read head global state: %w", err)
 	}
@@ -182,22 +215,30 @@ func getOverallTransitions(
 		return nil, fmt.Errorf("parse head global state: %w", err)
 	}
 
+	headSpans, err := bufstate.GlobalStateReferenceSpans(headContent)
+	if err != nil {
+		return nil, fmt.Errorf("index head global state positions: %w", err)
+	}
+	if len(headSpans) != len(headGlobalState.GetModules()) {
+		return nil, fmt.Errorf("indexed %d modules positions, expected %d", len(headSpans), len(headGlobalState.GetModules()))
+	}
+
 	baseLatestRefs := make(map[string]string, len(baseGlobalState.GetModules()))
 	for _, mod := range baseGlobalState.GetModules() {
 		baseLatestRefs[mod.GetModuleName()] = mod.GetLatestReference()
 	}
 
 	var transitions []stateTransition
-	for _, mod := range headGlobalState.GetModules() {
+	for i, mod := range headGlobalState.GetModules() {
 		moduleName := mod.GetModuleName()
 		toRef := mod.GetLatestReference()
 		fromRef, existsInBase := baseLatestRefs[moduleName]
 		if !existsInBase || fromRef == toRef {
 			continue // it is a new module, or the reference did not change.
 		}
-		lineNumber, err := findLatestReferenceLineInGlobalState(headContent, moduleName)
-		if err != nil {
-			return nil, fmt.Errorf("find line number for %q: %w", moduleName, err)
+		lineNumber := headSpans[i].Start.Line
+		if latestReferenceSpan, ok := headSpans[i].FieldSpan("latest_reference"); ok {
+			lineNumber = latestReferenceSpan.Start.Line
 		}
 		transitions = append(transitions, stateTransition{
 			modulePath:          "modules/sync/" + moduleName,
@@ -210,105 +251,3 @@ func getOverallTransitions(
 	}
 	return transitions, nil
 }
-
-// findLatestReferenceLineInGlobalState scans the raw JSON of modules/sync/state.json and returns
-// the 1-based line number of the "latest_reference" field for the given module.
-func findLatestReferenceLineInGlobalState(content []byte, moduleName string) (int, error) {
-	quotedName := `"` + moduleName + `"`
-	scanner := bufio.NewScanner(bytes.NewReader(content))
-	var (
-		lineNum     int
-		foundModule bool
-	)
-	for scanner.Scan() {
-		lineNum++
-		line := scanner.Text()
-		if !foundModule {
-			if strings.Contains(line, `"module_name"`) && strings.Contains(line, quotedName) {
-				foundModule = true
-			}
-		} else if strings.Contains(line, `"latest_reference"`) {
-			return lineNum, nil
-		}
-	}
-	if err := scanner.Err(); err != nil {
-		return 0, fmt.Errorf("scan global state: %w", err)
-	}
-	return 0, fmt.Errorf("latest_reference for module %q not found in global state", moduleName)
-}
-
-// readFileAtRef reads a file's content at a specific git ref using git show.
-func readFileAtRef(ctx context.Context, filePath string, ref string) ([]byte, error) {
-	cmd := exec.CommandContext(ctx, "git", "show", fmt.Sprintf("%s:%s", ref, filePath)) //nolint:gosec
-	output, err := cmd.Output()
-	if err != nil {
-		return nil, fmt.Errorf("git show %s:%s: %w", ref, filePath, err)
-	}
-	return output, nil
-}
-
-// getLineNumbersForAppendedRefs calculates the line numbers in the diff where each appended
-// reference's digest appears.
-func getLineNumbersForAppendedRefs(
-	ctx context.Context,
-	filePath string,
-	baseRef string,
-	headRef string,
-	baseCount int,
-	headCount int,
-) ([]int, error) {
-	// Get the unified diff, with zero lines of context up or down.
-	cmd := exec.CommandContext(ctx, "git", "diff", "-U0", baseRef, headRef, "--", filePath) //nolint:gosec
-	output, err := cmd.Output()
-	if err != nil {
-		return nil, fmt.Errorf("git diff: %w", err)
-	}
-	return parseLineNumbersFromDiff(string(output), headCount-baseCount)
-}
-
-// parseLineNumbersFromDiff parses a git diff output and extracts line numbers where "digest" fields
-// appear in added lines.
-func parseLineNumbersFromDiff(diffOutput string, expectedCount int) ([]int, error) {
-	lineNumbers := make([]int, expectedCount)
-	scanner := bufio.NewScanner(strings.NewReader(diffOutput))
-
-	var (
-		currentLine    int
-		refIndex       = 0
-		inAddedSection = false
-	)
-	for scanner.Scan() {
-		line := scanner.Text()
-		// Parse hunk headers like: @@ -275,0 +276,12 @@
-		if strings.HasPrefix(line, "@@") {
-			parts := strings.Split(line, " ")
-			if len(parts) >= 3 {
-				// Extract new file line number from +276,12
-				newRange := strings.TrimPrefix(parts[2], "+")
-				newRange = strings.Split(newRange, ",")[0]
-				if _, err := fmt.Sscanf(newRange, "%d", &currentLine); err != nil {
-					return nil, fmt.Errorf("parse hunk header line number %q: %w", newRange, err)
-				}
-				inAddedSection = true
-				continue
-			}
-		}
-		if !inAddedSection {
-			continue
-		}
-		// Look for added lines (starting with +) with the string `"digest":`
-		if strings.HasPrefix(line, "+") && !strings.HasPrefix(line, "+++") {
-			if strings.Contains(line, `"digest":`) {
-				if refIndex < len(lineNumbers) {
-					lineNumbers[refIndex] = currentLine
-					refIndex++
-				}
-			}
-			currentLine++
-		}
-	}
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("scan diff: %w", err)
-	}
-	return lineNumbers, nil
-}
@@ -15,24 +15,29 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"io/fs"
 	"testing"
 
-	statev1alpha1 "github.com/bufbuild/modules/private/gen/modules/state/v1alpha1"
+	"github.com/bufbuild/modules/internal/gitutil"
+	"github.com/bufbuild/modules/private/bufpkg/bufstate"
+	statev1beta1 "github.com/bufbuild/modules/private/gen/modules/state/v1beta1"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
 func TestResolveAppendedRefs(t *testing.T) {
 	t.Parallel()
-	ref := func(name, digest string) *statev1alpha1.ModuleReference {
-		return statev1alpha1.ModuleReference_builder{Name: name, Digest: digest}.Build()
+	ref := func(name, digest string) *statev1beta1.ModuleReference {
+		return statev1beta1.ModuleReference_builder{Name: name, Digest: digest}.Build()
 	}
 	type testCase struct {
 		name         string
-		baseRefs     []*statev1alpha1.ModuleReference
-		headRefs     []*statev1alpha1.ModuleReference
-		wantCurrent  *statev1alpha1.ModuleReference
-		wantAppended []*statev1alpha1.ModuleReference
+		baseRefs     []*statev1beta1.ModuleReference
+		headRefs     []*statev1beta1.ModuleReference
+		wantCurrent  *statev1beta1.ModuleReference
+		wantAppended []*statev1beta1.ModuleReference
 	}
 	testCases := []testCase{
 		{
@@ -47,21 +52,21 @@ func TestResolveAppendedRefs(t *testing.T) {
 			// base=0, head=1 → head[0] is baseline, nothing appended
 			name:         "base_empty_single_ref_in_head",
 			baseRefs:     nil,
-			headRefs:     []*statev1alpha1.ModuleReference{ref("v1.0.0", "d1")},
+			headRefs:     []*statev1beta1.ModuleReference{ref("v1.0.0", "d1")},
 			wantCurrent:  ref("v1.0.0", "d1"),
-			wantAppended: []*statev1alpha1.ModuleReference{},
+			wantAppended: []*statev1beta1.ModuleReference{},
 		},
 		{
 			// base=0, head=3 → head[0] is baseline, head[1:] are appended
 			name:     "base_empty_multiple_refs",
 			baseRefs: nil,
-			headRefs: []*statev1alpha1.ModuleReference{
+			headRefs: []*statev1beta1.ModuleReference{
 				ref("v1.0.0", "d1"),
 				ref("v2.0.0", "d2"),
 				ref("v3.0.0", "d2"),
 			},
 			wantCurrent: ref("v1.0.0", "d1"),
-			wantAppended: []*statev1alpha1.ModuleReference{
+			wantAppended: []*statev1beta1.ModuleReference{
 				ref("v2.0.0", "d2"),
 				ref("v3.0.0", "d2"),
 			},
@@ -69,18 +74,18 @@ func TestResolveAppendedRefs(t *testing.T) {
 		{
 			// base=2, head=4 → base[latest] is baseline, head[2:] are appended
 			name: "base_non_empty_some_appends",
-			baseRefs: []*statev1alpha1.ModuleReference{
+			baseRefs: []*statev1beta1.ModuleReference{
 				ref("v1.0.0", "d1"),
 				ref("v1.1.0", "d1"),
 			},
-			headRefs: []*statev1alpha1.ModuleReference{
+			headRefs: []*statev1beta1.ModuleReference{
 				ref("v1.0.0", "d1"),
 				ref("v1.1.0", "d1"),
 				ref("v2.0.0", "d2"),
 				ref("v2.1.0", "d2"),
 			},
 			wantCurrent: ref("v1.1.0", "d1"),
-			wantAppended: []*statev1alpha1.ModuleReference{
+			wantAppended: []*statev1beta1.ModuleReference{
 				ref("v2.0.0", "d2"),
 				ref("v2.1.0", "d2"),
 			},
@@ -88,19 +93,19 @@ func TestResolveAppendedRefs(t *testing.T) {
 		{
 			// base=1, head=1 → base[latest] is baseline, no appends
 			name:         "head_count_equals_base_count_not_supported",
-			baseRefs:     []*statev1alpha1.ModuleReference{ref("v1.0.0", "d1")},
-			headRefs:     []*statev1alpha1.ModuleReference{ref("v1.0.0", "d1")},
+			baseRefs:     []*statev1beta1.ModuleReference{ref("v1.0.0", "d1")},
+			headRefs:     []*statev1beta1.ModuleReference{ref("v1.0.0", "d1")},
 			wantCurrent:  ref("v1.0.0", "d1"),
 			wantAppended: nil,
 		},
 		{
 			// base=2, head=1 → base[latest] is baseline, no appends
 			name: "head_shorter_than_base_not_supported",
-			baseRefs: []*statev1alpha1.ModuleReference{
+			baseRefs: []*statev1beta1.ModuleReference{
 				ref("v1.0.0", "d1"),
 				ref("v2.0.0", "d2"),
 			},
-			headRefs:     []*statev1alpha1.ModuleReference{ref("v1.0.0", "d1")},
+			headRefs:     []*statev1beta1.ModuleReference{ref("v1.0.0", "d1")},
 			wantCurrent:  ref("v2.0.0", "d2"),
 			wantAppended: nil,
 		},
@@ -108,12 +113,12 @@ func TestResolveAppendedRefs(t *testing.T) {
 			// base=3, head=5, but the digests don't match. The function only looks at counts, not
 			// content: base[latest] is baseline, head[2:] are appended.
 			name: "existing_ref_modified_not_supported",
-			baseRefs: []*statev1alpha1.ModuleReference{
+			baseRefs: []*statev1beta1.ModuleReference{
 				ref("v1.0.0", "d1"),
 				ref("v2.0.0", "d2"),
 				ref("v3.0.0", "d3"),
 			},
-			headRefs: []*statev1alpha1.ModuleReference{
+			headRefs: []*statev1beta1.ModuleReference{
 				ref("v1.0.0", "d1-modified"),
 				ref("v2.0.0", "d2-modified"),
 				ref("v3.0.0", "d3-modified"),
@@ -121,7 +126,7 @@ func TestResolveAppendedRefs(t *testing.T) {
 				ref("v5.0.0", "d5"),
 			},
 			wantCurrent: ref("v3.0.0", "d3"), // the one from base, not from head
-			wantAppended: []*statev1alpha1.ModuleReference{
+			wantAppended: []*statev1beta1.ModuleReference{
 				ref("v4.0.0", "d4"),
 				ref("v5.0.0", "d5"),
 			},
@@ -137,102 +142,112 @@ func TestResolveAppendedRefs(t *testing.T) {
 	}
 }
 
-func TestParseLineNumbersFromDiff(t *testing.T) {
+func TestTransitionLineNumbers(t *testing.T) {
 	t.Parallel()
-	type testCase struct {
-		name          string
-		diffOutput    string
-		expectedCount int
-		want          []int
-	}
-	testCases := []testCase{
-		{
-			name: "single_digest_change",
-			diffOutput: `@@ -275,0 +276,6 @@
-+    },
-+    {
-+      "name": "v1.1.0",
-+      "digest": "aaa"
-+    }
-+  ]`,
-			expectedCount: 1,
-			want:          []int{279},
+	// Unusual but valid formatting: line numbers are resolved from the JSON positions, not from the
+	// layout of the file.
+	repo := fakeGitRepository{
+		"base": {
+			globalStatePath:     `{"modules": [{"module_name": "foo/bar", "latest_reference": "v1.0.0"}]}`,
+			testModuleStatePath: `{"references": [{"name": "v1.0.0", "digest": "aaa"}]}`,
 		},
-		{
-			name: "multiple_digest_changes",
-			diffOutput: `@@ -100,0 +101,12 @@
-+    },
-+    {
-+      "name": "v1.1.0",
-+      "digest": "aaa"
-+    },
-+    {
-+      "name": "v1.2.0",
-+      "digest": "bbb"
-+    },
-+    {
-+      "name": "v1.3.0",
-+      "digest": "ccc"`,
-			expectedCount: 3,
-			want:          []int{104, 108, 112},
-		},
-		{
-			name: "multiple_hunks",
-			diffOutput: `@@ -50,0 +51,4 @@
-+    {
-+      "name": "v2.0.0",
-+      "digest": "xxx"
-+    }
-@@ -100,0 +105,8 @@
-+    {
-+      "name": "v3.0.0",
-+      "digest": "yyy"
-+    },
-+    {
-+      "name": "v4.0.0",
-+      "digest": "zzz"
-+    }`,
-			expectedCount: 3,
-			want:          []int{53, 107, 111},
+		"head": {
+			globalStatePath: `{"modules": [
+{"latestReference": "v0.1.0", "moduleName": "foo/baz"},
+{"moduleName": "foo/bar",
+ "latestReference": "v1.2.0"}]}`,
+			testModuleStatePath: `{"references": [{"name": "v1.0.0", "digest": "aaa"},
+{"name": "v1.1.0", "digest": "bbb"}, {"name": "v1.1.1", "digest": "bbb"},
+{"digest": "ccc",
+
+"name": "v1.2.0"}]}`,
 		},
-		{
-			name: "no_digest_lines",
-			diffOutput: `@@ -10,0 +11,4 @@
-+    {
-+      "name": "v1.0.0",
-+      "other": "field"
-+    }`,
-			expectedCount: 1,
-			want:          []int{0},
+	}
+	stateRW, err := bufstate.NewReadWriter()
+	require.NoError(t, err)
+	transitions, err := getStateFileTransitions(t.Context(), stateRW, newRevisionSource(repo, "base"), newRevisionSource(repo, "head"), testModuleStatePath)
+	require.NoError(t, err)
+	require.Len(t, transitions, 2)
+	assert.Equal(t, 2, transitions[0].lineNumber)
+	assert.Equal(t, 3, transitions[1].lineNumber)
+	overallTransitions, err := getOverallTransitions(t.Context(), stateRW, newRevisionSource(repo, "base"), newRevisionSource(repo, "head"))
+	require.NoError(t, err)
+	require.Len(t, overallTransitions, 1)
+	assert.Equal(t, 4, overallTransitions[0].lineNumber)
+}
+
+func TestNewModuleTransitions(t *testing.T) {
+	t.Parallel()
+	repo := fakeGitRepository{
+		"base": {},
+		"head": {
+			testModuleStatePath: `{
+  "references": [
+    {
+      "name": "v1.0.0",
+      "digest": "aaa"
+    },
+    {
+      "name": "v1.1.0",
+      "digest": "bbb"
+    }
+  ]
+}`,
 		},
+	}
+	stateRW, err := bufstate.NewReadWriter()
+	require.NoError(t, err)
+	transitions, err := getStateFileTransitions(t.Context(), stateRW, newRevisionSource(repo, "base"), newRevisionSource(repo, "head"), testModuleStatePath)
+	require.NoError(t, err)
+	assert.Equal(t, []stateTransition{
 		{
-			name:          "empty_diff",
-			diffOutput:    "",
-			expectedCount: 1,
-			want:          []int{0},
+			modulePath:  "modules/sync/foo/bar",
+			filePath:    testModuleStatePath,
+			toRef:       "v1.0.0",
+			toDigest:    "aaa",
+			lineNumber:  5,
+			isNewModule: true,
 		},
 		{
-			name: "digest_with_formatting",
-			diffOutput: `@@ -200,0 +201,8 @@
-+{
-+"name": "v0.1.0",
-+"digest": "abc123"
-+},
-+		{
-+			"name": "v0.2.0",
-+			"digest": "def456"
-+		}`,
-			expectedCount: 2,
-			want:          []int{203, 207},
+			modulePath: "modules/sync/foo/bar",
+			filePath:   testModuleStatePath,
+			fromRef:    "v1.0.0",
+			toRef:      "v1.1.0",
+			fromDigest: "aaa",
+			toDigest:   "bbb",
+			lineNumber: 9,
 		},
+	}, transitions)
+	_, err = getStateFileTransitions(t.Context(), stateRW, newRevisionSource(repo, "unknown"), newRevisionSource(repo, "head"), testModuleStatePath)
+	require.Error(t, err)
+}
+
+// newRevisionSource returns the source of the root sync directory of the repository at the ref.
+func newRevisionSource(repo gitutil.Repository, ref string) bufstate.Source {
+	return gitutil.NewRevisionSource(repo, ref, bufstate.SyncRoot)
+}
+
+// fakeGitRepository is a gitutil.Repository of file contents by path, by ref.
+type fakeGitRepository map[string]map[string]string
+
+func (r fakeGitRepository) ReadFile(_ context.Context, ref string, path string) ([]byte, error) {
+	files, ok := r[ref]
+	if !ok {
+		return nil, fmt.Errorf("unknown ref %s", ref)
 	}
+	content, ok := files[path]
+	if !ok {
+		return nil, fmt.Errorf("%s:%s: %w", ref, path, fs.ErrNotExist)
+	}
+	return []byte(content), nil
+}
 
-	for _, tc := range testCases {
-		t.Run(tc.name, func(t *testing.T) {
-			t.Parallel()
-			got, err := parseLineNumbersFromDiff(tc.diffOutput, tc.expectedCount)
-			require.NoError(t, err)
-			assert.Equal(t, tc.want, got)
-		})
+func (r fakeGitRepository) ChangedFiles(_ context.Context, baseRef string, headRef string) ([]string, error) {
+	var changedFiles []string
+	for path, headContent := range r[headRef] {
+		if baseContent, ok := r[baseRef][path]; !ok || baseContent != headContent {
+			changedFiles = append(changedFiles, path)
+		}
 	}
+	return changedFiles, nil
 }
@@ -15,19 +15,21 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/hex"
 	"flag"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/bufbuild/buf/private/pkg/cas"
 	"github.com/bufbuild/buf/private/pkg/storage/storageos"
+	"github.com/bufbuild/modules/private/bufpkg/bufcas"
 	"github.com/bufbuild/modules/private/bufpkg/bufstate"
+	statev1beta1 "github.com/bufbuild/modules/private/gen/modules/state/v1beta1"
 	"go.uber.org/multierr"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 const (
@@ -36,14 +38,36 @@ const (
 	ownerFlagName       = "owner"
 	repoFlagName        = "repo"
 	refFlagName         = "ref"
+	casLayoutFlagName   = "cas-layout"
+
+	commitFlagName              = "commit"
+	refKindFlagName             = "ref-kind"
+	sourceConfigVersionFlagName = "source-config-version"
+	toolVersionFlagName         = "tool-version"
+
+	supersedeReasonFlagName = "supersede-reason"
 )
 
+//nolint:gochecknoglobals // treated as consts
+var refKindStringToReferenceKind = map[string]statev1beta1.ReferenceKind{
+	"tag":    statev1beta1.ReferenceKind_REFERENCE_KIND_TAG,
+	"commit": statev1beta1.ReferenceKind_REFERENCE_KIND_COMMIT,
+}
+
 type command struct {
 	rootSyncDir string
 	srcDir      string
 	owner       string
 	repo        string
 	ref         string
+	casLayout   bufcas.Layout // Zero to keep the layout of the module CAS directory.
+
+	commit              string
+	refKind             statev1beta1.ReferenceKind
+	sourceConfigVersion string
+	toolVersion         string
+
+	supersedeReason string // Empty to append a new reference.
 }
 
 func newCmd(
@@ -52,6 +76,12 @@ func newCmd(
 	owner string,
 	repo string,
 	modRef string,
+	casLayout string,
+	commit string,
+	refKind string,
+	sourceConfigVersion string,
+	toolVersion string,
+	supersedeReason string,
 ) (*command, error) {
 	var err error
 	if len(rootSyncDir) == 0 {
@@ -69,6 +99,20 @@ func newCmd(
 	if len(modRef) == 0 {
 		err = multierr.Append(err, fmt.Errorf("%s is required", refFlagName))
 	}
+	var layout bufcas.Layout
+	if len(casLayout) > 0 {
+		var parseErr error
+		if layout, parseErr = bufcas.ParseLayout(casLayout); parseErr != nil {
+			err = multierr.Append(err, fmt.Errorf("%s: %w", casLayoutFlagName, parseErr))
+		}
+	}
+	var referenceKind statev1beta1.ReferenceKind
+	if len(refKind) > 0 {
+		var ok bool
+		if referenceKind, ok = refKindStringToReferenceKind[refKind]; !ok {
+			err = multierr.Append(err, fmt.Errorf("%s: unknown reference kind %q, expected tag or commit", refKindFlagName, refKind))
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -78,6 +122,14 @@ func newCmd(
 		owner:       owner,
 		repo:        repo,
 		ref:         modRef,
+		casLayout:   layout,
+
+		commit:              commit,
+		refKind:             referenceKind,
+		sourceConfigVersion: sourceConfigVersion,
+		toolVersion:         toolVersion,
+
+		supersedeReason: supersedeReason,
 	}, nil
 }
 
@@ -88,6 +140,14 @@ func main() {
 		owner       = flag.String(ownerFlagName, "", "Managed module owner name.")
 		repo        = flag.String(repoFlagName, "", "Managed module repository name.")
 		ref         = flag.String(refFlagName, "", "Managed module reference that matches the contents in the source directory.")
+		casLayout   = flag.String(casLayoutFlagName, "", "Layout to write new blobs in, loose or packed. Defaults to the current layout of the module CAS directory.")
+
+		commit              = flag.String(commitFlagName, "", "Full SHA of the upstream commit the reference resolved to.")
+		refKind             = flag.String(refKindFlagName, "", "Kind of the reference, tag or commit.")
+		sourceConfigVersion = flag.String(sourceConfigVersionFlagName, "", "Version of the buf configuration of the upstream source, v1 or v2.")
+		toolVersion         = flag.String(toolVersionFlagName, "", "Version of the tools that validated the reference before it was synced.")
+
+		supersedeReason = flag.String(supersedeReasonFlagName, "", "Supersede the digest of the existing reference instead of appending it, with this reason, e.g. after the upstream tag was recreated.")
 	)
 	flag.Parse()
 	cmd, err := newCmd(
@@ -96,6 +156,12 @@ func main() {
 		*owner,
 		*repo,
 		*ref,
+		*casLayout,
+		*commit,
+		*refKind,
+		*sourceConfigVersion,
+		*toolVersion,
+		*supersedeReason,
 	)
 	if err != nil {
 		_, _ = fmt.Fprintf(os.Stderr, "cannot run mod processor: %v\n\nusage: modprocessor [flags]\n\n", err)
@@ -120,15 +186,49 @@ func (c *command) run() error {
 		return fmt.Errorf("new state read writer: %w", err)
 	}
 	manifestHexDigest := hex.EncodeToString(manifestDigest.Value())
-	if err := stateRW.AppendModuleReference(c.rootSyncDir, c.owner, c.repo, c.ref, manifestHexDigest); err != nil {
-		return fmt.Errorf("update mod reference: %w", err)
-	}
-	return nil
+	now := time.Now().UTC()
+	syncMetadata := statev1beta1.SyncMetadata_builder{
+		SyncTime:            timestamppb.New(now),
+		Commit:              c.commit,
+		Kind:                c.refKind,
+		SourceConfigVersion: c.sourceConfigVersion,
+		ToolVersion:         c.toolVersion,
+	}.Build()
+	appendOption := bufstate.AppendModuleReferenceWithSyncMetadata(syncMetadata)
+	return stateRW.UpdateSyncDir(ctx, c.rootSyncDir, func(update *bufstate.SyncDirUpdate) error {
+		version, err := update.ModuleStateVersion(c.owner, c.repo)
+		if err != nil {
+			return err
+		}
+		if version == bufstate.VersionV1Alpha1 && c.hasSyncMetadata() {
+			return fmt.Errorf(
+				"module state of %s/%s is %s, which has no sync metadata, migrate it to %s first",
+				c.owner, c.repo, bufstate.VersionV1Alpha1, bufstate.VersionV1Beta1,
+			)
+		}
+		if c.supersedeReason != "" {
+			if err := update.SupersedeModuleReference(c.owner, c.repo, c.ref, manifestHexDigest, c.supersedeReason, now, appendOption); err != nil {
+				return fmt.Errorf("supersede mod reference: %w", err)
+			}
+			return nil
+		}
+		if err := update.AppendModuleReference(c.owner, c.repo, c.ref, manifestHexDigest, appendOption); err != nil {
+			return fmt.Errorf("update mod reference: %w", err)
+		}
+		return nil
+	})
+}
+
+// hasSyncMetadata returns true if any sync metadata besides the sync time was passed, which is lost
+// in state.v1alpha1 module state files.
+func (c *command) hasSyncMetadata() bool {
+	return c.commit != "" || c.refKind != statev1beta1.ReferenceKind_REFERENCE_KIND_UNSPECIFIED ||
+		c.sourceConfigVersion != "" || c.toolVersion != ""
 }
 
-// convertToCAS converts all files in the source directory to blobs, and a saves
-// them in the module destination directory using its manifest digest hex string
-// as filenames.
+// convertToCAS converts all files in the source directory to blobs, and saves
+// them in the module CAS directory, either as files named by their digest hex
+// string or in a new pack, depending on the CAS layout.
 func (c *command) convertToCAS(ctx context.Context) (cas.Digest, error) {
 	storageosProvider := storageos.NewProvider()
 	bucket, err := storageosProvider.NewReadWriteBucket(c.srcDir)
@@ -143,48 +243,15 @@ func (c *command) convertToCAS(ctx context.Context) (cas.Digest, error) {
 	if err != nil {
 		return nil, fmt.Errorf("manifest to blob: %w", err)
 	}
-	modSyncDir := filepath.Join(c.rootSyncDir, c.owner, c.repo, "cas")
-	// mkdir directory in case this is the first time a reference is being synced for this module.
-	if err := os.MkdirAll(modSyncDir, 0755); err != nil {
-		return nil, fmt.Errorf("make module sync cas dir: %w", err)
-	}
-	// TODO: parallelize
-	for _, blob := range append([]cas.Blob{manifestBlob}, fileSet.BlobSet().Blobs()...) {
-		if err := writeBlobInDir(blob, modSyncDir); err != nil {
-			hexDigest := hex.EncodeToString(blob.Digest().Value())
-			return nil, fmt.Errorf("write blob %q to file: %w", hexDigest, err)
+	casDir := filepath.Join(c.rootSyncDir, c.owner, c.repo, bufcas.CASDirName)
+	layout := c.casLayout
+	if layout == 0 {
+		if layout, err = bufcas.DetectLayout(casDir); err != nil {
+			return nil, fmt.Errorf("detect cas layout: %w", err)
 		}
 	}
-	return manifestBlob.Digest(), nil
-}
-
-// writeBlobInDir takes a blob and writes its content to a file named as its
-// digest hex in the given directory (only if it doesn't exist already).
-func writeBlobInDir(blob cas.Blob, dir string) (retErr error) {
-	fileName := hex.EncodeToString(blob.Digest().Value())
-	filePath := filepath.Join(dir, fileName)
-	_, err := os.Stat(filePath)
-	if err == nil {
-		// no error, file exists
-		return nil
-	}
-	// some error
-	if !os.IsNotExist(err) {
-		// some error different from "not exists"
-		return fmt.Errorf("stat file: %w", err)
-	}
-	// error is "not exists", we'll create it
-	file, err := os.Create(filePath)
-	if err != nil {
-		return fmt.Errorf("create file: %w", err)
-	}
-	defer func() {
-		if err := file.Close(); err != nil {
-			retErr = multierr.Append(retErr, fmt.Errorf("close file: %w", err))
-		}
-	}()
-	if _, err := io.Copy(file, bytes.NewReader(blob.Content())); err != nil {
-		return fmt.Errorf("copy to file: %w", err)
+	if err := bufcas.WriteBlobs(ctx, casDir, layout, append([]cas.Blob{manifestBlob}, fileSet.BlobSet().Blobs()...)); err != nil {
+		return nil, fmt.Errorf("write blobs: %w", err)
 	}
-	return nil
+	return manifestBlob.Digest(), nil
 }
@@ -0,0 +1,159 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bufbuild/modules/private/bufpkg/bufstate"
+	statev1beta1 "github.com/bufbuild/modules/private/gen/modules/state/v1beta1"
+	"go.uber.org/multierr"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	rootSyncDirFlagName = "root-sync-dir"
+	reasonFlagName      = "reason"
+	replacementFlagName = "replacement"
+	undeprecateFlagName = "undeprecate"
+	removeFlagName      = "remove"
+)
+
+type command struct {
+	rootSyncDir string
+	ownerName   string
+	repoName    string
+	reason      string
+	replacement string
+	undeprecate bool
+	remove      bool
+}
+
+func newCmd(
+	rootSyncDir string,
+	args []string,
+	reason string,
+	replacement string,
+	undeprecate bool,
+	remove bool,
+) (*command, error) {
+	var err error
+	if len(rootSyncDir) == 0 {
+		err = multierr.Append(err, fmt.Errorf("%s is required", rootSyncDirFlagName))
+	}
+	var ownerName, repoName string
+	if len(args) != 1 {
+		err = multierr.Append(err, errors.New("expected a single <owner>/<repo> module argument"))
+	} else {
+		var ok bool
+		ownerName, repoName, ok = strings.Cut(args[0], "/")
+		if !ok || ownerName == "" || repoName == "" || strings.Contains(repoName, "/") {
+			err = multierr.Append(err, fmt.Errorf("module %q is not in the shape of <owner>/<repo>", args[0]))
+		}
+	}
+	var actions int
+	for _, action := range []bool{reason != "", undeprecate, remove} {
+		if action {
+			actions++
+		}
+	}
+	if actions != 1 {
+		err = multierr.Append(err, fmt.Errorf("exactly one of %s, %s or %s is required", reasonFlagName, undeprecateFlagName, removeFlagName))
+	}
+	if replacement != "" && reason == "" {
+		err = multierr.Append(err, fmt.Errorf("%s requires %s", replacementFlagName, reasonFlagName))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &command{
+		rootSyncDir: rootSyncDir,
+		ownerName:   ownerName,
+		repoName:    repoName,
+		reason:      reason,
+		replacement: replacement,
+		undeprecate: undeprecate,
+		remove:      remove,
+	}, nil
+}
+
+func main() {
+	var (
+		rootSyncDir = flag.String(rootSyncDirFlagName, "", "Root sync directory where all the managed modules live.")
+		reason      = flag.String(reasonFlagName, "", "Deprecate the module with this reason.")
+		replacement = flag.String(replacementFlagName, "", "The managed module that replaces the deprecated module, in the shape of <owner>/<repo>.")
+		undeprecate = flag.Bool(undeprecateFlagName, false, "Clear the deprecation of the module.")
+		remove      = flag.Bool(removeFlagName, false, "Remove the module from the global state, with its module directory and CAS.")
+	)
+	flag.Parse()
+	cmd, err := newCmd(
+		*rootSyncDir,
+		flag.Args(),
+		*reason,
+		*replacement,
+		*undeprecate,
+		*remove,
+	)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "cannot run module retire: %v\n\nusage: moduleretire [flags] <owner>/<repo>\n\n", err)
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	if err := cmd.run(); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "moduleretire failed: %v\n", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// run deprecates, undeprecates or removes the module in a single state update.
+func (c *command) run() error {
+	stateRW, err := bufstate.NewReadWriter()
+	if err != nil {
+		return fmt.Errorf("new state read writer: %w", err)
+	}
+	moduleName := c.ownerName + "/" + c.repoName
+	if err := stateRW.UpdateSyncDir(context.Background(), c.rootSyncDir, func(update *bufstate.SyncDirUpdate) error {
+		switch {
+		case c.remove:
+			return update.RemoveModule(c.ownerName, c.repoName)
+		case c.undeprecate:
+			return update.UndeprecateModule(c.ownerName, c.repoName)
+		default:
+			return update.DeprecateModule(c.ownerName, c.repoName, statev1beta1.Deprecation_builder{
+				Reason:        c.reason,
+				Replacement:   c.replacement,
+				DeprecateTime: timestamppb.New(time.Now().UTC()),
+			}.Build())
+		}
+	}); err != nil {
+		return err
+	}
+	switch {
+	case c.remove:
+		_, _ = fmt.Fprintf(os.Stdout, "removed %s, remove its sync from scripts/fetch.sh too\n", moduleName)
+	case c.undeprecate:
+		_, _ = fmt.Fprintf(os.Stdout, "undeprecated %s\n", moduleName)
+	default:
+		_, _ = fmt.Fprintf(os.Stdout, "deprecated %s\n", moduleName)
+	}
+	return nil
+}
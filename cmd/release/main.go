@@ -15,10 +15,13 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"slices"
@@ -27,24 +30,45 @@ import (
 	"time"
 
 	"github.com/bufbuild/modules/internal/githubutil"
+	"github.com/bufbuild/modules/internal/gitutil"
 	"github.com/bufbuild/modules/internal/modules"
+	"github.com/bufbuild/modules/internal/statesource"
 	"github.com/bufbuild/modules/private/bufpkg/bufstate"
-	statev1alpha1 "github.com/bufbuild/modules/private/gen/modules/state/v1alpha1"
+	statev1beta1 "github.com/bufbuild/modules/private/gen/modules/state/v1beta1"
 	"github.com/google/go-github/v64/github"
 	"go.uber.org/multierr"
+	"google.golang.org/protobuf/proto"
 )
 
 type command struct {
 	dryRun bool
+	state  string
 }
 
 type releaseModuleState struct {
 	status     modules.Status
-	references []*statev1alpha1.ModuleReference
+	references []*statev1beta1.ModuleReference
+	// deprecation is set if the module was deprecated, or its deprecation changed, since the
+	// previous release.
+	deprecation *statev1beta1.Deprecation
+	// supersededReferences are the references superseded since the previous release.
+	supersededReferences []supersededReference
+}
+
+// supersededReference is a supersession of a module reference, with the digest that superseded it.
+type supersededReference struct {
+	name         string
+	digest       string
+	supersession *statev1beta1.Supersession
 }
 
 func main() {
 	dryRun := flag.Bool("dry-run", false, "perform a dry-run (no GitHub modifications)")
+	state := flag.String(
+		"state",
+		statesource.WorkTree,
+		fmt.Sprintf("state to release, %s or %s<revision>", statesource.WorkTree, statesource.GitPrefix),
+	)
 	flag.Parse()
 
 	if len(flag.Args()) != 1 {
@@ -52,8 +76,13 @@ func main() {
 		flag.PrintDefaults()
 		os.Exit(2)
 	}
+	if strings.HasPrefix(*state, statesource.ReleasePrefix) {
+		_, _ = fmt.Fprintln(flag.CommandLine.Output(), "-state cannot be a release, releases have no module state files")
+		os.Exit(2)
+	}
 	cmd := &command{
 		dryRun: *dryRun,
+		state:  *state,
 	}
 	if err := cmd.run(); err != nil {
 		_, _ = fmt.Fprintf(os.Stderr, "run release : %v\n", err)
@@ -81,40 +110,66 @@ func (c *command) run() (retErr error) {
 	if err != nil && !errors.Is(err, githubutil.ErrNotFound) {
 		return fmt.Errorf("retrieve latest release: %w", err)
 	}
-	var prevReleaseState *statev1alpha1.GlobalState
+	stateRW, err := bufstate.NewReadWriter()
+	if err != nil {
+		return fmt.Errorf("new state read writer: %w", err)
+	}
+	var prevReleaseState *statev1beta1.GlobalState
 	if prevRelease != nil {
-		prevReleaseState, err = githubClient.DownloadReleaseState(ctx, prevRelease)
+		prevReleaseState, err = stateRW.ReadSourceGlobalState(ctx, githubClient.NewReleaseSource(prevRelease))
 		if err != nil {
 			return err
 		}
 	}
-	stateRW, err := bufstate.NewReadWriter()
+	source, err := statesource.New(ctx, c.state, bufstate.SyncRoot)
 	if err != nil {
-		return fmt.Errorf("new state read writer: %w", err)
+		return err
 	}
-	globalStateFilePath := filepath.Join(bufstate.SyncRoot, bufstate.GlobalStateFileName)
-	var currentReleaseState *statev1alpha1.GlobalState
-	if _, err := os.Stat(globalStateFilePath); err != nil {
-		if !os.IsNotExist(err) {
-			return fmt.Errorf("stat file: %w", err)
+	// The global state file of the source is uploaded as is as the release asset, so copy it to the
+	// temporary directory, as the source is not necessarily the working tree.
+	globalStateFilePath := filepath.Join(tmpDir, bufstate.GlobalStateFileName)
+	currentReleaseState := &statev1beta1.GlobalState{}
+	globalStateData, err := source.ReadFile(ctx, bufstate.GlobalStateFileName)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("read global state from %s: %w", source, err)
 		}
-		currentReleaseState = &statev1alpha1.GlobalState{}
 	} else {
-		globalStateFile, err := os.Open(globalStateFilePath)
+		currentReleaseState, err = stateRW.ReadGlobalState(io.NopCloser(bytes.NewReader(globalStateData)))
 		if err != nil {
-			return fmt.Errorf("open file: %w", err)
+			return fmt.Errorf("read global state from %s: %w", source, err)
 		}
-		currentReleaseState, err = stateRW.ReadGlobalState(globalStateFile)
-		if err != nil {
-			return fmt.Errorf("read local state file: %w", err)
+		if err := os.WriteFile(globalStateFilePath, globalStateData, 0600); err != nil {
+			return fmt.Errorf("write global state file: %w", err)
 		}
 	}
 	prevMap := mapGlobalStateReferences(prevReleaseState)
 	currentMap := mapGlobalStateReferences(currentReleaseState)
-	modulesStates, err := calculateModulesStates(stateRW, bufstate.SyncRoot, prevMap, currentMap)
+	modulesStates, err := calculateModulesStates(ctx, stateRW, source, prevMap, currentMap)
 	if err != nil {
 		return fmt.Errorf("produce new module list: %w", err)
 	}
+	setNewDeprecations(modulesStates, prevReleaseState, currentReleaseState)
+	repo, err := gitutil.OpenGoGitRepository(".")
+	if err != nil {
+		return err
+	}
+	// Releases are tagged on the commit of the state they release, so the next release can compare
+	// against it.
+	stateRevision := "HEAD"
+	if revision, ok := strings.CutPrefix(c.state, statesource.GitPrefix); ok {
+		stateRevision = revision
+	}
+	stateCommit, err := repo.ResolveCommit(stateRevision)
+	if err != nil {
+		return fmt.Errorf("resolve commit of the state to release: %w", err)
+	}
+	if prevRelease != nil {
+		prevSource := gitutil.NewRevisionSource(repo, prevRelease.GetTagName(), bufstate.SyncRoot)
+		if err := setSupersededReferences(ctx, stateRW, source, prevSource, modulesStates, currentMap); err != nil {
+			return fmt.Errorf("find superseded references: %w", err)
+		}
+	}
 	if !shouldRelease(modulesStates) {
 		errMsg := "no changes to modules - not creating initial release"
 		if tagName := prevRelease.GetTagName(); tagName != "" {
@@ -138,13 +193,13 @@ func (c *command) run() (retErr error) {
 		_, _ = fmt.Fprintf(os.Stdout, "release assets created in %q\n", tmpDir)
 		return nil
 	}
-	if err := createRelease(ctx, githubClient, releaseName, modulesStates); err != nil {
+	if err := createRelease(ctx, githubClient, releaseName, stateCommit, modulesStates, globalStateFilePath); err != nil {
 		return fmt.Errorf("create GitHub release: %w", err)
 	}
 	return nil
 }
 
-func mapGlobalStateReferences(globalState *statev1alpha1.GlobalState) map[string]string {
+func mapGlobalStateReferences(globalState *statev1beta1.GlobalState) map[string]string {
 	if globalState == nil || len(globalState.GetModules()) == 0 {
 		return nil
 	}
@@ -155,17 +210,113 @@ func mapGlobalStateReferences(globalState *statev1alpha1.GlobalState) map[string
 	return stateMap
 }
 
-// calculateModulesStates accepts the repository's checked out module manifest file `current`, as
+// setNewDeprecations sets the deprecation of the modules in `current` that were deprecated, or whose
+// deprecation changed, since `prev`.
+func setNewDeprecations(
+	modulesStates map[string]releaseModuleState,
+	prev *statev1beta1.GlobalState,
+	current *statev1beta1.GlobalState,
+) {
+	prevDeprecations := make(map[string]*statev1beta1.Deprecation, len(prev.GetModules()))
+	for _, module := range prev.GetModules() {
+		prevDeprecations[module.GetModuleName()] = module.GetDeprecation()
+	}
+	for _, module := range current.GetModules() {
+		if !module.HasDeprecation() || proto.Equal(module.GetDeprecation(), prevDeprecations[module.GetModuleName()]) {
+			continue
+		}
+		modState := modulesStates[module.GetModuleName()]
+		modState.deprecation = module.GetDeprecation()
+		modulesStates[module.GetModuleName()] = modState
+	}
+}
+
+// setSupersededReferences sets the references of the modules in `current` that were superseded since
+// the previous release, that is the supersessions in their module state files in `source` that are
+// not in `prevSource`, the state at the git tag of the previous release. Supersede times are not
+// used, as a supersession can be recorded before the previous release and merged after it.
+func setSupersededReferences(
+	ctx context.Context,
+	stateRW *bufstate.ReadWriter,
+	source bufstate.Source,
+	prevSource bufstate.Source,
+	modulesStates map[string]releaseModuleState,
+	current map[string]string,
+) error {
+	for moduleName := range current {
+		moduleManifest, err := readModuleState(ctx, stateRW, source, moduleName)
+		if err != nil {
+			return err
+		}
+		prevModuleManifest, err := readModuleState(ctx, stateRW, prevSource, moduleName)
+		if err != nil {
+			return fmt.Errorf("read module state of the previous release: %w", err)
+		}
+		prevSupersessions := make(map[string][]*statev1beta1.Supersession, len(prevModuleManifest.GetReferences()))
+		for _, reference := range prevModuleManifest.GetReferences() {
+			prevSupersessions[reference.GetName()] = reference.GetSupersessions()
+		}
+		var supersededReferences []supersededReference
+		for _, reference := range moduleManifest.GetReferences() {
+			supersessions := reference.GetSupersessions()
+			for i, supersession := range supersessions {
+				if slices.ContainsFunc(prevSupersessions[reference.GetName()], func(prevSupersession *statev1beta1.Supersession) bool {
+					return proto.Equal(prevSupersession, supersession)
+				}) {
+					continue // already released
+				}
+				// superseded by the previous digest of the next supersession, or the current digest
+				digest := reference.GetDigest()
+				if i+1 < len(supersessions) {
+					digest = supersessions[i+1].GetPreviousDigest()
+				}
+				supersededReferences = append(supersededReferences, supersededReference{
+					name:         reference.GetName(),
+					digest:       digest,
+					supersession: supersession,
+				})
+			}
+		}
+		if len(supersededReferences) == 0 {
+			continue
+		}
+		modState := modulesStates[moduleName]
+		modState.supersededReferences = supersededReferences
+		modulesStates[moduleName] = modState
+	}
+	return nil
+}
+
+// readModuleState reads the module state file of the module in `source`, or returns an empty module
+// state if it does not exist.
+func readModuleState(
+	ctx context.Context,
+	stateRW *bufstate.ReadWriter,
+	source bufstate.Source,
+	moduleName string,
+) (*statev1beta1.ModuleState, error) {
+	moduleManifest, err := stateRW.ReadSourceModuleState(ctx, source, moduleName)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return &statev1beta1.ModuleState{}, nil
+		}
+		return nil, fmt.Errorf("retrieve module state: %w", err)
+	}
+	return moduleManifest, nil
+}
+
+// calculateModulesStates accepts the module manifest file `current` of the state to release, as
 // well as the `prev` from the latest published release. It will build a list of all modules
 // `updatedModules` that have not yet been released and the last version of that module, if present,
 // that was released. Using the `updatedModules` list, it will load the individual module's manifest
-// file from the module`s directory, and determine which versions of the module have not been
+// file from `source`, and determine which versions of the module have not been
 // released by comparing it against the last released version of the module. The resultant list
 // tells us which modules and which of their references have not been released, and the state of
 // each of the modules, whether they're new, updated, or unchanged.
 func calculateModulesStates(
+	ctx context.Context,
 	stateRW *bufstate.ReadWriter,
-	dir string,
+	source bufstate.Source,
 	prev map[string]string,
 	current map[string]string,
 ) (map[string]releaseModuleState, error) {
@@ -213,49 +364,29 @@ func calculateModulesStates(
 	}
 
 	for _, updatedModule := range updatedModules {
-		modFilePath := filepath.Join(dir, updatedModule.Name, bufstate.ModStateFileName)
-		var moduleManifest *statev1alpha1.ModuleState
-		if _, err := os.Stat(modFilePath); err != nil {
-			if !os.IsNotExist(err) {
-				return nil, fmt.Errorf("stat file: %w", err)
-			}
-			moduleManifest = &statev1alpha1.ModuleState{}
-		} else {
-			modStateFile, err := os.Open(modFilePath)
-			if err != nil {
-				return nil, fmt.Errorf("open file: %w", err)
-			}
-			moduleManifest, err = stateRW.ReadModStateFile(modStateFile)
-			if err != nil {
-				return nil, fmt.Errorf("retrieve module state: %w", err)
-			}
+		moduleManifest, err := readModuleState(ctx, stateRW, source, updatedModule.Name)
+		if err != nil {
+			return nil, err
 		}
+		history := bufstate.NewModuleHistory(moduleManifest)
 		if updatedModule.LastReleasedReference == "" {
 			moduleReferences[updatedModule.Name] = releaseModuleState{
 				status:     modules.New,
-				references: moduleManifest.GetReferences(),
+				references: history.References(),
 			}
 			continue
 		}
-		for index, reference := range moduleManifest.GetReferences() {
-			if reference.GetName() == updatedModule.LastReleasedReference {
-				if len(moduleManifest.GetReferences()) == index-1 {
-					return nil, fmt.Errorf("module indicated as having updates, but previous release %s is the latest", updatedModule.LastReleasedReference)
-				}
-				moduleReferences[updatedModule.Name] = releaseModuleState{
-					status:     modules.Updated,
-					references: moduleManifest.GetReferences()[index+1:],
-				}
-				break
-			}
-		}
-		if _, ok := moduleReferences[updatedModule.Name]; !ok {
+		references, ok := history.After(updatedModule.LastReleasedReference)
+		if !ok {
 			// if no match was found, it means the previous latest reference was gone, so take all refs
 			// as updated
-			moduleReferences[updatedModule.Name] = releaseModuleState{
-				status:     modules.Updated,
-				references: moduleManifest.GetReferences(),
-			}
+			references = history.References()
+		} else if len(references) == 0 {
+			return nil, fmt.Errorf("module indicated as having updates, but previous release %s is the latest", updatedModule.LastReleasedReference)
+		}
+		moduleReferences[updatedModule.Name] = releaseModuleState{
+			status:     modules.Updated,
+			references: references,
 		}
 	}
 	return moduleReferences, nil
@@ -281,11 +412,12 @@ func calculateNextRelease(now time.Time, prevRelease *github.RepositoryRelease)
 	return currentDate + "." + strconv.Itoa(currentRevision+1), nil
 }
 
-// shouldRelease checks if any module status is different than "unchanged", so we do releases for
-// new, updated, or removed modules.
+// shouldRelease checks if any module status is different than "unchanged", or any module was
+// deprecated or had references superseded, so we do releases for new, updated, removed, deprecated,
+// or superseded modules.
 func shouldRelease(modulesStates map[string]releaseModuleState) bool {
 	for _, state := range modulesStates {
-		if state.status != modules.Unchanged {
+		if state.status != modules.Unchanged || state.deprecation != nil || len(state.supersededReferences) > 0 {
 			return true
 		}
 	}
@@ -296,7 +428,9 @@ func createRelease(
 	ctx context.Context,
 	client *githubutil.Client,
 	releaseName string,
+	targetCommit string,
 	modules map[string]releaseModuleState,
+	globalStateFilePath string,
 ) error {
 	releaseBody, err := createReleaseBody(releaseName, modules)
 	if err != nil {
@@ -306,10 +440,11 @@ func createRelease(
 		githubutil.GithubOwnerBufbuild,
 		githubutil.GithubRepoModules,
 		&github.RepositoryRelease{
-			TagName: &releaseName,
-			Name:    &releaseName,
-			Body:    &releaseBody,
-			Draft:   new(true), // Start release as a draft until all assets are uploaded
+			TagName:         &releaseName,
+			TargetCommitish: &targetCommit,
+			Name:            &releaseName,
+			Body:            &releaseBody,
+			Draft:           new(true), // Start release as a draft until all assets are uploaded
 		})
 	if err != nil {
 		return err
@@ -319,7 +454,7 @@ func createRelease(
 		githubutil.GithubOwnerBufbuild,
 		githubutil.GithubRepoModules,
 		repositoryRelease.GetID(),
-		filepath.Join(bufstate.SyncRoot, bufstate.GlobalStateFileName),
+		globalStateFilePath,
 	); err != nil {
 		return err
 	}
@@ -346,9 +481,19 @@ func createReleaseBody(name string, moduleStates map[string]releaseModuleState)
 		sortedModNames = append(sortedModNames, modName)
 	}
 	slices.Sort(sortedModNames)
-	var newStringBuilder, updatedStringBuilder, unchangedStringBuilder, removedStringBuilder strings.Builder
+	var newStringBuilder, updatedStringBuilder, supersededStringBuilder, deprecatedStringBuilder, unchangedStringBuilder, removedStringBuilder strings.Builder
 	for _, modName := range sortedModNames {
 		modState := moduleStates[modName]
+		if len(modState.supersededReferences) > 0 {
+			if err := writeSupersededReferencesTable(&supersededStringBuilder, modName, modState.supersededReferences); err != nil {
+				return "", fmt.Errorf("write superseded references table: %w", err)
+			}
+		}
+		if modState.deprecation != nil {
+			if err := writeDeprecation(&deprecatedStringBuilder, modName, modState.deprecation); err != nil {
+				return "", fmt.Errorf("write deprecated modules list: %w", err)
+			}
+		}
 		switch modState.status {
 		case modules.New:
 			if err := writeUpdatedReferencesTable(&newStringBuilder, modName, modState.references); err != nil {
@@ -385,6 +530,20 @@ func createReleaseBody(name string, moduleStates map[string]releaseModuleState)
 		}
 	}
 
+	if superseded := supersededStringBuilder.String(); superseded != "" {
+		supersededReferenceHeader := "## Superseded References\n"
+		if _, err := fmt.Fprintf(&mainStringBuilder, "%s%s\n", supersededReferenceHeader, superseded); err != nil {
+			return "", err
+		}
+	}
+
+	if deprecated := deprecatedStringBuilder.String(); deprecated != "" {
+		deprecatedModuleHeader := "## Deprecated Modules\n\n"
+		if _, err := fmt.Fprintf(&mainStringBuilder, "%s%s\n", deprecatedModuleHeader, deprecated); err != nil {
+			return "", err
+		}
+	}
+
 	if unchanged := unchangedStringBuilder.String(); unchanged != "" {
 		unchangedModuleHeader := "## Unchanged Modules\n\n<details><summary>Expand</summary>\n"
 		if _, err := fmt.Fprintf(&mainStringBuilder, "%s\n%s\n</details>\n", unchangedModuleHeader, unchanged); err != nil {
@@ -402,15 +561,68 @@ func createReleaseBody(name string, moduleStates map[string]releaseModuleState)
 	return mainStringBuilder.String(), nil
 }
 
+// writeSupersededReferencesTable writes a table with the superseded references of a module, with
+// their new and previous digests.
+func writeSupersededReferencesTable(
+	stringBuilder *strings.Builder,
+	moduleName string,
+	supersededReferences []supersededReference,
+) error {
+	if _, err := fmt.Fprintf(stringBuilder,
+		"\n<details><summary>%s: %d superseded reference(s)</summary>\n\n%s",
+		moduleName, len(supersededReferences),
+		"| Reference | Manifest Digest | Previous Manifest Digest | Superseded | Reason |\n|---|---|---|---|---|\n",
+	); err != nil {
+		return err
+	}
+	for _, ref := range supersededReferences {
+		if _, err := fmt.Fprintf(stringBuilder,
+			"| `%s` | `%s` | `%s` | %s | %s |\n",
+			ref.name,
+			ref.digest,
+			ref.supersession.GetPreviousDigest(),
+			ref.supersession.GetSupersedeTime().AsTime().UTC().Format(time.RFC3339),
+			// pipes would end the cell
+			strings.ReplaceAll(ref.supersession.GetReason(), "|", `\|`),
+		); err != nil {
+			return err
+		}
+	}
+	if _, err := stringBuilder.WriteString("\n</details>\n"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// writeDeprecation writes a list item with the module deprecation reason, and its replacement if any.
+func writeDeprecation(
+	stringBuilder *strings.Builder,
+	moduleName string,
+	deprecation *statev1beta1.Deprecation,
+) error {
+	item := fmt.Sprintf("- %s: %s", moduleName, deprecation.GetReason())
+	if replacement := deprecation.GetReplacement(); replacement != "" {
+		item += fmt.Sprintf(" (replaced by `%s`)", replacement)
+	}
+	_, err := fmt.Fprintln(stringBuilder, item)
+	return err
+}
+
 func writeUpdatedReferencesTable(
 	stringBuilder *strings.Builder,
 	moduleName string,
-	references []*statev1alpha1.ModuleReference,
+	references []*statev1beta1.ModuleReference,
 ) error {
 	refCount := len(references)
+	// Commit and sync time columns are only added if some reference was synced with sync metadata.
+	withSyncMetadata := slices.ContainsFunc(references, (*statev1beta1.ModuleReference).HasSyncMetadata)
+	tableHeader := "| Reference | Manifest Digest |\n|---|---|\n"
+	if withSyncMetadata {
+		tableHeader = "| Reference | Manifest Digest | Commit | Synced |\n|---|---|---|---|\n"
+	}
 	if _, err := fmt.Fprintf(stringBuilder,
-		"\n<details><summary>%s: %d update(s)</summary>\n\n| Reference | Manifest Digest |\n|---|---|\n",
-		moduleName, refCount,
+		"\n<details><summary>%s: %d update(s)</summary>\n\n%s",
+		moduleName, refCount, tableHeader,
 	); err != nil {
 		return err
 	}
@@ -421,7 +633,7 @@ func writeUpdatedReferencesTable(
 		maxRows = topRows + bottomRows + 1 // +1 middle row saying something was skipped
 	)
 	var (
-		refsToWrite []*statev1alpha1.ModuleReference
+		refsToWrite []*statev1beta1.ModuleReference
 		fitsInTable = refCount <= maxRows
 	)
 	if fitsInTable {
@@ -434,14 +646,22 @@ func writeUpdatedReferencesTable(
 	for i, ref := range refsToWrite {
 		if !fitsInTable && i == topRows {
 			skippedRows := refCount - topRows - bottomRows
-			if _, err := fmt.Fprintf(stringBuilder,
-				"| ... %d references skipped ... | ... %d references skipped ... |\n",
+			skippedRow := fmt.Sprintf(
+				"| ... %d references skipped ... | ... %d references skipped ... |",
 				skippedRows, skippedRows,
-			); err != nil {
+			)
+			if withSyncMetadata {
+				skippedRow += "  |  |"
+			}
+			if _, err := fmt.Fprintln(stringBuilder, skippedRow); err != nil {
 				return err
 			}
 		}
-		if _, err := fmt.Fprintf(stringBuilder, "| `%s` | `%s` |\n", ref.GetName(), ref.GetDigest()); err != nil {
+		row := fmt.Sprintf("| `%s` | `%s` |", ref.GetName(), ref.GetDigest())
+		if withSyncMetadata {
+			row += syncMetadataColumns(ref.GetSyncMetadata())
+		}
+		if _, err := fmt.Fprintln(stringBuilder, row); err != nil {
 			return err
 		}
 	}
@@ -450,3 +670,16 @@ func writeUpdatedReferencesTable(
 	}
 	return nil
 }
+
+// syncMetadataColumns returns the commit and sync time columns of a reference in the updated
+// references table, empty for the fields that were not recorded.
+func syncMetadataColumns(syncMetadata *statev1beta1.SyncMetadata) string {
+	var commit, syncTime string
+	if syncMetadata.GetCommit() != "" {
+		commit = "`" + syncMetadata.GetCommit() + "`"
+	}
+	if syncMetadata.HasSyncTime() {
+		syncTime = syncMetadata.GetSyncTime().AsTime().UTC().Format(time.RFC3339)
+	}
+	return fmt.Sprintf(" %s | %s |", commit, syncTime)
+}
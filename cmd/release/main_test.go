@@ -16,18 +16,21 @@ package main
 
 import (
 	"fmt"
+	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"buf.build/go/standard/xslices"
 	"github.com/bufbuild/modules/internal/modules"
 	"github.com/bufbuild/modules/private/bufpkg/bufstate"
-	statev1alpha1 "github.com/bufbuild/modules/private/gen/modules/state/v1alpha1"
+	statev1beta1 "github.com/bufbuild/modules/private/gen/modules/state/v1beta1"
 	"github.com/google/go-cmp/cmp"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 func TestCalculateNewReleaseModules(t *testing.T) {
@@ -39,12 +42,12 @@ func TestCalculateNewReleaseModules(t *testing.T) {
 		currentRelease := map[string]string{
 			"envoyproxy/envoy": "bb554f53ad8d3a2a2ae4cbd7102a3e20ae00b558",
 		}
-		got, err := calculateModulesStates(stateRW, filepath.Join("testdata/golden/new-release", bufstate.SyncRoot), nil, currentRelease)
+		got, err := calculateModulesStates(t.Context(), stateRW, bufstate.NewDirSource(filepath.Join("testdata/golden/new-release", bufstate.SyncRoot)), nil, currentRelease)
 		require.NoError(t, err)
 		assertModuleStates(t, map[string]releaseModuleState{
 			"envoyproxy/envoy": {
 				status: modules.New,
-				references: []*statev1alpha1.ModuleReference{statev1alpha1.ModuleReference_builder{
+				references: []*statev1beta1.ModuleReference{statev1beta1.ModuleReference_builder{
 					Name:   "bb554f53ad8d3a2a2ae4cbd7102a3e20ae00b558",
 					Digest: "dummyManifestDigestEnvoy",
 				}.Build()},
@@ -60,12 +63,12 @@ func TestCalculateNewReleaseModules(t *testing.T) {
 			"envoyproxy/envoy": "7850b6bb6494e3bfc093b1aff20282ab30b67940", // updated
 
 		}
-		got, err := calculateModulesStates(stateRW, filepath.Join("testdata/golden/updated-release", bufstate.SyncRoot), prevRelease, currentRelease)
+		got, err := calculateModulesStates(t.Context(), stateRW, bufstate.NewDirSource(filepath.Join("testdata/golden/updated-release", bufstate.SyncRoot)), prevRelease, currentRelease)
 		require.NoError(t, err)
 		assertModuleStates(t, map[string]releaseModuleState{
 			"envoyproxy/envoy": {
 				status: modules.Updated,
-				references: []*statev1alpha1.ModuleReference{statev1alpha1.ModuleReference_builder{
+				references: []*statev1beta1.ModuleReference{statev1beta1.ModuleReference_builder{
 					Name:   "7850b6bb6494e3bfc093b1aff20282ab30b67940",
 					Digest: "updatedDummyManifestDigestEnvoy",
 				}.Build()},
@@ -81,7 +84,7 @@ func TestCalculateNewReleaseModules(t *testing.T) {
 		currentRelease := map[string]string{
 			"envoyproxy/envoy": "7850b6bb6494e3bfc093b1aff20282ab30b67940",
 		}
-		got, err := calculateModulesStates(stateRW, filepath.Join("not-relevant", bufstate.SyncRoot), prevRelease, currentRelease)
+		got, err := calculateModulesStates(t.Context(), stateRW, bufstate.NewDirSource(filepath.Join("not-relevant", bufstate.SyncRoot)), prevRelease, currentRelease)
 		require.NoError(t, err)
 		assertModuleStates(t, map[string]releaseModuleState{
 			"envoyproxy/envoy": {
@@ -101,7 +104,7 @@ func TestCalculateNewReleaseModules(t *testing.T) {
 			"envoyproxy/protoc-gen-validate": "38260ee45796b420276ac925d826ecec8fc3e9a8", // unchanged
 			"gogo/protobuf":                  "8892e00f944642b7dc8d81b419879fd4be12f056", // new
 		}
-		got, err := calculateModulesStates(stateRW, filepath.Join("testdata/golden/newupdatedandunchanged-release", bufstate.SyncRoot), prevRelease, currentRelease)
+		got, err := calculateModulesStates(t.Context(), stateRW, bufstate.NewDirSource(filepath.Join("testdata/golden/newupdatedandunchanged-release", bufstate.SyncRoot)), prevRelease, currentRelease)
 		require.NoError(t, err)
 		assertModuleStates(t, map[string]releaseModuleState{
 			"envoyproxy/protoc-gen-validate": {
@@ -109,14 +112,14 @@ func TestCalculateNewReleaseModules(t *testing.T) {
 			},
 			"envoyproxy/envoy": {
 				status: modules.Updated,
-				references: []*statev1alpha1.ModuleReference{statev1alpha1.ModuleReference_builder{
+				references: []*statev1beta1.ModuleReference{statev1beta1.ModuleReference_builder{
 					Name:   "7850b6bb6494e3bfc093b1aff20282ab30b67940",
 					Digest: "updatedDummyManifestDigestEnvoy",
 				}.Build()},
 			},
 			"gogo/protobuf": {
 				status: modules.New,
-				references: []*statev1alpha1.ModuleReference{statev1alpha1.ModuleReference_builder{
+				references: []*statev1beta1.ModuleReference{statev1beta1.ModuleReference_builder{
 					Name:   "8892e00f944642b7dc8d81b419879fd4be12f056",
 					Digest: "newDummyManifestDigestGogoProtobuf",
 				}.Build()},
@@ -134,7 +137,7 @@ func TestCalculateNewReleaseModules(t *testing.T) {
 			"envoyproxy/envoy":               "v0.2.0",                                   // totally updated (old reference gone)
 			"envoyproxy/protoc-gen-validate": "38260ee45796b420276ac925d826ecec8fc3e9a8", // unchanged
 		}
-		got, err := calculateModulesStates(stateRW, filepath.Join("testdata/golden/totallyupdatedandunchanged-release", bufstate.SyncRoot), prevRelease, currentRelease)
+		got, err := calculateModulesStates(t.Context(), stateRW, bufstate.NewDirSource(filepath.Join("testdata/golden/totallyupdatedandunchanged-release", bufstate.SyncRoot)), prevRelease, currentRelease)
 		require.NoError(t, err)
 		assertModuleStates(t, map[string]releaseModuleState{
 			"envoyproxy/protoc-gen-validate": {
@@ -142,12 +145,12 @@ func TestCalculateNewReleaseModules(t *testing.T) {
 			},
 			"envoyproxy/envoy": {
 				status: modules.Updated,
-				references: []*statev1alpha1.ModuleReference{
-					statev1alpha1.ModuleReference_builder{
+				references: []*statev1beta1.ModuleReference{
+					statev1beta1.ModuleReference_builder{
 						Name:   "v0.1.0",
 						Digest: "dummyManifestDigestEnvoy",
 					}.Build(),
-					statev1alpha1.ModuleReference_builder{
+					statev1beta1.ModuleReference_builder{
 						Name:   "v0.2.0",
 						Digest: "updatedDummyManifestDigestEnvoy",
 					}.Build(),
@@ -166,7 +169,7 @@ func TestCalculateNewReleaseModules(t *testing.T) {
 			"new/foo": "ref3",
 			"new/bar": "ref4",
 		}
-		got, err := calculateModulesStates(stateRW, filepath.Join("testdata/golden/newandremoved-release", bufstate.SyncRoot), prevRelease, currentRelease)
+		got, err := calculateModulesStates(t.Context(), stateRW, bufstate.NewDirSource(filepath.Join("testdata/golden/newandremoved-release", bufstate.SyncRoot)), prevRelease, currentRelease)
 		require.NoError(t, err)
 		assertModuleStates(t, map[string]releaseModuleState{
 			"old/foo": {
@@ -177,20 +180,36 @@ func TestCalculateNewReleaseModules(t *testing.T) {
 			},
 			"new/foo": {
 				status: modules.New,
-				references: []*statev1alpha1.ModuleReference{statev1alpha1.ModuleReference_builder{
+				references: []*statev1beta1.ModuleReference{statev1beta1.ModuleReference_builder{
 					Name:   "ref3",
 					Digest: "dummyManifestDigestNewFoo",
 				}.Build()},
 			},
 			"new/bar": {
 				status: modules.New,
-				references: []*statev1alpha1.ModuleReference{statev1alpha1.ModuleReference_builder{
+				references: []*statev1beta1.ModuleReference{statev1beta1.ModuleReference_builder{
 					Name:   "ref4",
 					Digest: "dummyManifestDigestNewBar",
 				}.Build()},
 			}}, got)
 		assert.True(t, shouldRelease(got))
 	})
+	t.Run("UpdatedButPreviousReleaseIsLatest", func(t *testing.T) {
+		t.Parallel()
+		dir := t.TempDir()
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "test-org", "test-repo"), 0755))
+		require.NoError(t, stateRW.AppendModuleReference(dir, "test-org", "test-repo", "v1.0.0", "a"))
+		require.NoError(t, stateRW.AppendModuleReference(dir, "test-org", "test-repo", "v1.1.0", "b"))
+		// the global state points past the last reference in the module state file
+		_, err := calculateModulesStates(
+			t.Context(),
+			stateRW,
+			bufstate.NewDirSource(dir),
+			map[string]string{"test-org/test-repo": "v1.1.0"},
+			map[string]string{"test-org/test-repo": "v1.2.0"},
+		)
+		require.ErrorContains(t, err, "module indicated as having updates, but previous release v1.1.0 is the latest")
+	})
 }
 
 func TestMapGlobalStateReferences(t *testing.T) {
@@ -198,19 +217,19 @@ func TestMapGlobalStateReferences(t *testing.T) {
 	t.Run("nil_state", func(t *testing.T) {
 		t.Parallel()
 
-		var globalState *statev1alpha1.GlobalState
+		var globalState *statev1beta1.GlobalState
 		got := mapGlobalStateReferences(globalState)
 		require.Equal(t, map[string]string(nil), got)
 	})
 	t.Run("not_nil_state", func(t *testing.T) {
 		t.Parallel()
 
-		globalState := statev1alpha1.GlobalState_builder{
-			Modules: []*statev1alpha1.GlobalStateReference{
-				statev1alpha1.GlobalStateReference_builder{
+		globalState := statev1beta1.GlobalState_builder{
+			Modules: []*statev1beta1.GlobalStateReference{
+				statev1beta1.GlobalStateReference_builder{
 					ModuleName:      "test-org/test-repo",
 					LatestReference: "v1.0.0",
-				}.Build(), statev1alpha1.GlobalStateReference_builder{
+				}.Build(), statev1beta1.GlobalStateReference_builder{
 					ModuleName:      "other-org/other-repo",
 					LatestReference: "v1.0.0",
 				}.Build(),
@@ -224,6 +243,102 @@ func TestMapGlobalStateReferences(t *testing.T) {
 	})
 }
 
+func TestSetNewDeprecations(t *testing.T) {
+	t.Parallel()
+	deprecation := statev1beta1.Deprecation_builder{
+		Reason:        "upstream archived",
+		Replacement:   "test-org/new-repo",
+		DeprecateTime: timestamppb.New(time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)),
+	}.Build()
+	prev := statev1beta1.GlobalState_builder{
+		Modules: []*statev1beta1.GlobalStateReference{
+			statev1beta1.GlobalStateReference_builder{ModuleName: "test-org/already-deprecated-repo", LatestReference: "v1.0.0", Deprecation: deprecation}.Build(),
+			statev1beta1.GlobalStateReference_builder{ModuleName: "test-org/deprecated-repo", LatestReference: "v1.0.0"}.Build(),
+			statev1beta1.GlobalStateReference_builder{ModuleName: "test-org/new-repo", LatestReference: "v1.0.0"}.Build(),
+		},
+	}.Build()
+	current := statev1beta1.GlobalState_builder{
+		Modules: []*statev1beta1.GlobalStateReference{
+			statev1beta1.GlobalStateReference_builder{ModuleName: "test-org/already-deprecated-repo", LatestReference: "v1.0.0", Deprecation: deprecation}.Build(),
+			statev1beta1.GlobalStateReference_builder{ModuleName: "test-org/deprecated-repo", LatestReference: "v1.0.0", Deprecation: deprecation}.Build(),
+			statev1beta1.GlobalStateReference_builder{ModuleName: "test-org/new-repo", LatestReference: "v1.0.0"}.Build(),
+		},
+	}.Build()
+	modulesStates := map[string]releaseModuleState{
+		"test-org/already-deprecated-repo": {status: modules.Unchanged},
+		"test-org/deprecated-repo":         {status: modules.Unchanged},
+		"test-org/new-repo":                {status: modules.Unchanged},
+	}
+	require.False(t, shouldRelease(modulesStates))
+	setNewDeprecations(modulesStates, prev, current)
+	assert.Nil(t, modulesStates["test-org/already-deprecated-repo"].deprecation)
+	assert.True(t, cmp.Equal(deprecation, modulesStates["test-org/deprecated-repo"].deprecation, protocmp.Transform()))
+	assert.Equal(t, modules.Unchanged, modulesStates["test-org/deprecated-repo"].status)
+	assert.Nil(t, modulesStates["test-org/new-repo"].deprecation)
+	assert.True(t, shouldRelease(modulesStates))
+}
+
+func TestSetSupersededReferences(t *testing.T) {
+	t.Parallel()
+	stateRW, err := bufstate.NewReadWriter()
+	require.NoError(t, err)
+	prevDir, dir := t.TempDir(), t.TempDir()
+	releaseTime := time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)
+	for _, dir := range []string{prevDir, dir} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "test-org", "test-repo"), 0755))
+		require.NoError(t, stateRW.AppendModuleReference(dir, "test-org", "test-repo", "v1.0.0", "a"))
+		require.NoError(t, stateRW.AppendModuleReference(dir, "test-org", "test-repo", "v1.1.0", "b"))
+		// released, even if recorded after the previous release was created
+		require.NoError(t, stateRW.SupersedeModuleReference(dir, "test-org", "test-repo", "v1.0.0", "c", "released", releaseTime.Add(time.Hour)))
+	}
+	// not released, even if recorded before the previous release was created, e.g. in a PR merged
+	// after it
+	require.NoError(t, stateRW.SupersedeModuleReference(dir, "test-org", "test-repo", "v1.0.0", "d", "first retag", releaseTime.Add(-2*time.Hour)))
+	require.NoError(t, stateRW.SupersedeModuleReference(dir, "test-org", "test-repo", "v1.0.0", "e", "second retag", releaseTime.Add(-time.Hour)))
+	// new in this release
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "test-org", "new-repo"), 0755))
+	require.NoError(t, stateRW.AppendModuleReference(dir, "test-org", "new-repo", "v0.1.0", "f"))
+	require.NoError(t, stateRW.SupersedeModuleReference(dir, "test-org", "new-repo", "v0.1.0", "g", "retag", releaseTime.Add(-time.Hour)))
+	modulesStates := map[string]releaseModuleState{
+		"test-org/test-repo": {status: modules.Unchanged},
+		"test-org/new-repo":  {status: modules.New},
+	}
+	require.NoError(t, setSupersededReferences(
+		t.Context(),
+		stateRW,
+		bufstate.NewDirSource(dir),
+		bufstate.NewDirSource(prevDir),
+		modulesStates,
+		map[string]string{"test-org/test-repo": "v1.1.0", "test-org/new-repo": "v0.1.0"},
+	))
+	supersededReferences := modulesStates["test-org/test-repo"].supersededReferences
+	require.Len(t, supersededReferences, 2)
+	assert.Equal(t, "v1.0.0", supersededReferences[0].name)
+	assert.Equal(t, "d", supersededReferences[0].digest)
+	assert.Equal(t, "c", supersededReferences[0].supersession.GetPreviousDigest())
+	assert.Equal(t, "first retag", supersededReferences[0].supersession.GetReason())
+	assert.Equal(t, "e", supersededReferences[1].digest)
+	assert.Equal(t, "d", supersededReferences[1].supersession.GetPreviousDigest())
+	newSupersededReferences := modulesStates["test-org/new-repo"].supersededReferences
+	require.Len(t, newSupersededReferences, 1)
+	assert.Equal(t, "g", newSupersededReferences[0].digest)
+
+	// nothing superseded since a release of the same state
+	modulesStates = map[string]releaseModuleState{
+		"test-org/test-repo": {status: modules.Unchanged},
+	}
+	require.NoError(t, setSupersededReferences(
+		t.Context(),
+		stateRW,
+		bufstate.NewDirSource(dir),
+		bufstate.NewDirSource(dir),
+		modulesStates,
+		map[string]string{"test-org/test-repo": "v1.1.0"},
+	))
+	assert.Empty(t, modulesStates["test-org/test-repo"].supersededReferences)
+	assert.False(t, shouldRelease(modulesStates))
+}
+
 func TestCreateReleaseBody(t *testing.T) {
 	t.Parallel()
 	t.Run("New", func(t *testing.T) {
@@ -231,11 +346,11 @@ func TestCreateReleaseBody(t *testing.T) {
 		mods := map[string]releaseModuleState{
 			"test-org/test-repo": {
 				status: modules.New,
-				references: []*statev1alpha1.ModuleReference{
-					statev1alpha1.ModuleReference_builder{
+				references: []*statev1beta1.ModuleReference{
+					statev1beta1.ModuleReference_builder{
 						Name:   "v1.0.0",
 						Digest: "fakedigest",
-					}.Build(), statev1alpha1.ModuleReference_builder{
+					}.Build(), statev1beta1.ModuleReference_builder{
 						Name:   "v1.1.0",
 						Digest: "fakedigest",
 					}.Build(),
@@ -266,11 +381,11 @@ func TestCreateReleaseBody(t *testing.T) {
 		mods := map[string]releaseModuleState{
 			"test-org/new-repo": {
 				status: modules.New,
-				references: []*statev1alpha1.ModuleReference{
-					statev1alpha1.ModuleReference_builder{
+				references: []*statev1beta1.ModuleReference{
+					statev1beta1.ModuleReference_builder{
 						Name:   "v1.0.0",
 						Digest: "fakedigest",
-					}.Build(), statev1alpha1.ModuleReference_builder{
+					}.Build(), statev1beta1.ModuleReference_builder{
 						Name:   "v1.1.0",
 						Digest: "fakedigest",
 					}.Build(),
@@ -278,11 +393,11 @@ func TestCreateReleaseBody(t *testing.T) {
 			},
 			"test-org/updated-repo": {
 				status: modules.Updated,
-				references: []*statev1alpha1.ModuleReference{
-					statev1alpha1.ModuleReference_builder{
+				references: []*statev1beta1.ModuleReference{
+					statev1beta1.ModuleReference_builder{
 						Name:   "v1.0.0",
 						Digest: "fakedigest",
-					}.Build(), statev1alpha1.ModuleReference_builder{
+					}.Build(), statev1beta1.ModuleReference_builder{
 						Name:   "v1.1.0",
 						Digest: "fakedigest",
 					}.Build(),
@@ -314,6 +429,91 @@ func TestCreateReleaseBody(t *testing.T) {
 
 </details>
 
+`
+		got, err := createReleaseBody("20230519.1", mods)
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+	})
+	t.Run("Superseded", func(t *testing.T) {
+		t.Parallel()
+		mods := map[string]releaseModuleState{
+			"test-org/test-repo": {
+				status: modules.Unchanged,
+				supersededReferences: []supersededReference{
+					{
+						name:   "v1.0.0",
+						digest: "newdigest",
+						supersession: statev1beta1.Supersession_builder{
+							PreviousDigest: "olddigest",
+							SupersedeTime:  timestamppb.New(time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)),
+							Reason:         "tag recreated upstream",
+						}.Build(),
+					},
+				},
+			},
+		}
+
+		const want = `# Buf Modules Release 20230519.1
+
+## Superseded References
+
+<details><summary>test-org/test-repo: 1 superseded reference(s)</summary>
+
+| Reference | Manifest Digest | Previous Manifest Digest | Superseded | Reason |
+|---|---|---|---|---|
+| ` + "`v1.0.0`" + ` | ` + "`newdigest`" + ` | ` + "`olddigest`" + ` | 2025-02-01T00:00:00Z | tag recreated upstream |
+
+</details>
+
+## Unchanged Modules
+
+<details><summary>Expand</summary>
+
+- test-org/test-repo
+
+</details>
+`
+		got, err := createReleaseBody("20230519.1", mods)
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+	})
+	t.Run("Deprecated", func(t *testing.T) {
+		t.Parallel()
+		mods := map[string]releaseModuleState{
+			"test-org/new-repo": {
+				status: modules.Unchanged,
+			},
+			"test-org/old-repo": {
+				status: modules.Unchanged,
+				deprecation: statev1beta1.Deprecation_builder{
+					Reason:      "upstream archived",
+					Replacement: "test-org/new-repo",
+				}.Build(),
+			},
+			"test-org/other-repo": {
+				status: modules.Unchanged,
+				deprecation: statev1beta1.Deprecation_builder{
+					Reason: "no longer maintained",
+				}.Build(),
+			},
+		}
+
+		const want = `# Buf Modules Release 20230519.1
+
+## Deprecated Modules
+
+- test-org/old-repo: upstream archived (replaced by ` + "`test-org/new-repo`" + `)
+- test-org/other-repo: no longer maintained
+
+## Unchanged Modules
+
+<details><summary>Expand</summary>
+
+- test-org/new-repo
+- test-org/old-repo
+- test-org/other-repo
+
+</details>
 `
 		got, err := createReleaseBody("20230519.1", mods)
 		require.NoError(t, err)
@@ -337,11 +537,11 @@ func TestCreateReleaseBody(t *testing.T) {
 		}
 		mods := make(map[string]releaseModuleState, len(modulesInBody))
 		for modName, state := range modulesInBody {
-			var references []*statev1alpha1.ModuleReference
+			var references []*statev1beta1.ModuleReference
 			//nolint:exhaustive // other module states should not have any reference
 			switch state {
 			case modules.New, modules.Updated:
-				references = []*statev1alpha1.ModuleReference{statev1alpha1.ModuleReference_builder{Name: "v1.0.0", Digest: "fakedigest"}.Build()}
+				references = []*statev1beta1.ModuleReference{statev1beta1.ModuleReference_builder{Name: "v1.0.0", Digest: "fakedigest"}.Build()}
 			}
 			// map order is not guaranteed
 			mods[modName] = releaseModuleState{
@@ -434,10 +634,10 @@ func TestCreateReleaseBody(t *testing.T) {
 func TestWriteReferencesTable(t *testing.T) {
 	t.Parallel()
 
-	populateReferences := func(refsCount int) []*statev1alpha1.ModuleReference {
-		refs := make([]*statev1alpha1.ModuleReference, refsCount)
+	populateReferences := func(refsCount int) []*statev1beta1.ModuleReference {
+		refs := make([]*statev1beta1.ModuleReference, refsCount)
 		for i := range refsCount {
-			refs[i] = statev1alpha1.ModuleReference_builder{
+			refs[i] = statev1beta1.ModuleReference_builder{
 				Name:   fmt.Sprintf("commit%03d", i),
 				Digest: fmt.Sprintf("digest%03d", i),
 			}.Build()
@@ -534,6 +734,36 @@ func TestWriteReferencesTable(t *testing.T) {
 | ` + "`commit098`" + ` | ` + "`digest098`" + ` |
 | ` + "`commit099`" + ` | ` + "`digest099`" + ` |
 
+</details>
+`
+		assert.Equal(t, want, strBuilder.String())
+	})
+	t.Run("withSyncMetadata", func(t *testing.T) {
+		t.Parallel()
+
+		const moduleName = "foo/bar"
+		refs := populateReferences(7)
+		// references synced before sync metadata was recorded have empty columns
+		for _, i := range []int{1, 5, 6} {
+			refs[i].SetSyncMetadata(statev1beta1.SyncMetadata_builder{
+				SyncTime: timestamppb.New(time.Date(2025, 1, 2, 3, 4, i, 0, time.UTC)),
+				Commit:   fmt.Sprintf("%040d", i),
+				Kind:     statev1beta1.ReferenceKind_REFERENCE_KIND_COMMIT,
+			}.Build())
+		}
+		var strBuilder strings.Builder
+		require.NoError(t, writeUpdatedReferencesTable(&strBuilder, moduleName, refs))
+		const want = `
+<details><summary>foo/bar: 7 update(s)</summary>
+
+| Reference | Manifest Digest | Commit | Synced |
+|---|---|---|---|
+| ` + "`commit000`" + ` | ` + "`digest000`" + ` |  |  |
+| ` + "`commit001`" + ` | ` + "`digest001`" + ` | ` + "`0000000000000000000000000000000000000001`" + ` | 2025-01-02T03:04:01Z |
+| ... 3 references skipped ... | ... 3 references skipped ... |  |  |
+| ` + "`commit005`" + ` | ` + "`digest005`" + ` | ` + "`0000000000000000000000000000000000000005`" + ` | 2025-01-02T03:04:05Z |
+| ` + "`commit006`" + ` | ` + "`digest006`" + ` | ` + "`0000000000000000000000000000000000000006`" + ` | 2025-01-02T03:04:06Z |
+
 </details>
 `
 		assert.Equal(t, want, strBuilder.String())
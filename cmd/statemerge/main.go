@@ -0,0 +1,300 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bufbuild/modules/internal/gitutil"
+	"github.com/bufbuild/modules/private/bufpkg/bufstate"
+	statev1beta1 "github.com/bufbuild/modules/private/gen/modules/state/v1beta1"
+	"go.uber.org/multierr"
+)
+
+const rootSyncDirFlagName = "root-sync-dir"
+
+type command struct {
+	rootSyncDir string
+	// basePath, oursPath and theirsPath are the git merge driver %O, %A and %B temporary files, and
+	// the merge result is written to oursPath.
+	basePath   string
+	oursPath   string
+	theirsPath string
+	// path is the git merge driver %P, the path of the merged file in the repository.
+	path string
+	// repo is the repository with the merge in progress, and theirsRevision the commit merged into
+	// its HEAD, if known.
+	repo           *gitutil.GoGitRepository
+	theirsRevision string
+}
+
+func newCmd(
+	rootSyncDir string,
+	args []string,
+	repo *gitutil.GoGitRepository,
+	environ []string,
+) (*command, error) {
+	var err error
+	if len(rootSyncDir) == 0 {
+		err = multierr.Append(err, fmt.Errorf("%s is required", rootSyncDirFlagName))
+	}
+	if len(args) != 4 {
+		err = multierr.Append(err, fmt.Errorf("expected 4 arguments <base> <ours> <theirs> <path>, got %d", len(args)))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &command{
+		rootSyncDir:    rootSyncDir,
+		basePath:       args[0],
+		oursPath:       args[1],
+		theirsPath:     args[2],
+		path:           args[3],
+		repo:           repo,
+		theirsRevision: theirsRevisionFromEnviron(environ),
+	}, nil
+}
+
+func main() {
+	rootSyncDir := flag.String(rootSyncDirFlagName, "", "Root sync directory where all the managed modules live, relative to the repository root.")
+	flag.Parse()
+	repo, err := gitutil.OpenGoGitRepository(".")
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "statemerge failed: %v\n", err)
+		os.Exit(1)
+	}
+	cmd, err := newCmd(
+		*rootSyncDir,
+		flag.Args(),
+		repo,
+		os.Environ(),
+	)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "cannot run state merge: %v\n\nusage: statemerge [flags] <base> <ours> <theirs> <path>\n\n", err)
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	if err := cmd.run(); err != nil {
+		// git leaves the file as a conflict on any non zero exit code
+		_, _ = fmt.Fprintf(os.Stderr, "statemerge failed for %s: %v\n", cmd.path, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func (c *command) run() error {
+	ctx := context.Background()
+	stateRW, err := bufstate.NewReadWriter()
+	if err != nil {
+		return fmt.Errorf("new state read writer: %w", err)
+	}
+	var data []byte
+	if filepath.Clean(c.path) == filepath.Join(filepath.Clean(c.rootSyncDir), bufstate.GlobalStateFileName) {
+		data, err = c.mergeGlobalStates(ctx, stateRW)
+	} else {
+		data, err = c.mergeModuleStates(stateRW)
+	}
+	if err != nil {
+		return err
+	}
+	// only written once merged, so a failed merge leaves ours for git to mark as conflicted
+	if err := os.WriteFile(c.oursPath, data, 0644); err != nil { //nolint:gosec // state files are not secret
+		return fmt.Errorf("write merged state file: %w", err)
+	}
+	return nil
+}
+
+func (c *command) mergeModuleStates(stateRW *bufstate.ReadWriter) ([]byte, error) {
+	moduleStates := make([]*statev1beta1.ModuleState, 3)
+	versions := make([]bufstate.Version, 3)
+	for i, filePath := range []string{c.basePath, c.oursPath, c.theirsPath} {
+		file, err := openStateFile(filePath)
+		if err != nil {
+			return nil, err
+		}
+		if file == nil {
+			// added on both sides
+			moduleStates[i] = &statev1beta1.ModuleState{}
+			continue
+		}
+		if moduleStates[i], versions[i], err = stateRW.ReadModStateFileWithVersion(file); err != nil {
+			return nil, fmt.Errorf("read module state file %s: %w", filePath, err)
+		}
+	}
+	merged, err := bufstate.MergeModuleStates(moduleStates[0], moduleStates[1], moduleStates[2])
+	if err != nil {
+		return nil, err
+	}
+	var buffer closingBuffer
+	if err := stateRW.WriteModStateFileWithVersion(&buffer, merged, max(versions[1], versions[2])); err != nil {
+		return nil, fmt.Errorf("write merged module state: %w", err)
+	}
+	return buffer.Bytes(), nil
+}
+
+func (c *command) mergeGlobalStates(ctx context.Context, stateRW *bufstate.ReadWriter) ([]byte, error) {
+	globalStates := make([]*statev1beta1.GlobalState, 3)
+	versions := make([]bufstate.Version, 3)
+	for i, filePath := range []string{c.basePath, c.oursPath, c.theirsPath} {
+		file, err := openStateFile(filePath)
+		if err != nil {
+			return nil, err
+		}
+		if file == nil {
+			globalStates[i] = &statev1beta1.GlobalState{}
+			continue
+		}
+		if globalStates[i], versions[i], err = stateRW.ReadGlobalStateWithVersion(file); err != nil {
+			return nil, fmt.Errorf("read global state file %s: %w", filePath, err)
+		}
+	}
+	merged, err := bufstate.MergeGlobalStates(
+		globalStates[0],
+		globalStates[1],
+		globalStates[2],
+		func(moduleName string, _ string, _ string) (string, error) {
+			return c.resolveLatestReference(ctx, stateRW, moduleName)
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	var buffer closingBuffer
+	if err := stateRW.WriteGlobalStateWithVersion(&buffer, merged, max(versions[1], versions[2])); err != nil {
+		return nil, fmt.Errorf("write merged global state: %w", err)
+	}
+	return buffer.Bytes(), nil
+}
+
+// resolveLatestReference recomputes the latest reference of a module whose latest reference was
+// changed on both sides, by merging the base, ours and theirs versions of its module state file.
+func (c *command) resolveLatestReference(ctx context.Context, stateRW *bufstate.ReadWriter, moduleName string) (string, error) {
+	modFilePath := path.Join(filepath.ToSlash(filepath.Clean(c.rootSyncDir)), moduleName, bufstate.ModStateFileName)
+	contents, err := c.readMergeVersions(ctx, modFilePath)
+	if err != nil {
+		return "", fmt.Errorf("read module state file %s: %w", modFilePath, err)
+	}
+	moduleStates := make([]*statev1beta1.ModuleState, len(contents))
+	for i, content := range contents {
+		if len(content) == 0 {
+			moduleStates[i] = &statev1beta1.ModuleState{}
+			continue
+		}
+		if moduleStates[i], err = stateRW.ReadModStateFile(io.NopCloser(bytes.NewReader(content))); err != nil {
+			return "", fmt.Errorf("read module state file %s: %w", modFilePath, err)
+		}
+	}
+	merged, err := bufstate.MergeModuleStates(moduleStates[0], moduleStates[1], moduleStates[2])
+	if err != nil {
+		return "", fmt.Errorf("merge module state file %s: %w", modFilePath, err)
+	}
+	latest, ok := bufstate.NewModuleHistory(merged).Latest()
+	if !ok {
+		return "", fmt.Errorf("merged module state file %s has no references", modFilePath)
+	}
+	return latest.GetName(), nil
+}
+
+// readMergeVersions returns the base, ours and theirs versions of a file of the merge in progress, nil
+// for a version where it does not exist.
+//
+// A conflicted file has its versions in the index stages 1, 2 and 3. Otherwise, they are read from
+// the commits being merged, as git only writes the index stages once all files are merged, and the
+// working tree and stage 0 may or may not be merged yet depending on the order git merges files.
+func (c *command) readMergeVersions(ctx context.Context, filePath string) ([][]byte, error) {
+	contents := make([][]byte, 3)
+	for i, stage := range []int{1, 2, 3} {
+		content, err := c.repo.ReadStagedFile(filePath, stage)
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				return nil, err
+			}
+			continue
+		}
+		contents[i] = content
+	}
+	if contents[1] != nil && contents[2] != nil {
+		return contents, nil
+	}
+	if c.theirsRevision == "" {
+		return nil, errors.New("the file is not conflicted and the commit being merged is unknown, run staterebuild after the merge")
+	}
+	baseRevision, err := c.repo.MergeBase("HEAD", c.theirsRevision)
+	if err != nil {
+		return nil, err
+	}
+	for i, revision := range []string{baseRevision, "HEAD", c.theirsRevision} {
+		content, err := c.repo.ReadFile(ctx, revision, filePath)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		contents[i] = content
+	}
+	return contents, nil
+}
+
+// theirsRevisionFromEnviron returns the commit being merged into HEAD, which git merge passes to
+// merge drivers as a GITHEAD_<commit> environment variable, or an empty string if there is not
+// exactly one.
+func theirsRevisionFromEnviron(environ []string) string {
+	var theirsRevision string
+	for _, variable := range environ {
+		name, _, _ := strings.Cut(variable, "=")
+		revision, ok := strings.CutPrefix(name, "GITHEAD_")
+		if !ok {
+			continue
+		}
+		if theirsRevision != "" {
+			return ""
+		}
+		theirsRevision = revision
+	}
+	return theirsRevision
+}
+
+// openStateFile opens a state file given to the merge driver, and returns nil if it is empty, which
+// is how git passes the base of a file added on both sides.
+func openStateFile(filePath string) (*os.File, error) {
+	fileInfo, err := os.Stat(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("stat state file: %w", err)
+	}
+	if fileInfo.Size() == 0 {
+		return nil, nil
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("open state file: %w", err)
+	}
+	return file, nil
+}
+
+type closingBuffer struct {
+	bytes.Buffer
+}
+
+func (*closingBuffer) Close() error {
+	return nil
+}
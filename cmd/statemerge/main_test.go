@@ -0,0 +1,229 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/modules/internal/gitutil"
+	"github.com/bufbuild/modules/private/bufpkg/bufstate"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testRootSyncDir     = "modules/sync"
+	testGlobalStatePath = "modules/sync/state.json"
+	testModuleStatePath = "modules/sync/foo/bar/state.json"
+	testBaseModuleState = `{
+  "version": "v1beta1",
+  "references": [
+    {"name": "v1.0.0", "digest": "aaa"}
+  ]
+}
+`
+	testOursModuleState = `{
+  "version": "v1beta1",
+  "references": [
+    {"name": "v1.0.0", "digest": "aaa"},
+    {"name": "v1.1.0", "digest": "bbb"}
+  ]
+}
+`
+	testTheirsModuleState = `{
+  "version": "v1beta1",
+  "references": [
+    {"name": "v1.0.0", "digest": "aaa"},
+    {"name": "v1.0.1", "digest": "ccc"}
+  ]
+}
+`
+	// testMergedModuleState is the module state file as merged by the merge driver.
+	testMergedModuleState = `{
+  "version": "v1beta1",
+  "references": [
+    {"name": "v1.0.0", "digest": "aaa"},
+    {"name": "v1.1.0", "digest": "bbb"},
+    {"name": "v1.0.1", "digest": "ccc"}
+  ]
+}
+`
+	testBaseGlobalState   = `{"version": "v1beta1", "modules": [{"module_name": "foo/bar", "latest_reference": "v1.0.0"}]}`
+	testOursGlobalState   = `{"version": "v1beta1", "modules": [{"module_name": "foo/bar", "latest_reference": "v1.1.0"}]}`
+	testTheirsGlobalState = `{"version": "v1beta1", "modules": [{"module_name": "foo/bar", "latest_reference": "v1.0.1"}]}`
+)
+
+func TestMergeGlobalStates(t *testing.T) {
+	t.Parallel()
+	type testCase struct {
+		name string
+		// prepare updates the index of the merge in progress before the global state file is merged.
+		prepare     func(t *testing.T, repo *git.Repository, commits testCommits)
+		knownTheirs bool
+		wantErr     string
+	}
+	testCases := []testCase{
+		{
+			// the module state file is merged after the global state file
+			name:        "module_state_not_merged",
+			prepare:     func(*testing.T, *git.Repository, testCommits) {},
+			knownTheirs: true,
+		},
+		{
+			// the module state file is merged before the global state file
+			name: "module_state_merged",
+			prepare: func(t *testing.T, repo *git.Repository, _ testCommits) {
+				worktree, err := repo.Worktree()
+				require.NoError(t, err)
+				require.NoError(t, util.WriteFile(worktree.Filesystem, testModuleStatePath, []byte(testMergedModuleState), 0600))
+				_, err = worktree.Add(testModuleStatePath)
+				require.NoError(t, err)
+			},
+			knownTheirs: true,
+		},
+		{
+			// the module state file is conflicted, and the commit being merged is unknown
+			name:    "module_state_conflicted",
+			prepare: setConflictedModuleState,
+		},
+		{
+			name:    "unknown_theirs",
+			prepare: func(*testing.T, *git.Repository, testCommits) {},
+			wantErr: "run staterebuild after the merge",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			repo, commits := newTestMergeRepository(t)
+			tc.prepare(t, repo, commits)
+			var environ []string
+			if tc.knownTheirs {
+				environ = []string{"GITHEAD_" + commits.theirs + "=theirs"}
+			}
+			dir := t.TempDir()
+			args := make([]string, 0, 4)
+			for name, content := range map[string]string{
+				"base":   testBaseGlobalState,
+				"ours":   testOursGlobalState,
+				"theirs": testTheirsGlobalState,
+			} {
+				require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0600))
+			}
+			for _, name := range []string{"base", "ours", "theirs"} {
+				args = append(args, filepath.Join(dir, name))
+			}
+			cmd, err := newCmd(testRootSyncDir, append(args, testGlobalStatePath), gitutil.NewGoGitRepository(repo), environ)
+			require.NoError(t, err)
+			err = cmd.run()
+			if tc.wantErr != "" {
+				require.ErrorContains(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			stateRW, err := bufstate.NewReadWriter()
+			require.NoError(t, err)
+			file, err := os.Open(filepath.Join(dir, "ours"))
+			require.NoError(t, err)
+			merged, err := stateRW.ReadGlobalState(file)
+			require.NoError(t, err)
+			require.Len(t, merged.GetModules(), 1)
+			assert.Equal(t, "v1.0.1", merged.GetModules()[0].GetLatestReference())
+		})
+	}
+}
+
+func TestTheirsRevisionFromEnviron(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, "abc", theirsRevisionFromEnviron([]string{"HOME=/root", "GITHEAD_abc=feature"}))
+	assert.Empty(t, theirsRevisionFromEnviron([]string{"HOME=/root"}))
+	// octopus merges
+	assert.Empty(t, theirsRevisionFromEnviron([]string{"GITHEAD_abc=feature", "GITHEAD_def=other"}))
+}
+
+// testCommits are the commit hashes of a test merge.
+type testCommits struct {
+	base   string
+	ours   string
+	theirs string
+}
+
+// newTestMergeRepository creates an in-memory git repository with ours and theirs commits on top of
+// a base commit, and HEAD at ours.
+func newTestMergeRepository(t *testing.T) (*git.Repository, testCommits) {
+	t.Helper()
+	billyFS := memfs.New()
+	repo, err := git.Init(memory.NewStorage(), billyFS)
+	require.NoError(t, err)
+	worktree, err := repo.Worktree()
+	require.NoError(t, err)
+	commit := func(globalState string, moduleState string) string {
+		for filePath, content := range map[string]string{
+			testGlobalStatePath: globalState,
+			testModuleStatePath: moduleState,
+		} {
+			require.NoError(t, util.WriteFile(billyFS, filePath, []byte(content), 0600))
+			_, err := worktree.Add(filePath)
+			require.NoError(t, err)
+		}
+		hash, err := worktree.Commit("commit", &git.CommitOptions{
+			Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Unix(0, 0)},
+		})
+		require.NoError(t, err)
+		return hash.String()
+	}
+	var commits testCommits
+	commits.base = commit(testBaseGlobalState, testBaseModuleState)
+	require.NoError(t, worktree.Checkout(&git.CheckoutOptions{
+		Hash:   plumbing.NewHash(commits.base),
+		Branch: plumbing.NewBranchReferenceName("theirs"),
+		Create: true,
+	}))
+	commits.theirs = commit(testTheirsGlobalState, testTheirsModuleState)
+	require.NoError(t, worktree.Checkout(&git.CheckoutOptions{Branch: plumbing.Master}))
+	commits.ours = commit(testOursGlobalState, testOursModuleState)
+	return repo, commits
+}
+
+// setConflictedModuleState replaces the module state file in the index by its base, ours and theirs
+// versions in the stages 1, 2 and 3, as git does for a conflicted file.
+func setConflictedModuleState(t *testing.T, repo *git.Repository, commits testCommits) {
+	t.Helper()
+	gitIndex, err := repo.Storer.Index()
+	require.NoError(t, err)
+	_, err = gitIndex.Remove(testModuleStatePath)
+	require.NoError(t, err)
+	for i, commitHash := range []string{commits.base, commits.ours, commits.theirs} {
+		commit, err := repo.CommitObject(plumbing.NewHash(commitHash))
+		require.NoError(t, err)
+		file, err := commit.File(testModuleStatePath)
+		require.NoError(t, err)
+		entry := gitIndex.Add(testModuleStatePath)
+		entry.Hash = file.Hash
+		entry.Mode = file.Mode
+		entry.Stage = index.Stage(i + 1)
+	}
+	require.NoError(t, repo.Storer.SetIndex(gitIndex))
+}
@@ -0,0 +1,93 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/bufbuild/modules/private/bufpkg/bufstate"
+	"go.uber.org/multierr"
+)
+
+const (
+	rootSyncDirFlagName = "root-sync-dir"
+	versionFlagName     = "version"
+)
+
+type command struct {
+	rootSyncDir string
+	version     bufstate.Version
+}
+
+func newCmd(
+	rootSyncDir string,
+	version string,
+) (*command, error) {
+	var err error
+	if len(rootSyncDir) == 0 {
+		err = multierr.Append(err, fmt.Errorf("%s is required", rootSyncDirFlagName))
+	}
+	stateVersion, parseErr := bufstate.ParseVersion(version)
+	if parseErr != nil {
+		err = multierr.Append(err, fmt.Errorf("%s: %w", versionFlagName, parseErr))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &command{
+		rootSyncDir: rootSyncDir,
+		version:     stateVersion,
+	}, nil
+}
+
+func main() {
+	var (
+		rootSyncDir = flag.String(rootSyncDirFlagName, "", "Root sync directory where all the managed modules live.")
+		version     = flag.String(versionFlagName, bufstate.LatestVersion.String(), "State version to migrate to, v1alpha1 or v1beta1.")
+	)
+	flag.Parse()
+	cmd, err := newCmd(
+		*rootSyncDir,
+		*version,
+	)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "cannot run state migrate: %v\n\nusage: statemigrate [flags]\n\n", err)
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	if err := cmd.run(); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "statemigrate failed: %v\n", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func (c *command) run() error {
+	stateRW, err := bufstate.NewReadWriter()
+	if err != nil {
+		return fmt.Errorf("new state read writer: %w", err)
+	}
+	migratedFilePaths, err := stateRW.MigrateSyncDir(c.rootSyncDir, c.version)
+	if err != nil {
+		return err
+	}
+	for _, migratedFilePath := range migratedFilePaths {
+		_, _ = fmt.Fprintf(os.Stdout, "migrated %s to %s\n", migratedFilePath, c.version)
+	}
+	_, _ = fmt.Fprintf(os.Stdout, "%d state files migrated to %s\n", len(migratedFilePaths), c.version)
+	return nil
+}
@@ -0,0 +1,108 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/bufbuild/modules/private/bufpkg/bufstate"
+	"go.uber.org/multierr"
+)
+
+const (
+	rootSyncDirFlagName = "root-sync-dir"
+	fixFlagName         = "fix"
+)
+
+type command struct {
+	rootSyncDir string
+	fix         bool
+}
+
+func newCmd(
+	rootSyncDir string,
+	fix bool,
+) (*command, error) {
+	var err error
+	if len(rootSyncDir) == 0 {
+		err = multierr.Append(err, fmt.Errorf("%s is required", rootSyncDirFlagName))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &command{
+		rootSyncDir: rootSyncDir,
+		fix:         fix,
+	}, nil
+}
+
+func main() {
+	var (
+		rootSyncDir = flag.String(rootSyncDirFlagName, "", "Root sync directory where all the managed modules live.")
+		fix         = flag.Bool(fixFlagName, false, "Rewrite the global state file with the latest references of the module state files.")
+	)
+	flag.Parse()
+	cmd, err := newCmd(
+		*rootSyncDir,
+		*fix,
+	)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "cannot run state rebuild: %v\n\nusage: staterebuild [flags]\n\n", err)
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	if err := cmd.run(); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "staterebuild failed: %v\n", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// run reports the discrepancies between the global state file and the module state files, and
+// fails if there are any, unless they are fixed.
+func (c *command) run() error {
+	stateRW, err := bufstate.NewReadWriter()
+	if err != nil {
+		return fmt.Errorf("new state read writer: %w", err)
+	}
+	var discrepancies []bufstate.GlobalStateDiscrepancy
+	if c.fix {
+		if err := stateRW.UpdateSyncDir(context.Background(), c.rootSyncDir, func(update *bufstate.SyncDirUpdate) error {
+			discrepancies, err = update.RebuildGlobalState()
+			return err
+		}); err != nil {
+			return err
+		}
+	} else {
+		if _, discrepancies, err = stateRW.RebuildGlobalState(c.rootSyncDir); err != nil {
+			return err
+		}
+	}
+	for _, discrepancy := range discrepancies {
+		_, _ = fmt.Fprintln(os.Stdout, discrepancy.String())
+	}
+	switch {
+	case len(discrepancies) == 0:
+		_, _ = fmt.Fprintln(os.Stdout, "global state file matches the module state files")
+	case c.fix:
+		_, _ = fmt.Fprintf(os.Stdout, "%d discrepancies fixed in the global state file\n", len(discrepancies))
+	default:
+		return fmt.Errorf("%d discrepancies in the global state file, run with -%s to fix them", len(discrepancies), fixFlagName)
+	}
+	return nil
+}
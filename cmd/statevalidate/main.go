@@ -0,0 +1,135 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/bufbuild/buf/private/pkg/storage"
+	"github.com/bufbuild/modules/private/bufpkg/bufcas"
+	"github.com/bufbuild/modules/private/bufpkg/bufstate"
+	"go.uber.org/multierr"
+)
+
+const (
+	rootSyncDirFlagName = "root-sync-dir"
+	fetchScriptFlagName = "fetch-script"
+)
+
+// releaseModuleRegexp matches the modules synced with the releases strategy in the fetch script, in
+// the shape of:
+//
+//	sync_references releases <owner> <repo> ...
+var releaseModuleRegexp = regexp.MustCompile(`^sync_references\s+releases\s+(\S+)\s+(\S+)`) //nolint:gochecknoglobals // treated as const
+
+type command struct {
+	rootSyncDir string
+	fetchScript string
+}
+
+func newCmd(
+	rootSyncDir string,
+	fetchScript string,
+) (*command, error) {
+	var err error
+	if len(rootSyncDir) == 0 {
+		err = multierr.Append(err, fmt.Errorf("%s is required", rootSyncDirFlagName))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &command{
+		rootSyncDir: rootSyncDir,
+		fetchScript: fetchScript,
+	}, nil
+}
+
+func main() {
+	var (
+		rootSyncDir = flag.String(rootSyncDirFlagName, "", "Root sync directory where all the managed modules live.")
+		fetchScript = flag.String(fetchScriptFlagName, "", "Fetch script with the sync strategy of each module, to validate the references of the modules synced from releases.")
+	)
+	flag.Parse()
+	cmd, err := newCmd(
+		*rootSyncDir,
+		*fetchScript,
+	)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "cannot run state validate: %v\n\nusage: statevalidate [flags]\n\n", err)
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	if err := cmd.run(); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "statevalidate failed: %v\n", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func (c *command) run() error {
+	stateRW, err := bufstate.NewReadWriter()
+	if err != nil {
+		return fmt.Errorf("new state read writer: %w", err)
+	}
+	options := []bufstate.ValidateSyncDirOption{
+		bufstate.ValidateSyncDirWithCASBuckets(func(moduleDirPath string) (storage.ReadBucket, error) {
+			return bufcas.NewReadBucket(filepath.Join(moduleDirPath, bufcas.CASDirName))
+		}),
+	}
+	if c.fetchScript != "" {
+		releaseModuleNames, err := readReleaseModuleNames(c.fetchScript)
+		if err != nil {
+			return err
+		}
+		options = append(options, bufstate.ValidateSyncDirWithReleaseModules(releaseModuleNames...))
+	}
+	if err := stateRW.ValidateSyncDir(context.Background(), c.rootSyncDir, options...); err != nil {
+		errs := multierr.Errors(err)
+		for _, err := range errs {
+			_, _ = fmt.Fprintln(os.Stdout, err)
+		}
+		return fmt.Errorf("%d violations found", len(errs))
+	}
+	_, _ = fmt.Fprintln(os.Stdout, "all state files are valid")
+	return nil
+}
+
+// readReleaseModuleNames returns the modules synced with the releases strategy in the fetch script.
+func readReleaseModuleNames(fetchScriptPath string) (_ []string, retErr error) {
+	file, err := os.Open(fetchScriptPath)
+	if err != nil {
+		return nil, fmt.Errorf("open fetch script: %w", err)
+	}
+	defer func() {
+		retErr = multierr.Append(retErr, file.Close())
+	}()
+	var moduleNames []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if match := releaseModuleRegexp.FindStringSubmatch(scanner.Text()); match != nil {
+			moduleNames = append(moduleNames, filepath.Join(match[1], match[2]))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read fetch script: %w", err)
+	}
+	return moduleNames, nil
+}
@@ -0,0 +1,360 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufcasbuild
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/bufbuild/buf/private/bufpkg/bufcheck"
+	"github.com/bufbuild/buf/private/bufpkg/bufconfig"
+	"github.com/bufbuild/buf/private/bufpkg/bufimage"
+	"github.com/bufbuild/buf/private/bufpkg/bufmodule"
+	"github.com/bufbuild/buf/private/bufpkg/bufparse"
+	"github.com/bufbuild/buf/private/pkg/cas"
+	"github.com/bufbuild/buf/private/pkg/storage"
+	"github.com/bufbuild/buf/private/pkg/storage/storagemem"
+	"github.com/bufbuild/modules/private/bufpkg/bufcas"
+	"github.com/bufbuild/modules/private/bufpkg/bufstate"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// defaultBufYAMLData is used for references that have no buf.yaml.
+	defaultBufYAMLData = "version: v1\n"
+	// defaultRegistry is the registry of references that have no module name in their buf.yaml.
+	defaultRegistry = "buf.build"
+)
+
+// Builder builds synced module references from the CAS directories of a root sync directory, without
+// cloning their upstream repositories.
+//
+// Dependencies in the buf.yaml of a reference are resolved against the other managed modules: at
+// their pinned reference if any module of the dependency graph pins them, otherwise at their latest
+// reference in the global state file. Modules read from CAS are cached in memory, so a single builder can be shared
+// across many builds. It is safe for concurrent use.
+type Builder struct {
+	logger      *slog.Logger
+	rootSyncDir string
+	// moduleNameToLatestRef has the latest reference of all managed modules, by "owner/repo" name.
+	moduleNameToLatestRef map[string]string
+
+	lock                 sync.Mutex
+	moduleRefToCASModule map[string]*casModule
+	// casModuleReads deduplicates concurrent reads of the same module reference.
+	casModuleReads singleflight.Group
+}
+
+// NewBuilder returns a new Builder for the root sync directory at rootSyncDir.
+func NewBuilder(logger *slog.Logger, rootSyncDir string) (*Builder, error) {
+	globalStateFile, err := os.Open(filepath.Join(rootSyncDir, bufstate.GlobalStateFileName))
+	if err != nil {
+		return nil, fmt.Errorf("open global state file: %w", err)
+	}
+	stateRW, err := bufstate.NewReadWriter()
+	if err != nil {
+		_ = globalStateFile.Close()
+		return nil, fmt.Errorf("new state read writer: %w", err)
+	}
+	globalState, err := stateRW.ReadGlobalState(globalStateFile)
+	if err != nil {
+		return nil, fmt.Errorf("read global state file: %w", err)
+	}
+	moduleNameToLatestRef := make(map[string]string, len(globalState.GetModules()))
+	for _, module := range globalState.GetModules() {
+		moduleNameToLatestRef[module.GetModuleName()] = module.GetLatestReference()
+	}
+	return &Builder{
+		logger:                logger,
+		rootSyncDir:           rootSyncDir,
+		moduleNameToLatestRef: moduleNameToLatestRef,
+		moduleRefToCASModule:  make(map[string]*casModule),
+	}, nil
+}
+
+// LatestReference returns the latest reference of a managed module in the global state file.
+func (b *Builder) LatestReference(moduleName string) (string, bool) {
+	latestRef, ok := b.moduleNameToLatestRef[moduleName]
+	return latestRef, ok
+}
+
+// Build builds a reference of a managed module, and returns the image of its files. Files of
+// dependencies are included as imports.
+//
+// The ref is either a reference name in the module state file, or a manifest digest hex.
+func (b *Builder) Build(ctx context.Context, moduleName string, ref string) (bufimage.Image, error) {
+	image, _, err := b.build(ctx, moduleName, ref)
+	return image, err
+}
+
+// Lint builds a reference of a managed module, and lints its files with the lint configuration of
+// its buf.yaml. Lint failures are returned as a bufanalysis.FileAnnotationSet.
+//
+// The ref is either a reference name in the module state file, or a manifest digest hex.
+func (b *Builder) Lint(ctx context.Context, moduleName string, ref string) error {
+	image, target, err := b.build(ctx, moduleName, ref)
+	if err != nil {
+		return err
+	}
+	checkClient, err := bufcheck.NewClient(b.logger)
+	if err != nil {
+		return fmt.Errorf("new check client: %w", err)
+	}
+	return checkClient.Lint(ctx, target.moduleConfig.LintConfig(), image)
+}
+
+func (b *Builder) build(ctx context.Context, moduleName string, ref string) (bufimage.Image, *casModule, error) {
+	target, err := b.getCASModule(ctx, moduleName, ref)
+	if err != nil {
+		return nil, nil, err
+	}
+	deps, err := b.resolveDeps(ctx, target)
+	if err != nil {
+		return nil, nil, err
+	}
+	moduleSetBuilder := bufmodule.NewModuleSetBuilder(ctx, b.logger, bufmodule.NopModuleDataProvider, bufmodule.NopCommitProvider)
+	for _, module := range append([]*casModule{target}, deps...) {
+		moduleSetBuilder.AddLocalModule(
+			module.bucket,
+			module.moduleName,
+			module == target,
+			bufmodule.LocalModuleWithFullName(module.fullName),
+		)
+	}
+	moduleSet, err := moduleSetBuilder.Build()
+	if err != nil {
+		return nil, nil, fmt.Errorf("build module set: %w", err)
+	}
+	image, err := bufimage.BuildImage(ctx, b.logger, bufmodule.ModuleSetToModuleReadBucketWithOnlyProtoFiles(moduleSet))
+	if err != nil {
+		return nil, nil, err
+	}
+	return image, target, nil
+}
+
+// resolveDeps returns the transitive dependencies of the target module. A module can only be resolved
+// at a single reference: a dependency pinned by any module of the graph is resolved at its pinned
+// reference, whatever the order modules are visited in, and other dependencies are resolved at their
+// latest reference. Two modules pinning the same dependency at different references is an error.
+//
+// Resolving a dependency at its pinned reference can change its own dependencies, so the graph is
+// walked again until its pinned references no longer change.
+func (b *Builder) resolveDeps(ctx context.Context, target *casModule) ([]*casModule, error) {
+	moduleNameToPinnedRef := map[string]string{target.moduleName: target.ref}
+	seenPinnedRefs := make(map[string]struct{})
+	for {
+		deps, moduleNameToPins, err := b.walkDeps(ctx, target, moduleNameToPinnedRef)
+		if err != nil {
+			return nil, err
+		}
+		newModuleNameToPinnedRef := map[string]string{target.moduleName: target.ref}
+		for _, moduleName := range slices.Sorted(maps.Keys(moduleNameToPins)) {
+			refToDependent := moduleNameToPins[moduleName]
+			if moduleName == target.moduleName {
+				delete(refToDependent, target.ref)
+				if len(refToDependent) > 0 {
+					ref := slices.Min(slices.Collect(maps.Keys(refToDependent)))
+					return nil, fmt.Errorf("%s depends on %s@%s, but the build target is %s", refToDependent[ref], moduleName, ref, target)
+				}
+				continue
+			}
+			refs := slices.Sorted(maps.Keys(refToDependent))
+			if len(refs) > 1 {
+				return nil, fmt.Errorf(
+					"%s depends on %s@%s, but %s depends on %s@%s",
+					refToDependent[refs[0]], moduleName, refs[0],
+					refToDependent[refs[1]], moduleName, refs[1],
+				)
+			}
+			newModuleNameToPinnedRef[moduleName] = refs[0]
+		}
+		if maps.Equal(moduleNameToPinnedRef, newModuleNameToPinnedRef) {
+			return deps, nil
+		}
+		key := pinnedRefsKey(newModuleNameToPinnedRef)
+		if _, ok := seenPinnedRefs[key]; ok {
+			return nil, fmt.Errorf("pinned references of %s dependencies do not converge", target)
+		}
+		seenPinnedRefs[key] = struct{}{}
+		moduleNameToPinnedRef = newModuleNameToPinnedRef
+	}
+}
+
+// walkDeps walks the dependency graph of the target module breadth-first, resolving dependencies at
+// their reference in moduleNameToPinnedRef, or at their latest reference. It returns the dependencies,
+// and the modules pinning each dependency by module name and pinned reference.
+func (b *Builder) walkDeps(
+	ctx context.Context,
+	target *casModule,
+	moduleNameToPinnedRef map[string]string,
+) ([]*casModule, map[string]map[string]*casModule, error) {
+	moduleNameToPins := make(map[string]map[string]*casModule)
+	visited := map[string]struct{}{target.moduleName: {}}
+	var deps []*casModule
+	queue := []*casModule{target}
+	for len(queue) > 0 {
+		module := queue[0]
+		queue = queue[1:]
+		for _, depRef := range module.depRefs {
+			depModuleName := depRef.FullName().Owner() + "/" + depRef.FullName().Name()
+			if pinnedRef := depRef.Ref(); pinnedRef != "" {
+				refToDependent, ok := moduleNameToPins[depModuleName]
+				if !ok {
+					refToDependent = make(map[string]*casModule)
+					moduleNameToPins[depModuleName] = refToDependent
+				}
+				// Keep the same dependent in errors, whatever the visit order.
+				if dependent, ok := refToDependent[pinnedRef]; !ok || module.String() < dependent.String() {
+					refToDependent[pinnedRef] = module
+				}
+			}
+			if _, ok := visited[depModuleName]; ok {
+				continue
+			}
+			visited[depModuleName] = struct{}{}
+			ref, ok := moduleNameToPinnedRef[depModuleName]
+			if !ok {
+				latestRef, ok := b.LatestReference(depModuleName)
+				if !ok {
+					return nil, nil, fmt.Errorf("%s depends on %s, which is not a managed module", module, depModuleName)
+				}
+				ref = latestRef
+			}
+			dep, err := b.getCASModule(ctx, depModuleName, ref)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s dependency: %w", module, err)
+			}
+			deps = append(deps, dep)
+			queue = append(queue, dep)
+		}
+	}
+	return deps, moduleNameToPins, nil
+}
+
+// pinnedRefsKey returns a key identifying the pinned references of a dependency graph.
+func pinnedRefsKey(moduleNameToPinnedRef map[string]string) string {
+	var key strings.Builder
+	for _, moduleName := range slices.Sorted(maps.Keys(moduleNameToPinnedRef)) {
+		key.WriteString(moduleName + "@" + moduleNameToPinnedRef[moduleName] + "\n")
+	}
+	return key.String()
+}
+
+// getCASModule returns the module reference from the cache, or reads it from its CAS directory.
+func (b *Builder) getCASModule(ctx context.Context, moduleName string, ref string) (*casModule, error) {
+	moduleRef := moduleName + "@" + ref
+	b.lock.Lock()
+	module, ok := b.moduleRefToCASModule[moduleRef]
+	b.lock.Unlock()
+	if ok {
+		return module, nil
+	}
+	// Concurrent reads of the same reference share a single read, and reads of different references
+	// run concurrently, outside of the lock. Failed reads are not cached, so later calls retry them.
+	value, err, _ := b.casModuleReads.Do(moduleRef, func() (any, error) {
+		module, err := b.readCASModule(ctx, moduleName, ref)
+		if err != nil {
+			return nil, err
+		}
+		b.lock.Lock()
+		b.moduleRefToCASModule[moduleRef] = module
+		b.lock.Unlock()
+		return module, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(*casModule), nil
+}
+
+func (b *Builder) readCASModule(ctx context.Context, moduleName string, ref string) (*casModule, error) {
+	fileSet, err := bufcas.ReadModuleReference(ctx, filepath.Join(b.rootSyncDir, filepath.FromSlash(moduleName)), ref)
+	if err != nil {
+		return nil, fmt.Errorf("read %s@%s: %w", moduleName, ref, err)
+	}
+	bucket := storagemem.NewReadWriteBucket()
+	if err := cas.PutFileSetToBucket(ctx, fileSet, bucket); err != nil {
+		return nil, fmt.Errorf("put %s@%s files: %w", moduleName, ref, err)
+	}
+	bufYAMLData, err := storage.ReadPath(ctx, bucket, bufconfig.DefaultBufYAMLFileName)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("read %s@%s buf.yaml: %w", moduleName, ref, err)
+		}
+		bufYAMLData = []byte(defaultBufYAMLData)
+	}
+	bufYAMLFile, err := bufconfig.ReadBufYAMLFile(bytes.NewReader(bufYAMLData), bufconfig.DefaultBufYAMLFileName)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s@%s buf.yaml: %w", moduleName, ref, err)
+	}
+	moduleConfigs := bufYAMLFile.ModuleConfigs()
+	if len(moduleConfigs) != 1 {
+		return nil, fmt.Errorf("%s@%s buf.yaml has %d modules, expected 1", moduleName, ref, len(moduleConfigs))
+	}
+	moduleConfig := moduleConfigs[0]
+	rootToExcludes := moduleConfig.RootToExcludes()
+	excludes, ok := rootToExcludes["."]
+	if !ok || len(rootToExcludes) != 1 {
+		return nil, fmt.Errorf("%s@%s buf.yaml has unsupported roots, expected only the module root", moduleName, ref)
+	}
+	var readBucket storage.ReadBucket = bucket
+	if len(excludes) > 0 {
+		excludeMatchers := make([]storage.Matcher, len(excludes))
+		for i, exclude := range excludes {
+			excludeMatchers[i] = storage.MatchPathContained(exclude)
+		}
+		readBucket = storage.FilterReadBucket(readBucket, storage.MatchNot(storage.MatchOr(excludeMatchers...)))
+	}
+	fullName := moduleConfig.FullName()
+	if fullName == nil {
+		owner, repo, _ := strings.Cut(moduleName, "/")
+		fullName, err = bufparse.NewFullName(defaultRegistry, owner, repo)
+		if err != nil {
+			return nil, fmt.Errorf("%s@%s full name: %w", moduleName, ref, err)
+		}
+	}
+	return &casModule{
+		moduleName:   moduleName,
+		ref:          ref,
+		fullName:     fullName,
+		bucket:       readBucket,
+		moduleConfig: moduleConfig,
+		depRefs:      bufYAMLFile.ConfiguredDepModuleRefs(),
+	}, nil
+}
+
+// casModule is a managed module reference read from CAS.
+type casModule struct {
+	moduleName   string
+	ref          string
+	fullName     bufparse.FullName
+	bucket       storage.ReadBucket
+	moduleConfig bufconfig.ModuleConfig
+	depRefs      []bufparse.Ref
+}
+
+func (m *casModule) String() string {
+	return m.moduleName + "@" + m.ref
+}
@@ -0,0 +1,247 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufcasbuild
+
+import (
+	"context"
+	"encoding/hex"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bufbuild/buf/private/pkg/cas"
+	"github.com/bufbuild/buf/private/pkg/storage/storagemem"
+	"github.com/bufbuild/modules/private/bufpkg/bufcas"
+	"github.com/bufbuild/modules/private/bufpkg/bufstate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuild(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	rootSyncDir := t.TempDir()
+	writeModuleReference(ctx, t, rootSyncDir, "acme", "dep", "v1", map[string]string{
+		"buf.yaml": "version: v1\nname: buf.build/acme/dep\n",
+		"acme/dep/v1/dep.proto": `syntax = "proto3";
+package acme.dep.v1;
+message Dep {}
+`,
+	})
+	writeModuleReference(ctx, t, rootSyncDir, "acme", "dep", "v2", map[string]string{
+		"buf.yaml": "version: v1\nname: buf.build/acme/dep\n",
+		"acme/dep/v1/dep.proto": `syntax = "proto3";
+package acme.dep.v1;
+import "google/protobuf/timestamp.proto";
+message Dep {}
+message DepV2 {
+  google.protobuf.Timestamp time = 1;
+}
+`,
+	})
+	appProto := `syntax = "proto3";
+package acme.app.v1;
+import "acme/dep/v1/dep.proto";
+message App {
+  acme.dep.v1.DepV2 dep = 1;
+}
+`
+	writeModuleReference(ctx, t, rootSyncDir, "acme", "app", "latest-dep", map[string]string{
+		"buf.yaml":              "version: v1\nname: buf.build/acme/app\ndeps:\n  - buf.build/acme/dep\n",
+		"acme/app/v1/app.proto": appProto,
+	})
+	writeModuleReference(ctx, t, rootSyncDir, "acme", "app", "pinned-dep", map[string]string{
+		"buf.yaml":              "version: v1\nname: buf.build/acme/app\ndeps:\n  - buf.build/acme/dep:v1\n",
+		"acme/app/v1/app.proto": appProto,
+	})
+	writeModuleReference(ctx, t, rootSyncDir, "acme", "app", "excluded", map[string]string{
+		"buf.yaml":              "version: v1\nname: buf.build/acme/app\nbuild:\n  excludes:\n    - broken\n",
+		"acme/app/v1/app.proto": "syntax = \"proto3\";\npackage acme.app.v1;\nmessage App {}\n",
+		"broken/broken.proto":   "syntax = \"proto3\";\nmessage {",
+	})
+	writeModuleReference(ctx, t, rootSyncDir, "acme", "app", "unmanaged-dep", map[string]string{
+		"buf.yaml":              "version: v1\nname: buf.build/acme/app\ndeps:\n  - buf.build/acme/unmanaged\n",
+		"acme/app/v1/app.proto": appProto,
+	})
+	builder, err := NewBuilder(slog.New(slog.DiscardHandler), rootSyncDir)
+	require.NoError(t, err)
+	latestRef, ok := builder.LatestReference("acme/dep")
+	require.True(t, ok)
+	assert.Equal(t, "v2", latestRef)
+
+	image, err := builder.Build(ctx, "acme/app", "latest-dep")
+	require.NoError(t, err)
+	var targetPaths []string
+	for _, imageFile := range image.Files() {
+		if !imageFile.IsImport() {
+			targetPaths = append(targetPaths, imageFile.Path())
+		}
+	}
+	assert.Equal(t, []string{"acme/app/v1/app.proto"}, targetPaths)
+	assert.NotNil(t, image.GetFile("acme/dep/v1/dep.proto"))
+	assert.NotNil(t, image.GetFile("google/protobuf/timestamp.proto"))
+
+	// concurrent builds share the module references read from CAS
+	var wg sync.WaitGroup
+	for _, ref := range []string{"latest-dep", "latest-dep", "excluded", "excluded"} {
+		wg.Go(func() {
+			_, err := builder.Build(ctx, "acme/app", ref)
+			assert.NoError(t, err)
+		})
+	}
+	wg.Wait()
+
+	_, err = builder.Build(ctx, "acme/app", "pinned-dep")
+	require.ErrorContains(t, err, "DepV2")
+	_, err = builder.Build(ctx, "acme/app", "excluded")
+	require.NoError(t, err)
+	_, err = builder.Build(ctx, "acme/app", "unmanaged-dep")
+	require.ErrorContains(t, err, "acme/unmanaged, which is not a managed module")
+	_, err = builder.Build(ctx, "acme/app", "unknown")
+	require.Error(t, err)
+}
+
+func TestLint(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	rootSyncDir := t.TempDir()
+	proto := "syntax = \"proto3\";\npackage acme.app;\nmessage App {}\n"
+	writeModuleReference(ctx, t, rootSyncDir, "acme", "app", "default", map[string]string{
+		"buf.yaml":           "version: v1\nname: buf.build/acme/app\n",
+		"acme/app/app.proto": proto,
+	})
+	writeModuleReference(ctx, t, rootSyncDir, "acme", "app", "except", map[string]string{
+		"buf.yaml":           "version: v1\nname: buf.build/acme/app\nlint:\n  use:\n    - STANDARD\n  except:\n    - PACKAGE_VERSION_SUFFIX\n",
+		"acme/app/app.proto": proto,
+	})
+	builder, err := NewBuilder(slog.New(slog.DiscardHandler), rootSyncDir)
+	require.NoError(t, err)
+	_, err = builder.Build(ctx, "acme/app", "default")
+	require.NoError(t, err)
+	err = builder.Lint(ctx, "acme/app", "default")
+	require.ErrorContains(t, err, "should be suffixed with a correctly formed version")
+	require.NoError(t, builder.Lint(ctx, "acme/app", "except"))
+}
+
+// writeModuleReference syncs the files as a new reference of the module in the root sync directory.
+func writeModuleReference(
+	ctx context.Context,
+	t *testing.T,
+	rootSyncDir string,
+	owner string,
+	repo string,
+	ref string,
+	files map[string]string,
+) {
+	t.Helper()
+	pathToData := make(map[string][]byte, len(files))
+	for path, content := range files {
+		pathToData[path] = []byte(content)
+	}
+	bucket, err := storagemem.NewReadBucket(pathToData)
+	require.NoError(t, err)
+	fileSet, err := cas.NewFileSetForBucket(ctx, bucket, cas.DigestTypeShake256)
+	require.NoError(t, err)
+	manifestBlob, err := cas.ManifestToBlob(fileSet.Manifest(), cas.DigestTypeShake256)
+	require.NoError(t, err)
+	casDirPath := filepath.Join(rootSyncDir, owner, repo, bufcas.CASDirName)
+	require.NoError(t, bufcas.WriteBlobs(ctx, casDirPath, bufcas.LayoutLoose, append([]cas.Blob{manifestBlob}, fileSet.BlobSet().Blobs()...)))
+	stateRW, err := bufstate.NewReadWriter()
+	require.NoError(t, err)
+	require.NoError(t, stateRW.AppendModuleReference(rootSyncDir, owner, repo, ref, hex.EncodeToString(manifestBlob.Digest().Value())))
+}
+
+func TestBuildPinnedDeps(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	rootSyncDir := t.TempDir()
+	for _, ref := range []string{"v1", "v2", "v3"} {
+		writeModuleReference(ctx, t, rootSyncDir, "acme", "dep", ref, map[string]string{
+			"buf.yaml":              "version: v1\nname: buf.build/acme/dep\n",
+			"acme/dep/v1/dep.proto": "syntax = \"proto3\";\npackage acme.dep.v1;\nmessage Dep" + strings.ToUpper(ref) + " {}\n",
+		})
+	}
+	// The deps of a buf.yaml are sorted, so the order dependencies are visited in is set by their depth.
+	for repo, dep := range map[string]string{
+		"latest":      "dep",
+		"pinnedv1":    "dep:v1",
+		"pinnedv2":    "dep:v2",
+		"vialatest":   "latest",
+		"viapinnedv1": "pinnedv1",
+	} {
+		depRepo, _, _ := strings.Cut(dep, ":")
+		writeModuleReference(ctx, t, rootSyncDir, "acme", repo, "v1", map[string]string{
+			"buf.yaml": "version: v1\nname: buf.build/acme/" + repo + "\ndeps:\n  - buf.build/acme/" + dep + "\n",
+			"acme/" + repo + "/v1/" + repo + ".proto": "syntax = \"proto3\";\npackage acme." + repo + ".v1;\n" +
+				"import \"acme/" + depRepo + "/v1/" + depRepo + ".proto\";\n",
+		})
+	}
+	type testCase struct {
+		name    string
+		deps    []string
+		wantErr string
+	}
+	testCases := []testCase{
+		{
+			name: "unpinned_first",
+			deps: []string{"latest", "viapinnedv1"},
+		},
+		{
+			name: "pinned_first",
+			deps: []string{"pinnedv1", "vialatest"},
+		},
+		{
+			name:    "conflicting_pins",
+			deps:    []string{"pinnedv1", "pinnedv2"},
+			wantErr: "acme/pinnedv1@v1 depends on acme/dep@v1, but acme/pinnedv2@v1 depends on acme/dep@v2",
+		},
+		{
+			name:    "conflicting_nested_pins",
+			deps:    []string{"pinnedv2", "viapinnedv1"},
+			wantErr: "acme/pinnedv1@v1 depends on acme/dep@v1, but acme/pinnedv2@v1 depends on acme/dep@v2",
+		},
+	}
+	for _, tc := range testCases {
+		bufYAML := "version: v1\nname: buf.build/acme/app\ndeps:\n"
+		appProto := "syntax = \"proto3\";\npackage acme.app.v1;\n"
+		for _, dep := range tc.deps {
+			bufYAML += "  - buf.build/acme/" + dep + "\n"
+			appProto += "import \"acme/" + dep + "/v1/" + dep + ".proto\";\n"
+		}
+		writeModuleReference(ctx, t, rootSyncDir, "acme", "app", tc.name, map[string]string{
+			"buf.yaml":              bufYAML,
+			"acme/app/v1/app.proto": appProto,
+		})
+	}
+	builder, err := NewBuilder(slog.New(slog.DiscardHandler), rootSyncDir)
+	require.NoError(t, err)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			image, err := builder.Build(ctx, "acme/app", tc.name)
+			if tc.wantErr != "" {
+				require.ErrorContains(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			depFile := image.GetFile("acme/dep/v1/dep.proto")
+			require.NotNil(t, depFile)
+			require.Len(t, depFile.FileDescriptorProto().GetMessageType(), 1)
+			assert.Equal(t, "DepV1", depFile.FileDescriptorProto().GetMessageType()[0].GetName())
+		})
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufcasdiff
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/bufbuild/buf/private/pkg/storage"
+)
+
+// DefaultBlobCacheSize is the default maximum size in bytes of the blobs a module reader keeps in
+// memory.
+const DefaultBlobCacheSize = 64 << 20
+
+// BlobCache keeps the most recently read CAS blobs in memory, up to a maximum total size. Blobs are
+// content addressed, so a single cache can be shared across the module readers of many modules,
+// bounding their memory all together. It is safe for concurrent use.
+type BlobCache struct {
+	maxSize int
+	// maxBlobSize is the maximum size of a single cached blob, larger blobs are streamed from disk.
+	maxBlobSize int
+
+	lock          sync.Mutex
+	size          int
+	lru           *list.List // of *cachedBlob, most recently used first.
+	pathToElement map[string]*list.Element
+}
+
+// NewBlobCache returns a new BlobCache of up to maxSize bytes. Blobs over DefaultMaxFileSize are
+// never cached.
+func NewBlobCache(maxSize int) *BlobCache {
+	return &BlobCache{
+		maxSize:       max(maxSize, 0),
+		maxBlobSize:   min(max(maxSize, 0), DefaultMaxFileSize),
+		lru:           list.New(),
+		pathToElement: make(map[string]*list.Element),
+	}
+}
+
+type cachedBlob struct {
+	path string
+	info storage.ObjectInfo
+	data []byte
+}
+
+func (c *BlobCache) get(path string) (*cachedBlob, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	element, ok := c.pathToElement[path]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(element)
+	return element.Value.(*cachedBlob), true
+}
+
+func (c *BlobCache) add(blob *cachedBlob) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if _, ok := c.pathToElement[blob.path]; ok {
+		// Concurrent misses on the same path read the same content.
+		return
+	}
+	c.pathToElement[blob.path] = c.lru.PushFront(blob)
+	c.size += len(blob.data)
+	for c.size > c.maxSize {
+		oldest := c.lru.Back()
+		oldestBlob := c.lru.Remove(oldest).(*cachedBlob)
+		delete(c.pathToElement, oldestBlob.path)
+		c.size -= len(oldestBlob.data)
+	}
+}
+
+// cachedReadBucket is a storage.ReadBucket that reads blobs through a BlobCache. Objects whose
+// paths are not digest hexes, and blobs over the max blob size of the cache, are streamed from the
+// delegate.
+type cachedReadBucket struct {
+	storage.ReadBucket
+
+	cache *BlobCache
+}
+
+func newCachedReadBucket(delegate storage.ReadBucket, cache *BlobCache) *cachedReadBucket {
+	return &cachedReadBucket{
+		ReadBucket: delegate,
+		cache:      cache,
+	}
+}
+
+func (b *cachedReadBucket) Get(ctx context.Context, path string) (storage.ReadObjectCloser, error) {
+	if _, err := hex.DecodeString(path); err != nil || path == "" {
+		return b.ReadBucket.Get(ctx, path)
+	}
+	if blob, ok := b.cache.get(path); ok {
+		return newBytesReadObjectCloser(blob.info, blob.data), nil
+	}
+	readObjectCloser, err := b.ReadBucket.Get(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(readObjectCloser, int64(b.cache.maxBlobSize)+1))
+	if err != nil {
+		_ = readObjectCloser.Close()
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	if len(data) > b.cache.maxBlobSize {
+		// Stream the rest of the blob, without keeping it in memory.
+		return newReadObjectCloser(readObjectCloser, io.MultiReader(bytes.NewReader(data), readObjectCloser), readObjectCloser), nil
+	}
+	if err := readObjectCloser.Close(); err != nil {
+		return nil, fmt.Errorf("close %s: %w", path, err)
+	}
+	blob := &cachedBlob{
+		path: path,
+		info: newObjectInfo(readObjectCloser),
+		data: data,
+	}
+	b.cache.add(blob)
+	return newBytesReadObjectCloser(blob.info, blob.data), nil
+}
+
+type readObjectCloser struct {
+	storage.ObjectInfo
+	io.Reader
+	io.Closer
+}
+
+func newReadObjectCloser(objectInfo storage.ObjectInfo, reader io.Reader, closer io.Closer) *readObjectCloser {
+	return &readObjectCloser{
+		ObjectInfo: objectInfo,
+		Reader:     reader,
+		Closer:     closer,
+	}
+}
+
+func newBytesReadObjectCloser(objectInfo storage.ObjectInfo, data []byte) *readObjectCloser {
+	reader := bytes.NewReader(data)
+	return newReadObjectCloser(objectInfo, reader, io.NopCloser(reader))
+}
+
+// objectInfo is a copy of a storage.ObjectInfo, which does not keep its object alive.
+type objectInfo struct {
+	path         string
+	externalPath string
+	localPath    string
+}
+
+func newObjectInfo(info storage.ObjectInfo) *objectInfo {
+	return &objectInfo{
+		path:         info.Path(),
+		externalPath: info.ExternalPath(),
+		localPath:    info.LocalPath(),
+	}
+}
+
+func (i *objectInfo) Path() string {
+	return i.path
+}
+
+func (i *objectInfo) ExternalPath() string {
+	return i.externalPath
+}
+
+func (i *objectInfo) LocalPath() string {
+	return i.localPath
+}
@@ -0,0 +1,71 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufcasdiff
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/bufbuild/buf/private/pkg/storage"
+	"github.com/bufbuild/buf/private/pkg/storage/storagemem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCachedReadBucket(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	delegate := storagemem.NewReadWriteBucket()
+	pathToData := map[string]string{
+		"aa":       strings.Repeat("a", 40),
+		"bb":       strings.Repeat("b", 40),
+		"cc":       strings.Repeat("c", 40),
+		"dd":       strings.Repeat("d", DefaultMaxFileSize+1),
+		"manifest": "not a digest",
+	}
+	for path, data := range pathToData {
+		require.NoError(t, storage.PutPath(ctx, delegate, path, []byte(data)))
+	}
+	countingBucket := &countingReadBucket{ReadBucket: delegate, pathsToReads: make(map[string]int)}
+	// room for two small blobs only
+	bucket := newCachedReadBucket(countingBucket, NewBlobCache(100))
+	read := func(path string) {
+		t.Helper()
+		readObjectCloser, err := bucket.Get(ctx, path)
+		require.NoError(t, err)
+		data, err := io.ReadAll(readObjectCloser)
+		require.NoError(t, err)
+		require.NoError(t, readObjectCloser.Close())
+		assert.Equal(t, pathToData[path], string(data))
+		assert.Equal(t, path, readObjectCloser.Path())
+	}
+	for _, path := range []string{"aa", "bb", "aa", "cc", "aa", "bb", "dd", "dd", "manifest", "manifest"} {
+		read(path)
+	}
+	assert.Equal(t, map[string]int{
+		// bb is evicted by cc, as aa was used more recently
+		"aa": 1,
+		"bb": 2,
+		"cc": 1,
+		// too large or not content addressed, never cached
+		"dd":       2,
+		"manifest": 2,
+	}, countingBucket.reads())
+	assert.LessOrEqual(t, bucket.cache.size, 100)
+
+	_, err := bucket.Get(ctx, "ee")
+	require.Error(t, err)
+}
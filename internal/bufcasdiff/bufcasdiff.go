@@ -16,15 +16,20 @@ package bufcasdiff
 
 import (
 	"context"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io/fs"
 	"path/filepath"
+	"sync"
 
 	"github.com/bufbuild/buf/private/pkg/cas"
 	"github.com/bufbuild/buf/private/pkg/storage"
+	"github.com/bufbuild/buf/private/pkg/storage/storagemem"
 	"github.com/bufbuild/buf/private/pkg/storage/storageos"
+	"github.com/bufbuild/modules/private/bufpkg/bufcas"
 	"github.com/bufbuild/modules/private/bufpkg/bufstate"
+	statev1beta1 "github.com/bufbuild/modules/private/gen/modules/state/v1beta1"
 )
 
 // DiffModuleDirectory computes the diff between two refs or two digests in the module directory at
@@ -37,84 +42,346 @@ func DiffModuleDirectory(
 	dirPath string,
 	from string,
 	to string,
+	options ...DiffOption,
 ) (*ManifestDiff, error) {
 	if from == to {
 		return newManifestDiff(), nil
 	}
-	bucket, err := storageos.NewProvider().NewReadWriteBucket(dirPath)
+	moduleReader, err := NewModuleReader(ctx, dirPath)
 	if err != nil {
-		return nil, fmt.Errorf("new rw bucket: %w", err)
+		return nil, err
 	}
-	moduleStateReader, err := bucket.Get(ctx, bufstate.ModStateFileName)
+	return moduleReader.Diff(ctx, from, to, options...)
+}
+
+// DiffModuleDirectories computes the diff between a ref or digest in the module directory at
+// fromDirPath and a ref or digest in the module directory at toDirPath, e.g. to compare a module
+// with another module synced from the same upstream. Manifests and blobs of each side are read from
+// their own module directory.
+func DiffModuleDirectories(
+	ctx context.Context,
+	fromDirPath string,
+	from string,
+	toDirPath string,
+	to string,
+	options ...DiffOption,
+) (*ManifestDiff, error) {
+	fromModuleReader, err := NewModuleReader(ctx, fromDirPath)
 	if err != nil {
-		if !errors.Is(err, fs.ErrNotExist) {
-			return nil, fmt.Errorf("read module state file: %w", err)
-		}
-		// No state.json — dirPath is a CAS directory and from/to are manifest filenames.
-		return calculateDiffFromCASDirectory(ctx, bucket, from, to)
+		return nil, fmt.Errorf("from module: %w", err)
 	}
-	// state file was found, attempt to parse it and match from/to with its references
-	stateRW, err := bufstate.NewReadWriter()
+	toModuleReader, err := NewModuleReader(ctx, toDirPath)
 	if err != nil {
-		return nil, fmt.Errorf("new state rw: %w", err)
+		return nil, fmt.Errorf("to module: %w", err)
 	}
-	moduleState, err := stateRW.ReadModStateFile(moduleStateReader)
+	return fromModuleReader.DiffModule(ctx, from, toModuleReader, to, options...)
+}
+
+// DiffModuleDirectoryAgainstDirectory computes the diff between a ref or digest in the module
+// directory at moduleDirPath and the files in the local directory at dirPath, as if they were synced
+// as a new reference. Nothing is written to the module directory.
+//
+// If from is empty, the latest reference in the module state file is used.
+func DiffModuleDirectoryAgainstDirectory(
+	ctx context.Context,
+	moduleDirPath string,
+	from string,
+	dirPath string,
+	options ...DiffOption,
+) (*ManifestDiff, error) {
+	moduleReader, err := NewModuleReader(ctx, moduleDirPath)
 	if err != nil {
-		return nil, fmt.Errorf("read module state: %w", err)
+		return nil, err
 	}
-	var (
-		fromManifestPath string
-		toManifestPath   string
-	)
-	for _, ref := range moduleState.GetReferences() {
-		if ref.GetName() == from {
-			fromManifestPath = ref.GetDigest()
-			if toManifestPath != "" {
-				break
-			}
-		} else if ref.GetName() == to {
-			toManifestPath = ref.GetDigest()
-			if fromManifestPath != "" {
-				break
-			}
+	if from == "" {
+		latestRef, ok := moduleReader.LatestReference()
+		if !ok {
+			return nil, errors.New("no from reference given, and the module directory has no references")
 		}
+		from = latestRef
 	}
-	if fromManifestPath == "" {
-		return nil, fmt.Errorf("from reference %s not found in the module state file", from)
+	return moduleReader.DiffDirectory(ctx, from, dirPath, options...)
+}
+
+// DescribeModuleDirectory computes a report of the full content of a single ref or digest in the
+// module directory at dirPath.
+//
+// If a state.json file is present, ref is resolved as a ref name against it, otherwise, dirPath is
+// treated as a CAS directory and ref is a manifest filename directly.
+func DescribeModuleDirectory(
+	ctx context.Context,
+	dirPath string,
+	ref string,
+) (*ManifestReport, error) {
+	moduleReader, err := NewModuleReader(ctx, dirPath)
+	if err != nil {
+		return nil, err
 	}
-	if toManifestPath == "" {
-		return nil, fmt.Errorf("to reference %s not found in the module state file", to)
+	return moduleReader.Describe(ctx, ref)
+}
+
+// ModuleReader reads references, manifests and blobs from a single module directory. Manifests it
+// reads are cached in memory, and blobs in a size-bounded BlobCache, so a single reader can be
+// shared across many diffs and reports of the same module. It is safe for concurrent use.
+type ModuleReader struct {
+	history   *bufstate.ModuleHistory // nil if the module directory is a CAS directory.
+	casBucket storage.ReadBucket
+
+	lock                   sync.RWMutex
+	manifestPathToManifest map[string]cas.Manifest
+}
+
+// NewModuleReader returns a new ModuleReader for the module directory at dirPath.
+//
+// If a state.json file is present, refs are resolved as ref names against it, otherwise, dirPath is
+// treated as a CAS directory and refs are manifest filenames directly.
+func NewModuleReader(ctx context.Context, dirPath string, options ...ModuleReaderOption) (*ModuleReader, error) {
+	moduleReaderOptions := &moduleReaderOptions{}
+	for _, option := range options {
+		option(moduleReaderOptions)
+	}
+	if moduleReaderOptions.blobCache == nil {
+		moduleReaderOptions.blobCache = NewBlobCache(DefaultBlobCacheSize)
+	}
+	bucket, err := storageos.NewProvider().NewReadWriteBucket(dirPath)
+	if err != nil {
+		return nil, fmt.Errorf("new rw bucket: %w", err)
 	}
-	if fromManifestPath == toManifestPath {
+	moduleState, found, err := readModuleState(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+	casDirPath := filepath.Join(dirPath, bufcas.CASDirName)
+	if !found {
+		// No state.json — dirPath is a CAS directory and refs are manifest filenames.
+		casDirPath = dirPath
+	}
+	// Blobs can be stored in either CAS layout.
+	casBucket, err := bufcas.NewReadBucket(casDirPath)
+	if err != nil {
+		return nil, fmt.Errorf("new cas bucket: %w", err)
+	}
+	return newModuleReader(moduleState, newCachedReadBucket(casBucket, moduleReaderOptions.blobCache)), nil
+}
+
+// ModuleReaderOption is an option for a new ModuleReader.
+type ModuleReaderOption func(*moduleReaderOptions)
+
+// ModuleReaderWithBlobCache sets the cache of the blobs read by the module reader, which can be
+// shared with other module readers. The default is a cache of DefaultBlobCacheSize for the reader
+// alone.
+func ModuleReaderWithBlobCache(blobCache *BlobCache) ModuleReaderOption {
+	return func(options *moduleReaderOptions) {
+		options.blobCache = blobCache
+	}
+}
+
+type moduleReaderOptions struct {
+	blobCache *BlobCache
+}
+
+func newModuleReader(moduleState *statev1beta1.ModuleState, casBucket storage.ReadBucket) *ModuleReader {
+	var history *bufstate.ModuleHistory
+	if moduleState != nil {
+		history = bufstate.NewModuleHistory(moduleState)
+	}
+	return &ModuleReader{
+		history:                history,
+		casBucket:              casBucket,
+		manifestPathToManifest: make(map[string]cas.Manifest),
+	}
+}
+
+// Diff computes the diff between two refs or two digests in the module.
+func (r *ModuleReader) Diff(ctx context.Context, from string, to string, options ...DiffOption) (*ManifestDiff, error) {
+	if from == to {
 		return newManifestDiff(), nil
 	}
-	casBucket, err := storageos.NewProvider().NewReadWriteBucket(filepath.Join(dirPath, "cas"))
+	return r.DiffModule(ctx, from, r, to, options...)
+}
+
+// DiffModule computes the diff between a ref or digest in the module and a ref or digest in the
+// module read by toReader, which can be the same module.
+func (r *ModuleReader) DiffModule(
+	ctx context.Context,
+	from string,
+	toReader *ModuleReader,
+	to string,
+	options ...DiffOption,
+) (*ManifestDiff, error) {
+	fromManifestPath, err := r.manifestPath(from)
+	if err != nil {
+		return nil, fmt.Errorf("from %w", err)
+	}
+	toManifestPath, err := toReader.manifestPath(to)
 	if err != nil {
-		return nil, fmt.Errorf("new rw cas bucket: %w", err)
+		return nil, fmt.Errorf("to %w", err)
 	}
-	return calculateDiffFromCASDirectory(ctx, casBucket, fromManifestPath, toManifestPath)
+	mdiff := newManifestDiff()
+	if fromManifestPath != toManifestPath {
+		// manifest paths are digests, so the same path is the same content even across modules
+		fromManifest, err := r.readManifest(ctx, fromManifestPath)
+		if err != nil {
+			return nil, fmt.Errorf("read manifest from: %w", err)
+		}
+		toManifest, err := toReader.readManifest(ctx, toManifestPath)
+		if err != nil {
+			return nil, fmt.Errorf("read manifest to: %w", err)
+		}
+		bucket := r.casBucket
+		if toReader != r {
+			// Blobs are addressed by digest, so the same path has the same content in both buckets, and
+			// each side's blobs are found in its own bucket.
+			bucket = storage.OverlayReadBucket(r.casBucket, toReader.casBucket)
+		}
+		mdiff, err = buildManifestDiff(ctx, fromManifest, toManifest, bucket, options...)
+		if err != nil {
+			return nil, err
+		}
+	}
+	mdiff.fromReference = r.moduleReference(from)
+	mdiff.toReference = toReader.moduleReference(to)
+	return mdiff, nil
 }
 
-// calculateDiffFromCASDirectory takes the cas bucket, and the from/to manifest paths to calculate a
-// diff.
-func calculateDiffFromCASDirectory(
+// DiffDirectory computes the diff between a ref or digest in the module and the files in the local
+// directory at dirPath, as if they were synced as a new reference. The directory files are only read
+// in memory, nothing is written to the module directory.
+func (r *ModuleReader) DiffDirectory(
 	ctx context.Context,
-	casBucket storage.ReadBucket,
-	fromManifestPath string,
-	toManifestPath string,
+	from string,
+	dirPath string,
+	options ...DiffOption,
 ) (*ManifestDiff, error) {
-	if fromManifestPath == toManifestPath {
-		return newManifestDiff(), nil
+	fromManifestPath, err := r.manifestPath(from)
+	if err != nil {
+		return nil, fmt.Errorf("from %w", err)
 	}
-	fromManifest, err := readManifest(ctx, casBucket, fromManifestPath)
+	fromManifest, err := r.readManifest(ctx, fromManifestPath)
 	if err != nil {
 		return nil, fmt.Errorf("read manifest from: %w", err)
 	}
-	toManifest, err := readManifest(ctx, casBucket, toManifestPath)
+	dirBucket, err := storageos.NewProvider().NewReadWriteBucket(dirPath)
+	if err != nil {
+		return nil, fmt.Errorf("new directory bucket: %w", err)
+	}
+	fileSet, err := cas.NewFileSetForBucket(ctx, dirBucket, cas.DigestTypeShake256)
+	if err != nil {
+		return nil, fmt.Errorf("new file set for directory: %w", err)
+	}
+	// Directory blobs are kept in memory, on top of the module CAS blobs for the from side.
+	dirBlobsBucket := storagemem.NewReadWriteBucket()
+	for _, blob := range fileSet.BlobSet().Blobs() {
+		if err := storage.PutPath(ctx, dirBlobsBucket, hex.EncodeToString(blob.Digest().Value()), blob.Content()); err != nil {
+			return nil, fmt.Errorf("put directory blob: %w", err)
+		}
+	}
+	mdiff, err := buildManifestDiff(
+		ctx,
+		fromManifest,
+		fileSet.Manifest(),
+		storage.OverlayReadBucket(dirBlobsBucket, r.casBucket),
+		options...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	mdiff.fromReference = r.moduleReference(from)
+	return mdiff, nil
+}
+
+// LatestReference returns the name of the last reference in the module state file. Returns false
+// if the module has no references, or if the module directory is a CAS directory.
+func (r *ModuleReader) LatestReference() (string, bool) {
+	if r.history == nil {
+		return "", false
+	}
+	latest, ok := r.history.Latest()
+	return latest.GetName(), ok
+}
+
+// Describe computes a report of the full content of a single ref or digest in the module.
+func (r *ModuleReader) Describe(ctx context.Context, ref string) (*ManifestReport, error) {
+	manifestPath, err := r.manifestPath(ref)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := r.readManifest(ctx, manifestPath)
+	if err != nil {
+		return nil, fmt.Errorf("read manifest: %w", err)
+	}
+	report, err := buildManifestReport(ctx, manifest, r.casBucket)
+	if err != nil {
+		return nil, err
+	}
+	report.reference = r.moduleReference(ref)
+	return report, nil
+}
+
+// manifestPath resolves a ref to its manifest path in the CAS bucket. The previous digest of a
+// superseded reference resolves to itself, so superseded contents can be diffed.
+func (r *ModuleReader) manifestPath(ref string) (string, error) {
+	if r.history == nil {
+		return ref, nil
+	}
+	if moduleRef := r.moduleReference(ref); moduleRef != nil {
+		return moduleRef.GetDigest(), nil
+	}
+	if _, ok := r.history.SupersededReference(ref); ok {
+		return ref, nil
+	}
+	return "", fmt.Errorf("reference %s not found in the module state file", ref)
+}
+
+// moduleReference returns the reference named ref in the module state file, or nil if there is no
+// such reference or the module directory is a CAS directory.
+func (r *ModuleReader) moduleReference(ref string) *statev1beta1.ModuleReference {
+	if r.history == nil {
+		return nil
+	}
+	moduleRef, _ := r.history.Reference(ref)
+	return moduleRef
+}
+
+func (r *ModuleReader) readManifest(ctx context.Context, manifestPath string) (cas.Manifest, error) {
+	r.lock.RLock()
+	manifest, ok := r.manifestPathToManifest[manifestPath]
+	r.lock.RUnlock()
+	if ok {
+		return manifest, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	manifest, err := readManifest(ctx, r.casBucket, manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	r.lock.Lock()
+	r.manifestPathToManifest[manifestPath] = manifest
+	r.lock.Unlock()
+	return manifest, nil
+}
+
+// readModuleState reads the module state file in the module directory bucket. Returns false if the
+// bucket has no module state file.
+func readModuleState(ctx context.Context, bucket storage.ReadBucket) (*statev1beta1.ModuleState, bool, error) {
+	moduleStateReader, err := bucket.Get(ctx, bufstate.ModStateFileName)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, false, fmt.Errorf("read module state file: %w", err)
+		}
+		return nil, false, nil
+	}
+	stateRW, err := bufstate.NewReadWriter()
+	if err != nil {
+		return nil, false, fmt.Errorf("new state rw: %w", err)
+	}
+	moduleState, err := stateRW.ReadModStateFile(moduleStateReader)
 	if err != nil {
-		return nil, fmt.Errorf("read manifest to: %w", err)
+		return nil, false, fmt.Errorf("read module state: %w", err)
 	}
-	return buildManifestDiff(ctx, fromManifest, toManifest, casBucket)
+	return moduleState, true, nil
 }
 
 func readManifest(ctx context.Context, bucket storage.ReadBucket, manifestPath string) (cas.Manifest, error) {
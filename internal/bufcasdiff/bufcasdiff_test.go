@@ -0,0 +1,185 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufcasdiff
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/bufbuild/buf/private/pkg/cas"
+	"github.com/bufbuild/buf/private/pkg/storage"
+	"github.com/bufbuild/modules/private/bufpkg/bufcas"
+	"github.com/bufbuild/modules/private/bufpkg/bufstate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestModuleReader(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	casBucket, mFrom, mTo := prepareDiffCASBucket(ctx, t)
+	countingBucket := &countingReadBucket{ReadBucket: casBucket, pathsToReads: make(map[string]int)}
+	moduleReader := newModuleReader(nil, newCachedReadBucket(countingBucket, NewBlobCache(DefaultBlobCacheSize)))
+	fromManifestPath := manifestPath(t, mFrom)
+	toManifestPath := manifestPath(t, mTo)
+
+	want, err := buildManifestDiff(ctx, mFrom, mTo, casBucket)
+	require.NoError(t, err)
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Go(func() {
+			mdiff, err := moduleReader.Diff(ctx, fromManifestPath, toManifestPath)
+			assert.NoError(t, err)
+			assert.Equal(t, want.String(ManifestDiffOutputFormatText), mdiff.String(ManifestDiffOutputFormatText))
+		})
+	}
+	wg.Wait()
+	report, err := moduleReader.Describe(ctx, toManifestPath)
+	require.NoError(t, err)
+	assert.Len(t, report.fileNodes, len(mTo.FileNodes()))
+
+	// Concurrent reads can race to fill the cache, but sequential ones must all be served from it.
+	readsAfterDiffs := countingBucket.reads()
+	_, err = moduleReader.Diff(ctx, fromManifestPath, toManifestPath)
+	require.NoError(t, err)
+	_, err = moduleReader.Describe(ctx, toManifestPath)
+	require.NoError(t, err)
+	assert.Equal(t, readsAfterDiffs, countingBucket.reads())
+
+	_, err = moduleReader.Diff(ctx, fromManifestPath, "does-not-exist")
+	require.Error(t, err)
+
+	canceledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = newModuleReader(nil, casBucket).Diff(canceledCtx, fromManifestPath, toManifestPath)
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestDiffModuleDirectoryAgainstDirectory(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	casBucket, mFrom, mTo := prepareDiffCASBucket(ctx, t)
+	// only the from blobs are synced, the to files are read from their local directory
+	moduleDirPath := writeModuleDirectory(ctx, t, casBucket, "v1", mFrom)
+	casDirPath := filepath.Join(moduleDirPath, "cas")
+	casEntries, err := os.ReadDir(casDirPath)
+	require.NoError(t, err)
+
+	want, err := buildManifestDiff(ctx, mFrom, mTo, casBucket)
+	require.NoError(t, err)
+	for _, from := range []string{"v1", ""} {
+		mdiff, err := DiffModuleDirectoryAgainstDirectory(ctx, moduleDirPath, from, "testdata/manifest_diff/to")
+		require.NoError(t, err)
+		assert.Equal(t, want.String(ManifestDiffOutputFormatText), mdiff.String(ManifestDiffOutputFormatText))
+	}
+	casEntriesAfter, err := os.ReadDir(casDirPath)
+	require.NoError(t, err)
+	assert.Len(t, casEntriesAfter, len(casEntries))
+
+	_, err = DiffModuleDirectoryAgainstDirectory(ctx, moduleDirPath, "v2", "testdata/manifest_diff/to")
+	require.Error(t, err)
+}
+
+func TestDiffModuleDirectories(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	casBucket, mFrom, mTo := prepareDiffCASBucket(ctx, t)
+	// each module directory only has the blobs of its own reference
+	fromModuleDirPath := writeModuleDirectory(ctx, t, casBucket, "v1", mFrom)
+	toModuleDirPath := writeModuleDirectory(ctx, t, casBucket, "v2", mTo)
+
+	want, err := buildManifestDiff(ctx, mFrom, mTo, casBucket)
+	require.NoError(t, err)
+	mdiff, err := DiffModuleDirectories(ctx, fromModuleDirPath, "v1", toModuleDirPath, "v2")
+	require.NoError(t, err)
+	assert.Equal(t, want.String(ManifestDiffOutputFormatText), mdiff.String(ManifestDiffOutputFormatText))
+	// either module can use the packed layout
+	require.NoError(t, bufcas.Migrate(ctx, filepath.Join(toModuleDirPath, bufcas.CASDirName), bufcas.LayoutPacked))
+	mdiff, err = DiffModuleDirectories(ctx, fromModuleDirPath, "v1", toModuleDirPath, "v2")
+	require.NoError(t, err)
+	assert.Equal(t, want.String(ManifestDiffOutputFormatText), mdiff.String(ManifestDiffOutputFormatText))
+
+	mdiff, err = DiffModuleDirectories(ctx, fromModuleDirPath, "v1", writeModuleDirectory(ctx, t, casBucket, "other", mFrom), "other")
+	require.NoError(t, err)
+	assert.True(t, mdiff.IsEmpty())
+
+	_, err = DiffModuleDirectories(ctx, fromModuleDirPath, "v1", toModuleDirPath, "v1")
+	require.Error(t, err)
+}
+
+// writeModuleDirectory writes a module directory with a single reference to the manifest, and only
+// the blobs of that manifest, copied from casBucket.
+func writeModuleDirectory(
+	ctx context.Context,
+	t *testing.T,
+	casBucket storage.ReadBucket,
+	refName string,
+	manifest cas.Manifest,
+) string {
+	t.Helper()
+	moduleDirPath := t.TempDir()
+	casDirPath := filepath.Join(moduleDirPath, "cas")
+	require.NoError(t, os.Mkdir(casDirPath, 0700))
+	manifestDigest := manifestPath(t, manifest)
+	require.NoError(t, copyBlob(ctx, casBucket, casDirPath, manifestDigest))
+	for _, fileNode := range manifest.FileNodes() {
+		require.NoError(t, copyBlob(ctx, casBucket, casDirPath, hex.EncodeToString(fileNode.Digest().Value())))
+	}
+	moduleState := fmt.Sprintf(`{"references": [{"name": %q, "digest": %q}]}`, refName, manifestDigest)
+	require.NoError(t, os.WriteFile(filepath.Join(moduleDirPath, bufstate.ModStateFileName), []byte(moduleState), 0600))
+	return moduleDirPath
+}
+
+func copyBlob(ctx context.Context, bucket storage.ReadBucket, dirPath string, blobPath string) error {
+	data, err := storage.ReadPath(ctx, bucket, blobPath)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dirPath, blobPath), data, 0600)
+}
+
+func manifestPath(t *testing.T, manifest cas.Manifest) string {
+	t.Helper()
+	manifestBlob, err := cas.ManifestToBlob(manifest, cas.DigestTypeShake256)
+	require.NoError(t, err)
+	return hex.EncodeToString(manifestBlob.Digest().Value())
+}
+
+// countingReadBucket is a storage.ReadBucket that counts reads per path.
+type countingReadBucket struct {
+	storage.ReadBucket
+
+	lock         sync.Mutex
+	pathsToReads map[string]int
+}
+
+func (b *countingReadBucket) Get(ctx context.Context, path string) (storage.ReadObjectCloser, error) {
+	b.lock.Lock()
+	b.pathsToReads[path]++
+	b.lock.Unlock()
+	return b.ReadBucket.Get(ctx, path)
+}
+
+func (b *countingReadBucket) reads() map[string]int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return maps.Clone(b.pathsToReads)
+}
@@ -18,13 +18,16 @@ import (
 	"bytes"
 	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"slices"
 	"strings"
 
 	"buf.build/go/standard/xslices"
 	"github.com/bufbuild/buf/private/pkg/cas"
-	"github.com/bufbuild/buf/private/pkg/diff"
 	"github.com/bufbuild/buf/private/pkg/storage"
+	statev1beta1 "github.com/bufbuild/modules/private/gen/modules/state/v1beta1"
 )
 
 // ManifestDiffOutputFormat is the format in which a manifest diff can output its results.
@@ -33,6 +36,10 @@ type ManifestDiffOutputFormat int
 const (
 	ManifestDiffOutputFormatText = iota + 1
 	ManifestDiffOutputFormatMarkdown
+	// ManifestDiffOutputFormatMarkdownHTML is the same as ManifestDiffOutputFormatMarkdown, except
+	// content diffs are rendered as HTML pre blocks that highlight the changed tokens within each
+	// changed line, which GitHub renders in comments.
+	ManifestDiffOutputFormatMarkdownHTML
 )
 
 // ManifestDiff represents a change in between two CAS manifests.
@@ -41,12 +48,108 @@ type ManifestDiff struct {
 	pathsRenamed        map[string]fileDiff
 	pathsRemoved        map[string]cas.FileNode
 	pathsChangedContent map[string]fileDiff
+	// filtered is true if the diff was computed with path filters, and pathsExcluded is the number of
+	// changed paths left out by them.
+	filtered      bool
+	pathsExcluded int
+	// sizeDelta is the difference in size of all files in the diff, in bytes.
+	sizeDelta int
+	// fromReference and toReference are the diffed module references, nil if a side is not a
+	// reference in a module state file.
+	fromReference *statev1beta1.ModuleReference
+	toReference   *statev1beta1.ModuleReference
 }
 
 type fileDiff struct {
-	from cas.FileNode
-	to   cas.FileNode
-	diff string
+	from       cas.FileNode
+	to         cas.FileNode
+	diff       string       // Unified diff of the content, empty if the content is the same or not diffable.
+	unified    *unifiedDiff // Nil if the content is the same or not diffable.
+	similarity int          // Only set for renames, 100 for exact renames.
+	fromSize   int
+	toSize     int
+	// notDiffable is set instead of the content diff, for binary or large files, e.g. "Binary file".
+	notDiffable string
+}
+
+// DefaultRenameThreshold is the default minimum content similarity percentage for a removed and an
+// added file to be reported as renamed with changes, the same default as git's -M.
+const DefaultRenameThreshold = 50
+
+// DiffOption is an option for a manifest diff.
+type DiffOption func(*diffOptions)
+
+// DiffWithRenameThreshold sets the minimum content similarity percentage, from 1 to 100, for a
+// removed and an added file to be reported as renamed with changes. With 100, only files with the
+// exact same content are reported as renamed. Values out of range are clamped.
+//
+// The default is DefaultRenameThreshold.
+func DiffWithRenameThreshold(threshold int) DiffOption {
+	return func(options *diffOptions) {
+		options.renameThreshold = min(max(threshold, 1), 100)
+	}
+}
+
+// DiffWithPathPrefixes limits the diff to files equal to or contained in any of the given paths.
+func DiffWithPathPrefixes(pathPrefixes ...string) DiffOption {
+	return func(options *diffOptions) {
+		options.pathPrefixes = append(options.pathPrefixes, pathPrefixes...)
+	}
+}
+
+// DiffWithIncludeGlobs limits the diff to files matching any of the given globs. Globs are matched
+// segment by segment with path.Match semantics, and a "**" segment matches any number of
+// directories, e.g. "google/api/**" or "**/*.proto".
+func DiffWithIncludeGlobs(globs ...string) DiffOption {
+	return func(options *diffOptions) {
+		options.includeGlobs = append(options.includeGlobs, globs...)
+	}
+}
+
+// DiffWithExcludeGlobs leaves out of the diff the files matching any of the given globs, with the
+// same syntax as DiffWithIncludeGlobs. Exclusions take precedence over inclusions.
+func DiffWithExcludeGlobs(globs ...string) DiffOption {
+	return func(options *diffOptions) {
+		options.excludeGlobs = append(options.excludeGlobs, globs...)
+	}
+}
+
+// DiffWithContextLines sets the number of unchanged lines shown around each change in content
+// diffs. The default is DefaultContextLines.
+func DiffWithContextLines(contextLines int) DiffOption {
+	return func(options *diffOptions) {
+		options.contextLines = max(contextLines, 0)
+	}
+}
+
+// DefaultMaxFileSize is the default maximum size of files that are diffed by content.
+const DefaultMaxFileSize = 1 << 20
+
+// DiffWithMaxFileSize sets the maximum size in bytes of files that are diffed by content. Changed
+// files over this size are only reported with their sizes and digests, the same as binary files.
+//
+// The default is DefaultMaxFileSize.
+func DiffWithMaxFileSize(maxFileSize int) DiffOption {
+	return func(options *diffOptions) {
+		options.maxFileSize = max(maxFileSize, 0)
+	}
+}
+
+type diffOptions struct {
+	renameThreshold int
+	contextLines    int
+	maxFileSize     int
+	pathPrefixes    []string
+	includeGlobs    []string
+	excludeGlobs    []string
+}
+
+func newDiffOptions() *diffOptions {
+	return &diffOptions{
+		renameThreshold: DefaultRenameThreshold,
+		contextLines:    DefaultContextLines,
+		maxFileSize:     DefaultMaxFileSize,
+	}
 }
 
 func newManifestDiff() *ManifestDiff {
@@ -63,106 +166,203 @@ func buildManifestDiff(
 	from cas.Manifest,
 	to cas.Manifest,
 	bucket storage.ReadBucket,
+	options ...DiffOption,
 ) (*ManifestDiff, error) {
-	var (
-		diff                 = newManifestDiff()
-		digestToAddedPaths   = make(map[string][]string)
-		digestToRemovedPaths = make(map[string][]string)
-	)
+	diffOptions := newDiffOptions()
+	for _, option := range options {
+		option(diffOptions)
+	}
+	filter, err := newPathFilter(diffOptions.pathPrefixes, diffOptions.includeGlobs, diffOptions.excludeGlobs)
+	if err != nil {
+		return nil, err
+	}
+	diff := newManifestDiff()
+	diff.filtered = !filter.isEmpty()
 	// removed and changed
 	for _, fromNode := range from.FileNodes() {
 		path := fromNode.Path()
 		toNode := to.GetFileNode(path)
+		if !filter.matches(path) {
+			if toNode == nil || !cas.DigestEqual(fromNode.Digest(), toNode.Digest()) {
+				diff.pathsExcluded++
+			}
+			continue
+		}
 		if toNode == nil {
 			diff.pathsRemoved[path] = fromNode
-			digestToRemovedPaths[fromNode.Digest().String()] = append(digestToRemovedPaths[fromNode.Digest().String()], path)
 			continue
 		}
 		if cas.DigestEqual(fromNode.Digest(), toNode.Digest()) {
 			continue // no changes
 		}
-		diffString, err := calculateFileNodeDiff(ctx, fromNode, toNode, bucket)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		changedDiff, err := calculateFileNodeDiff(ctx, fromNode, toNode, bucket, diffOptions)
 		if err != nil {
 			return nil, fmt.Errorf("calculate file node diff: %w", err)
 		}
-		diff.pathsChangedContent[path] = fileDiff{
-			from: fromNode,
-			to:   toNode,
-			diff: diffString,
-		}
+		diff.pathsChangedContent[path] = changedDiff
+		diff.sizeDelta += changedDiff.toSize - changedDiff.fromSize
 	}
 	// added
 	for _, toNode := range to.FileNodes() {
 		path := toNode.Path()
-		if from.GetFileNode(path) == nil {
-			diff.pathsAdded[path] = toNode
-			digestToAddedPaths[toNode.Digest().String()] = append(digestToAddedPaths[toNode.Digest().String()], path)
+		if from.GetFileNode(path) != nil {
+			continue
 		}
-	}
-	// renamed: defined as digests present both in pathsRemoved and pathsAdded but under different
-	// paths
-	//
-	// We'll keep track of the paths that we matched as renames to later remove them from the
-	// added/removed maps
-	var (
-		matchedRemovedPaths []string
-		matchedAddedPaths   []string
-	)
-	for digest, removedPaths := range digestToRemovedPaths {
-		// removedPaths and addedPaths should be lists with no items in common since they're recorded
-		// only for added and removed nodes exclusively
-		addedPaths, digestHasAddedPaths := digestToAddedPaths[digest]
-		if !digestHasAddedPaths {
+		if !filter.matches(path) {
+			diff.pathsExcluded++
 			continue
 		}
-		for _, removedPath := range removedPaths {
-			if len(addedPaths) == 0 {
-				continue
-			}
-			// both lists are sorted by path, we can always take out the first one to match
-			var addedPath string
-			addedPaths, addedPath = removeSliceItem(addedPaths, 0)
-			matchedRemovedPaths = append(matchedRemovedPaths, removedPath)
-			matchedAddedPaths = append(matchedAddedPaths, addedPath)
-			diff.pathsRenamed[removedPath] = fileDiff{
-				from: from.GetFileNode(removedPath),
-				to:   to.GetFileNode(addedPath),
+		diff.pathsAdded[path] = toNode
+	}
+	// The content of removed and added files is only needed to detect renames with changes, otherwise
+	// only their sizes are read.
+	withContent := detectsInexactRenames(len(diff.pathsRemoved), len(diff.pathsAdded), diffOptions.renameThreshold)
+	removedBlobs, err := readBlobs(ctx, diff.pathsRemoved, bucket, diffOptions.maxFileSize, withContent)
+	if err != nil {
+		return nil, fmt.Errorf("read removed blobs: %w", err)
+	}
+	addedBlobs, err := readBlobs(ctx, diff.pathsAdded, bucket, diffOptions.maxFileSize, withContent)
+	if err != nil {
+		return nil, fmt.Errorf("read added blobs: %w", err)
+	}
+	// renamed: removed and added paths matched either by digest or by content similarity
+	renames, err := detectRenames(ctx, diff.pathsRemoved, diff.pathsAdded, removedBlobs, addedBlobs, diffOptions.renameThreshold)
+	if err != nil {
+		return nil, fmt.Errorf("detect renames: %w", err)
+	}
+	for _, rename := range renames {
+		delete(diff.pathsRemoved, rename.from.Path())
+		delete(diff.pathsAdded, rename.to.Path())
+		renamedDiff := fileDiff{
+			from:       rename.from,
+			to:         rename.to,
+			similarity: rename.similarity,
+			fromSize:   removedBlobs[rename.from.Path()].size,
+			toSize:     addedBlobs[rename.to.Path()].size,
+		}
+		if !rename.exact {
+			renamedDiff, err = calculateFileNodeDiff(ctx, rename.from, rename.to, bucket, diffOptions)
+			if err != nil {
+				return nil, fmt.Errorf("calculate renamed file node diff: %w", err)
 			}
+			renamedDiff.similarity = rename.similarity
 		}
+		diff.pathsRenamed[rename.from.Path()] = renamedDiff
+		diff.sizeDelta += renamedDiff.toSize - renamedDiff.fromSize
 	}
-	// delete the matches
-	for _, matchedRemovedPath := range matchedRemovedPaths {
-		delete(diff.pathsRemoved, matchedRemovedPath)
+	for path := range diff.pathsRemoved {
+		diff.sizeDelta -= removedBlobs[path].size
 	}
-	for _, matchedAddedPath := range matchedAddedPaths {
-		delete(diff.pathsAdded, matchedAddedPath)
+	for path := range diff.pathsAdded {
+		diff.sizeDelta += addedBlobs[path].size
 	}
 	return diff, nil
 }
 
 // Summary returns a manifest diff summary in the shape of:
 //
-// %d files changed: %d removed, %d renamed, %d added, %d changed content.
+// %d files changed: %d removed, %d renamed, %d added, %d changed content, %s size delta.
+//
+// If the diff was computed with path filters, the counts only include the matching files and the
+// summary is followed by:
+//
+// %d changed files excluded by filters.
 func (d *ManifestDiff) Summary() string {
-	return fmt.Sprintf(
-		"%d files changed: %d removed, %d renamed, %d added, %d changed content.",
+	summary := fmt.Sprintf(
+		"%d files changed: %d removed, %d renamed, %d added, %d changed content, %s size delta.",
 		len(d.pathsRemoved)+len(d.pathsRenamed)+len(d.pathsAdded)+len(d.pathsChangedContent),
 		len(d.pathsRemoved),
 		len(d.pathsRenamed),
 		len(d.pathsAdded),
 		len(d.pathsChangedContent),
+		formatSizeDelta(d.sizeDelta),
 	)
+	if d.filtered {
+		summary += fmt.Sprintf(" %d changed files excluded by filters.", d.pathsExcluded)
+	}
+	return summary
+}
+
+// IsEmpty returns true if the diff has no changed files. Files excluded by filters do not count as
+// changed.
+func (d *ManifestDiff) IsEmpty() bool {
+	return len(d.pathsRemoved)+len(d.pathsRenamed)+len(d.pathsAdded)+len(d.pathsChangedContent) == 0
+}
+
+// NameOnly returns the paths of all changed files, one per line and sorted, in the same shape as
+// git diff --name-only. Renamed files are listed by their new path.
+func (d *ManifestDiff) NameOnly() string {
+	var b strings.Builder
+	for _, status := range d.nameStatuses() {
+		b.WriteString(status.path + "\n")
+	}
+	return b.String()
+}
+
+// NameStatus returns the change kind and paths of all changed files, one per line and sorted, in
+// the same shape as git diff --name-status:
+//
+//	D	<removed path>
+//	R<similarity>	<from path>	<to path>
+//	A	<added path>
+//	M	<changed path>
+func (d *ManifestDiff) NameStatus() string {
+	var b strings.Builder
+	for _, status := range d.nameStatuses() {
+		if status.fromPath != "" {
+			b.WriteString(status.status + "\t" + status.fromPath + "\t" + status.path + "\n")
+		} else {
+			b.WriteString(status.status + "\t" + status.path + "\n")
+		}
+	}
+	return b.String()
+}
+
+// nameStatus is a single line of NameStatus.
+type nameStatus struct {
+	status   string
+	path     string
+	fromPath string // Only set for renames.
+}
+
+// nameStatuses returns all changed files sorted by path.
+func (d *ManifestDiff) nameStatuses() []nameStatus {
+	statuses := make([]nameStatus, 0, len(d.pathsRemoved)+len(d.pathsRenamed)+len(d.pathsAdded)+len(d.pathsChangedContent))
+	for path := range d.pathsRemoved {
+		statuses = append(statuses, nameStatus{status: "D", path: path})
+	}
+	for path, fdiff := range d.pathsRenamed {
+		statuses = append(statuses, nameStatus{
+			status:   fmt.Sprintf("R%03d", fdiff.similarity),
+			path:     fdiff.to.Path(),
+			fromPath: path,
+		})
+	}
+	for path := range d.pathsAdded {
+		statuses = append(statuses, nameStatus{status: "A", path: path})
+	}
+	for path := range d.pathsChangedContent {
+		statuses = append(statuses, nameStatus{status: "M", path: path})
+	}
+	slices.SortFunc(statuses, func(a nameStatus, b nameStatus) int {
+		return strings.Compare(a.path, b.path)
+	})
+	return statuses
 }
 
 // String returns the diff output in the given format. On invalid or unknown format, this function
 // defaults to ManifestDiffOutputFormatText.
 func (d *ManifestDiff) String(format ManifestDiffOutputFormat) string {
 	var b bytes.Buffer
-	isMarkdown := format == ManifestDiffOutputFormatMarkdown
+	isMarkdown := format == ManifestDiffOutputFormatMarkdown || format == ManifestDiffOutputFormatMarkdownHTML
 	if isMarkdown {
 		b.WriteString("> ")
 	}
 	b.WriteString(d.Summary() + "\n")
+	d.writeSyncedReferences(&b, isMarkdown)
 	if len(d.pathsRemoved) > 0 {
 		b.WriteString("\n")
 		if isMarkdown {
@@ -179,7 +379,18 @@ func (d *ManifestDiff) String(format ManifestDiffOutputFormat) string {
 			b.WriteString("```\n")
 		}
 	}
-	if len(d.pathsRenamed) > 0 {
+	var (
+		exactRenamedPaths       []string
+		renamedWithChangesPaths []string
+	)
+	for _, path := range xslices.MapKeysToSortedSlice(d.pathsRenamed) {
+		if d.pathsRenamed[path].diff == "" {
+			exactRenamedPaths = append(exactRenamedPaths, path)
+		} else {
+			renamedWithChangesPaths = append(renamedWithChangesPaths, path)
+		}
+	}
+	if len(exactRenamedPaths) > 0 {
 		b.WriteString("\n")
 		if isMarkdown {
 			b.WriteString("# ")
@@ -188,7 +399,7 @@ func (d *ManifestDiff) String(format ManifestDiffOutputFormat) string {
 		if isMarkdown {
 			b.WriteString("```diff\n")
 		}
-		for _, path := range xslices.MapKeysToSortedSlice(d.pathsRenamed) {
+		for _, path := range exactRenamedPaths {
 			b.WriteString("- " + d.pathsRenamed[path].from.String() + "\n")
 			b.WriteString("+ " + d.pathsRenamed[path].to.String() + "\n")
 		}
@@ -196,6 +407,23 @@ func (d *ManifestDiff) String(format ManifestDiffOutputFormat) string {
 			b.WriteString("```\n")
 		}
 	}
+	if len(renamedWithChangesPaths) > 0 {
+		b.WriteString("\n")
+		if isMarkdown {
+			b.WriteString("# ")
+		}
+		b.WriteString("Files renamed with changes:\n\n")
+		for _, path := range renamedWithChangesPaths {
+			fdiff := d.pathsRenamed[path]
+			if isMarkdown {
+				fmt.Fprintf(&b, "## `%s` → `%s` (%d%% similar):\n", fdiff.from.Path(), fdiff.to.Path(), fdiff.similarity)
+				b.WriteString(d.markdownFileDiff(fdiff, format))
+			} else {
+				fmt.Fprintf(&b, "%s -> %s (%d%% similar):\n", fdiff.from.Path(), fdiff.to.Path(), fdiff.similarity)
+				b.WriteString(fdiff.diff + "\n")
+			}
+		}
+	}
 	if len(d.pathsAdded) > 0 {
 		b.WriteString("\n")
 		if isMarkdown {
@@ -225,9 +453,12 @@ func (d *ManifestDiff) String(format ManifestDiffOutputFormat) string {
 			} else {
 				b.WriteString(fdiff.from.Path() + ":\n")
 			}
-			if isMarkdown {
-				b.WriteString(markdownFencedDiff(fdiff.diff))
-			} else {
+			switch {
+			case fdiff.notDiffable != "":
+				b.WriteString(notDiffableFileDiff(fdiff, isMarkdown))
+			case isMarkdown:
+				b.WriteString(d.markdownFileDiff(fdiff, format))
+			default:
 				b.WriteString(fdiff.diff + "\n")
 			}
 		}
@@ -235,6 +466,39 @@ func (d *ManifestDiff) String(format ManifestDiffOutputFormat) string {
 	return b.String()
 }
 
+// notDiffableFileDiff returns the sizes and digests of a binary or large changed file, in place of
+// its content diff.
+func notDiffableFileDiff(fdiff fileDiff, isMarkdown bool) string {
+	var b strings.Builder
+	fmt.Fprintf(
+		&b,
+		"%s changed, %s -> %s (%s):\n",
+		fdiff.notDiffable,
+		formatSize(fdiff.fromSize),
+		formatSize(fdiff.toSize),
+		formatSizeDelta(fdiff.toSize-fdiff.fromSize),
+	)
+	if isMarkdown {
+		b.WriteString("```diff\n")
+	}
+	b.WriteString("- " + fdiff.from.String() + "\n")
+	b.WriteString("+ " + fdiff.to.String() + "\n")
+	if isMarkdown {
+		b.WriteString("```\n")
+	} else {
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// markdownFileDiff returns the content diff of a file for markdown formats.
+func (d *ManifestDiff) markdownFileDiff(fdiff fileDiff, format ManifestDiffOutputFormat) string {
+	if format == ManifestDiffOutputFormatMarkdownHTML && fdiff.unified != nil {
+		return fdiff.unified.HTML()
+	}
+	return markdownFencedDiff(fdiff.diff)
+}
+
 // markdownFencedDiff wraps content in a ```diff code fence, using a longer fence if the content
 // itself contains backtick runs that would break the fence.
 func markdownFencedDiff(content string) string {
@@ -245,44 +509,129 @@ func markdownFencedDiff(content string) string {
 	return fence + "diff\n" + content + "\n" + fence + "\n"
 }
 
+// calculateFileNodeDiff returns the diff between two file nodes, with their content diff if both
+// are diffable.
 func calculateFileNodeDiff(
 	ctx context.Context,
 	from cas.FileNode,
 	to cas.FileNode,
 	bucket storage.ReadBucket,
-) (string, error) {
-	if from.Path() == to.Path() && cas.DigestEqual(from.Digest(), to.Digest()) {
-		return "", nil
+	diffOptions *diffOptions,
+) (fileDiff, error) {
+	fromBlob, err := readBlob(ctx, bucket, from, diffOptions.maxFileSize)
+	if err != nil {
+		return fileDiff{}, fmt.Errorf("read from: %w", err)
 	}
-	var (
-		fromFilePath = hex.EncodeToString(from.Digest().Value())
-		toFilePath   = hex.EncodeToString(to.Digest().Value())
-	)
-	fromData, err := storage.ReadPath(ctx, bucket, fromFilePath)
+	toBlob, err := readBlob(ctx, bucket, to, diffOptions.maxFileSize)
 	if err != nil {
-		return "", fmt.Errorf("read path from: %w", err)
+		return fileDiff{}, fmt.Errorf("read to: %w", err)
+	}
+	fdiff := fileDiff{
+		from:     from,
+		to:       to,
+		fromSize: fromBlob.size,
+		toSize:   toBlob.size,
 	}
-	toData, err := storage.ReadPath(ctx, bucket, toFilePath)
+	switch {
+	case fromBlob.data == nil || toBlob.data == nil:
+		fdiff.notDiffable = "Large file"
+	case isBinary(fromBlob.data) || isBinary(toBlob.data):
+		fdiff.notDiffable = "Binary file"
+	default:
+		fdiff.unified = newUnifiedDiff(from.String(), fromBlob.data, to.String(), toBlob.data, diffOptions.contextLines)
+		fdiff.diff = fdiff.unified.String()
+	}
+	return fdiff, nil
+}
+
+// blobContent is the content of a blob as read for a diff.
+type blobContent struct {
+	data []byte // Nil if the blob is larger than the max file size.
+	size int
+}
+
+// isDiffable returns true if the blob can be diffed by content.
+func (c blobContent) isDiffable() bool {
+	return c.data != nil && !isBinary(c.data)
+}
+
+// readBlob reads the blob of a file node. If the blob is larger than maxSize, only its size is
+// returned, without keeping its content in memory.
+func readBlob(ctx context.Context, bucket storage.ReadBucket, fileNode cas.FileNode, maxSize int) (_ blobContent, retErr error) {
+	readObjectCloser, err := bucket.Get(ctx, hex.EncodeToString(fileNode.Digest().Value()))
 	if err != nil {
-		return "", fmt.Errorf("read path to: %w", err)
-	}
-	diffData, err := diff.Diff(
-		ctx,
-		fromData,
-		toData,
-		from.String(),
-		to.String(),
-		diff.DiffWithSuppressCommands(),
-		diff.DiffWithSuppressTimestamps(),
-	)
+		return blobContent{}, fmt.Errorf("get %s: %w", fileNode.Path(), err)
+	}
+	defer func() {
+		if err := readObjectCloser.Close(); err != nil {
+			retErr = errors.Join(retErr, fmt.Errorf("close %s: %w", fileNode.Path(), err))
+		}
+	}()
+	data, err := io.ReadAll(io.LimitReader(readObjectCloser, int64(maxSize)+1))
+	if err != nil {
+		return blobContent{}, fmt.Errorf("read %s: %w", fileNode.Path(), err)
+	}
+	if len(data) <= maxSize {
+		return blobContent{data: data, size: len(data)}, nil
+	}
+	rest, err := io.Copy(io.Discard, readObjectCloser)
+	if err != nil {
+		return blobContent{}, fmt.Errorf("read %s: %w", fileNode.Path(), err)
+	}
+	return blobContent{size: len(data) + int(rest)}, nil
+}
+
+// readBlobSize returns the size of the blob of a file node, without keeping its content in memory.
+func readBlobSize(ctx context.Context, bucket storage.ReadBucket, fileNode cas.FileNode) (_ int, retErr error) {
+	readObjectCloser, err := bucket.Get(ctx, hex.EncodeToString(fileNode.Digest().Value()))
 	if err != nil {
-		return "", fmt.Errorf("diff: %w", err)
+		return 0, fmt.Errorf("get %s: %w", fileNode.Path(), err)
+	}
+	defer func() {
+		if err := readObjectCloser.Close(); err != nil {
+			retErr = errors.Join(retErr, fmt.Errorf("close %s: %w", fileNode.Path(), err))
+		}
+	}()
+	size, err := io.Copy(io.Discard, readObjectCloser)
+	if err != nil {
+		return 0, fmt.Errorf("read %s: %w", fileNode.Path(), err)
+	}
+	return int(size), nil
+}
+
+// readBlobs reads the blobs of all the given file nodes by path. Without content, only their sizes
+// are read.
+func readBlobs(
+	ctx context.Context,
+	pathsToFileNodes map[string]cas.FileNode,
+	bucket storage.ReadBucket,
+	maxSize int,
+	withContent bool,
+) (map[string]blobContent, error) {
+	pathsToBlobs := make(map[string]blobContent, len(pathsToFileNodes))
+	for path, fileNode := range pathsToFileNodes {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if !withContent {
+			size, err := readBlobSize(ctx, bucket, fileNode)
+			if err != nil {
+				return nil, err
+			}
+			pathsToBlobs[path] = blobContent{size: size}
+			continue
+		}
+		blob, err := readBlob(ctx, bucket, fileNode, maxSize)
+		if err != nil {
+			return nil, err
+		}
+		pathsToBlobs[path] = blob
 	}
-	return string(diffData), nil
+	return pathsToBlobs, nil
 }
 
-// removeSliceItem returns the slice with the item in index i removed, and the removed item.
-func removeSliceItem[T any](s []T, i int) ([]T, T) {
-	item := s[i]
-	return append(s[:i], s[i+1:]...), item
+// isBinary returns true if data looks like binary content, using the same heuristic as git: a NUL
+// byte in the first 8000 bytes.
+func isBinary(data []byte) bool {
+	return bytes.IndexByte(data[:min(len(data), 8000)], 0) >= 0
 }
@@ -43,6 +43,8 @@ func TestManifestDiff(t *testing.T) {
 		t.Parallel()
 		expectedRemovedPaths := map[string]struct{}{
 			"to_remove.txt": {},
+			// same digest as ties/b/second.txt, which is a closer match for ties/c/second.txt
+			"ties/a/first.txt": {},
 		}
 		assert.Len(t, mdiff.pathsRemoved, len(expectedRemovedPaths))
 		for expectedRemovedPath := range expectedRemovedPaths {
@@ -66,8 +68,10 @@ func TestManifestDiff(t *testing.T) {
 			"to_rename_foo/1.txt": "renamed_foo/1.txt",
 			"to_rename_foo/2.txt": "renamed_foo/2.txt",
 			"to_rename_foo/3.txt": "renamed_foo/3.txt",
+			"ties/b/second.txt":   "ties/c/second.txt",
 		}
-		assert.Len(t, mdiff.pathsRenamed, len(expectedRenamedPaths))
+		// plus one renamed with changes
+		assert.Len(t, mdiff.pathsRenamed, len(expectedRenamedPaths)+1)
 		for fromPath, toPath := range expectedRenamedPaths {
 			expected := mFrom.GetFileNode(fromPath)
 			require.NotNil(t, expected)
@@ -78,8 +82,57 @@ func TestManifestDiff(t *testing.T) {
 			assert.True(t, cas.DigestEqual(expected.Digest(), actual.from.Digest()))
 			assert.True(t, cas.DigestEqual(actual.from.Digest(), actual.to.Digest()))
 			assert.Empty(t, actual.diff)
+			assert.Equal(t, 100, actual.similarity)
 		}
 	})
+	t.Run("renamed_with_changes", func(t *testing.T) {
+		t.Parallel()
+		actual, present := mdiff.pathsRenamed["to_move_and_edit/service.proto"]
+		require.True(t, present)
+		assert.Equal(t, "moved_and_edited/service.proto", actual.to.Path())
+		assert.False(t, cas.DigestEqual(actual.from.Digest(), actual.to.Digest()))
+		assert.Equal(t, 88, actual.similarity)
+		assert.Contains(t, actual.diff, "+  string description = 2;")
+	})
+	t.Run("filtered", func(t *testing.T) {
+		t.Parallel()
+		filteredDiff, err := buildManifestDiff(
+			ctx,
+			mFrom,
+			mTo,
+			casBucket,
+			DiffWithPathPrefixes("to_rename_foo", "renamed_foo", "changes.txt"),
+			DiffWithExcludeGlobs("**/3.txt"),
+		)
+		require.NoError(t, err)
+		assert.Empty(t, filteredDiff.pathsRemoved)
+		assert.Empty(t, filteredDiff.pathsAdded)
+		assert.Len(t, filteredDiff.pathsRenamed, 2)
+		assert.Contains(t, filteredDiff.pathsRenamed, "to_rename_foo/1.txt")
+		assert.Contains(t, filteredDiff.pathsRenamed, "to_rename_foo/2.txt")
+		assert.Len(t, filteredDiff.pathsChangedContent, 1)
+		// 8 removed and 7 added paths, and 1 changed path are excluded.
+		assert.Equal(
+			t,
+			"3 files changed: 0 removed, 2 renamed, 0 added, 1 changed content, -2 B size delta. 16 changed files excluded by filters.",
+			filteredDiff.Summary(),
+		)
+		_, err = buildManifestDiff(ctx, mFrom, mTo, casBucket, DiffWithIncludeGlobs("[invalid"))
+		require.Error(t, err)
+	})
+	t.Run("rename_threshold", func(t *testing.T) {
+		t.Parallel()
+		strictDiff, err := buildManifestDiff(ctx, mFrom, mTo, casBucket, DiffWithRenameThreshold(90))
+		require.NoError(t, err)
+		assert.Len(t, strictDiff.pathsRenamed, len(mdiff.pathsRenamed)-1)
+		assert.Contains(t, strictDiff.pathsRemoved, "to_move_and_edit/service.proto")
+		assert.Contains(t, strictDiff.pathsAdded, "moved_and_edited/service.proto")
+		// only exact renames, removed and added blobs are read for their sizes only
+		exactDiff, err := buildManifestDiff(ctx, mFrom, mTo, casBucket, DiffWithRenameThreshold(100))
+		require.NoError(t, err)
+		assert.Len(t, exactDiff.pathsRenamed, len(mdiff.pathsRenamed)-1)
+		assert.Equal(t, mdiff.sizeDelta, exactDiff.sizeDelta)
+	})
 	t.Run("added", func(t *testing.T) {
 		t.Parallel()
 		expectedAddedPaths := map[string]struct{}{
@@ -99,6 +152,7 @@ func TestManifestDiff(t *testing.T) {
 		t.Parallel()
 		expectedChangedContentPaths := map[string]struct{}{
 			"changes.txt": {},
+			"image.bin":   {},
 		}
 		assert.Len(t, mdiff.pathsChangedContent, len(expectedChangedContentPaths))
 		for expectedChangedContentPath := range expectedChangedContentPaths {
@@ -108,8 +162,31 @@ func TestManifestDiff(t *testing.T) {
 			require.True(t, present)
 			assert.Equal(t, actual.from.Path(), actual.to.Path())
 			assert.False(t, cas.DigestEqual(actual.from.Digest(), actual.to.Digest()))
-			assert.NotEmpty(t, actual.diff)
 		}
+		assert.NotEmpty(t, mdiff.pathsChangedContent["changes.txt"].diff)
+	})
+	t.Run("binary", func(t *testing.T) {
+		t.Parallel()
+		actual, present := mdiff.pathsChangedContent["image.bin"]
+		require.True(t, present)
+		assert.Equal(t, "Binary file", actual.notDiffable)
+		assert.Empty(t, actual.diff)
+		assert.Nil(t, actual.unified)
+		assert.Equal(t, 17, actual.fromSize)
+		assert.Equal(t, 33, actual.toSize)
+	})
+	t.Run("max_file_size", func(t *testing.T) {
+		t.Parallel()
+		limitedDiff, err := buildManifestDiff(ctx, mFrom, mTo, casBucket, DiffWithMaxFileSize(17))
+		require.NoError(t, err)
+		actual, present := limitedDiff.pathsChangedContent["changes.txt"]
+		require.True(t, present)
+		assert.Equal(t, "Large file", actual.notDiffable)
+		assert.Empty(t, actual.diff)
+		assert.Equal(t, 18, actual.fromSize)
+		assert.Equal(t, 16, actual.toSize)
+		// sizes are the same with or without a max file size
+		assert.Equal(t, mdiff.sizeDelta, limitedDiff.sizeDelta)
 	})
 }
 
@@ -137,6 +214,11 @@ func TestManifestDiffString(t *testing.T) {
 			format:    ManifestDiffOutputFormatMarkdown,
 			extension: ".md",
 		},
+		{
+			name:      "markdown_html",
+			format:    ManifestDiffOutputFormatMarkdownHTML,
+			extension: ".md",
+		},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
@@ -152,6 +234,58 @@ func TestManifestDiffString(t *testing.T) {
 	}
 }
 
+func TestManifestDiffNames(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	casBucket, mFrom, mTo := prepareDiffCASBucket(ctx, t)
+	mdiff, err := buildManifestDiff(ctx, mFrom, mTo, casBucket)
+	require.NoError(t, err)
+	assert.False(t, mdiff.IsEmpty())
+	assert.Equal(
+		t,
+		`A	added.txt
+M	changes.txt
+M	image.bin
+R088	to_move_and_edit/service.proto	moved_and_edited/service.proto
+R100	to_rename_bar/1.txt	renamed_bar/1.txt
+R100	to_rename_bar/2.txt	renamed_bar/2.txt
+R100	to_rename_bar/3.txt	renamed_bar/3.txt
+R100	to_rename_foo/1.txt	renamed_foo/1.txt
+R100	to_rename_foo/2.txt	renamed_foo/2.txt
+R100	to_rename_foo/3.txt	renamed_foo/3.txt
+D	ties/a/first.txt
+R100	ties/b/second.txt	ties/c/second.txt
+D	to_remove.txt
+`,
+		mdiff.NameStatus(),
+	)
+	assert.Equal(
+		t,
+		`added.txt
+changes.txt
+image.bin
+moved_and_edited/service.proto
+renamed_bar/1.txt
+renamed_bar/2.txt
+renamed_bar/3.txt
+renamed_foo/1.txt
+renamed_foo/2.txt
+renamed_foo/3.txt
+ties/a/first.txt
+ties/c/second.txt
+to_remove.txt
+`,
+		mdiff.NameOnly(),
+	)
+	emptyDiff, err := buildManifestDiff(ctx, mFrom, mFrom, casBucket)
+	require.NoError(t, err)
+	assert.True(t, emptyDiff.IsEmpty())
+	assert.Empty(t, emptyDiff.NameStatus())
+	filteredDiff, err := buildManifestDiff(ctx, mFrom, mTo, casBucket, DiffWithPathPrefixes("does_not_exist"))
+	require.NoError(t, err)
+	assert.True(t, filteredDiff.IsEmpty())
+}
+
 func prepareDiffCASBucket(ctx context.Context, t *testing.T) (
 	storage.ReadBucket,
 	cas.Manifest,
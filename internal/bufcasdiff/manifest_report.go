@@ -0,0 +1,186 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufcasdiff
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"buf.build/go/standard/xslices"
+	"github.com/bufbuild/buf/private/pkg/cas"
+	"github.com/bufbuild/buf/private/pkg/storage"
+	statev1beta1 "github.com/bufbuild/modules/private/gen/modules/state/v1beta1"
+)
+
+// protoPackageRegexp matches a package declaration at the start of a line in a .proto file.
+var protoPackageRegexp = regexp.MustCompile(`(?m)^\s*package\s+([\w.]+)\s*;`) //nolint:gochecknoglobals // treated as const
+
+// ManifestReport represents the full content of a single CAS manifest.
+type ManifestReport struct {
+	fileNodes     []cas.FileNode
+	pathsToSizes  map[string]int
+	totalSize     int
+	protoPackages map[string]struct{}
+	// reference is the described module reference, nil if it is not a reference in a module state
+	// file.
+	reference *statev1beta1.ModuleReference
+}
+
+func buildManifestReport(
+	ctx context.Context,
+	manifest cas.Manifest,
+	bucket storage.ReadBucket,
+) (*ManifestReport, error) {
+	report := &ManifestReport{
+		fileNodes:     manifest.FileNodes(),
+		pathsToSizes:  make(map[string]int, len(manifest.FileNodes())),
+		protoPackages: make(map[string]struct{}),
+	}
+	for _, fileNode := range report.fileNodes {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if path.Ext(fileNode.Path()) != ".proto" {
+			// only the size of other files is needed
+			size, err := readBlobSize(ctx, bucket, fileNode)
+			if err != nil {
+				return nil, err
+			}
+			report.pathsToSizes[fileNode.Path()] = size
+			report.totalSize += size
+			continue
+		}
+		data, err := storage.ReadPath(ctx, bucket, hex.EncodeToString(fileNode.Digest().Value()))
+		if err != nil {
+			return nil, fmt.Errorf("read path %s: %w", fileNode.Path(), err)
+		}
+		report.pathsToSizes[fileNode.Path()] = len(data)
+		report.totalSize += len(data)
+		if match := protoPackageRegexp.FindSubmatch(data); match != nil {
+			report.protoPackages[string(match[1])] = struct{}{}
+		}
+	}
+	return report, nil
+}
+
+// Summary returns a manifest report summary in the shape of:
+//
+// %d files, %s total, %d proto packages.
+func (r *ManifestReport) Summary() string {
+	return fmt.Sprintf(
+		"%d files, %s total, %d proto packages.",
+		len(r.fileNodes),
+		formatSize(r.totalSize),
+		len(r.protoPackages),
+	)
+}
+
+// String returns the report output in the given format. On invalid or unknown format, this function
+// defaults to ManifestDiffOutputFormatText.
+func (r *ManifestReport) String(format ManifestDiffOutputFormat) string {
+	var b bytes.Buffer
+	isMarkdown := format == ManifestDiffOutputFormatMarkdown || format == ManifestDiffOutputFormatMarkdownHTML
+	if isMarkdown {
+		b.WriteString("> ")
+	}
+	b.WriteString(r.Summary() + "\n")
+	if r.reference != nil {
+		writeSyncedReferences(&b, []syncedReference{{reference: r.reference}}, isMarkdown)
+	}
+	if len(r.protoPackages) > 0 {
+		b.WriteString("\n")
+		if isMarkdown {
+			b.WriteString("# ")
+		}
+		b.WriteString("Proto packages:\n\n")
+		for _, protoPackage := range xslices.MapKeysToSortedSlice(r.protoPackages) {
+			if isMarkdown {
+				b.WriteString("- `" + protoPackage + "`\n")
+			} else {
+				b.WriteString("- " + protoPackage + "\n")
+			}
+		}
+	}
+	if len(r.fileNodes) > 0 {
+		b.WriteString("\n")
+		if isMarkdown {
+			b.WriteString("# ")
+		}
+		b.WriteString("Files:\n\n")
+		if isMarkdown {
+			b.WriteString("```\n")
+		}
+		r.writeFileTree(&b)
+		if isMarkdown {
+			b.WriteString("```\n")
+		}
+	}
+	return b.String()
+}
+
+// writeFileTree writes all files as an indented tree, one directory or file per line, with file
+// sizes.
+func (r *ManifestReport) writeFileTree(b *bytes.Buffer) {
+	var prevDirs []string
+	for _, fileNode := range r.fileNodes {
+		dirs := strings.Split(path.Dir(fileNode.Path()), "/")
+		if dirs[0] == "." {
+			dirs = nil
+		}
+		// skip all parent directories already written for the previous file
+		var common int
+		for common < len(dirs) && common < len(prevDirs) && dirs[common] == prevDirs[common] {
+			common++
+		}
+		for i := common; i < len(dirs); i++ {
+			b.WriteString(strings.Repeat("  ", i) + dirs[i] + "/\n")
+		}
+		fmt.Fprintf(
+			b,
+			"%s%s (%s)\n",
+			strings.Repeat("  ", len(dirs)),
+			path.Base(fileNode.Path()),
+			formatSize(r.pathsToSizes[fileNode.Path()]),
+		)
+		prevDirs = dirs
+	}
+}
+
+// formatSizeDelta returns a human readable size difference in bytes, always signed.
+func formatSizeDelta(delta int) string {
+	if delta < 0 {
+		return "-" + formatSize(-delta)
+	}
+	return "+" + formatSize(delta)
+}
+
+// formatSize returns a human readable size in bytes, using binary prefixes.
+func formatSize(size int) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := unit, 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,85 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufcasdiff
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bufbuild/buf/private/pkg/cas"
+	"github.com/bufbuild/buf/private/pkg/storage"
+	"github.com/bufbuild/buf/private/pkg/storage/storagemem"
+	"github.com/bufbuild/buf/private/pkg/storage/storageos"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManifestReport(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	testFiles, err := storageos.NewProvider().NewReadWriteBucket("testdata/manifest_report/module")
+	require.NoError(t, err)
+	fileSet, err := cas.NewFileSetForBucket(ctx, testFiles, cas.DigestTypeShake256)
+	require.NoError(t, err)
+	casBucket := storagemem.NewReadWriteBucket()
+	for _, blob := range fileSet.BlobSet().Blobs() {
+		require.NoError(t, storage.PutPath(ctx, casBucket, hex.EncodeToString(blob.Digest().Value()), blob.Content()))
+	}
+	report, err := buildManifestReport(ctx, fileSet.Manifest(), casBucket)
+	require.NoError(t, err)
+	assert.Len(t, report.fileNodes, 5)
+	assert.Equal(t, map[string]struct{}{"baz": {}, "foo.v1": {}}, report.protoPackages)
+
+	type testCase struct {
+		name      string
+		format    ManifestDiffOutputFormat
+		extension string
+	}
+	for _, tc := range []testCase{
+		{
+			name:      "text",
+			format:    ManifestDiffOutputFormatText,
+			extension: ".txt",
+		},
+		{
+			name:      "markdown",
+			format:    ManifestDiffOutputFormatMarkdown,
+			extension: ".md",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			got := report.String(tc.format)
+			golden := filepath.Join("testdata", "manifest_report", tc.name+".golden"+tc.extension)
+			if *update {
+				require.NoError(t, os.WriteFile(golden, []byte(got), 0600))
+			}
+			want, err := os.ReadFile(golden)
+			require.NoError(t, err)
+			assert.Equal(t, string(want), got)
+		})
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, "0 B", formatSize(0))
+	assert.Equal(t, "1023 B", formatSize(1023))
+	assert.Equal(t, "1.0 KiB", formatSize(1024))
+	assert.Equal(t, "1.5 KiB", formatSize(1536))
+	assert.Equal(t, "2.0 MiB", formatSize(2*1024*1024))
+}
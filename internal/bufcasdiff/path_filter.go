@@ -0,0 +1,116 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufcasdiff
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// pathFilter decides which file paths are part of a diff.
+type pathFilter struct {
+	pathPrefixes []string
+	includeGlobs []string
+	excludeGlobs []string
+}
+
+func newPathFilter(pathPrefixes []string, includeGlobs []string, excludeGlobs []string) (*pathFilter, error) {
+	filter := &pathFilter{
+		includeGlobs: includeGlobs,
+		excludeGlobs: excludeGlobs,
+	}
+	for _, pathPrefix := range pathPrefixes {
+		filter.pathPrefixes = append(filter.pathPrefixes, path.Clean(pathPrefix))
+	}
+	for _, glob := range includeGlobs {
+		if err := validateGlob(glob); err != nil {
+			return nil, fmt.Errorf("include glob %q: %w", glob, err)
+		}
+	}
+	for _, glob := range excludeGlobs {
+		if err := validateGlob(glob); err != nil {
+			return nil, fmt.Errorf("exclude glob %q: %w", glob, err)
+		}
+	}
+	return filter, nil
+}
+
+// isEmpty returns true if the filter matches all paths.
+func (f *pathFilter) isEmpty() bool {
+	return len(f.pathPrefixes) == 0 && len(f.includeGlobs) == 0 && len(f.excludeGlobs) == 0
+}
+
+// matches returns true if filePath is contained in any of the path prefixes, matches any of the
+// include globs, and does not match any of the exclude globs. Empty path prefixes or include globs
+// match all paths.
+func (f *pathFilter) matches(filePath string) bool {
+	if len(f.pathPrefixes) > 0 && !anyMatch(f.pathPrefixes, filePath, isEqualOrContained) {
+		return false
+	}
+	if len(f.includeGlobs) > 0 && !anyMatch(f.includeGlobs, filePath, matchGlob) {
+		return false
+	}
+	return !anyMatch(f.excludeGlobs, filePath, matchGlob)
+}
+
+func anyMatch(patterns []string, filePath string, match func(pattern string, filePath string) bool) bool {
+	for _, pattern := range patterns {
+		if match(pattern, filePath) {
+			return true
+		}
+	}
+	return false
+}
+
+func isEqualOrContained(pathPrefix string, filePath string) bool {
+	return pathPrefix == "." || filePath == pathPrefix || strings.HasPrefix(filePath, pathPrefix+"/")
+}
+
+// matchGlob matches a slash separated path against a glob. Each glob segment is matched against a
+// path segment with path.Match semantics, and a "**" segment matches zero or more path segments.
+func matchGlob(glob string, filePath string) bool {
+	return matchGlobSegments(strings.Split(glob, "/"), strings.Split(filePath, "/"))
+}
+
+func matchGlobSegments(globSegments []string, pathSegments []string) bool {
+	for len(globSegments) > 0 {
+		if globSegments[0] == "**" {
+			for i := 0; i <= len(pathSegments); i++ {
+				if matchGlobSegments(globSegments[1:], pathSegments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(pathSegments) == 0 {
+			return false
+		}
+		if matched, _ := path.Match(globSegments[0], pathSegments[0]); !matched {
+			return false
+		}
+		globSegments, pathSegments = globSegments[1:], pathSegments[1:]
+	}
+	return len(pathSegments) == 0
+}
+
+func validateGlob(glob string) error {
+	for segment := range strings.SplitSeq(glob, "/") {
+		if _, err := path.Match(segment, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
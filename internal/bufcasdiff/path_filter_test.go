@@ -0,0 +1,66 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufcasdiff
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchGlob(t *testing.T) {
+	t.Parallel()
+	type testCase struct {
+		glob     string
+		filePath string
+		expected bool
+	}
+	for _, tc := range []testCase{
+		{glob: "google/api/**", filePath: "google/api/http.proto", expected: true},
+		{glob: "google/api/**", filePath: "google/api/expr/v1/syntax.proto", expected: true},
+		{glob: "google/api/**", filePath: "google/rpc/status.proto", expected: false},
+		{glob: "google/api/*", filePath: "google/api/expr/v1/syntax.proto", expected: false},
+		{glob: "**/*.proto", filePath: "a.proto", expected: true},
+		{glob: "**/*.proto", filePath: "a/b/c.proto", expected: true},
+		{glob: "**/*.proto", filePath: "a/b/README.md", expected: false},
+		{glob: "google/**/v1/*.proto", filePath: "google/api/expr/v1/syntax.proto", expected: true},
+		{glob: "google/**/v1/*.proto", filePath: "google/v1/foo.proto", expected: true},
+		{glob: "*.md", filePath: "README.md", expected: true},
+		{glob: "*.md", filePath: "docs/README.md", expected: false},
+	} {
+		assert.Equal(t, tc.expected, matchGlob(tc.glob, tc.filePath), "glob %q path %q", tc.glob, tc.filePath)
+	}
+}
+
+func TestPathFilter(t *testing.T) {
+	t.Parallel()
+	filter, err := newPathFilter([]string{"./google/"}, []string{"**/*.proto"}, []string{"google/api/expr/**"})
+	require.NoError(t, err)
+	assert.False(t, filter.isEmpty())
+	assert.True(t, filter.matches("google/api/http.proto"))
+	assert.True(t, filter.matches("google/rpc/status.proto"))
+	assert.False(t, filter.matches("google/api/expr/v1/syntax.proto"))
+	assert.False(t, filter.matches("google/api/README.md"))
+	assert.False(t, filter.matches("googleapis/foo.proto"))
+
+	filter, err = newPathFilter(nil, nil, nil)
+	require.NoError(t, err)
+	assert.True(t, filter.isEmpty())
+	assert.True(t, filter.matches("any/path"))
+
+	_, err = newPathFilter(nil, []string{"google/[api"}, nil)
+	require.Error(t, err)
+}
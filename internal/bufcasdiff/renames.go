@@ -0,0 +1,214 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufcasdiff
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"path"
+	"slices"
+	"strings"
+
+	"buf.build/go/standard/xslices"
+	"github.com/bufbuild/buf/private/pkg/cas"
+)
+
+// maxInexactRenameFiles is the maximum number of removed or added files for which renames with
+// changes are detected. Every removed file is compared against every added file, so past this limit
+// only exact renames are detected, similar to git's diff.renameLimit.
+const maxInexactRenameFiles = 1000
+
+// rename is a removed file matched to an added file.
+type rename struct {
+	from       cas.FileNode
+	to         cas.FileNode
+	similarity int // Content similarity percentage, 100 for exact renames.
+	exact      bool
+}
+
+// detectRenames matches removed and added files as renames. Files with the same digest are always
+// matched, files with different digests are matched if their content similarity percentage is at
+// least threshold. Blobs only have content if detectsInexactRenames is true. Each file is matched at most once, preferring exact matches, then the highest
+// similarity, then the closest paths.
+func detectRenames(
+	ctx context.Context,
+	pathsRemoved map[string]cas.FileNode,
+	pathsAdded map[string]cas.FileNode,
+	removedBlobs map[string]blobContent,
+	addedBlobs map[string]blobContent,
+	threshold int,
+) ([]rename, error) {
+	var (
+		removedPaths = xslices.MapKeysToSortedSlice(pathsRemoved)
+		addedPaths   = xslices.MapKeysToSortedSlice(pathsAdded)
+		candidates   []rename
+	)
+	// exact: all removed and added pairs with the same digest
+	digestToAddedPaths := make(map[string][]string)
+	for _, addedPath := range addedPaths {
+		digest := pathsAdded[addedPath].Digest().String()
+		digestToAddedPaths[digest] = append(digestToAddedPaths[digest], addedPath)
+	}
+	for _, removedPath := range removedPaths {
+		fromNode := pathsRemoved[removedPath]
+		for _, addedPath := range digestToAddedPaths[fromNode.Digest().String()] {
+			candidates = append(candidates, rename{
+				from:       fromNode,
+				to:         pathsAdded[addedPath],
+				similarity: 100,
+				exact:      true,
+			})
+		}
+	}
+	// inexact: all removed and added pairs with different digests and similar content
+	if detectsInexactRenames(len(removedPaths), len(addedPaths), threshold) {
+		removedContents := newFileContents(removedBlobs)
+		addedContents := newFileContents(addedBlobs)
+		for _, removedPath := range removedPaths {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			fromNode := pathsRemoved[removedPath]
+			for _, addedPath := range addedPaths {
+				toNode := pathsAdded[addedPath]
+				if cas.DigestEqual(fromNode.Digest(), toNode.Digest()) {
+					continue // already an exact candidate
+				}
+				removedContent, addedContent := removedContents[removedPath], addedContents[addedPath]
+				if removedContent == nil || addedContent == nil {
+					continue // not diffable
+				}
+				similarity := removedContent.similarity(addedContent, threshold)
+				if similarity < threshold {
+					continue
+				}
+				candidates = append(candidates, rename{
+					from:       fromNode,
+					to:         toNode,
+					similarity: similarity,
+				})
+			}
+		}
+	}
+	slices.SortFunc(candidates, compareRenames)
+	var (
+		renames      []rename
+		matchedPaths = make(map[string]struct{}) // removed and added paths are disjoint
+	)
+	for _, candidate := range candidates {
+		if _, ok := matchedPaths[candidate.from.Path()]; ok {
+			continue
+		}
+		if _, ok := matchedPaths[candidate.to.Path()]; ok {
+			continue
+		}
+		matchedPaths[candidate.from.Path()] = struct{}{}
+		matchedPaths[candidate.to.Path()] = struct{}{}
+		renames = append(renames, candidate)
+	}
+	return renames, nil
+}
+
+// detectsInexactRenames returns true if renames with changes are detected between the given numbers
+// of removed and added files. Only then is the content of removed and added files needed.
+func detectsInexactRenames(removedCount int, addedCount int, threshold int) bool {
+	return threshold < 100 &&
+		removedCount > 0 && removedCount <= maxInexactRenameFiles &&
+		addedCount > 0 && addedCount <= maxInexactRenameFiles
+}
+
+// compareRenames sorts rename candidates from best to worst match.
+func compareRenames(a rename, b rename) int {
+	if a.exact != b.exact {
+		if a.exact {
+			return -1
+		}
+		return 1
+	}
+	if c := cmp.Compare(b.similarity, a.similarity); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(pathsSimilarity(b.from.Path(), b.to.Path()), pathsSimilarity(a.from.Path(), a.to.Path())); c != 0 {
+		return c
+	}
+	if c := strings.Compare(a.from.Path(), b.from.Path()); c != 0 {
+		return c
+	}
+	return strings.Compare(a.to.Path(), b.to.Path())
+}
+
+// pathsSimilarity scores how close two paths are: a matching base name counts more than any number
+// of matching leading directories.
+func pathsSimilarity(fromPath string, toPath string) int {
+	var score int
+	if path.Base(fromPath) == path.Base(toPath) {
+		score += 1 << 16
+	}
+	fromDirs := strings.Split(path.Dir(fromPath), "/")
+	toDirs := strings.Split(path.Dir(toPath), "/")
+	for i := 0; i < len(fromDirs) && i < len(toDirs) && fromDirs[i] == toDirs[i]; i++ {
+		score++
+	}
+	return score
+}
+
+// fileContent is the content of a file indexed for similarity comparisons.
+type fileContent struct {
+	size         int
+	linesToCount map[string]int
+}
+
+func newFileContent(data []byte) *fileContent {
+	content := &fileContent{
+		size:         len(data),
+		linesToCount: make(map[string]int),
+	}
+	for line := range bytes.SplitAfterSeq(data, []byte("\n")) {
+		content.linesToCount[string(line)]++
+	}
+	return content
+}
+
+// similarity returns the percentage of content in common between two files, as the size of their
+// common lines over the size of the largest file. It returns early with 0 if the files sizes are
+// too far apart to reach the threshold.
+func (c *fileContent) similarity(other *fileContent, threshold int) int {
+	minSize, maxSize := min(c.size, other.size), max(c.size, other.size)
+	if maxSize == 0 || minSize*100 < threshold*maxSize {
+		return 0
+	}
+	small, large := c, other
+	if len(small.linesToCount) > len(large.linesToCount) {
+		small, large = large, small
+	}
+	var common int
+	for line, count := range small.linesToCount {
+		common += min(count, large.linesToCount[line]) * len(line)
+	}
+	return common * 100 / maxSize
+}
+
+// newFileContents indexes all diffable blobs by path. Binary and large blobs are left out, they
+// can only be exact renames.
+func newFileContents(pathsToBlobs map[string]blobContent) map[string]*fileContent {
+	pathsToContents := make(map[string]*fileContent, len(pathsToBlobs))
+	for filePath, blob := range pathsToBlobs {
+		if blob.isDiffable() {
+			pathsToContents[filePath] = newFileContent(blob.data)
+		}
+	}
+	return pathsToContents
+}
@@ -0,0 +1,126 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufcasdiff
+
+import (
+	"bytes"
+	"strings"
+	"time"
+
+	statev1beta1 "github.com/bufbuild/modules/private/gen/modules/state/v1beta1"
+)
+
+// syncedReference is a module reference shown with its sync metadata, labeled by its side in a
+// diff, e.g. "from", or unlabeled in a report.
+type syncedReference struct {
+	label     string
+	reference *statev1beta1.ModuleReference
+}
+
+// writeSyncedReferences writes the sync metadata of the references, one per line, each followed by
+// the supersessions of the reference, if it was superseded. Nothing is written if none of the
+// references has sync metadata or supersessions, like references synced before they were recorded,
+// or CAS directories without a state file.
+func writeSyncedReferences(b *bytes.Buffer, syncedReferences []syncedReference, isMarkdown bool) {
+	hasSyncMetadata := false
+	for _, syncedReference := range syncedReferences {
+		if syncedReference.reference.HasSyncMetadata() || len(syncedReference.reference.GetSupersessions()) > 0 {
+			hasSyncMetadata = true
+			break
+		}
+	}
+	if !hasSyncMetadata {
+		return
+	}
+	b.WriteString("\n")
+	if isMarkdown {
+		b.WriteString("# ")
+	}
+	b.WriteString("Synced references:\n\n")
+	for _, syncedReference := range syncedReferences {
+		b.WriteString("- ")
+		if syncedReference.label != "" {
+			b.WriteString(syncedReference.label + " ")
+		}
+		b.WriteString(inlineCode(syncedReference.reference.GetName(), isMarkdown) + ": ")
+		b.WriteString(formatSyncMetadata(syncedReference.reference.GetSyncMetadata(), isMarkdown) + "\n")
+		for _, supersession := range syncedReference.reference.GetSupersessions() {
+			b.WriteString("  - " + formatSupersession(supersession, isMarkdown) + "\n")
+		}
+	}
+}
+
+// writeSyncedReferences writes the sync metadata of the diffed references, if any of them has it.
+func (d *ManifestDiff) writeSyncedReferences(b *bytes.Buffer, isMarkdown bool) {
+	var syncedReferences []syncedReference
+	if d.fromReference != nil {
+		syncedReferences = append(syncedReferences, syncedReference{label: "from", reference: d.fromReference})
+	}
+	if d.toReference != nil {
+		syncedReferences = append(syncedReferences, syncedReference{label: "to", reference: d.toReference})
+	}
+	writeSyncedReferences(b, syncedReferences, isMarkdown)
+}
+
+// formatSyncMetadata returns the sync metadata of a reference in the shape of:
+//
+// tag at commit <commit>, synced <time>, source config <version>, <tool version>
+//
+// or "commit <commit>, ..." for commit references.
+//
+// Unset fields are left out.
+func formatSyncMetadata(syncMetadata *statev1beta1.SyncMetadata, isMarkdown bool) string {
+	if syncMetadata == nil {
+		return "no sync metadata"
+	}
+	var parts []string
+	commit := syncMetadata.GetCommit()
+	switch {
+	case syncMetadata.GetKind() == statev1beta1.ReferenceKind_REFERENCE_KIND_TAG && commit != "":
+		parts = append(parts, "tag at commit "+inlineCode(commit, isMarkdown))
+	case syncMetadata.GetKind() == statev1beta1.ReferenceKind_REFERENCE_KIND_TAG:
+		parts = append(parts, "tag")
+	case commit != "":
+		parts = append(parts, "commit "+inlineCode(commit, isMarkdown))
+	case syncMetadata.GetKind() == statev1beta1.ReferenceKind_REFERENCE_KIND_COMMIT:
+		parts = append(parts, "commit")
+	}
+	if syncMetadata.HasSyncTime() {
+		parts = append(parts, "synced "+syncMetadata.GetSyncTime().AsTime().UTC().Format(time.RFC3339))
+	}
+	if sourceConfigVersion := syncMetadata.GetSourceConfigVersion(); sourceConfigVersion != "" {
+		parts = append(parts, "source config "+sourceConfigVersion)
+	}
+	if toolVersion := syncMetadata.GetToolVersion(); toolVersion != "" {
+		parts = append(parts, toolVersion)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// formatSupersession returns a supersession of a reference in the shape of:
+//
+// superseded <time>: <reason>, previous digest <digest>
+func formatSupersession(supersession *statev1beta1.Supersession, isMarkdown bool) string {
+	return "superseded " + supersession.GetSupersedeTime().AsTime().UTC().Format(time.RFC3339) +
+		": " + supersession.GetReason() +
+		", previous digest " + inlineCode(supersession.GetPreviousDigest(), isMarkdown)
+}
+
+func inlineCode(s string, isMarkdown bool) string {
+	if isMarkdown {
+		return "`" + s + "`"
+	}
+	return s
+}
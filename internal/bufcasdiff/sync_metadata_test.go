@@ -0,0 +1,137 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufcasdiff
+
+import (
+	"testing"
+	"time"
+
+	statev1beta1 "github.com/bufbuild/modules/private/gen/modules/state/v1beta1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestSyncedReferences(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	casBucket, mFrom, mTo := prepareDiffCASBucket(ctx, t)
+	moduleState := statev1beta1.ModuleState_builder{
+		References: []*statev1beta1.ModuleReference{
+			statev1beta1.ModuleReference_builder{Name: "v1", Digest: manifestPath(t, mFrom)}.Build(),
+			statev1beta1.ModuleReference_builder{
+				Name:   "v2",
+				Digest: manifestPath(t, mTo),
+				SyncMetadata: statev1beta1.SyncMetadata_builder{
+					SyncTime:            timestamppb.New(time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)),
+					Commit:              "0123456789abcdef0123456789abcdef01234567",
+					Kind:                statev1beta1.ReferenceKind_REFERENCE_KIND_TAG,
+					SourceConfigVersion: "v2",
+					ToolVersion:         "buf 1.50.0",
+				}.Build(),
+			}.Build(),
+			statev1beta1.ModuleReference_builder{
+				Name:   "0123456789abcdef0123456789abcdef01234567",
+				Digest: manifestPath(t, mTo),
+				SyncMetadata: statev1beta1.SyncMetadata_builder{
+					SyncTime: timestamppb.New(time.Date(2025, 1, 3, 0, 0, 0, 0, time.UTC)),
+					Commit:   "0123456789abcdef0123456789abcdef01234567",
+					Kind:     statev1beta1.ReferenceKind_REFERENCE_KIND_COMMIT,
+				}.Build(),
+			}.Build(),
+		},
+	}.Build()
+	moduleReader := newModuleReader(moduleState, casBucket)
+
+	mdiff, err := moduleReader.Diff(ctx, "v1", "v2")
+	require.NoError(t, err)
+	assert.Contains(
+		t,
+		mdiff.String(ManifestDiffOutputFormatText),
+		`
+Synced references:
+
+- from v1: no sync metadata
+- to v2: tag at commit 0123456789abcdef0123456789abcdef01234567, synced 2025-01-02T03:04:05Z, source config v2, buf 1.50.0
+`,
+	)
+	assert.Contains(
+		t,
+		mdiff.String(ManifestDiffOutputFormatMarkdown),
+		"- to `v2`: tag at commit `0123456789abcdef0123456789abcdef01234567`, synced 2025-01-02T03:04:05Z, source config v2, buf 1.50.0\n",
+	)
+	// same content, only the sync metadata is shown
+	mdiff, err = moduleReader.Diff(ctx, "v2", "0123456789abcdef0123456789abcdef01234567")
+	require.NoError(t, err)
+	assert.True(t, mdiff.IsEmpty())
+	assert.Contains(
+		t,
+		mdiff.String(ManifestDiffOutputFormatText),
+		"- to 0123456789abcdef0123456789abcdef01234567: commit 0123456789abcdef0123456789abcdef01234567, synced 2025-01-03T00:00:00Z\n",
+	)
+	report, err := moduleReader.Describe(ctx, "v2")
+	require.NoError(t, err)
+	assert.Contains(t, report.String(ManifestDiffOutputFormatText), "\nSynced references:\n\n- v2: tag at commit")
+
+	// references without sync metadata show nothing
+	report, err = moduleReader.Describe(ctx, "v1")
+	require.NoError(t, err)
+	assert.NotContains(t, report.String(ManifestDiffOutputFormatText), "Synced references")
+	mdiff, err = newModuleReader(nil, casBucket).Diff(ctx, manifestPath(t, mFrom), manifestPath(t, mTo))
+	require.NoError(t, err)
+	assert.NotContains(t, mdiff.String(ManifestDiffOutputFormatText), "Synced references")
+}
+
+func TestSupersededReferences(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	casBucket, mFrom, mTo := prepareDiffCASBucket(ctx, t)
+	moduleState := statev1beta1.ModuleState_builder{
+		References: []*statev1beta1.ModuleReference{
+			statev1beta1.ModuleReference_builder{
+				Name:   "v1",
+				Digest: manifestPath(t, mTo),
+				Supersessions: []*statev1beta1.Supersession{
+					statev1beta1.Supersession_builder{
+						PreviousDigest: manifestPath(t, mFrom),
+						SupersedeTime:  timestamppb.New(time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)),
+						Reason:         "tag recreated upstream",
+					}.Build(),
+				},
+			}.Build(),
+		},
+	}.Build()
+	moduleReader := newModuleReader(moduleState, casBucket)
+
+	report, err := moduleReader.Describe(ctx, "v1")
+	require.NoError(t, err)
+	assert.Contains(
+		t,
+		report.String(ManifestDiffOutputFormatText),
+		"\nSynced references:\n\n- v1: no sync metadata\n  - superseded 2025-02-01T00:00:00Z: tag recreated upstream, previous digest "+manifestPath(t, mFrom)+"\n",
+	)
+	assert.Contains(
+		t,
+		report.String(ManifestDiffOutputFormatMarkdown),
+		"  - superseded 2025-02-01T00:00:00Z: tag recreated upstream, previous digest `"+manifestPath(t, mFrom)+"`\n",
+	)
+	// the previous digest can be diffed against the current content
+	mdiff, err := moduleReader.Diff(ctx, manifestPath(t, mFrom), "v1")
+	require.NoError(t, err)
+	assert.False(t, mdiff.IsEmpty())
+	assert.Contains(t, mdiff.String(ManifestDiffOutputFormatText), "- to v1: no sync metadata\n  - superseded")
+	_, err = moduleReader.Diff(ctx, "unknown", "v1")
+	require.ErrorContains(t, err, "reference unknown not found in the module state file")
+}
@@ -0,0 +1,415 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufcasdiff
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/bufbuild/buf/private/pkg/diff/diffmyers"
+)
+
+// htmlEscaper escapes the characters with special meaning in HTML text. Quotes are kept as they are,
+// so diffs are still readable in their raw form.
+var htmlEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;") //nolint:gochecknoglobals // treated as const
+
+// DefaultContextLines is the default number of unchanged lines shown around each change in content
+// diffs, the same default as diff -u.
+const DefaultContextLines = 3
+
+type diffLineKind int
+
+const (
+	diffLineKindEqual diffLineKind = iota + 1
+	diffLineKindDelete
+	diffLineKindInsert
+)
+
+// diffLine is a single line in a content diff. Its text includes the trailing newline, if any.
+type diffLine struct {
+	kind diffLineKind
+	text string
+}
+
+// diffHunk is a group of nearby changed lines with their surrounding context lines. Line numbers
+// are 1-based.
+type diffHunk struct {
+	fromStart int
+	fromCount int
+	toStart   int
+	toCount   int
+	lines     []diffLine
+}
+
+// unifiedDiff is the line diff between two files.
+type unifiedDiff struct {
+	fromName string
+	toName   string
+	hunks    []diffHunk
+}
+
+// newUnifiedDiff computes the line diff between two files, grouping changes in hunks with
+// contextLines unchanged lines around them.
+func newUnifiedDiff(fromName string, fromData []byte, toName string, toData []byte, contextLines int) *unifiedDiff {
+	fromLines, toLines := splitLines(fromData), splitLines(toData)
+	return &unifiedDiff{
+		fromName: fromName,
+		toName:   toName,
+		hunks:    groupHunks(diffLines(fromLines, toLines), max(contextLines, 0)),
+	}
+}
+
+// String returns the diff in the unified format, the same as diff -u without timestamps. Returns
+// an empty string if there are no changes.
+func (d *unifiedDiff) String() string {
+	if len(d.hunks) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("--- " + d.fromName + "\n")
+	b.WriteString("+++ " + d.toName + "\n")
+	for _, hunk := range d.hunks {
+		b.WriteString(hunk.header() + "\n")
+		for _, line := range hunk.lines {
+			b.WriteString(line.prefix() + line.text)
+			if !strings.HasSuffix(line.text, "\n") {
+				b.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+	}
+	return b.String()
+}
+
+// HTML returns the diff in the unified format as HTML, in a pre block. Paired deleted and inserted
+// lines that are mostly the same have their changed tokens highlighted with del and ins tags.
+// Returns an empty string if there are no changes.
+func (d *unifiedDiff) HTML() string {
+	if len(d.hunks) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("<pre>\n")
+	b.WriteString(htmlEscaper.Replace("--- "+d.fromName) + "\n")
+	b.WriteString(htmlEscaper.Replace("+++ "+d.toName) + "\n")
+	for _, hunk := range d.hunks {
+		b.WriteString(hunk.header() + "\n")
+		lines := hunk.lines
+		for len(lines) > 0 {
+			deletes := countLines(lines, diffLineKindDelete)
+			inserts := countLines(lines[deletes:], diffLineKindInsert)
+			if deletes == 0 || inserts == 0 {
+				// not a block of changed lines, write the line as is
+				writeHTMLLine(&b, lines[0].prefix(), htmlEscaper.Replace(lines[0].text))
+				lines = lines[1:]
+				continue
+			}
+			fromHTMLs, toHTMLs := highlightLineBlock(lines[:deletes], lines[deletes:deletes+inserts])
+			for _, fromHTML := range fromHTMLs {
+				writeHTMLLine(&b, "-", fromHTML)
+			}
+			for _, toHTML := range toHTMLs {
+				writeHTMLLine(&b, "+", toHTML)
+			}
+			lines = lines[deletes+inserts:]
+		}
+	}
+	b.WriteString("</pre>\n")
+	return b.String()
+}
+
+func (h diffHunk) header() string {
+	return fmt.Sprintf("@@ -%s +%s @@", hunkRange(h.fromStart, h.fromCount), hunkRange(h.toStart, h.toCount))
+}
+
+func (l diffLine) prefix() string {
+	switch l.kind {
+	case diffLineKindDelete:
+		return "-"
+	case diffLineKindInsert:
+		return "+"
+	default:
+		return " "
+	}
+}
+
+// hunkRange formats a hunk range the same way as diff -u: the count is omitted when it is 1, and an
+// empty range starts at the line before it.
+func hunkRange(start int, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start-1)
+	case 1:
+		return fmt.Sprintf("%d", start)
+	default:
+		return fmt.Sprintf("%d,%d", start, count)
+	}
+}
+
+func writeHTMLLine(b *strings.Builder, prefix string, escapedText string) {
+	b.WriteString(prefix + escapedText)
+	if !strings.HasSuffix(escapedText, "\n") {
+		b.WriteString("\n\\ No newline at end of file\n")
+	}
+}
+
+func countLines(lines []diffLine, kind diffLineKind) int {
+	var count int
+	for count < len(lines) && lines[count].kind == kind {
+		count++
+	}
+	return count
+}
+
+// splitLines splits data in lines, keeping their trailing newlines.
+func splitLines(data []byte) [][]byte {
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	if len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines returns all lines of both files, in order, each marked as equal, deleted or inserted.
+func diffLines(fromLines [][]byte, toLines [][]byte) []diffLine {
+	var (
+		lines   = make([]diffLine, 0, max(len(fromLines), len(toLines)))
+		fromIdx int
+	)
+	for _, edit := range diffmyers.Diff(fromLines, toLines) {
+		for ; fromIdx < edit.FromPosition; fromIdx++ {
+			lines = append(lines, diffLine{kind: diffLineKindEqual, text: string(fromLines[fromIdx])})
+		}
+		switch edit.Kind {
+		case diffmyers.EditKindDelete:
+			lines = append(lines, diffLine{kind: diffLineKindDelete, text: string(fromLines[fromIdx])})
+			fromIdx++
+		case diffmyers.EditKindInsert:
+			lines = append(lines, diffLine{kind: diffLineKindInsert, text: string(toLines[edit.ToPosition])})
+		}
+	}
+	for ; fromIdx < len(fromLines); fromIdx++ {
+		lines = append(lines, diffLine{kind: diffLineKindEqual, text: string(fromLines[fromIdx])})
+	}
+	// Edits can interleave deleted and inserted lines, sort each block of changed lines with deleted
+	// lines first, as diff -u does.
+	for i := 0; i < len(lines); {
+		if lines[i].kind == diffLineKindEqual {
+			i++
+			continue
+		}
+		j := i
+		for j < len(lines) && lines[j].kind != diffLineKindEqual {
+			j++
+		}
+		slices.SortStableFunc(lines[i:j], func(a, b diffLine) int {
+			return cmp.Compare(a.kind, b.kind)
+		})
+		i = j
+	}
+	return lines
+}
+
+// groupHunks groups changed lines in hunks with up to contextLines equal lines before and after
+// them. Changes separated by up to twice contextLines equal lines are in the same hunk.
+func groupHunks(lines []diffLine, contextLines int) []diffHunk {
+	var (
+		hunks []diffHunk
+		// 1-based line numbers in each file of the line at each index in lines
+		fromLines = make([]int, len(lines))
+		toLines   = make([]int, len(lines))
+		fromLine  = 1
+		toLine    = 1
+	)
+	for i, line := range lines {
+		fromLines[i], toLines[i] = fromLine, toLine
+		if line.kind != diffLineKindInsert {
+			fromLine++
+		}
+		if line.kind != diffLineKindDelete {
+			toLine++
+		}
+	}
+	for i := 0; i < len(lines); i++ {
+		if lines[i].kind == diffLineKindEqual {
+			continue
+		}
+		// extend the hunk while the next change is within twice the context of the last one
+		lastChange := i
+		for j := i + 1; j < len(lines) && j <= lastChange+2*contextLines+1; j++ {
+			if lines[j].kind != diffLineKindEqual {
+				lastChange = j
+			}
+		}
+		start := max(i-contextLines, 0)
+		end := min(lastChange+contextLines+1, len(lines)) // exclusive
+		hunk := diffHunk{
+			fromStart: fromLines[start],
+			toStart:   toLines[start],
+			lines:     lines[start:end],
+		}
+		for _, line := range hunk.lines {
+			if line.kind != diffLineKindInsert {
+				hunk.fromCount++
+			}
+			if line.kind != diffLineKindDelete {
+				hunk.toCount++
+			}
+		}
+		hunks = append(hunks, hunk)
+		i = end - 1
+	}
+	return hunks
+}
+
+// maxHighlightPairs is the maximum number of deleted and inserted line pairs compared in a single
+// block of changed lines, past it the block is not highlighted.
+const maxHighlightPairs = 1024
+
+// highlightLineBlock returns the deleted and inserted lines of a block of changed lines HTML
+// escaped. Deleted and inserted lines are paired in order when they are mostly the same, and their
+// changed tokens are wrapped in del and ins tags respectively.
+func highlightLineBlock(deletedLines []diffLine, insertedLines []diffLine) ([]string, []string) {
+	var (
+		fromHTMLs = make([]string, len(deletedLines))
+		toHTMLs   = make([]string, len(insertedLines))
+		nextTo    int
+	)
+	for i, line := range deletedLines {
+		fromHTMLs[i] = htmlEscaper.Replace(line.text)
+	}
+	for i, line := range insertedLines {
+		toHTMLs[i] = htmlEscaper.Replace(line.text)
+	}
+	if len(deletedLines)*len(insertedLines) > maxHighlightPairs {
+		return fromHTMLs, toHTMLs
+	}
+	for i, deletedLine := range deletedLines {
+		for j := nextTo; j < len(insertedLines); j++ {
+			fromHTML, toHTML, ok := highlightLinePair(deletedLine.text, insertedLines[j].text)
+			if !ok {
+				continue
+			}
+			fromHTMLs[i], toHTMLs[j] = fromHTML, toHTML
+			nextTo = j + 1
+			break
+		}
+	}
+	return fromHTMLs, toHTMLs
+}
+
+// highlightLinePair returns both lines HTML escaped, with the tokens that changed from one to the
+// other wrapped in del and ins tags respectively. Returns false if less than a third of the lines
+// is unchanged, as they are not the same line with some edits.
+func highlightLinePair(fromText string, toText string) (string, string, bool) {
+	fromTokens, toTokens := tokenize(fromText), tokenize(toText)
+	var (
+		fromChanged = make([]bool, len(fromTokens))
+		toChanged   = make([]bool, len(toTokens))
+	)
+	for _, edit := range diffmyers.Diff(fromTokens, toTokens) {
+		switch edit.Kind {
+		case diffmyers.EditKindDelete:
+			fromChanged[edit.FromPosition] = true
+		case diffmyers.EditKindInsert:
+			toChanged[edit.ToPosition] = true
+		}
+	}
+	var unchangedSize int
+	for i, token := range fromTokens {
+		if !fromChanged[i] && strings.TrimSpace(string(token)) != "" {
+			unchangedSize += len(token)
+		}
+	}
+	if unchangedSize*3 < max(len(strings.TrimSpace(fromText)), len(strings.TrimSpace(toText))) {
+		return "", "", false
+	}
+	return highlightTokens(fromTokens, fromChanged, "del"), highlightTokens(toTokens, toChanged, "ins"), true
+}
+
+// highlightTokens joins HTML escaped tokens, wrapping each run of changed tokens in the given tag.
+// Whitespace between changed tokens is highlighted too, and the trailing newline never is.
+func highlightTokens(tokens [][]byte, changed []bool, tag string) string {
+	// join runs of changed tokens separated by a single unchanged whitespace token
+	for i := 1; i+1 < len(tokens); i++ {
+		if !changed[i] && changed[i-1] && changed[i+1] && strings.TrimSpace(string(tokens[i])) == "" {
+			changed[i] = true
+		}
+	}
+	var (
+		b      strings.Builder
+		inside bool
+	)
+	for i, token := range tokens {
+		isChanged := changed[i] && string(token) != "\n"
+		if isChanged && !inside {
+			b.WriteString("<" + tag + ">")
+		}
+		if !isChanged && inside {
+			b.WriteString("</" + tag + ">")
+		}
+		inside = isChanged
+		b.WriteString(htmlEscaper.Replace(string(token)))
+	}
+	if inside {
+		b.WriteString("</" + tag + ">")
+	}
+	return b.String()
+}
+
+// tokenize splits a line in words, runs of whitespace, and single punctuation characters.
+func tokenize(text string) [][]byte {
+	var (
+		tokens [][]byte
+		data   = []byte(text)
+	)
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		class := runeClass(r)
+		for size < len(data) && class != runeClassOther {
+			next, nextSize := utf8.DecodeRune(data[size:])
+			if runeClass(next) != class {
+				break
+			}
+			size += nextSize
+		}
+		tokens = append(tokens, data[:size])
+		data = data[size:]
+	}
+	return tokens
+}
+
+type runeClassKind int
+
+const (
+	runeClassWord runeClassKind = iota + 1
+	runeClassSpace
+	runeClassOther
+)
+
+func runeClass(r rune) runeClassKind {
+	switch {
+	case r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r):
+		return runeClassWord
+	case r != '\n' && unicode.IsSpace(r):
+		return runeClassSpace
+	default:
+		return runeClassOther
+	}
+}
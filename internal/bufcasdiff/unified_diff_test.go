@@ -0,0 +1,129 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufcasdiff
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testUnifiedDiffFrom = `syntax = "proto3";
+
+package foo.v1;
+
+option go_package = "example.com/foo/v1;foov1";
+option java_package = "com.foo.v1";
+
+message Foo {
+  string name = 1;
+}
+`
+	testUnifiedDiffTo = `syntax = "proto3";
+
+package foo.v1;
+
+option go_package = "example.com/foo/gen/v1;foov1";
+option java_package = "com.foo.v1";
+
+message Foo {
+  string name = 1;
+  int64 created_at = 2;
+}`
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	t.Parallel()
+	t.Run("default_context", func(t *testing.T) {
+		t.Parallel()
+		unified := newUnifiedDiff("a.proto", []byte(testUnifiedDiffFrom), "b.proto", []byte(testUnifiedDiffTo), DefaultContextLines)
+		assert.Equal(t, `--- a.proto
++++ b.proto
+@@ -2,9 +2,10 @@
+ 
+ package foo.v1;
+ 
+-option go_package = "example.com/foo/v1;foov1";
++option go_package = "example.com/foo/gen/v1;foov1";
+ option java_package = "com.foo.v1";
+ 
+ message Foo {
+   string name = 1;
+-}
++  int64 created_at = 2;
++}
+\ No newline at end of file
+`, unified.String())
+	})
+	t.Run("no_context", func(t *testing.T) {
+		t.Parallel()
+		unified := newUnifiedDiff("a.proto", []byte(testUnifiedDiffFrom), "b.proto", []byte(testUnifiedDiffTo), 0)
+		assert.Equal(t, `--- a.proto
++++ b.proto
+@@ -5 +5 @@
+-option go_package = "example.com/foo/v1;foov1";
++option go_package = "example.com/foo/gen/v1;foov1";
+@@ -10 +10,2 @@
+-}
++  int64 created_at = 2;
++}
+\ No newline at end of file
+`, unified.String())
+	})
+	t.Run("insert_only", func(t *testing.T) {
+		t.Parallel()
+		unified := newUnifiedDiff("a", []byte("a\nb\n"), "b", []byte("a\nx\nb\n"), 0)
+		assert.Equal(t, "--- a\n+++ b\n@@ -1,0 +2 @@\n+x\n", unified.String())
+		unified = newUnifiedDiff("a", nil, "b", []byte("x\n"), DefaultContextLines)
+		assert.Equal(t, "--- a\n+++ b\n@@ -0,0 +1 @@\n+x\n", unified.String())
+	})
+	t.Run("equal", func(t *testing.T) {
+		t.Parallel()
+		unified := newUnifiedDiff("a", []byte("a\n"), "b", []byte("a\n"), DefaultContextLines)
+		assert.Empty(t, unified.String())
+		assert.Empty(t, unified.HTML())
+	})
+	t.Run("html", func(t *testing.T) {
+		t.Parallel()
+		unified := newUnifiedDiff("a.proto", []byte(testUnifiedDiffFrom), "b.proto", []byte(testUnifiedDiffTo), 1)
+		assert.Equal(t, `<pre>
+--- a.proto
++++ b.proto
+@@ -4,3 +4,3 @@
+ 
+-option go_package = "example.com/foo/v1;foov1";
++option go_package = "example.com/foo<ins>/gen</ins>/v1;foov1";
+ option java_package = "com.foo.v1";
+@@ -9,2 +9,3 @@
+   string name = 1;
+-}
++  int64 created_at = 2;
++}
+\ No newline at end of file
+</pre>
+`, unified.HTML())
+	})
+}
+
+func TestHighlightLinePair(t *testing.T) {
+	t.Parallel()
+	fromHTML, toHTML, ok := highlightLinePair("  string name = 1; // <b>\n", "  string display_name = 1; // <b>\n")
+	assert.True(t, ok)
+	assert.Equal(t, "  string <del>name</del> = 1; // &lt;b&gt;\n", fromHTML)
+	assert.Equal(t, "  string <ins>display_name</ins> = 1; // &lt;b&gt;\n", toHTML)
+	_, _, ok = highlightLinePair("message Foo {\n", "  int64 created_at = 2;\n")
+	assert.False(t, ok)
+}
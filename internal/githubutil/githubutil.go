@@ -19,15 +19,14 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
-	"github.com/bufbuild/buf/private/pkg/protoencoding"
 	"github.com/bufbuild/modules/private/bufpkg/bufstate"
-	statev1alpha1 "github.com/bufbuild/modules/private/gen/modules/state/v1alpha1"
 	"github.com/google/go-github/v64/github"
 	"github.com/hashicorp/go-retryablehttp"
 	"golang.org/x/oauth2"
@@ -186,20 +185,35 @@ func (c *Client) AllReleaseTagNames(
 	}
 }
 
-// DownloadReleaseState loads the state.json file from the specified GitHub release.
-func (c *Client) DownloadReleaseState(
-	ctx context.Context,
-	release *github.RepositoryRelease,
-) (*statev1alpha1.GlobalState, error) {
-	data, _, err := c.downloadAsset(ctx, release, bufstate.ModStateFileName)
-	if err != nil {
-		return nil, err
+// NewReleaseSource returns a bufstate.Source of the state files published in the GitHub release.
+// Releases only publish the global state file, as their state.json asset, so reading any other file
+// fails with an error that wraps fs.ErrNotExist. Releases published before state.v1beta1 have a
+// state.v1alpha1 asset.
+func (c *Client) NewReleaseSource(release *github.RepositoryRelease) bufstate.Source {
+	return &releaseSource{client: c, release: release}
+}
+
+type releaseSource struct {
+	client  *Client
+	release *github.RepositoryRelease
+}
+
+func (s *releaseSource) ReadFile(ctx context.Context, filePath string) ([]byte, error) {
+	if filePath != bufstate.GlobalStateFileName {
+		return nil, fmt.Errorf("release %s only has the global state file, not %s: %w", s.release.GetTagName(), filePath, fs.ErrNotExist)
 	}
-	var globalState statev1alpha1.GlobalState
-	if err := protoencoding.NewJSONUnmarshaler(protoencoding.EmptyResolver).Unmarshal(data, &globalState); err != nil {
-		return nil, err
+	data, _, err := s.client.downloadAsset(ctx, s.release, bufstate.GlobalStateFileName)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, fmt.Errorf("release %s has no %s asset: %w", s.release.GetTagName(), filePath, fs.ErrNotExist)
+		}
+		return nil, fmt.Errorf("download %s asset: %w", filePath, err)
 	}
-	return &globalState, nil
+	return data, nil
+}
+
+func (s *releaseSource) String() string {
+	return fmt.Sprintf("release %s", s.release.GetTagName())
 }
 
 // downloadAsset uses the GitHub API to download the asset with the given name from the release.
@@ -0,0 +1,262 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bufbuild/modules/private/bufpkg/bufstate"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"go.uber.org/multierr"
+)
+
+// Repository is the set of git operations needed to read and diff state files between refs.
+type Repository interface {
+	// ReadFile returns the content of the file at path as of the given ref. If the ref exists but the
+	// file does not exist in it, the returned error wraps fs.ErrNotExist.
+	ReadFile(ctx context.Context, ref string, path string) ([]byte, error)
+	// ChangedFiles returns the paths of all files with any change between baseRef and headRef.
+	ChangedFiles(ctx context.Context, baseRef string, headRef string) ([]string, error)
+}
+
+// GoGitRepository is a Repository that runs all operations in-process.
+type GoGitRepository struct {
+	repo *git.Repository
+}
+
+// NewGoGitRepository returns a Repository backed by the given go-git repository, which can be
+// on disk or in memory.
+func NewGoGitRepository(repo *git.Repository) *GoGitRepository {
+	return &GoGitRepository{repo: repo}
+}
+
+// OpenGoGitRepository opens the git repository that contains dirPath.
+func OpenGoGitRepository(dirPath string) (*GoGitRepository, error) {
+	repo, err := git.PlainOpenWithOptions(dirPath, &git.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return nil, fmt.Errorf("open git repository: %w", err)
+	}
+	return NewGoGitRepository(repo), nil
+}
+
+func (r *GoGitRepository) ReadFile(_ context.Context, ref string, path string) ([]byte, error) {
+	tree, err := r.tree(ref)
+	if err != nil {
+		return nil, err
+	}
+	file, err := tree.File(path)
+	if err != nil {
+		if errors.Is(err, object.ErrFileNotFound) {
+			return nil, fmt.Errorf("read %s:%s: %w", ref, path, fs.ErrNotExist)
+		}
+		return nil, fmt.Errorf("read %s:%s: %w", ref, path, err)
+	}
+	contents, err := file.Contents()
+	if err != nil {
+		return nil, fmt.Errorf("read %s:%s contents: %w", ref, path, err)
+	}
+	return []byte(contents), nil
+}
+
+func (r *GoGitRepository) ChangedFiles(ctx context.Context, baseRef string, headRef string) ([]string, error) {
+	baseTree, err := r.tree(baseRef)
+	if err != nil {
+		return nil, err
+	}
+	headTree, err := r.tree(headRef)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := object.DiffTreeWithOptions(ctx, baseTree, headTree, object.DefaultDiffTreeOptions)
+	if err != nil {
+		return nil, fmt.Errorf("diff trees %s..%s: %w", baseRef, headRef, err)
+	}
+	paths := make([]string, 0, len(changes))
+	for _, change := range changes {
+		// Deleted files only have a "from" name, all others have a "to" name.
+		if change.To.Name != "" {
+			paths = append(paths, change.To.Name)
+		} else {
+			paths = append(paths, change.From.Name)
+		}
+	}
+	return paths, nil
+}
+
+// ResolveCommit resolves a revision, e.g. a branch, a tag or HEAD, to its commit hash.
+func (r *GoGitRepository) ResolveCommit(ref string) (string, error) {
+	commit, err := r.commit(ref)
+	if err != nil {
+		return "", err
+	}
+	return commit.Hash.String(), nil
+}
+
+// MergeBase returns the commit hash of the best common ancestor of two revisions.
+func (r *GoGitRepository) MergeBase(ref string, otherRef string) (string, error) {
+	commit, err := r.commit(ref)
+	if err != nil {
+		return "", err
+	}
+	otherCommit, err := r.commit(otherRef)
+	if err != nil {
+		return "", err
+	}
+	mergeBases, err := commit.MergeBase(otherCommit)
+	if err != nil {
+		return "", fmt.Errorf("merge base of %s and %s: %w", ref, otherRef, err)
+	}
+	if len(mergeBases) == 0 {
+		return "", fmt.Errorf("%s and %s have no merge base", ref, otherRef)
+	}
+	return mergeBases[0].Hash.String(), nil
+}
+
+// ReadStagedFile returns the content of the file at path in a stage of the index, e.g. 1, 2 and 3
+// for the base, ours and theirs versions of a conflicted file. If the file is not in the stage, the
+// returned error wraps fs.ErrNotExist.
+func (r *GoGitRepository) ReadStagedFile(path string, stage int) (_ []byte, retErr error) {
+	gitIndex, err := r.repo.Storer.Index()
+	if err != nil {
+		return nil, fmt.Errorf("read index: %w", err)
+	}
+	for _, entry := range gitIndex.Entries {
+		if entry.Name != path || int(entry.Stage) != stage {
+			continue
+		}
+		blob, err := r.repo.BlobObject(entry.Hash)
+		if err != nil {
+			return nil, fmt.Errorf("read :%d:%s: %w", stage, path, err)
+		}
+		reader, err := blob.Reader()
+		if err != nil {
+			return nil, fmt.Errorf("read :%d:%s: %w", stage, path, err)
+		}
+		defer func() {
+			retErr = multierr.Append(retErr, reader.Close())
+		}()
+		return io.ReadAll(reader)
+	}
+	return nil, fmt.Errorf("read :%d:%s: %w", stage, path, fs.ErrNotExist)
+}
+
+// commit resolves a revision to its commit.
+func (r *GoGitRepository) commit(ref string) (*object.Commit, error) {
+	hash, err := r.repo.ResolveRevision(plumbing.Revision(ref))
+	if err != nil {
+		return nil, fmt.Errorf("resolve revision %s: %w", ref, err)
+	}
+	commit, err := r.repo.CommitObject(*hash)
+	if err != nil {
+		return nil, fmt.Errorf("commit object %s: %w", ref, err)
+	}
+	return commit, nil
+}
+
+// tree resolves a revision to its commit tree.
+func (r *GoGitRepository) tree(ref string) (*object.Tree, error) {
+	commit, err := r.commit(ref)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("commit tree %s: %w", ref, err)
+	}
+	return tree, nil
+}
+
+// ExecRepository is a Repository that shells out to the git binary.
+type ExecRepository struct {
+	dirPath string
+}
+
+// NewExecRepository returns a Repository that runs git commands in dirPath.
+func NewExecRepository(dirPath string) *ExecRepository {
+	return &ExecRepository{dirPath: dirPath}
+}
+
+func (r *ExecRepository) ReadFile(ctx context.Context, ref string, path string) ([]byte, error) {
+	output, err := r.output(ctx, "show", fmt.Sprintf("%s:%s", ref, path))
+	if err != nil {
+		// git show fails the same way for missing refs and missing files, tell them apart by checking
+		// the ref alone.
+		if _, refErr := r.output(ctx, "cat-file", "-e", ref+"^{commit}"); refErr == nil {
+			return nil, fmt.Errorf("git show %s:%s: %w", ref, path, fs.ErrNotExist)
+		}
+		return nil, fmt.Errorf("git show %s:%s: %w", ref, path, err)
+	}
+	return output, nil
+}
+
+func (r *ExecRepository) ChangedFiles(ctx context.Context, baseRef string, headRef string) ([]string, error) {
+	output, err := r.output(ctx, "diff", "--name-only", baseRef, headRef)
+	if err != nil {
+		return nil, fmt.Errorf("git diff: %w", err)
+	}
+	var paths []string
+	for line := range strings.SplitSeq(string(output), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		paths = append(paths, line)
+	}
+	return paths, nil
+}
+
+func (r *ExecRepository) output(ctx context.Context, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = r.dirPath
+	return cmd.Output()
+}
+
+// NewRevisionSource returns a bufstate.Source of the state files in the root sync directory at the
+// git revision, e.g. a branch, a tag or a commit hash. The root sync directory is relative to the
+// repository root, e.g. bufstate.SyncRoot.
+func NewRevisionSource(repo Repository, revision string, rootSyncDir string) bufstate.Source {
+	return &revisionSource{
+		repo:        repo,
+		revision:    revision,
+		rootSyncDir: filepath.ToSlash(rootSyncDir),
+	}
+}
+
+type revisionSource struct {
+	repo        Repository
+	revision    string
+	rootSyncDir string
+}
+
+func (s *revisionSource) ReadFile(ctx context.Context, filePath string) ([]byte, error) {
+	if !fs.ValidPath(filePath) {
+		return nil, fmt.Errorf("invalid state file path %q", filePath)
+	}
+	return s.repo.ReadFile(ctx, s.revision, path.Join(s.rootSyncDir, filePath))
+}
+
+func (s *revisionSource) String() string {
+	return fmt.Sprintf("git revision %s", s.revision)
+}
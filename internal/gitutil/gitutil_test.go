@@ -0,0 +1,124 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitutil
+
+import (
+	"io/fs"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/modules/private/bufpkg/bufstate"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRevisionSource(t *testing.T) {
+	t.Parallel()
+	billyFS := memfs.New()
+	repo, err := git.Init(memory.NewStorage(), billyFS)
+	require.NoError(t, err)
+	worktree, err := repo.Worktree()
+	require.NoError(t, err)
+	commit := func(globalState string) string {
+		require.NoError(t, util.WriteFile(billyFS, "modules/sync/state.json", []byte(globalState), 0600))
+		_, err := worktree.Add("modules/sync/state.json")
+		require.NoError(t, err)
+		hash, err := worktree.Commit("commit", &git.CommitOptions{
+			Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Unix(0, 0)},
+		})
+		require.NoError(t, err)
+		return hash.String()
+	}
+	baseRevision := commit(`{"modules": [{"module_name": "foo/bar", "latest_reference": "v1.0.0"}]}`)
+	headRevision := commit(`{"modules": [{"module_name": "foo/bar", "latest_reference": "v1.1.0"}]}`)
+	// releases are tagged on the commit of the state they release
+	_, err = repo.CreateTag("20250301.1", plumbing.NewHash(baseRevision), nil)
+	require.NoError(t, err)
+
+	stateRW, err := bufstate.NewReadWriter()
+	require.NoError(t, err)
+	for revision, latestReference := range map[string]string{
+		baseRevision: "v1.0.0",
+		headRevision: "v1.1.0",
+		"HEAD":       "v1.1.0",
+		"20250301.1": "v1.0.0",
+	} {
+		source := NewRevisionSource(NewGoGitRepository(repo), revision, bufstate.SyncRoot)
+		globalState, err := stateRW.ReadSourceGlobalState(t.Context(), source)
+		require.NoError(t, err)
+		require.Len(t, globalState.GetModules(), 1)
+		assert.Equal(t, latestReference, globalState.GetModules()[0].GetLatestReference())
+	}
+	for revision, commit := range map[string]string{
+		"HEAD":       headRevision,
+		"20250301.1": baseRevision,
+	} {
+		resolvedCommit, err := NewGoGitRepository(repo).ResolveCommit(revision)
+		require.NoError(t, err)
+		assert.Equal(t, commit, resolvedCommit)
+	}
+	source := NewRevisionSource(NewGoGitRepository(repo), headRevision, bufstate.SyncRoot)
+	assert.Equal(t, "git revision "+headRevision, source.String())
+	_, err = stateRW.ReadSourceModuleState(t.Context(), source, "foo/bar")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	_, err = source.ReadFile(t.Context(), "/state.json")
+	require.ErrorContains(t, err, "invalid state file path")
+	_, err = stateRW.ReadSourceGlobalState(t.Context(), NewRevisionSource(NewGoGitRepository(repo), "unknown", bufstate.SyncRoot))
+	require.ErrorContains(t, err, "resolve revision unknown")
+}
+
+func TestMergeBaseAndReadStagedFile(t *testing.T) {
+	t.Parallel()
+	billyFS := memfs.New()
+	repo, err := git.Init(memory.NewStorage(), billyFS)
+	require.NoError(t, err)
+	worktree, err := repo.Worktree()
+	require.NoError(t, err)
+	commit := func(content string) string {
+		require.NoError(t, util.WriteFile(billyFS, "state.json", []byte(content), 0600))
+		_, err := worktree.Add("state.json")
+		require.NoError(t, err)
+		hash, err := worktree.Commit("commit", &git.CommitOptions{
+			Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Unix(0, 0)},
+		})
+		require.NoError(t, err)
+		return hash.String()
+	}
+	baseRevision := commit("base")
+	require.NoError(t, worktree.Checkout(&git.CheckoutOptions{
+		Hash:   plumbing.NewHash(baseRevision),
+		Branch: plumbing.NewBranchReferenceName("theirs"),
+		Create: true,
+	}))
+	theirsRevision := commit("theirs")
+	require.NoError(t, worktree.Checkout(&git.CheckoutOptions{Branch: plumbing.Master}))
+	commit("ours")
+	gitRepo := NewGoGitRepository(repo)
+	mergeBase, err := gitRepo.MergeBase("HEAD", theirsRevision)
+	require.NoError(t, err)
+	assert.Equal(t, baseRevision, mergeBase)
+
+	content, err := gitRepo.ReadStagedFile("state.json", 0)
+	require.NoError(t, err)
+	assert.Equal(t, "ours", string(content))
+	_, err = gitRepo.ReadStagedFile("state.json", 3)
+	require.ErrorIs(t, err, fs.ErrNotExist)
+}
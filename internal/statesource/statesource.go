@@ -0,0 +1,77 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package statesource
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/bufbuild/modules/internal/githubutil"
+	"github.com/bufbuild/modules/internal/gitutil"
+	"github.com/bufbuild/modules/private/bufpkg/bufstate"
+	"github.com/google/go-github/v64/github"
+)
+
+const (
+	// WorkTree is the spec of the root sync directory in the working tree.
+	WorkTree = "worktree"
+	// GitPrefix is the prefix of the spec of the root sync directory at a git revision, e.g.
+	// "git:main".
+	GitPrefix = "git:"
+	// ReleasePrefix is the prefix of the spec of the state published in a GitHub release, e.g.
+	// "release:20250301.2", or "release:latest" for the latest release.
+	ReleasePrefix = "release:"
+
+	latestRelease = "latest"
+)
+
+// New returns the source of the state files of the spec, which is either WorkTree, GitPrefix
+// followed by a git revision, or ReleasePrefix followed by a release tag or "latest". The root sync
+// directory is relative to the working directory, which must be the repository root for git
+// revisions, e.g. bufstate.SyncRoot.
+func New(ctx context.Context, spec string, rootSyncDir string) (bufstate.Source, error) {
+	if spec == WorkTree {
+		return bufstate.NewDirSource(rootSyncDir), nil
+	}
+	if revision, ok := strings.CutPrefix(spec, GitPrefix); ok {
+		if revision == "" {
+			return nil, fmt.Errorf("state source %q: empty git revision", spec)
+		}
+		repo, err := gitutil.OpenGoGitRepository(".")
+		if err != nil {
+			return nil, fmt.Errorf("state source %q: %w", spec, err)
+		}
+		return gitutil.NewRevisionSource(repo, revision, rootSyncDir), nil
+	}
+	if tag, ok := strings.CutPrefix(spec, ReleasePrefix); ok {
+		if tag == "" {
+			return nil, fmt.Errorf("state source %q: empty release tag", spec)
+		}
+		client := githubutil.NewClient(ctx)
+		var err error
+		var release *github.RepositoryRelease
+		if tag == latestRelease {
+			release, err = client.GetLatestRelease(ctx, githubutil.GithubOwnerBufbuild, githubutil.GithubRepoModules)
+		} else {
+			release, err = client.GetReleaseByTag(ctx, githubutil.GithubOwnerBufbuild, githubutil.GithubRepoModules, tag)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("state source %q: %w", spec, err)
+		}
+		return client.NewReleaseSource(release), nil
+	}
+	return nil, fmt.Errorf("unknown state source %q, expected %s, %s<revision> or %s<tag>", spec, WorkTree, GitPrefix, ReleasePrefix)
+}
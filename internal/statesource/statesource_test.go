@@ -0,0 +1,35 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package statesource
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+	source, err := New(t.Context(), WorkTree, "modules/sync")
+	require.NoError(t, err)
+	assert.Equal(t, "directory modules/sync", source.String())
+	_, err = New(t.Context(), "git:", "modules/sync")
+	require.ErrorContains(t, err, "empty git revision")
+	_, err = New(t.Context(), "release:", "modules/sync")
+	require.ErrorContains(t, err, "empty release tag")
+	_, err = New(t.Context(), "main", "modules/sync")
+	require.ErrorContains(t, err, `unknown state source "main"`)
+}
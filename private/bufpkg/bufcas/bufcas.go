@@ -0,0 +1,293 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufcas
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/bufbuild/buf/private/pkg/cas"
+	"github.com/bufbuild/buf/private/pkg/storage"
+	"github.com/bufbuild/buf/private/pkg/storage/storageos"
+	"go.uber.org/multierr"
+)
+
+const (
+	// CASDirName is the name of the directory in each module directory that holds all its blobs.
+	CASDirName = "cas"
+	// PackDirName is the name of the directory in a CAS directory that holds its pack files.
+	PackDirName = "pack"
+)
+
+// Layout is the way blobs are stored in a CAS directory.
+type Layout int
+
+const (
+	// LayoutLoose stores every blob in its own file, named by the blob digest hex.
+	LayoutLoose Layout = iota + 1
+	// LayoutPacked stores blobs concatenated in pack files, each with an index of the blob offsets by
+	// digest, in the pack directory.
+	LayoutPacked
+)
+
+// String implements fmt.Stringer.
+func (l Layout) String() string {
+	switch l {
+	case LayoutLoose:
+		return "loose"
+	case LayoutPacked:
+		return "packed"
+	default:
+		return strconv.Itoa(int(l))
+	}
+}
+
+// ParseLayout parses a layout from its string representation.
+func ParseLayout(s string) (Layout, error) {
+	for _, layout := range []Layout{LayoutLoose, LayoutPacked} {
+		if s == layout.String() {
+			return layout, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown CAS layout %q, must be one of %s or %s", s, LayoutLoose, LayoutPacked)
+}
+
+// DetectLayout returns LayoutPacked if the CAS directory has any pack file, and LayoutLoose
+// otherwise, including if the directory does not exist yet.
+func DetectLayout(casDirPath string) (Layout, error) {
+	packIndexPaths, err := listPackIndexes(casDirPath)
+	if err != nil {
+		return 0, err
+	}
+	if len(packIndexPaths) > 0 {
+		return LayoutPacked, nil
+	}
+	return LayoutLoose, nil
+}
+
+// NewReadBucket returns a bucket with all the blobs in the CAS directory, in either layout or both,
+// with each blob path being its digest hex.
+func NewReadBucket(casDirPath string) (storage.ReadBucket, error) {
+	looseBucket, err := newLooseReadBucket(casDirPath)
+	if err != nil {
+		return nil, err
+	}
+	packIndexPaths, err := listPackIndexes(casDirPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(packIndexPaths) == 0 {
+		return looseBucket, nil
+	}
+	packBucket, err := newPackReadBucket(packIndexPaths)
+	if err != nil {
+		return nil, err
+	}
+	return storage.OverlayReadBucket(looseBucket, packBucket), nil
+}
+
+// WriteBlobs writes all blobs not already in the CAS directory, in the given layout. The directory
+// is created if it does not exist.
+//
+// In the packed layout, all missing blobs are written to a single new pack.
+func WriteBlobs(ctx context.Context, casDirPath string, layout Layout, blobs []cas.Blob) error {
+	// mkdir directory in case this is the first time a reference is being synced for this module.
+	if err := os.MkdirAll(casDirPath, 0755); err != nil {
+		return fmt.Errorf("make cas dir: %w", err)
+	}
+	bucket, err := NewReadBucket(casDirPath)
+	if err != nil {
+		return err
+	}
+	var missingBlobs []cas.Blob
+	for _, blob := range blobs {
+		exists, err := storage.Exists(ctx, bucket, hex.EncodeToString(blob.Digest().Value()))
+		if err != nil {
+			return fmt.Errorf("check blob exists: %w", err)
+		}
+		if !exists {
+			missingBlobs = append(missingBlobs, blob)
+		}
+	}
+	switch layout {
+	case LayoutLoose:
+		// TODO: parallelize
+		for _, blob := range missingBlobs {
+			hexDigest := hex.EncodeToString(blob.Digest().Value())
+			if err := writeFileAtomic(filepath.Join(casDirPath, hexDigest), blob.Content()); err != nil {
+				return fmt.Errorf("write blob %q to file: %w", hexDigest, err)
+			}
+		}
+		return nil
+	case LayoutPacked:
+		if len(missingBlobs) == 0 {
+			return nil
+		}
+		if _, err := writePack(filepath.Join(casDirPath, PackDirName), missingBlobs); err != nil {
+			return fmt.Errorf("write pack: %w", err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown CAS layout %s", layout)
+	}
+}
+
+// Migrate rewrites the CAS directory to the given layout. Migrating to LayoutPacked writes all blobs
+// to a single pack, which also consolidates previous packs, and migrating to LayoutLoose unpacks all
+// packs. Blob files and packs left over from the previous layout are only removed once all blobs are
+// written and verified against their digests in the new one, so an interrupted migration can be run
+// again.
+func Migrate(ctx context.Context, casDirPath string, layout Layout) error {
+	looseBucket, err := newLooseReadBucket(casDirPath)
+	if err != nil {
+		return err
+	}
+	loosePaths, err := storage.AllPaths(ctx, looseBucket, "")
+	if err != nil {
+		return fmt.Errorf("list loose blobs: %w", err)
+	}
+	packIndexPaths, err := listPackIndexes(casDirPath)
+	if err != nil {
+		return err
+	}
+	switch layout {
+	case LayoutLoose:
+		if len(packIndexPaths) == 0 {
+			return nil // already loose
+		}
+		packBucket, err := newPackReadBucket(packIndexPaths)
+		if err != nil {
+			return err
+		}
+		packPaths, err := storage.AllPaths(ctx, packBucket, "")
+		if err != nil {
+			return fmt.Errorf("list packed blobs: %w", err)
+		}
+		for _, path := range packPaths {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			filePath := filepath.Join(casDirPath, path)
+			// The blob file can exist from a previous migration or write, only keep it if it is intact.
+			if err := verifyBlobFile(filePath, path); err == nil {
+				continue
+			}
+			data, err := storage.ReadPath(ctx, packBucket, path)
+			if err != nil {
+				return fmt.Errorf("read blob %s: %w", path, err)
+			}
+			if err := verifyBlob(path, bytes.NewReader(data)); err != nil {
+				return err
+			}
+			if err := writeFileAtomic(filePath, data); err != nil {
+				return fmt.Errorf("write blob %s: %w", path, err)
+			}
+		}
+		// Packs may have the only intact copy of a blob, re-read all blob files before removing them.
+		for _, path := range packPaths {
+			if err := verifyBlobFile(filepath.Join(casDirPath, path), path); err != nil {
+				return err
+			}
+		}
+		if err := os.RemoveAll(filepath.Join(casDirPath, PackDirName)); err != nil {
+			return fmt.Errorf("remove packs: %w", err)
+		}
+		return nil
+	case LayoutPacked:
+		if len(loosePaths) == 0 && len(packIndexPaths) <= 1 {
+			return nil // already packed
+		}
+		bucket, err := NewReadBucket(casDirPath)
+		if err != nil {
+			return err
+		}
+		paths, err := storage.AllPaths(ctx, bucket, "")
+		if err != nil {
+			return fmt.Errorf("list blobs: %w", err)
+		}
+		packIndexPath, err := writePackBlobs(filepath.Join(casDirPath, PackDirName), paths, func(path string) (io.ReadCloser, error) {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			return bucket.Get(ctx, path)
+		})
+		if err != nil {
+			return fmt.Errorf("write pack: %w", err)
+		}
+		for _, oldPackIndexPath := range packIndexPaths {
+			if oldPackIndexPath == packIndexPath {
+				continue
+			}
+			if err := removePack(oldPackIndexPath); err != nil {
+				return err
+			}
+		}
+		for _, loosePath := range loosePaths {
+			if err := os.Remove(filepath.Join(casDirPath, loosePath)); err != nil {
+				return fmt.Errorf("remove loose blob %s: %w", loosePath, err)
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown CAS layout %s", layout)
+	}
+}
+
+// newLooseReadBucket returns a bucket with the loose blobs in the CAS directory.
+func newLooseReadBucket(casDirPath string) (storage.ReadBucket, error) {
+	bucket, err := storageos.NewProvider().NewReadWriteBucket(casDirPath)
+	if err != nil {
+		return nil, fmt.Errorf("new loose bucket: %w", err)
+	}
+	return storage.FilterReadBucket(
+		bucket,
+		storage.MatchNot(storage.MatchOr(
+			storage.MatchPathContained(PackDirName),
+			// temporary files of interrupted writes
+			storage.MatchPathExt(tmpFileExt),
+		)),
+	), nil
+}
+
+// verifyBlob returns an error if the content read from the reader does not match the blob path, its
+// digest hex.
+func verifyBlob(path string, reader io.Reader) error {
+	digest, err := cas.NewDigestForContent(cas.DigestTypeShake256, reader)
+	if err != nil {
+		return fmt.Errorf("read blob %s: %w", path, err)
+	}
+	if digestHex := hex.EncodeToString(digest.Value()); digestHex != path {
+		return fmt.Errorf("blob %s is corrupted, its content has digest %s", path, digestHex)
+	}
+	return nil
+}
+
+// verifyBlobFile returns an error if the blob file does not exist or does not match the blob path.
+func verifyBlobFile(filePath string, path string) (retErr error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("open blob %s: %w", path, err)
+	}
+	defer func() {
+		retErr = multierr.Append(retErr, file.Close())
+	}()
+	return verifyBlob(path, file)
+}
@@ -0,0 +1,180 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufcas
+
+import (
+	"context"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bufbuild/buf/private/pkg/cas"
+	"github.com/bufbuild/buf/private/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteBlobs(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	blobs := newTestBlobs(t, "foo", "bar", "")
+	moreBlobs := newTestBlobs(t, "foo", "baz")
+	for _, layout := range []Layout{LayoutLoose, LayoutPacked} {
+		t.Run(layout.String(), func(t *testing.T) {
+			t.Parallel()
+			casDirPath := filepath.Join(t.TempDir(), CASDirName)
+			require.NoError(t, WriteBlobs(ctx, casDirPath, layout, blobs))
+			require.NoError(t, WriteBlobs(ctx, casDirPath, layout, moreBlobs))
+			detectedLayout, err := DetectLayout(casDirPath)
+			require.NoError(t, err)
+			assert.Equal(t, layout, detectedLayout)
+			assertBlobs(ctx, t, casDirPath, append(blobs, moreBlobs...))
+			packIndexPaths, err := listPackIndexes(casDirPath)
+			require.NoError(t, err)
+			if layout == LayoutPacked {
+				// "foo" is already packed, so the second pack only has "baz"
+				assert.Len(t, packIndexPaths, 2)
+				require.NoError(t, WriteBlobs(ctx, casDirPath, layout, blobs))
+				packIndexPaths, err = listPackIndexes(casDirPath)
+				require.NoError(t, err)
+				assert.Len(t, packIndexPaths, 2)
+			} else {
+				assert.Empty(t, packIndexPaths)
+			}
+		})
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	blobs := newTestBlobs(t, "foo", "bar", "baz")
+	casDirPath := filepath.Join(t.TempDir(), CASDirName)
+	require.NoError(t, WriteBlobs(ctx, casDirPath, LayoutLoose, blobs[:1]))
+	require.NoError(t, WriteBlobs(ctx, casDirPath, LayoutPacked, blobs[1:2]))
+	require.NoError(t, WriteBlobs(ctx, casDirPath, LayoutPacked, blobs[2:]))
+	assertBlobs(ctx, t, casDirPath, blobs)
+
+	require.NoError(t, Migrate(ctx, casDirPath, LayoutPacked))
+	assertBlobs(ctx, t, casDirPath, blobs)
+	entries, err := os.ReadDir(casDirPath)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, PackDirName, entries[0].Name())
+	packIndexPaths, err := listPackIndexes(casDirPath)
+	require.NoError(t, err)
+	assert.Len(t, packIndexPaths, 1)
+	// migrating again is a no-op
+	require.NoError(t, Migrate(ctx, casDirPath, LayoutPacked))
+	packIndexPathsAfter, err := listPackIndexes(casDirPath)
+	require.NoError(t, err)
+	assert.Equal(t, packIndexPaths, packIndexPathsAfter)
+
+	require.NoError(t, Migrate(ctx, casDirPath, LayoutLoose))
+	assertBlobs(ctx, t, casDirPath, blobs)
+	entries, err = os.ReadDir(casDirPath)
+	require.NoError(t, err)
+	assert.Len(t, entries, len(blobs))
+	detectedLayout, err := DetectLayout(casDirPath)
+	require.NoError(t, err)
+	assert.Equal(t, LayoutLoose, detectedLayout)
+}
+
+func TestMigrateInterrupted(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	blobs := newTestBlobs(t, "foo", "bar", "baz")
+	t.Run("truncated_loose_blob", func(t *testing.T) {
+		t.Parallel()
+		casDirPath := filepath.Join(t.TempDir(), CASDirName)
+		require.NoError(t, WriteBlobs(ctx, casDirPath, LayoutPacked, blobs))
+		// a migration to the loose layout interrupted while writing the first blob
+		truncatedPath := filepath.Join(casDirPath, hex.EncodeToString(blobs[0].Digest().Value()))
+		require.NoError(t, os.WriteFile(truncatedPath, blobs[0].Content()[:1], 0600))
+		require.NoError(t, os.WriteFile(truncatedPath+".123"+tmpFileExt, nil, 0600))
+		require.NoError(t, Migrate(ctx, casDirPath, LayoutLoose))
+		assertBlobs(ctx, t, casDirPath, blobs)
+		_, err := os.Stat(filepath.Join(casDirPath, PackDirName))
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+	t.Run("corrupted_pack", func(t *testing.T) {
+		t.Parallel()
+		casDirPath := filepath.Join(t.TempDir(), CASDirName)
+		require.NoError(t, WriteBlobs(ctx, casDirPath, LayoutPacked, blobs))
+		packIndexPaths, err := listPackIndexes(casDirPath)
+		require.NoError(t, err)
+		require.Len(t, packIndexPaths, 1)
+		packPath := strings.TrimSuffix(packIndexPaths[0], indexFileExt) + packFileExt
+		pack, err := os.ReadFile(packPath)
+		require.NoError(t, err)
+		pack[len(packMagic)] ^= 0xff
+		require.NoError(t, os.WriteFile(packPath, pack, 0600))
+		require.ErrorContains(t, Migrate(ctx, casDirPath, LayoutLoose), "corrupted")
+		packIndexPathsAfter, err := listPackIndexes(casDirPath)
+		require.NoError(t, err)
+		assert.Equal(t, packIndexPaths, packIndexPathsAfter)
+		// consolidating a corrupted pack with loose blobs fails too, and keeps the loose blobs
+		looseBlobs := newTestBlobs(t, "qux")
+		require.NoError(t, WriteBlobs(ctx, casDirPath, LayoutLoose, looseBlobs))
+		require.ErrorContains(t, Migrate(ctx, casDirPath, LayoutPacked), "corrupted")
+		_, err = os.Stat(filepath.Join(casDirPath, hex.EncodeToString(looseBlobs[0].Digest().Value())))
+		require.NoError(t, err)
+		packIndexPathsAfter, err = listPackIndexes(casDirPath)
+		require.NoError(t, err)
+		assert.Equal(t, packIndexPaths, packIndexPathsAfter)
+	})
+}
+
+func TestParseLayout(t *testing.T) {
+	t.Parallel()
+	layout, err := ParseLayout("packed")
+	require.NoError(t, err)
+	assert.Equal(t, LayoutPacked, layout)
+	_, err = ParseLayout("zipped")
+	require.Error(t, err)
+}
+
+// assertBlobs asserts the CAS directory has exactly the given blobs.
+func assertBlobs(ctx context.Context, t *testing.T, casDirPath string, blobs []cas.Blob) {
+	t.Helper()
+	bucket, err := NewReadBucket(casDirPath)
+	require.NoError(t, err)
+	expectedPaths := make(map[string]struct{})
+	for _, blob := range blobs {
+		path := hex.EncodeToString(blob.Digest().Value())
+		expectedPaths[path] = struct{}{}
+		data, err := storage.ReadPath(ctx, bucket, path)
+		require.NoError(t, err)
+		assert.Equal(t, string(blob.Content()), string(data))
+	}
+	paths, err := storage.AllPaths(ctx, bucket, "")
+	require.NoError(t, err)
+	assert.Len(t, paths, len(expectedPaths))
+	_, err = bucket.Stat(ctx, strings.Repeat("00", 64))
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func newTestBlobs(t *testing.T, contents ...string) []cas.Blob {
+	t.Helper()
+	blobs := make([]cas.Blob, len(contents))
+	for i, content := range contents {
+		blob, err := cas.NewBlobForContent(cas.DigestTypeShake256, strings.NewReader(content))
+		require.NoError(t, err)
+		blobs[i] = blob
+	}
+	return blobs
+}
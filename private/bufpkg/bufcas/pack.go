@@ -0,0 +1,326 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufcas
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/bufbuild/buf/private/pkg/cas"
+	"github.com/bufbuild/buf/private/pkg/storage"
+	"github.com/bufbuild/buf/private/pkg/storage/storageutil"
+	"go.uber.org/multierr"
+)
+
+// A pack is a pair of files in the pack directory, named after the SHA-256 hex of the pack content:
+//
+//	pack-<hex>.pack: packMagic, followed by the content of all blobs, concatenated.
+//	pack-<hex>.idx:  indexMagic, a uint32 entry count, and the entries sorted by digest, each a uint8
+//	                 digest length, the digest, and the uint64 offset and size of the blob in the pack.
+//
+// All integers are big endian. The index is written last, so a pack without an index is ignored.
+const (
+	packMagic       = "BUFCASP1"
+	indexMagic      = "BUFCASI1"
+	packFilePrefix  = "pack-"
+	packFileExt     = ".pack"
+	indexFileExt    = ".idx"
+	indexHeaderSize = len(indexMagic) + 4
+	tmpFileExt      = ".tmp"
+)
+
+// packEntry is the location of a blob in a pack file.
+type packEntry struct {
+	packPath string
+	offset   int64
+	size     int64
+}
+
+// packReadBucket is a storage.ReadBucket with the blobs of one or more packs.
+type packReadBucket struct {
+	pathToEntry map[string]packEntry
+	sortedPaths []string
+}
+
+func newPackReadBucket(packIndexPaths []string) (*packReadBucket, error) {
+	bucket := &packReadBucket{
+		pathToEntry: make(map[string]packEntry),
+	}
+	for _, packIndexPath := range packIndexPaths {
+		if err := bucket.readIndex(packIndexPath); err != nil {
+			return nil, fmt.Errorf("read pack index %s: %w", packIndexPath, err)
+		}
+	}
+	for path := range bucket.pathToEntry {
+		bucket.sortedPaths = append(bucket.sortedPaths, path)
+	}
+	slices.Sort(bucket.sortedPaths)
+	return bucket, nil
+}
+
+func (b *packReadBucket) Get(ctx context.Context, path string) (storage.ReadObjectCloser, error) {
+	entry, objectInfo, err := b.stat(path)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(entry.packPath)
+	if err != nil {
+		return nil, fmt.Errorf("open pack: %w", err)
+	}
+	return &packReadObjectCloser{
+		ObjectInfo: objectInfo,
+		Reader:     io.NewSectionReader(file, entry.offset, entry.size),
+		Closer:     file,
+	}, nil
+}
+
+func (b *packReadBucket) Stat(ctx context.Context, path string) (storage.ObjectInfo, error) {
+	_, objectInfo, err := b.stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return objectInfo, nil
+}
+
+func (b *packReadBucket) Walk(ctx context.Context, prefix string, f func(storage.ObjectInfo) error) error {
+	prefix, err := storageutil.ValidatePrefix(prefix)
+	if err != nil {
+		return err
+	}
+	walkChecker := storageutil.NewWalkChecker()
+	for _, path := range b.sortedPaths {
+		// blob paths are never nested, so only the root or the path itself can be a prefix
+		if prefix != "." && prefix != path {
+			continue
+		}
+		if err := walkChecker.Check(ctx); err != nil {
+			return err
+		}
+		if err := f(storageutil.NewObjectInfo(path, b.pathToEntry[path].packPath, "")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *packReadBucket) stat(path string) (packEntry, storage.ObjectInfo, error) {
+	path, err := storageutil.ValidatePath(path)
+	if err != nil {
+		return packEntry{}, nil, err
+	}
+	entry, ok := b.pathToEntry[path]
+	if !ok {
+		return packEntry{}, nil, &fs.PathError{Op: "read", Path: path, Err: fs.ErrNotExist}
+	}
+	return entry, storageutil.NewObjectInfo(path, entry.packPath, ""), nil
+}
+
+func (b *packReadBucket) readIndex(packIndexPath string) error {
+	data, err := os.ReadFile(packIndexPath)
+	if err != nil {
+		return err
+	}
+	if len(data) < indexHeaderSize || string(data[:len(indexMagic)]) != indexMagic {
+		return errors.New("invalid index header")
+	}
+	count := binary.BigEndian.Uint32(data[len(indexMagic):indexHeaderSize])
+	packPath := strings.TrimSuffix(packIndexPath, indexFileExt) + packFileExt
+	reader := bytes.NewReader(data[indexHeaderSize:])
+	for range count {
+		digestLength, err := reader.ReadByte()
+		if err != nil {
+			return fmt.Errorf("read entry: %w", err)
+		}
+		digest := make([]byte, digestLength)
+		var offsetAndSize [2]uint64
+		if _, err := io.ReadFull(reader, digest); err != nil {
+			return fmt.Errorf("read entry digest: %w", err)
+		}
+		if err := binary.Read(reader, binary.BigEndian, &offsetAndSize); err != nil {
+			return fmt.Errorf("read entry offset: %w", err)
+		}
+		// Packs can overlap, e.g. while migrating, and any of them has the same content for a digest.
+		b.pathToEntry[hex.EncodeToString(digest)] = packEntry{
+			packPath: packPath,
+			offset:   int64(offsetAndSize[0]),
+			size:     int64(offsetAndSize[1]),
+		}
+	}
+	if reader.Len() != 0 {
+		return errors.New("trailing data after index entries")
+	}
+	return nil
+}
+
+// packReadObjectCloser is a storage.ReadObjectCloser for a blob in a pack file.
+type packReadObjectCloser struct {
+	storage.ObjectInfo
+	io.Reader
+	io.Closer
+}
+
+// listPackIndexes returns the paths of all pack indexes in the CAS directory, sorted.
+func listPackIndexes(casDirPath string) ([]string, error) {
+	packIndexPaths, err := filepath.Glob(filepath.Join(casDirPath, PackDirName, packFilePrefix+"*"+indexFileExt))
+	if err != nil {
+		return nil, fmt.Errorf("list pack indexes: %w", err)
+	}
+	slices.Sort(packIndexPaths)
+	return packIndexPaths, nil
+}
+
+// writePack writes the blobs in a new pack in the pack directory, and returns its index path.
+func writePack(packDirPath string, blobs []cas.Blob) (string, error) {
+	pathToBlob := make(map[string]cas.Blob, len(blobs))
+	for _, blob := range blobs {
+		pathToBlob[hex.EncodeToString(blob.Digest().Value())] = blob
+	}
+	return writePackBlobs(packDirPath, slices.Collect(maps.Keys(pathToBlob)), func(path string) (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(pathToBlob[path].Content())), nil
+	})
+}
+
+// writePackBlobs writes the blobs at the given paths in a new pack in the pack directory, and
+// returns its index path. Blobs are sorted by digest and deduplicated, so the same blobs always
+// result in the same pack.
+//
+// Blobs are opened one at a time and streamed to the pack file, only the index is kept in memory.
+// The content of every blob is verified against its digest while it is written.
+func writePackBlobs(packDirPath string, paths []string, open func(path string) (io.ReadCloser, error)) (_ string, retErr error) {
+	paths = slices.Clone(paths)
+	slices.Sort(paths)
+	paths = slices.Compact(paths)
+	if err := os.MkdirAll(packDirPath, 0755); err != nil {
+		return "", fmt.Errorf("make pack dir: %w", err)
+	}
+	file, err := os.CreateTemp(packDirPath, packFilePrefix+"*"+tmpFileExt)
+	if err != nil {
+		return "", fmt.Errorf("create pack file: %w", err)
+	}
+	defer func() {
+		if retErr != nil {
+			_ = file.Close()
+			_ = os.Remove(file.Name())
+		}
+	}()
+	packHash := sha256.New()
+	pack := &countingWriter{writer: io.MultiWriter(file, packHash)}
+	if _, err := io.WriteString(pack, packMagic); err != nil {
+		return "", fmt.Errorf("write pack file: %w", err)
+	}
+	var index bytes.Buffer
+	index.WriteString(indexMagic)
+	_ = binary.Write(&index, binary.BigEndian, uint32(len(paths)))
+	for _, path := range paths {
+		digest, err := hex.DecodeString(path)
+		if err != nil || len(digest) > 255 {
+			return "", fmt.Errorf("invalid blob path %q, must be a digest hex", path)
+		}
+		offset := pack.size
+		if err := copyBlob(pack, path, open); err != nil {
+			return "", err
+		}
+		index.WriteByte(byte(len(digest)))
+		index.Write(digest)
+		_ = binary.Write(&index, binary.BigEndian, [2]uint64{uint64(offset), uint64(pack.size - offset)})
+	}
+	if err := file.Close(); err != nil {
+		return "", fmt.Errorf("close pack file: %w", err)
+	}
+	if err := os.Chmod(file.Name(), 0644); err != nil {
+		return "", fmt.Errorf("chmod pack file: %w", err)
+	}
+	packPathPrefix := filepath.Join(packDirPath, packFilePrefix+hex.EncodeToString(packHash.Sum(nil)))
+	if err := os.Rename(file.Name(), packPathPrefix+packFileExt); err != nil {
+		return "", fmt.Errorf("write pack file: %w", err)
+	}
+	if err := writeFileAtomic(packPathPrefix+indexFileExt, index.Bytes()); err != nil {
+		return "", fmt.Errorf("write pack index file: %w", err)
+	}
+	return packPathPrefix + indexFileExt, nil
+}
+
+// copyBlob copies the blob at path to the writer, and returns an error if its content does not match
+// its digest.
+func copyBlob(writer io.Writer, path string, open func(path string) (io.ReadCloser, error)) (retErr error) {
+	readCloser, err := open(path)
+	if err != nil {
+		return fmt.Errorf("read blob %s: %w", path, err)
+	}
+	defer func() {
+		retErr = multierr.Append(retErr, readCloser.Close())
+	}()
+	return verifyBlob(path, io.TeeReader(readCloser, writer))
+}
+
+// countingWriter is an io.Writer that counts the bytes written to the underlying writer.
+type countingWriter struct {
+	writer io.Writer
+	size   int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// removePack removes the pack of the given index, index first.
+func removePack(packIndexPath string) error {
+	if err := os.Remove(packIndexPath); err != nil {
+		return fmt.Errorf("remove pack index: %w", err)
+	}
+	if err := os.Remove(strings.TrimSuffix(packIndexPath, indexFileExt) + packFileExt); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("remove pack: %w", err)
+	}
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file next to filePath, and renames it to filePath. The
+// temporary file has the tmpFileExt extension.
+func writeFileAtomic(filePath string, data []byte) (retErr error) {
+	file, err := os.CreateTemp(filepath.Dir(filePath), filepath.Base(filePath)+".*"+tmpFileExt)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if retErr != nil {
+			_ = os.Remove(file.Name())
+		}
+	}()
+	if _, err := file.Write(data); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(file.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), filePath)
+}
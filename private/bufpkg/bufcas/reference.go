@@ -0,0 +1,130 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufcas
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bufbuild/buf/private/pkg/cas"
+	"github.com/bufbuild/buf/private/pkg/storage"
+	"github.com/bufbuild/modules/private/bufpkg/bufstate"
+)
+
+// ReadModuleReference reads all files of a reference in the module directory at moduleDirPath,
+// verifying the digest of the manifest and of every file against their content.
+//
+// The ref is either a reference name in the module state file, or a manifest digest hex.
+func ReadModuleReference(ctx context.Context, moduleDirPath string, ref string) (cas.FileSet, error) {
+	manifestDigestHex, err := resolveReference(moduleDirPath, ref)
+	if err != nil {
+		return nil, err
+	}
+	bucket, err := NewReadBucket(filepath.Join(moduleDirPath, CASDirName))
+	if err != nil {
+		return nil, err
+	}
+	return ReadFileSet(ctx, bucket, manifestDigestHex)
+}
+
+// ReadFileSet reads the manifest with the given digest hex and all its file blobs from a CAS
+// bucket, verifying the digest of every blob against its content.
+func ReadFileSet(ctx context.Context, bucket storage.ReadBucket, manifestDigestHex string) (cas.FileSet, error) {
+	manifestDigest, err := parseDigestHex(manifestDigestHex)
+	if err != nil {
+		return nil, err
+	}
+	manifestBlob, err := readBlob(ctx, bucket, manifestDigest)
+	if err != nil {
+		return nil, fmt.Errorf("read manifest: %w", err)
+	}
+	manifest, err := cas.BlobToManifest(manifestBlob)
+	if err != nil {
+		return nil, fmt.Errorf("parse manifest %s: %w", manifestDigestHex, err)
+	}
+	var blobs []cas.Blob
+	seenDigests := make(map[string]struct{})
+	for _, fileNode := range manifest.FileNodes() {
+		if _, ok := seenDigests[fileNode.Digest().String()]; ok {
+			continue
+		}
+		seenDigests[fileNode.Digest().String()] = struct{}{}
+		blob, err := readBlob(ctx, bucket, fileNode.Digest())
+		if err != nil {
+			return nil, fmt.Errorf("read file %s: %w", fileNode.Path(), err)
+		}
+		blobs = append(blobs, blob)
+	}
+	blobSet, err := cas.NewBlobSet(blobs)
+	if err != nil {
+		return nil, fmt.Errorf("new blob set: %w", err)
+	}
+	return cas.NewFileSet(manifest, blobSet)
+}
+
+// resolveReference resolves a reference name or manifest digest hex to a manifest digest hex.
+func resolveReference(moduleDirPath string, ref string) (string, error) {
+	moduleStateFile, err := os.Open(filepath.Join(moduleDirPath, bufstate.ModStateFileName))
+	if err != nil {
+		return "", fmt.Errorf("open module state file: %w", err)
+	}
+	stateRW, err := bufstate.NewReadWriter()
+	if err != nil {
+		_ = moduleStateFile.Close()
+		return "", fmt.Errorf("new state read writer: %w", err)
+	}
+	moduleState, err := stateRW.ReadModStateFile(moduleStateFile)
+	if err != nil {
+		return "", fmt.Errorf("read module state file: %w", err)
+	}
+	if moduleRef, ok := bufstate.NewModuleHistory(moduleState).Reference(ref); ok {
+		return moduleRef.GetDigest(), nil
+	}
+	if _, err := parseDigestHex(ref); err == nil {
+		return ref, nil
+	}
+	return "", fmt.Errorf("reference %s not found in the module state file", ref)
+}
+
+// readBlob reads the blob with the given digest, and verifies its content matches the digest.
+func readBlob(ctx context.Context, bucket storage.ReadBucket, digest cas.Digest) (_ cas.Blob, retErr error) {
+	digestHex := hex.EncodeToString(digest.Value())
+	readObjectCloser, err := bucket.Get(ctx, digestHex)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := readObjectCloser.Close(); err != nil && retErr == nil {
+			retErr = fmt.Errorf("close blob %s: %w", digestHex, err)
+		}
+	}()
+	blob, err := cas.NewBlobForContent(digest.Type(), readObjectCloser, cas.BlobWithKnownDigest(digest))
+	if err != nil {
+		return nil, fmt.Errorf("blob %s: %w", digestHex, err)
+	}
+	return blob, nil
+}
+
+// parseDigestHex parses a blob path, which is the hex of a shake256 digest.
+func parseDigestHex(digestHex string) (cas.Digest, error) {
+	value, err := hex.DecodeString(digestHex)
+	if err != nil {
+		return nil, fmt.Errorf("invalid digest hex %q: %w", digestHex, err)
+	}
+	return cas.NewDigest(cas.DigestTypeShake256, value)
+}
@@ -0,0 +1,75 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufcas
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bufbuild/buf/private/pkg/cas"
+	"github.com/bufbuild/buf/private/pkg/storage/storagemem"
+	"github.com/bufbuild/modules/private/bufpkg/bufstate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadModuleReference(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	srcBucket, err := storagemem.NewReadBucket(map[string][]byte{
+		"buf.yaml":       []byte("version: v1\n"),
+		"foo/v1/a.proto": []byte("syntax = \"proto3\";\n"),
+		"foo/v1/b.proto": []byte("syntax = \"proto3\";\n"),
+	})
+	require.NoError(t, err)
+	fileSet, err := cas.NewFileSetForBucket(ctx, srcBucket, cas.DigestTypeShake256)
+	require.NoError(t, err)
+	manifestBlob, err := cas.ManifestToBlob(fileSet.Manifest(), cas.DigestTypeShake256)
+	require.NoError(t, err)
+	manifestDigestHex := hex.EncodeToString(manifestBlob.Digest().Value())
+	for _, layout := range []Layout{LayoutLoose, LayoutPacked} {
+		t.Run(layout.String(), func(t *testing.T) {
+			t.Parallel()
+			moduleDirPath := t.TempDir()
+			casDirPath := filepath.Join(moduleDirPath, CASDirName)
+			require.NoError(t, WriteBlobs(ctx, casDirPath, layout, append([]cas.Blob{manifestBlob}, fileSet.BlobSet().Blobs()...)))
+			moduleState := fmt.Sprintf(`{"references": [{"name": "v1.0.0", "digest": %q}]}`, manifestDigestHex)
+			require.NoError(t, os.WriteFile(filepath.Join(moduleDirPath, bufstate.ModStateFileName), []byte(moduleState), 0600))
+			for _, ref := range []string{"v1.0.0", manifestDigestHex} {
+				readFileSet, err := ReadModuleReference(ctx, moduleDirPath, ref)
+				require.NoError(t, err)
+				assert.Equal(t, fileSet.Manifest().String(), readFileSet.Manifest().String())
+				assert.Len(t, readFileSet.BlobSet().Blobs(), len(fileSet.BlobSet().Blobs()))
+			}
+			_, err := ReadModuleReference(ctx, moduleDirPath, "v2.0.0")
+			require.Error(t, err)
+		})
+	}
+	t.Run("corrupted", func(t *testing.T) {
+		t.Parallel()
+		moduleDirPath := t.TempDir()
+		casDirPath := filepath.Join(moduleDirPath, CASDirName)
+		require.NoError(t, WriteBlobs(ctx, casDirPath, LayoutLoose, append([]cas.Blob{manifestBlob}, fileSet.BlobSet().Blobs()...)))
+		moduleState := fmt.Sprintf(`{"references": [{"name": "v1.0.0", "digest": %q}]}`, manifestDigestHex)
+		require.NoError(t, os.WriteFile(filepath.Join(moduleDirPath, bufstate.ModStateFileName), []byte(moduleState), 0600))
+		blobPath := filepath.Join(casDirPath, hex.EncodeToString(fileSet.Manifest().GetDigest("buf.yaml").Value()))
+		require.NoError(t, os.WriteFile(blobPath, []byte("version: v2\n"), 0600))
+		_, err := ReadModuleReference(ctx, moduleDirPath, "v1.0.0")
+		require.ErrorContains(t, err, "buf.yaml")
+	})
+}
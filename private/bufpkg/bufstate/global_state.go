@@ -19,15 +19,24 @@ import (
 	"io"
 	"sort"
 
-	"github.com/bufbuild/buf/private/pkg/protoencoding"
 	statev1alpha1 "github.com/bufbuild/modules/private/gen/modules/state/v1alpha1"
+	statev1beta1 "github.com/bufbuild/modules/private/gen/modules/state/v1beta1"
 	"go.uber.org/multierr"
+	"google.golang.org/protobuf/proto"
 )
 
 const GlobalStateFileName = "state.json"
 
-// ReadGlobalState reads a JSON encoded GlobalState from the given reader before closing it.
-func (rw *ReadWriter) ReadGlobalState(reader io.ReadCloser) (_ *statev1alpha1.GlobalState, retErr error) {
+// ReadGlobalState reads a JSON encoded GlobalState of any version from the given reader before
+// closing it.
+func (rw *ReadWriter) ReadGlobalState(reader io.ReadCloser) (*statev1beta1.GlobalState, error) {
+	globalState, _, err := rw.ReadGlobalStateWithVersion(reader)
+	return globalState, err
+}
+
+// ReadGlobalStateWithVersion reads a JSON encoded GlobalState of any version from the given reader
+// before closing it, and returns it with the version it was read in.
+func (rw *ReadWriter) ReadGlobalStateWithVersion(reader io.ReadCloser) (_ *statev1beta1.GlobalState, _ Version, retErr error) {
 	defer func() {
 		if err := reader.Close(); err != nil {
 			retErr = multierr.Append(retErr, fmt.Errorf("close file: %w", err))
@@ -35,43 +44,81 @@ func (rw *ReadWriter) ReadGlobalState(reader io.ReadCloser) (_ *statev1alpha1.Gl
 	}()
 	bytes, err := io.ReadAll(reader)
 	if err != nil {
-		return nil, fmt.Errorf("read global state file: %w", err)
+		return nil, 0, fmt.Errorf("read global state file: %w", err)
 	}
-	var globalState statev1alpha1.GlobalState
-	if err := protoencoding.NewJSONUnmarshaler(protoencoding.EmptyResolver).Unmarshal(bytes, &globalState); err != nil {
-		return nil, fmt.Errorf("unmarshal global state: %w", err)
+	version, err := DetectVersion(bytes)
+	if err != nil {
+		return nil, 0, fmt.Errorf("detect global state version: %w", err)
 	}
-	if err := rw.validator.Validate(&globalState); err != nil {
-		return nil, fmt.Errorf("validate global state: %w", err)
+	var globalState *statev1beta1.GlobalState
+	switch version {
+	case VersionV1Alpha1:
+		var globalStateV1Alpha1 statev1alpha1.GlobalState
+		if err := rw.unmarshalAndValidate(bytes, &globalStateV1Alpha1); err != nil {
+			return nil, 0, fmt.Errorf("global state %s: %w", version, err)
+		}
+		globalState = globalStateV1Alpha1ToV1Beta1(&globalStateV1Alpha1)
+	case VersionV1Beta1:
+		globalState = &statev1beta1.GlobalState{}
+		if err := rw.unmarshalAndValidate(bytes, globalState); err != nil {
+			return nil, 0, fmt.Errorf("global state %s: %w", version, err)
+		}
+	default:
+		return nil, 0, fmt.Errorf("unsupported global state version %s", version)
 	}
-	return &globalState, nil
+	return globalState, version, nil
 }
 
-// WriteGlobalState takes a global state and writes it to the given writer before closing it.
-func (rw *ReadWriter) WriteGlobalState(writer io.WriteCloser, globalState *statev1alpha1.GlobalState) (retErr error) {
+// WriteGlobalState takes a global state and writes it in the latest version to the given writer
+// before closing it.
+func (rw *ReadWriter) WriteGlobalState(writer io.WriteCloser, globalState *statev1beta1.GlobalState) error {
+	return rw.WriteGlobalStateWithVersion(writer, globalState, LatestVersion)
+}
+
+// WriteGlobalStateWithVersion takes a global state and writes it in the given version to the given
+// writer before closing it. Modules are sorted by name.
+func (rw *ReadWriter) WriteGlobalStateWithVersion(
+	writer io.WriteCloser,
+	globalState *statev1beta1.GlobalState,
+	version Version,
+) (retErr error) {
 	defer func() {
 		if err := writer.Close(); err != nil {
 			retErr = multierr.Append(retErr, fmt.Errorf("close file: %w", err))
 		}
 	}()
-	if err := rw.validator.Validate(globalState); err != nil {
-		return fmt.Errorf("validate global state: %w", err)
+	data, err := rw.marshalGlobalState(globalState, version)
+	if err != nil {
+		return err
 	}
+	if _, err := writer.Write(data); err != nil {
+		return fmt.Errorf("write to file: %w", err)
+	}
+	return nil
+}
+
+// marshalGlobalState sorts the modules of a global state by name, validates it and marshals it in
+// the given version.
+func (rw *ReadWriter) marshalGlobalState(globalState *statev1beta1.GlobalState, version Version) ([]byte, error) {
 	mods := globalState.GetModules()
 	sort.Slice(mods, func(i, j int) bool {
 		return mods[i].GetModuleName() < mods[j].GetModuleName()
 	})
 	globalState.SetModules(mods)
-	data, err := protoencoding.NewJSONMarshaler(
-		protoencoding.EmptyResolver,
-		protoencoding.JSONMarshalerWithUseProtoNames(),
-		protoencoding.JSONMarshalerWithIndent(),
-	).Marshal(globalState)
-	if err != nil {
-		return fmt.Errorf("marshal global state: %w", err)
+	globalState = proto.CloneOf(globalState)
+	globalState.SetVersion(VersionV1Beta1.String())
+	var message proto.Message
+	switch version {
+	case VersionV1Alpha1:
+		message = globalStateV1Beta1ToV1Alpha1(globalState)
+	case VersionV1Beta1:
+		message = globalState
+	default:
+		return nil, fmt.Errorf("unsupported global state version %s", version)
 	}
-	if _, err := writer.Write(data); err != nil {
-		return fmt.Errorf("write to file: %w", err)
+	data, err := rw.validateAndMarshal(message)
+	if err != nil {
+		return nil, fmt.Errorf("global state %s: %w", version, err)
 	}
-	return nil
+	return data, nil
 }
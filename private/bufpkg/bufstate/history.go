@@ -0,0 +1,161 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufstate
+
+import (
+	"fmt"
+
+	statev1beta1 "github.com/bufbuild/modules/private/gen/modules/state/v1beta1"
+)
+
+// ModuleHistory is a read-only view of the references of a module state, in the order they were
+// synced, indexed by name and digest.
+//
+// It holds the references of the module state, which must not be modified while the history is in
+// use.
+type ModuleHistory struct {
+	references []*statev1beta1.ModuleReference
+	// nameToIndex is the index of every reference by name.
+	nameToIndex map[string]int
+	// previousDigestToIndex is the index of the reference every previous digest was superseded in.
+	previousDigestToIndex map[string]int
+}
+
+// DigestSpan is a run of consecutive references of a module history with the same digest.
+type DigestSpan struct {
+	// Digest is the digest of all the references of the span.
+	Digest string
+	// Start is the index of the first reference of the span.
+	Start int
+	// End is the index of the last reference of the span, inclusive.
+	End int
+}
+
+// NewModuleHistory returns the history of the references of the module state. A nil module state
+// has an empty history.
+func NewModuleHistory(moduleState *statev1beta1.ModuleState) *ModuleHistory {
+	references := moduleState.GetReferences()
+	history := &ModuleHistory{
+		references:            references,
+		nameToIndex:           make(map[string]int, len(references)),
+		previousDigestToIndex: make(map[string]int),
+	}
+	for i, reference := range references {
+		history.nameToIndex[reference.GetName()] = i
+		for _, supersession := range reference.GetSupersessions() {
+			history.previousDigestToIndex[supersession.GetPreviousDigest()] = i
+		}
+	}
+	return history
+}
+
+// Len returns the number of references.
+func (h *ModuleHistory) Len() int {
+	return len(h.references)
+}
+
+// References returns all the references, in order.
+func (h *ModuleHistory) References() []*statev1beta1.ModuleReference {
+	return h.references
+}
+
+// Names returns the names of all the references, in order.
+func (h *ModuleHistory) Names() []string {
+	names := make([]string, len(h.references))
+	for i, reference := range h.references {
+		names[i] = reference.GetName()
+	}
+	return names
+}
+
+// At returns the reference at the index, which must be in [0, Len()).
+func (h *ModuleHistory) At(index int) *statev1beta1.ModuleReference {
+	return h.references[index]
+}
+
+// Index returns the index of the reference with the name, and false if there is none.
+func (h *ModuleHistory) Index(name string) (int, bool) {
+	index, ok := h.nameToIndex[name]
+	return index, ok
+}
+
+// Reference returns the reference with the name, and false if there is none.
+func (h *ModuleHistory) Reference(name string) (*statev1beta1.ModuleReference, bool) {
+	index, ok := h.nameToIndex[name]
+	if !ok {
+		return nil, false
+	}
+	return h.references[index], true
+}
+
+// Latest returns the last reference, and false if there are no references.
+func (h *ModuleHistory) Latest() (*statev1beta1.ModuleReference, bool) {
+	if len(h.references) == 0 {
+		return nil, false
+	}
+	return h.references[len(h.references)-1], true
+}
+
+// SupersededReference returns the reference that the digest was a previous digest of, before it was
+// superseded, and false if no reference was superseded from that digest.
+func (h *ModuleHistory) SupersededReference(previousDigest string) (*statev1beta1.ModuleReference, bool) {
+	index, ok := h.previousDigestToIndex[previousDigest]
+	if !ok {
+		return nil, false
+	}
+	return h.references[index], true
+}
+
+// After returns the references after the reference with the name, empty if it is the latest one,
+// and false if there is no reference with the name.
+func (h *ModuleHistory) After(name string) ([]*statev1beta1.ModuleReference, bool) {
+	index, ok := h.nameToIndex[name]
+	if !ok {
+		return nil, false
+	}
+	return h.references[index+1:], true
+}
+
+// Range returns the references after the reference named from, up to and including the reference
+// named to. It is empty if both are the same reference, and fails if either does not exist or to
+// comes before from.
+func (h *ModuleHistory) Range(from string, to string) ([]*statev1beta1.ModuleReference, error) {
+	fromIndex, ok := h.nameToIndex[from]
+	if !ok {
+		return nil, fmt.Errorf("reference %s not found", from)
+	}
+	toIndex, ok := h.nameToIndex[to]
+	if !ok {
+		return nil, fmt.Errorf("reference %s not found", to)
+	}
+	if toIndex < fromIndex {
+		return nil, fmt.Errorf("reference %s comes before %s", to, from)
+	}
+	return h.references[fromIndex+1 : toIndex+1], nil
+}
+
+// DigestSpans returns the runs of consecutive references with the same digest, in order. Every
+// span after the first one starts at a reference whose digest changed from the previous reference.
+func (h *ModuleHistory) DigestSpans() []DigestSpan {
+	var spans []DigestSpan
+	for i, reference := range h.references {
+		if len(spans) > 0 && spans[len(spans)-1].Digest == reference.GetDigest() {
+			spans[len(spans)-1].End = i
+			continue
+		}
+		spans = append(spans, DigestSpan{Digest: reference.GetDigest(), Start: i, End: i})
+	}
+	return spans
+}
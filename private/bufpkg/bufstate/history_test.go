@@ -0,0 +1,104 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufstate
+
+import (
+	"testing"
+
+	statev1beta1 "github.com/bufbuild/modules/private/gen/modules/state/v1beta1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestModuleHistory(t *testing.T) {
+	t.Parallel()
+	history := NewModuleHistory(statev1beta1.ModuleState_builder{
+		References: []*statev1beta1.ModuleReference{
+			statev1beta1.ModuleReference_builder{Name: "v1", Digest: "a"}.Build(),
+			statev1beta1.ModuleReference_builder{Name: "v2", Digest: "a"}.Build(),
+			statev1beta1.ModuleReference_builder{
+				Name:   "v3",
+				Digest: "b",
+				Supersessions: []*statev1beta1.Supersession{
+					statev1beta1.Supersession_builder{PreviousDigest: "old", SupersedeTime: timestamppb.Now(), Reason: "retag"}.Build(),
+				},
+			}.Build(),
+			statev1beta1.ModuleReference_builder{Name: "v4", Digest: "a"}.Build(),
+		},
+	}.Build())
+	assert.Equal(t, 4, history.Len())
+	assert.Equal(t, []string{"v1", "v2", "v3", "v4"}, history.Names())
+	assert.Equal(t, "v2", history.At(1).GetName())
+
+	index, ok := history.Index("v3")
+	require.True(t, ok)
+	assert.Equal(t, 2, index)
+	_, ok = history.Index("v5")
+	assert.False(t, ok)
+	reference, ok := history.Reference("v2")
+	require.True(t, ok)
+	assert.Equal(t, "a", reference.GetDigest())
+	_, ok = history.Reference("v5")
+	assert.False(t, ok)
+	latest, ok := history.Latest()
+	require.True(t, ok)
+	assert.Equal(t, "v4", latest.GetName())
+	reference, ok = history.SupersededReference("old")
+	require.True(t, ok)
+	assert.Equal(t, "v3", reference.GetName())
+	_, ok = history.SupersededReference("b")
+	assert.False(t, ok)
+
+	after, ok := history.After("v2")
+	require.True(t, ok)
+	assert.Equal(t, []string{"v3", "v4"}, referenceNames(after))
+	after, ok = history.After("v4")
+	require.True(t, ok)
+	assert.Empty(t, after)
+	_, ok = history.After("v5")
+	assert.False(t, ok)
+
+	references, err := history.Range("v1", "v3")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"v2", "v3"}, referenceNames(references))
+	references, err = history.Range("v3", "v3")
+	require.NoError(t, err)
+	assert.Empty(t, references)
+	_, err = history.Range("v3", "v1")
+	require.ErrorContains(t, err, "reference v1 comes before v3")
+	_, err = history.Range("v1", "v5")
+	require.ErrorContains(t, err, "reference v5 not found")
+
+	assert.Equal(t, []DigestSpan{
+		{Digest: "a", Start: 0, End: 1},
+		{Digest: "b", Start: 2, End: 2},
+		{Digest: "a", Start: 3, End: 3},
+	}, history.DigestSpans())
+
+	empty := NewModuleHistory(nil)
+	assert.Zero(t, empty.Len())
+	_, ok = empty.Latest()
+	assert.False(t, ok)
+	assert.Empty(t, empty.DigestSpans())
+}
+
+func referenceNames(references []*statev1beta1.ModuleReference) []string {
+	names := make([]string, len(references))
+	for i, reference := range references {
+		names[i] = reference.GetName()
+	}
+	return names
+}
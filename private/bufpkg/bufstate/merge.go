@@ -0,0 +1,181 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufstate
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	statev1beta1 "github.com/bufbuild/modules/private/gen/modules/state/v1beta1"
+	"google.golang.org/protobuf/proto"
+)
+
+// MergeModuleStates three-way merges the references of two module states that diverged from base,
+// e.g. after two syncs of the same module appended references concurrently.
+//
+// The merged references are ours, followed by the references only in theirs, in their order.
+// References removed from base by either side are removed. If a reference has different digests in
+// ours and theirs, the one that changed from base is kept, and if both changed it, or it was
+// appended by both with different digests, the merge fails. For references in both with the same
+// digest, ours are kept as is. The deprecation of the side that changed it from base is kept, and if
+// both changed it differently, the merge fails.
+func MergeModuleStates(base, ours, theirs *statev1beta1.ModuleState) (*statev1beta1.ModuleState, error) {
+	baseRefs := moduleReferencesByName(base)
+	oursRefs := moduleReferencesByName(ours)
+	theirsRefs := moduleReferencesByName(theirs)
+	removed := func(name string) bool {
+		_, inBase := baseRefs[name]
+		_, inOurs := oursRefs[name]
+		_, inTheirs := theirsRefs[name]
+		return inBase && (!inOurs || !inTheirs)
+	}
+	var references []*statev1beta1.ModuleReference
+	for _, oursRef := range ours.GetReferences() {
+		if removed(oursRef.GetName()) {
+			continue
+		}
+		theirsRef, ok := theirsRefs[oursRef.GetName()]
+		if !ok || theirsRef.GetDigest() == oursRef.GetDigest() {
+			references = append(references, oursRef)
+			continue
+		}
+		baseRef, ok := baseRefs[oursRef.GetName()]
+		switch {
+		case ok && baseRef.GetDigest() == oursRef.GetDigest():
+			references = append(references, theirsRef)
+		case ok && baseRef.GetDigest() == theirsRef.GetDigest():
+			references = append(references, oursRef)
+		default:
+			return nil, fmt.Errorf(
+				"reference %s has digest %s in ours and %s in theirs",
+				oursRef.GetName(),
+				oursRef.GetDigest(),
+				theirsRef.GetDigest(),
+			)
+		}
+	}
+	for _, theirsRef := range theirs.GetReferences() {
+		if _, ok := oursRefs[theirsRef.GetName()]; ok || removed(theirsRef.GetName()) {
+			continue
+		}
+		references = append(references, theirsRef)
+	}
+	deprecation, err := mergeDeprecations(base.GetDeprecation(), ours.GetDeprecation(), theirs.GetDeprecation())
+	if err != nil {
+		return nil, err
+	}
+	return statev1beta1.ModuleState_builder{
+		References:  references,
+		Deprecation: deprecation,
+	}.Build(), nil
+}
+
+// MergeGlobalStates three-way merges the modules of two global states that diverged from base.
+//
+// A module takes the latest reference of the side that changed it from base, and a module removed
+// from base by a side that did not change it is removed. If both sides changed the latest reference
+// of a module to different references, resolveLatestReference is called with the module name and
+// both latest references, to recompute it from the merged module state. The merged modules are
+// sorted by name, and their deprecations are merged like in MergeModuleStates.
+func MergeGlobalStates(
+	base *statev1beta1.GlobalState,
+	ours *statev1beta1.GlobalState,
+	theirs *statev1beta1.GlobalState,
+	resolveLatestReference func(moduleName string, oursLatestReference string, theirsLatestReference string) (string, error),
+) (*statev1beta1.GlobalState, error) {
+	baseModules := globalStateReferencesByModuleName(base)
+	oursModules := globalStateReferencesByModuleName(ours)
+	theirsModules := globalStateReferencesByModuleName(theirs)
+	moduleNames := make(map[string]struct{}, len(oursModules)+len(theirsModules))
+	for moduleName := range oursModules {
+		moduleNames[moduleName] = struct{}{}
+	}
+	for moduleName := range theirsModules {
+		moduleNames[moduleName] = struct{}{}
+	}
+	var modules []*statev1beta1.GlobalStateReference
+	for moduleName := range moduleNames {
+		baseLatestRef := baseModules[moduleName].GetLatestReference()
+		oursLatestRef := oursModules[moduleName].GetLatestReference()
+		theirsLatestRef := theirsModules[moduleName].GetLatestReference()
+		var latestRef string
+		switch {
+		case oursLatestRef == theirsLatestRef, theirsLatestRef == baseLatestRef:
+			latestRef = oursLatestRef
+		case oursLatestRef == baseLatestRef:
+			latestRef = theirsLatestRef
+		case oursLatestRef == "" || theirsLatestRef == "":
+			// removed by one side, and changed by the other
+			latestRef = oursLatestRef + theirsLatestRef
+		default:
+			var err error
+			if latestRef, err = resolveLatestReference(moduleName, oursLatestRef, theirsLatestRef); err != nil {
+				return nil, fmt.Errorf("resolve latest reference of %s: %w", moduleName, err)
+			}
+		}
+		if latestRef == "" {
+			continue
+		}
+		deprecation, err := mergeDeprecations(
+			baseModules[moduleName].GetDeprecation(),
+			oursModules[moduleName].GetDeprecation(),
+			theirsModules[moduleName].GetDeprecation(),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("module %s: %w", moduleName, err)
+		}
+		modules = append(modules, statev1beta1.GlobalStateReference_builder{
+			ModuleName:      moduleName,
+			LatestReference: latestRef,
+			Deprecation:     deprecation,
+		}.Build())
+	}
+	slices.SortFunc(modules, func(a *statev1beta1.GlobalStateReference, b *statev1beta1.GlobalStateReference) int {
+		return strings.Compare(a.GetModuleName(), b.GetModuleName())
+	})
+	return statev1beta1.GlobalState_builder{
+		Modules: modules,
+	}.Build(), nil
+}
+
+func moduleReferencesByName(moduleState *statev1beta1.ModuleState) map[string]*statev1beta1.ModuleReference {
+	nameToReference := make(map[string]*statev1beta1.ModuleReference, len(moduleState.GetReferences()))
+	for _, reference := range moduleState.GetReferences() {
+		nameToReference[reference.GetName()] = reference
+	}
+	return nameToReference
+}
+
+func globalStateReferencesByModuleName(globalState *statev1beta1.GlobalState) map[string]*statev1beta1.GlobalStateReference {
+	moduleNameToReference := make(map[string]*statev1beta1.GlobalStateReference, len(globalState.GetModules()))
+	for _, module := range globalState.GetModules() {
+		moduleNameToReference[module.GetModuleName()] = module
+	}
+	return moduleNameToReference
+}
+
+// mergeDeprecations three-way merges a deprecation, nil if not deprecated. The side that changed it
+// from base wins.
+func mergeDeprecations(base, ours, theirs *statev1beta1.Deprecation) (*statev1beta1.Deprecation, error) {
+	switch {
+	case proto.Equal(ours, theirs), proto.Equal(theirs, base):
+		return ours, nil
+	case proto.Equal(ours, base):
+		return theirs, nil
+	default:
+		return nil, fmt.Errorf("deprecation changed to %q in ours and %q in theirs", ours.GetReason(), theirs.GetReason())
+	}
+}
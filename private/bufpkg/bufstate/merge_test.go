@@ -0,0 +1,216 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufstate
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	statev1beta1 "github.com/bufbuild/modules/private/gen/modules/state/v1beta1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestMergeModuleStates(t *testing.T) {
+	t.Parallel()
+	// newModuleState builds a module state from name-digest pairs.
+	newModuleState := func(nameDigests ...string) *statev1beta1.ModuleState {
+		references := make([]*statev1beta1.ModuleReference, 0, len(nameDigests)/2)
+		for i := 0; i < len(nameDigests); i += 2 {
+			references = append(references, statev1beta1.ModuleReference_builder{
+				Name:   nameDigests[i],
+				Digest: nameDigests[i+1],
+			}.Build())
+		}
+		return statev1beta1.ModuleState_builder{References: references}.Build()
+	}
+	testCases := []struct {
+		name        string
+		base        *statev1beta1.ModuleState
+		ours        *statev1beta1.ModuleState
+		theirs      *statev1beta1.ModuleState
+		want        *statev1beta1.ModuleState
+		wantErrPart string
+	}{
+		{
+			name:   "appendedOnBothSides",
+			base:   newModuleState("v1", "a"),
+			ours:   newModuleState("v1", "a", "v2", "b", "v3", "c"),
+			theirs: newModuleState("v1", "a", "v2", "b", "v4", "d"),
+			want:   newModuleState("v1", "a", "v2", "b", "v3", "c", "v4", "d"),
+		},
+		{
+			name:   "addedOnBothSides",
+			base:   newModuleState(),
+			ours:   newModuleState("v1", "a"),
+			theirs: newModuleState("v2", "b"),
+			want:   newModuleState("v1", "a", "v2", "b"),
+		},
+		{
+			name:   "removedOnOneSide",
+			base:   newModuleState("v1", "a", "v2", "b"),
+			ours:   newModuleState("v2", "b", "v3", "c"),
+			theirs: newModuleState("v1", "a", "v2", "b", "v4", "d"),
+			want:   newModuleState("v2", "b", "v3", "c", "v4", "d"),
+		},
+		{
+			name:   "changedOnOneSide",
+			base:   newModuleState("v1", "a", "v2", "b"),
+			ours:   newModuleState("v1", "a", "v2", "b", "v3", "c"),
+			theirs: newModuleState("v1", "a", "v2", "changed"),
+			want:   newModuleState("v1", "a", "v2", "changed", "v3", "c"),
+		},
+		{
+			name:        "appendedOnBothSidesWithDifferentDigests",
+			base:        newModuleState("v1", "a"),
+			ours:        newModuleState("v1", "a", "v2", "b"),
+			theirs:      newModuleState("v1", "a", "v2", "c"),
+			wantErrPart: "reference v2 has digest b in ours and c in theirs",
+		},
+		{
+			name:        "changedOnBothSides",
+			base:        newModuleState("v1", "a"),
+			ours:        newModuleState("v1", "b"),
+			theirs:      newModuleState("v1", "c"),
+			wantErrPart: "reference v1 has digest b in ours and c in theirs",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+			merged, err := MergeModuleStates(testCase.base, testCase.ours, testCase.theirs)
+			if testCase.wantErrPart != "" {
+				require.ErrorContains(t, err, testCase.wantErrPart)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, moduleReferenceNames(testCase.want), moduleReferenceNames(merged))
+			assert.Equal(t, moduleReferenceDigests(testCase.want), moduleReferenceDigests(merged))
+		})
+	}
+}
+
+func TestMergeGlobalStates(t *testing.T) {
+	t.Parallel()
+	// newGlobalState builds a global state from module name-latest reference pairs.
+	newGlobalState := func(moduleLatestRefs ...string) *statev1beta1.GlobalState {
+		modules := make([]*statev1beta1.GlobalStateReference, 0, len(moduleLatestRefs)/2)
+		for i := 0; i < len(moduleLatestRefs); i += 2 {
+			modules = append(modules, statev1beta1.GlobalStateReference_builder{
+				ModuleName:      moduleLatestRefs[i],
+				LatestReference: moduleLatestRefs[i+1],
+			}.Build())
+		}
+		return statev1beta1.GlobalState_builder{Modules: modules}.Build()
+	}
+	var resolved []string
+	merged, err := MergeGlobalStates(
+		newGlobalState("acme/changed-both", "v1", "acme/changed-ours", "v1", "acme/changed-theirs", "v1", "acme/removed", "v1"),
+		newGlobalState("acme/added-ours", "v1", "acme/changed-both", "v2", "acme/changed-ours", "v2", "acme/changed-theirs", "v1"),
+		newGlobalState("acme/added-theirs", "v1", "acme/changed-both", "v3", "acme/changed-ours", "v1", "acme/changed-theirs", "v2", "acme/removed", "v1"),
+		func(moduleName string, oursLatestRef string, theirsLatestRef string) (string, error) {
+			resolved = append(resolved, moduleName)
+			assert.Equal(t, "v2", oursLatestRef)
+			assert.Equal(t, "v3", theirsLatestRef)
+			return "v3", nil
+		},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"acme/changed-both"}, resolved)
+	want := newGlobalState("acme/added-ours", "v1", "acme/added-theirs", "v1", "acme/changed-both", "v3", "acme/changed-ours", "v2", "acme/changed-theirs", "v2")
+	assert.Equal(t, globalLatestReferences(want), globalLatestReferences(merged))
+	assert.Equal(t, "acme/added-ours", merged.GetModules()[0].GetModuleName())
+
+	resolveErr := errors.New("module state file conflicted")
+	_, err = MergeGlobalStates(
+		newGlobalState("acme/a", "v1"),
+		newGlobalState("acme/a", "v2"),
+		newGlobalState("acme/a", "v3"),
+		func(string, string, string) (string, error) {
+			return "", resolveErr
+		},
+	)
+	require.ErrorIs(t, err, resolveErr)
+}
+
+func TestMergeDeprecations(t *testing.T) {
+	t.Parallel()
+	deprecated := statev1beta1.Deprecation_builder{
+		Reason:        "archived",
+		DeprecateTime: timestamppb.New(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)),
+	}.Build()
+	otherDeprecated := statev1beta1.Deprecation_builder{
+		Reason:        "replaced",
+		Replacement:   "acme/other",
+		DeprecateTime: timestamppb.New(time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)),
+	}.Build()
+	newModuleState := func(deprecation *statev1beta1.Deprecation) *statev1beta1.ModuleState {
+		return statev1beta1.ModuleState_builder{
+			References:  []*statev1beta1.ModuleReference{statev1beta1.ModuleReference_builder{Name: "v1", Digest: "a"}.Build()},
+			Deprecation: deprecation,
+		}.Build()
+	}
+	merged, err := MergeModuleStates(newModuleState(nil), newModuleState(nil), newModuleState(deprecated))
+	require.NoError(t, err)
+	assert.True(t, proto.Equal(deprecated, merged.GetDeprecation()))
+	// undeprecated in ours
+	merged, err = MergeModuleStates(newModuleState(deprecated), newModuleState(nil), newModuleState(deprecated))
+	require.NoError(t, err)
+	assert.False(t, merged.HasDeprecation())
+	_, err = MergeModuleStates(newModuleState(nil), newModuleState(otherDeprecated), newModuleState(deprecated))
+	require.ErrorContains(t, err, `deprecation changed to "replaced" in ours and "archived" in theirs`)
+
+	newGlobalState := func(deprecation *statev1beta1.Deprecation) *statev1beta1.GlobalState {
+		return statev1beta1.GlobalState_builder{
+			Modules: []*statev1beta1.GlobalStateReference{statev1beta1.GlobalStateReference_builder{
+				ModuleName:      "acme/a",
+				LatestReference: "v1",
+				Deprecation:     deprecation,
+			}.Build()},
+		}.Build()
+	}
+	mergedGlobalState, err := MergeGlobalStates(newGlobalState(nil), newGlobalState(otherDeprecated), newGlobalState(nil), nil)
+	require.NoError(t, err)
+	assert.True(t, proto.Equal(otherDeprecated, mergedGlobalState.GetModules()[0].GetDeprecation()))
+	_, err = MergeGlobalStates(newGlobalState(nil), newGlobalState(otherDeprecated), newGlobalState(deprecated), nil)
+	require.ErrorContains(t, err, "module acme/a: deprecation changed")
+}
+
+func globalLatestReferences(globalState *statev1beta1.GlobalState) map[string]string {
+	moduleNameToLatestRef := make(map[string]string, len(globalState.GetModules()))
+	for _, module := range globalState.GetModules() {
+		moduleNameToLatestRef[module.GetModuleName()] = module.GetLatestReference()
+	}
+	return moduleNameToLatestRef
+}
+
+func moduleReferenceNames(moduleState *statev1beta1.ModuleState) []string {
+	names := make([]string, len(moduleState.GetReferences()))
+	for i, reference := range moduleState.GetReferences() {
+		names[i] = reference.GetName()
+	}
+	return names
+}
+
+func moduleReferenceDigests(moduleState *statev1beta1.ModuleState) []string {
+	digests := make([]string, len(moduleState.GetReferences()))
+	for i, reference := range moduleState.GetReferences() {
+		digests[i] = reference.GetDigest()
+	}
+	return digests
+}
@@ -0,0 +1,63 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufstate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+)
+
+// MigrateSyncDir rewrites the global state file and all the module state files in the root sync
+// directory in the given version, and returns the paths of the rewritten files. Files already in
+// that version are left untouched.
+//
+// All state files are migrated in a single UpdateSyncDir, so either all of them are rewritten or
+// none. Migrating to state.v1alpha1 drops the fields it does not have, like the sync metadata of
+// references.
+func (rw *ReadWriter) MigrateSyncDir(rootSyncDir string, version Version) ([]string, error) {
+	if _, ok := versionToString[version]; !ok {
+		return nil, fmt.Errorf("unsupported state version %s", version)
+	}
+	modFilePaths, err := filepath.Glob(filepath.Join(rootSyncDir, "*", "*", ModStateFileName))
+	if err != nil {
+		return nil, fmt.Errorf("find module state files: %w", err)
+	}
+	var migratedFilePaths []string
+	if err := rw.UpdateSyncDir(context.Background(), rootSyncDir, func(update *SyncDirUpdate) error {
+		if update.globalState.data == nil {
+			return errors.New("global state file not found")
+		}
+		if update.globalState.version != version {
+			migratedFilePaths = append(migratedFilePaths, filepath.Join(rootSyncDir, GlobalStateFileName))
+			update.globalState.version = version
+		}
+		for _, modFilePath := range modFilePaths {
+			modState, err := update.moduleStateUpdate(modFilePath)
+			if err != nil {
+				return err
+			}
+			if modState.version != version {
+				migratedFilePaths = append(migratedFilePaths, modFilePath)
+				modState.version = version
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return migratedFilePaths, nil
+}
@@ -18,15 +18,24 @@ import (
 	"fmt"
 	"io"
 
-	"github.com/bufbuild/buf/private/pkg/protoencoding"
 	statev1alpha1 "github.com/bufbuild/modules/private/gen/modules/state/v1alpha1"
+	statev1beta1 "github.com/bufbuild/modules/private/gen/modules/state/v1beta1"
 	"go.uber.org/multierr"
+	"google.golang.org/protobuf/proto"
 )
 
 const ModStateFileName = "state.json"
 
-// ReadModStateFile reads a JSON encoded ModuleState from the given reader before closing it.
-func (rw *ReadWriter) ReadModStateFile(readCloser io.ReadCloser) (_ *statev1alpha1.ModuleState, retErr error) {
+// ReadModStateFile reads a JSON encoded ModuleState of any version from the given reader before
+// closing it.
+func (rw *ReadWriter) ReadModStateFile(readCloser io.ReadCloser) (*statev1beta1.ModuleState, error) {
+	moduleState, _, err := rw.ReadModStateFileWithVersion(readCloser)
+	return moduleState, err
+}
+
+// ReadModStateFileWithVersion reads a JSON encoded ModuleState of any version from the given reader
+// before closing it, and returns it with the version it was read in.
+func (rw *ReadWriter) ReadModStateFileWithVersion(readCloser io.ReadCloser) (_ *statev1beta1.ModuleState, _ Version, retErr error) {
 	defer func() {
 		if err := readCloser.Close(); err != nil {
 			retErr = multierr.Append(retErr, fmt.Errorf("close file: %w", err))
@@ -34,38 +43,76 @@ func (rw *ReadWriter) ReadModStateFile(readCloser io.ReadCloser) (_ *statev1alph
 	}()
 	bytes, err := io.ReadAll(readCloser)
 	if err != nil {
-		return nil, fmt.Errorf("read module state file: %w", err)
+		return nil, 0, fmt.Errorf("read module state file: %w", err)
 	}
-	var moduleState statev1alpha1.ModuleState
-	if err := protoencoding.NewJSONUnmarshaler(protoencoding.EmptyResolver).Unmarshal(bytes, &moduleState); err != nil {
-		return nil, fmt.Errorf("unmarshal module state: %w", err)
+	version, err := DetectVersion(bytes)
+	if err != nil {
+		return nil, 0, fmt.Errorf("detect module state version: %w", err)
 	}
-	if err := rw.validator.Validate(&moduleState); err != nil {
-		return nil, fmt.Errorf("validate: %w", err)
+	var moduleState *statev1beta1.ModuleState
+	switch version {
+	case VersionV1Alpha1:
+		var moduleStateV1Alpha1 statev1alpha1.ModuleState
+		if err := rw.unmarshalAndValidate(bytes, &moduleStateV1Alpha1); err != nil {
+			return nil, 0, fmt.Errorf("module state %s: %w", version, err)
+		}
+		moduleState = moduleStateV1Alpha1ToV1Beta1(&moduleStateV1Alpha1)
+	case VersionV1Beta1:
+		moduleState = &statev1beta1.ModuleState{}
+		if err := rw.unmarshalAndValidate(bytes, moduleState); err != nil {
+			return nil, 0, fmt.Errorf("module state %s: %w", version, err)
+		}
+	default:
+		return nil, 0, fmt.Errorf("unsupported module state version %s", version)
 	}
-	return &moduleState, nil
+	return moduleState, version, nil
+}
+
+// WriteModStateFile takes a module state and writes it in the latest version to the given writer
+// before closing it.
+func (rw *ReadWriter) WriteModStateFile(writeCloser io.WriteCloser, moduleState *statev1beta1.ModuleState) error {
+	return rw.WriteModStateFileWithVersion(writeCloser, moduleState, LatestVersion)
 }
 
-// WriteModStateFile takes a module state and writes it to the given writer before closing it.
-func (rw *ReadWriter) WriteModStateFile(writeCloser io.WriteCloser, moduleState *statev1alpha1.ModuleState) (retErr error) {
+// WriteModStateFileWithVersion takes a module state and writes it in the given version to the given
+// writer before closing it. Fields that state.v1alpha1 does not have, like the sync metadata of
+// references, are not written in state.v1alpha1.
+func (rw *ReadWriter) WriteModStateFileWithVersion(
+	writeCloser io.WriteCloser,
+	moduleState *statev1beta1.ModuleState,
+	version Version,
+) (retErr error) {
 	defer func() {
 		if err := writeCloser.Close(); err != nil {
 			retErr = multierr.Append(retErr, fmt.Errorf("close file: %w", err))
 		}
 	}()
-	if err := rw.validator.Validate(moduleState); err != nil {
-		return fmt.Errorf("validate: %w", err)
-	}
-	data, err := protoencoding.NewJSONMarshaler(
-		protoencoding.EmptyResolver,
-		protoencoding.JSONMarshalerWithUseProtoNames(),
-		protoencoding.JSONMarshalerWithIndent(),
-	).Marshal(moduleState)
+	data, err := rw.marshalModState(moduleState, version)
 	if err != nil {
-		return fmt.Errorf("marshal module state: %w", err)
+		return err
 	}
 	if _, err := writeCloser.Write(data); err != nil {
 		return fmt.Errorf("write to file: %w", err)
 	}
 	return nil
 }
+
+// marshalModState validates a module state and marshals it in the given version.
+func (rw *ReadWriter) marshalModState(moduleState *statev1beta1.ModuleState, version Version) ([]byte, error) {
+	moduleState = proto.CloneOf(moduleState)
+	moduleState.SetVersion(VersionV1Beta1.String())
+	var message proto.Message
+	switch version {
+	case VersionV1Alpha1:
+		message = moduleStateV1Beta1ToV1Alpha1(moduleState)
+	case VersionV1Beta1:
+		message = moduleState
+	default:
+		return nil, fmt.Errorf("unsupported module state version %s", version)
+	}
+	data, err := rw.validateAndMarshal(message)
+	if err != nil {
+		return nil, fmt.Errorf("module state %s: %w", version, err)
+	}
+	return data, nil
+}
@@ -0,0 +1,266 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufstate
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Position is a 1-based line and column in a JSON encoded state file. Columns are counted in bytes.
+type Position struct {
+	Line   int
+	Column int
+}
+
+// Span is the range of a JSON value in a state file, from its first byte to its last byte, both
+// inclusive.
+type Span struct {
+	Start Position
+	End   Position
+}
+
+// ElementSpan is the span of a single element in a state file array, such as a ModuleReference
+// in a module state file or a GlobalStateReference in a global state file, with the spans of each
+// of its field values.
+type ElementSpan struct {
+	Span
+	fieldSpans map[string]Span
+}
+
+// FieldSpan returns the span of the value of the field with the given proto name, which also matches
+// its JSON camel case name. Returns false if the field is not present in the element.
+func (e ElementSpan) FieldSpan(protoName string) (Span, bool) {
+	if span, ok := e.fieldSpans[protoName]; ok {
+		return span, true
+	}
+	span, ok := e.fieldSpans[jsonCamelCase(protoName)]
+	return span, ok
+}
+
+// ModuleStateReferenceSpans indexes the JSON encoded ModuleState in data, and returns the span of
+// each element in its references array, in the same order they appear in the file.
+func ModuleStateReferenceSpans(data []byte) ([]ElementSpan, error) {
+	return arrayElementSpans(data, "references")
+}
+
+// GlobalStateReferenceSpans indexes the JSON encoded GlobalState in data, and returns the span of
+// each element in its modules array, in the same order they appear in the file.
+func GlobalStateReferenceSpans(data []byte) ([]ElementSpan, error) {
+	return arrayElementSpans(data, "modules")
+}
+
+// arrayElementSpans returns the spans of the elements of the top-level array field with the given
+// proto name in a JSON object. Elements are expected to be JSON objects.
+func arrayElementSpans(data []byte, arrayProtoName string) ([]ElementSpan, error) {
+	s := newJSONScanner(data)
+	if err := s.expectDelim('{'); err != nil {
+		return nil, err
+	}
+	var elementSpans []ElementSpan
+	for s.more() {
+		key, err := s.key()
+		if err != nil {
+			return nil, err
+		}
+		if key != arrayProtoName && key != jsonCamelCase(arrayProtoName) {
+			if _, err := s.skipValue(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		tok, _, err := s.token()
+		if err != nil {
+			return nil, err
+		}
+		if tok == nil {
+			continue // null array
+		}
+		if tok != json.Delim('[') {
+			return nil, fmt.Errorf("field %q: expected array, got %v", key, tok)
+		}
+		for s.more() {
+			elementSpan, err := s.objectSpan()
+			if err != nil {
+				return nil, fmt.Errorf("field %q element %d: %w", key, len(elementSpans), err)
+			}
+			elementSpans = append(elementSpans, elementSpan)
+		}
+		if err := s.expectDelim(']'); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.expectDelim('}'); err != nil {
+		return nil, err
+	}
+	return elementSpans, nil
+}
+
+// jsonScanner reads JSON tokens keeping track of their byte offsets.
+type jsonScanner struct {
+	data       []byte
+	dec        *json.Decoder
+	lineStarts []int
+}
+
+func newJSONScanner(data []byte) *jsonScanner {
+	lineStarts := []int{0}
+	for i, b := range data {
+		if b == '\n' {
+			lineStarts = append(lineStarts, i+1)
+		}
+	}
+	return &jsonScanner{
+		data:       data,
+		dec:        json.NewDecoder(bytes.NewReader(data)),
+		lineStarts: lineStarts,
+	}
+}
+
+// token reads the next token, and returns it with the offset of its first byte.
+func (s *jsonScanner) token() (json.Token, int, error) {
+	start := int(s.dec.InputOffset())
+	tok, err := s.dec.Token()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, 0, io.ErrUnexpectedEOF
+		}
+		return nil, 0, fmt.Errorf("read JSON token: %w", err)
+	}
+	// The input offset before reading a token can still point to whitespace and separators.
+	for start < len(s.data) && strings.IndexByte(" \t\r\n,:", s.data[start]) >= 0 {
+		start++
+	}
+	return tok, start, nil
+}
+
+func (s *jsonScanner) more() bool {
+	return s.dec.More()
+}
+
+func (s *jsonScanner) expectDelim(delim json.Delim) error {
+	tok, _, err := s.token()
+	if err != nil {
+		return err
+	}
+	if tok != delim {
+		return fmt.Errorf("expected %v, got %v", delim, tok)
+	}
+	return nil
+}
+
+func (s *jsonScanner) key() (string, error) {
+	tok, _, err := s.token()
+	if err != nil {
+		return "", err
+	}
+	key, ok := tok.(string)
+	if !ok {
+		return "", fmt.Errorf("expected object key, got %v", tok)
+	}
+	return key, nil
+}
+
+// skipValue reads a whole value, and returns its span.
+func (s *jsonScanner) skipValue() (Span, error) {
+	tok, start, err := s.token()
+	if err != nil {
+		return Span{}, err
+	}
+	if delim, ok := tok.(json.Delim); ok && (delim == '{' || delim == '[') {
+		for depth := 1; depth > 0; {
+			tok, _, err := s.token()
+			if err != nil {
+				return Span{}, err
+			}
+			if delim, ok := tok.(json.Delim); ok {
+				switch delim {
+				case '{', '[':
+					depth++
+				case '}', ']':
+					depth--
+				}
+			}
+		}
+	}
+	return s.span(start, int(s.dec.InputOffset())-1), nil
+}
+
+// objectSpan reads a whole object, and returns its span with the spans of its field values.
+func (s *jsonScanner) objectSpan() (ElementSpan, error) {
+	tok, start, err := s.token()
+	if err != nil {
+		return ElementSpan{}, err
+	}
+	if tok != json.Delim('{') {
+		return ElementSpan{}, fmt.Errorf("expected object, got %v", tok)
+	}
+	fieldSpans := make(map[string]Span)
+	for s.more() {
+		key, err := s.key()
+		if err != nil {
+			return ElementSpan{}, err
+		}
+		valueSpan, err := s.skipValue()
+		if err != nil {
+			return ElementSpan{}, fmt.Errorf("field %q: %w", key, err)
+		}
+		fieldSpans[key] = valueSpan
+	}
+	if err := s.expectDelim('}'); err != nil {
+		return ElementSpan{}, err
+	}
+	return ElementSpan{
+		Span:       s.span(start, int(s.dec.InputOffset())-1),
+		fieldSpans: fieldSpans,
+	}, nil
+}
+
+func (s *jsonScanner) span(startOffset int, endOffset int) Span {
+	return Span{Start: s.position(startOffset), End: s.position(endOffset)}
+}
+
+func (s *jsonScanner) position(offset int) Position {
+	// index of the first line that starts after offset, the previous one contains it.
+	line := sort.SearchInts(s.lineStarts, offset+1)
+	return Position{Line: line, Column: offset - s.lineStarts[line-1] + 1}
+}
+
+// jsonCamelCase converts a proto field name to its default JSON name, e.g. module_name to
+// moduleName.
+func jsonCamelCase(protoName string) string {
+	var (
+		b         strings.Builder
+		upperNext bool
+	)
+	for _, r := range protoName {
+		if r == '_' {
+			upperNext = true
+			continue
+		}
+		if upperNext {
+			b.WriteString(strings.ToUpper(string(r)))
+			upperNext = false
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
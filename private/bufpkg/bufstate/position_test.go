@@ -0,0 +1,97 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufstate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestModuleStateReferenceSpans(t *testing.T) {
+	t.Parallel()
+	t.Run("indented", func(t *testing.T) {
+		t.Parallel()
+		spans, err := ModuleStateReferenceSpans([]byte(`{
+  "references": [
+    {
+      "name": "v1.0.0",
+      "digest": "aaa"
+    },
+    {
+      "name": "v1.1.0",
+      "digest": "bbb"
+    }
+  ]
+}
+`))
+		require.NoError(t, err)
+		require.Len(t, spans, 2)
+		assert.Equal(t, Span{Start: Position{Line: 3, Column: 5}, End: Position{Line: 6, Column: 5}}, spans[0].Span)
+		assert.Equal(t, Span{Start: Position{Line: 7, Column: 5}, End: Position{Line: 10, Column: 5}}, spans[1].Span)
+		digestSpan, ok := spans[1].FieldSpan("digest")
+		require.True(t, ok)
+		assert.Equal(t, Span{Start: Position{Line: 9, Column: 17}, End: Position{Line: 9, Column: 21}}, digestSpan)
+		_, ok = spans[1].FieldSpan("unknown")
+		assert.False(t, ok)
+	})
+	t.Run("compact", func(t *testing.T) {
+		t.Parallel()
+		spans, err := ModuleStateReferenceSpans([]byte(`{"references":[{"name":"v1.0.0","digest":"aaa"},` + "\n" + `{"digest":"bbb","name":"v1.1.0"}]}`))
+		require.NoError(t, err)
+		require.Len(t, spans, 2)
+		digestSpan, ok := spans[0].FieldSpan("digest")
+		require.True(t, ok)
+		assert.Equal(t, Span{Start: Position{Line: 1, Column: 42}, End: Position{Line: 1, Column: 46}}, digestSpan)
+		digestSpan, ok = spans[1].FieldSpan("digest")
+		require.True(t, ok)
+		assert.Equal(t, Span{Start: Position{Line: 2, Column: 11}, End: Position{Line: 2, Column: 15}}, digestSpan)
+	})
+	t.Run("other_fields_and_null", func(t *testing.T) {
+		t.Parallel()
+		spans, err := ModuleStateReferenceSpans([]byte(`{"other": {"references": [{"name": "x"}]}, "references": null}`))
+		require.NoError(t, err)
+		assert.Empty(t, spans)
+	})
+	t.Run("invalid", func(t *testing.T) {
+		t.Parallel()
+		_, err := ModuleStateReferenceSpans([]byte(`{"references": [{"name": "v1.0.0"`))
+		require.Error(t, err)
+		_, err = ModuleStateReferenceSpans([]byte(`{"references": ["v1.0.0"]}`))
+		require.Error(t, err)
+	})
+}
+
+func TestGlobalStateReferenceSpans(t *testing.T) {
+	t.Parallel()
+	spans, err := GlobalStateReferenceSpans([]byte(`{
+  "modules": [
+    {
+      "module_name": "aaa/bbb",
+      "latest_reference": "foo"
+    },
+    {"moduleName": "ccc/ddd", "latestReference": "bar"}
+  ]
+}`))
+	require.NoError(t, err)
+	require.Len(t, spans, 2)
+	latestReferenceSpan, ok := spans[0].FieldSpan("latest_reference")
+	require.True(t, ok)
+	assert.Equal(t, 5, latestReferenceSpan.Start.Line)
+	latestReferenceSpan, ok = spans[1].FieldSpan("latest_reference")
+	require.True(t, ok)
+	assert.Equal(t, Span{Start: Position{Line: 7, Column: 50}, End: Position{Line: 7, Column: 54}}, latestReferenceSpan)
+}
@@ -18,6 +18,8 @@ import (
 	"fmt"
 
 	"buf.build/go/protovalidate"
+	"github.com/bufbuild/buf/private/pkg/protoencoding"
+	"google.golang.org/protobuf/proto"
 )
 
 type ReadWriter struct {
@@ -31,3 +33,30 @@ func NewReadWriter() (*ReadWriter, error) {
 	}
 	return &ReadWriter{validator: v}, nil
 }
+
+// unmarshalAndValidate unmarshals JSON encoded state file data into the message, and validates it.
+func (rw *ReadWriter) unmarshalAndValidate(data []byte, message proto.Message) error {
+	if err := protoencoding.NewJSONUnmarshaler(protoencoding.EmptyResolver).Unmarshal(data, message); err != nil {
+		return fmt.Errorf("unmarshal: %w", err)
+	}
+	if err := rw.validator.Validate(message); err != nil {
+		return fmt.Errorf("validate: %w", err)
+	}
+	return nil
+}
+
+// validateAndMarshal validates the message, and marshals it to JSON encoded state file data.
+func (rw *ReadWriter) validateAndMarshal(message proto.Message) ([]byte, error) {
+	if err := rw.validator.Validate(message); err != nil {
+		return nil, fmt.Errorf("validate: %w", err)
+	}
+	data, err := protoencoding.NewJSONMarshaler(
+		protoencoding.EmptyResolver,
+		protoencoding.JSONMarshalerWithUseProtoNames(),
+		protoencoding.JSONMarshalerWithIndent(),
+	).Marshal(message)
+	if err != nil {
+		return nil, fmt.Errorf("marshal: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,134 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufstate
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	statev1beta1 "github.com/bufbuild/modules/private/gen/modules/state/v1beta1"
+	"google.golang.org/protobuf/proto"
+)
+
+// GlobalStateDiscrepancy is a module whose latest reference in the global state file does not match
+// the last reference in its module state file, or whose deprecation in the global state file does
+// not match the one in its module state file.
+type GlobalStateDiscrepancy struct {
+	// ModuleName is the module name, in the shape of <owner>/<repo>.
+	ModuleName string
+	// GlobalLatestReference is the latest reference of the module in the global state file, empty
+	// if the module is missing from it.
+	GlobalLatestReference string
+	// ModuleLatestReference is the last reference in the module state file, empty if the module has
+	// no module state file or no references in it.
+	ModuleLatestReference string
+	// DeprecationMismatch is whether the deprecation of the module in the global state file does not
+	// match the one in its module state file.
+	DeprecationMismatch bool
+}
+
+// String implements fmt.Stringer.
+func (d GlobalStateDiscrepancy) String() string {
+	switch {
+	case d.GlobalLatestReference == "":
+		return fmt.Sprintf("%s: missing from the global state, latest reference is %s", d.ModuleName, d.ModuleLatestReference)
+	case d.ModuleLatestReference == "":
+		return fmt.Sprintf("%s: in the global state at %s, but has no references in a module state file", d.ModuleName, d.GlobalLatestReference)
+	case d.GlobalLatestReference == d.ModuleLatestReference:
+		return fmt.Sprintf("%s: global state deprecation does not match the module state deprecation", d.ModuleName)
+	default:
+		return fmt.Sprintf("%s: global state latest reference is %s, but module state latest reference is %s", d.ModuleName, d.GlobalLatestReference, d.ModuleLatestReference)
+	}
+}
+
+// RebuildGlobalState scans every <owner>/<repo>/state.json module state file in the root sync
+// directory, and returns the global state derived from their last references, with its
+// discrepancies with the global state file. Nothing is written, use SyncDirUpdate.RebuildGlobalState
+// in an UpdateSyncDir to also fix them.
+//
+// If there is no global state file, every module is a discrepancy.
+func (rw *ReadWriter) RebuildGlobalState(rootSyncDir string) (*statev1beta1.GlobalState, []GlobalStateDiscrepancy, error) {
+	// a read only update, that is never committed
+	syncDirUpdate, err := rw.newSyncDirUpdate(rootSyncDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	discrepancies, err := syncDirUpdate.RebuildGlobalState()
+	if err != nil {
+		return nil, nil, err
+	}
+	return syncDirUpdate.GlobalState(), discrepancies, nil
+}
+
+// RebuildGlobalState replaces the modules of the global state with the last references of every
+// <owner>/<repo>/state.json module state file in the root sync directory, and returns the
+// discrepancies with the previous global state, sorted by module name. Deprecations are taken from
+// the module state files too. Modules without references are left out of the global state.
+func (u *SyncDirUpdate) RebuildGlobalState() ([]GlobalStateDiscrepancy, error) {
+	modFilePaths, err := filepath.Glob(filepath.Join(u.rootSyncDir, "*", "*", ModStateFileName))
+	if err != nil {
+		return nil, fmt.Errorf("find module state files: %w", err)
+	}
+	moduleNameToGlobalStateReference := make(map[string]*statev1beta1.GlobalStateReference, len(u.GlobalState().GetModules()))
+	for _, globalStateReference := range u.GlobalState().GetModules() {
+		moduleNameToGlobalStateReference[globalStateReference.GetModuleName()] = globalStateReference
+	}
+	var (
+		modules       []*statev1beta1.GlobalStateReference
+		discrepancies []GlobalStateDiscrepancy
+	)
+	for _, modFilePath := range modFilePaths {
+		modState, err := u.moduleStateUpdate(modFilePath)
+		if err != nil {
+			return nil, err
+		}
+		moduleDirPath := filepath.Dir(modFilePath)
+		moduleName := filepath.Join(filepath.Base(filepath.Dir(moduleDirPath)), filepath.Base(moduleDirPath))
+		globalStateReference := moduleNameToGlobalStateReference[moduleName]
+		delete(moduleNameToGlobalStateReference, moduleName)
+		var moduleLatestRef string
+		if latest, ok := NewModuleHistory(modState.state).Latest(); ok {
+			moduleLatestRef = latest.GetName()
+			modules = append(modules, statev1beta1.GlobalStateReference_builder{
+				ModuleName:      moduleName,
+				LatestReference: moduleLatestRef,
+				Deprecation:     modState.state.GetDeprecation(),
+			}.Build())
+		}
+		deprecationMismatch := moduleLatestRef != "" && !proto.Equal(globalStateReference.GetDeprecation(), modState.state.GetDeprecation())
+		if globalStateReference.GetLatestReference() != moduleLatestRef || deprecationMismatch {
+			discrepancies = append(discrepancies, GlobalStateDiscrepancy{
+				ModuleName:            moduleName,
+				GlobalLatestReference: globalStateReference.GetLatestReference(),
+				ModuleLatestReference: moduleLatestRef,
+				DeprecationMismatch:   deprecationMismatch,
+			})
+		}
+	}
+	// modules in the global state without a module state file
+	for moduleName, globalStateReference := range moduleNameToGlobalStateReference {
+		discrepancies = append(discrepancies, GlobalStateDiscrepancy{
+			ModuleName:            moduleName,
+			GlobalLatestReference: globalStateReference.GetLatestReference(),
+		})
+	}
+	slices.SortFunc(discrepancies, func(a GlobalStateDiscrepancy, b GlobalStateDiscrepancy) int {
+		return strings.Compare(a.ModuleName, b.ModuleName)
+	})
+	u.GlobalState().SetModules(modules)
+	return discrepancies, nil
+}
@@ -0,0 +1,121 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufstate
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	statev1beta1 "github.com/bufbuild/modules/private/gen/modules/state/v1beta1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestRebuildGlobalState(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	readWriter, err := NewReadWriter()
+	require.NoError(t, err)
+	rootSyncDir := t.TempDir()
+	for _, repoName := range []string{"bar", "baz", "empty"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(rootSyncDir, "foo", repoName), 0755))
+	}
+	require.NoError(t, readWriter.AppendModuleReference(rootSyncDir, "foo", "bar", "v1.0.0", "foo"))
+	require.NoError(t, readWriter.AppendModuleReference(rootSyncDir, "foo", "bar", "v1.1.0", "bar"))
+	require.NoError(t, readWriter.AppendModuleReference(rootSyncDir, "foo", "baz", "v1.0.0", "baz"))
+	require.NoError(t, readWriter.WriteModStateFile(createFile(t, filepath.Join(rootSyncDir, "foo", "empty", ModStateFileName)), &statev1beta1.ModuleState{}))
+
+	globalState, discrepancies, err := readWriter.RebuildGlobalState(rootSyncDir)
+	require.NoError(t, err)
+	assert.Empty(t, discrepancies)
+	require.Len(t, globalState.GetModules(), 2)
+
+	// drift the global state from the module state files
+	require.NoError(t, readWriter.UpdateSyncDir(ctx, rootSyncDir, func(update *SyncDirUpdate) error {
+		modules := update.GlobalState().GetModules()
+		modules[0].SetLatestReference("v1.0.0")
+		update.GlobalState().SetModules(append(modules[:1], statev1beta1.GlobalStateReference_builder{
+			ModuleName:      "foo/gone",
+			LatestReference: "v1.0.0",
+		}.Build()))
+		return nil
+	}))
+	globalFilePath := filepath.Join(rootSyncDir, GlobalStateFileName)
+	driftedData, err := os.ReadFile(globalFilePath)
+	require.NoError(t, err)
+	wantDiscrepancies := []GlobalStateDiscrepancy{
+		{ModuleName: "foo/bar", GlobalLatestReference: "v1.0.0", ModuleLatestReference: "v1.1.0"},
+		{ModuleName: "foo/baz", ModuleLatestReference: "v1.0.0"},
+		{ModuleName: "foo/gone", GlobalLatestReference: "v1.0.0"},
+	}
+	_, discrepancies, err = readWriter.RebuildGlobalState(rootSyncDir)
+	require.NoError(t, err)
+	assert.Equal(t, wantDiscrepancies, discrepancies)
+	// reporting does not write
+	data, err := os.ReadFile(globalFilePath)
+	require.NoError(t, err)
+	assert.Equal(t, string(driftedData), string(data))
+
+	require.NoError(t, readWriter.UpdateSyncDir(ctx, rootSyncDir, func(update *SyncDirUpdate) error {
+		discrepancies, err = update.RebuildGlobalState()
+		return err
+	}))
+	assert.Equal(t, wantDiscrepancies, discrepancies)
+	_, discrepancies, err = readWriter.RebuildGlobalState(rootSyncDir)
+	require.NoError(t, err)
+	assert.Empty(t, discrepancies)
+	globalState, err = readWriter.ReadGlobalState(openFile(t, globalFilePath))
+	require.NoError(t, err)
+	require.Len(t, globalState.GetModules(), 2)
+	assert.Equal(t, "v1.1.0", globalState.GetModules()[0].GetLatestReference())
+	assert.Equal(t, "foo/baz", globalState.GetModules()[1].GetModuleName())
+}
+
+func TestRebuildGlobalStateDeprecation(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	readWriter, err := NewReadWriter()
+	require.NoError(t, err)
+	rootSyncDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(rootSyncDir, "foo", "bar"), 0755))
+	require.NoError(t, readWriter.AppendModuleReference(rootSyncDir, "foo", "bar", "v1.0.0", "digest"))
+	require.NoError(t, readWriter.UpdateSyncDir(ctx, rootSyncDir, func(update *SyncDirUpdate) error {
+		if err := update.DeprecateModule("foo", "bar", statev1beta1.Deprecation_builder{
+			Reason:        "reason",
+			DeprecateTime: timestamppb.Now(),
+		}.Build()); err != nil {
+			return err
+		}
+		// drift the global state deprecation from the module state file
+		update.GlobalState().GetModules()[0].ClearDeprecation()
+		return nil
+	}))
+	globalState, discrepancies, err := readWriter.RebuildGlobalState(rootSyncDir)
+	require.NoError(t, err)
+	require.Equal(t, []GlobalStateDiscrepancy{
+		{ModuleName: "foo/bar", GlobalLatestReference: "v1.0.0", ModuleLatestReference: "v1.0.0", DeprecationMismatch: true},
+	}, discrepancies)
+	assert.Equal(t, "foo/bar: global state deprecation does not match the module state deprecation", discrepancies[0].String())
+	assert.Equal(t, "reason", globalState.GetModules()[0].GetDeprecation().GetReason())
+}
+
+func createFile(t *testing.T, filePath string) *os.File {
+	t.Helper()
+	file, err := os.Create(filePath)
+	require.NoError(t, err)
+	return file
+}
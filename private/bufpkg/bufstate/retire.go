@@ -0,0 +1,121 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufstate
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+
+	statev1beta1 "github.com/bufbuild/modules/private/gen/modules/state/v1beta1"
+)
+
+// DeprecateModule sets the deprecation of a module in its module state, and mirrors it in the global
+// state. The module must have synced references, the replacement, if any, must be another managed
+// module, and both state files must be in state.v1beta1, since state.v1alpha1 has no deprecations.
+func (u *SyncDirUpdate) DeprecateModule(
+	ownerName string,
+	repoName string,
+	deprecation *statev1beta1.Deprecation,
+) error {
+	moduleName := filepath.Join(ownerName, repoName)
+	modState, globalStateReference, err := u.managedModule(ownerName, repoName)
+	if err != nil {
+		return err
+	}
+	if modState.version == VersionV1Alpha1 || u.globalState.version == VersionV1Alpha1 {
+		return fmt.Errorf("deprecate module %s: state %s has no deprecations, migrate to %s first", moduleName, VersionV1Alpha1, VersionV1Beta1)
+	}
+	if replacement := deprecation.GetReplacement(); replacement != "" {
+		if replacement == moduleName {
+			return fmt.Errorf("deprecate module %s: it cannot replace itself", moduleName)
+		}
+		if u.globalStateReference(replacement) == nil {
+			return fmt.Errorf("deprecate module %s: replacement %s is not a managed module", moduleName, replacement)
+		}
+	}
+	modState.state.SetDeprecation(deprecation)
+	globalStateReference.SetDeprecation(deprecation)
+	return nil
+}
+
+// UndeprecateModule clears the deprecation of a deprecated module, in its module state and in the
+// global state.
+func (u *SyncDirUpdate) UndeprecateModule(ownerName string, repoName string) error {
+	modState, globalStateReference, err := u.managedModule(ownerName, repoName)
+	if err != nil {
+		return err
+	}
+	if !modState.state.HasDeprecation() {
+		return fmt.Errorf("undeprecate module %s: module is not deprecated", filepath.Join(ownerName, repoName))
+	}
+	modState.state.ClearDeprecation()
+	globalStateReference.ClearDeprecation()
+	return nil
+}
+
+// RemoveModule removes a module from the global state, and its whole module directory, with its
+// module state file and CAS directory, from the root sync directory. The module directory is only
+// removed when the update is committed. A module that replaces a deprecated module cannot be
+// removed.
+func (u *SyncDirUpdate) RemoveModule(ownerName string, repoName string) error {
+	moduleName := filepath.Join(ownerName, repoName)
+	if _, _, err := u.managedModule(ownerName, repoName); err != nil {
+		return err
+	}
+	for _, globalStateReference := range u.GlobalState().GetModules() {
+		if globalStateReference.GetDeprecation().GetReplacement() == moduleName {
+			return fmt.Errorf("remove module %s: it is the replacement of deprecated module %s", moduleName, globalStateReference.GetModuleName())
+		}
+	}
+	u.GlobalState().SetModules(slices.DeleteFunc(u.GlobalState().GetModules(), func(globalStateReference *statev1beta1.GlobalStateReference) bool {
+		return globalStateReference.GetModuleName() == moduleName
+	}))
+	moduleDirPath := filepath.Join(u.rootSyncDir, ownerName, repoName)
+	delete(u.modFilePathToState, filepath.Join(moduleDirPath, ModStateFileName))
+	u.removedModuleDirPaths = append(u.removedModuleDirPaths, moduleDirPath)
+	return nil
+}
+
+// managedModule returns the module state and the global state reference of a module, which must
+// have synced references.
+func (u *SyncDirUpdate) managedModule(
+	ownerName string,
+	repoName string,
+) (*stateFileUpdate[*statev1beta1.ModuleState], *statev1beta1.GlobalStateReference, error) {
+	moduleName := filepath.Join(ownerName, repoName)
+	modState, err := u.moduleStateUpdate(filepath.Join(u.rootSyncDir, ownerName, repoName, ModStateFileName))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(modState.state.GetReferences()) == 0 {
+		return nil, nil, fmt.Errorf("module %s has no synced references", moduleName)
+	}
+	globalStateReference := u.globalStateReference(moduleName)
+	if globalStateReference == nil {
+		return nil, nil, fmt.Errorf("module %s is missing from the global state", moduleName)
+	}
+	return modState, globalStateReference, nil
+}
+
+// globalStateReference returns the module in the global state, or nil if it is missing.
+func (u *SyncDirUpdate) globalStateReference(moduleName string) *statev1beta1.GlobalStateReference {
+	for _, globalStateReference := range u.GlobalState().GetModules() {
+		if globalStateReference.GetModuleName() == moduleName {
+			return globalStateReference
+		}
+	}
+	return nil
+}
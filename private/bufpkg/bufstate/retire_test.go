@@ -0,0 +1,196 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufstate
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	statev1beta1 "github.com/bufbuild/modules/private/gen/modules/state/v1beta1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestDeprecateModule(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	readWriter, err := NewReadWriter()
+	require.NoError(t, err)
+	rootSyncDir := t.TempDir()
+	for _, repoName := range []string{"old", "new"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(rootSyncDir, "foo", repoName), 0755))
+		require.NoError(t, readWriter.AppendModuleReference(rootSyncDir, "foo", repoName, "v1.0.0", "digest"))
+	}
+	deprecation := statev1beta1.Deprecation_builder{
+		Reason:        "upstream archived",
+		Replacement:   "foo/new",
+		DeprecateTime: timestamppb.New(time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)),
+	}.Build()
+	require.NoError(t, readWriter.UpdateSyncDir(ctx, rootSyncDir, func(update *SyncDirUpdate) error {
+		return update.DeprecateModule("foo", "old", deprecation)
+	}))
+	modState := readModStateFile(t, readWriter, filepath.Join(rootSyncDir, "foo", "old", ModStateFileName))
+	assert.True(t, proto.Equal(deprecation, modState.GetDeprecation()))
+	globalState, err := readWriter.ReadGlobalState(openFile(t, filepath.Join(rootSyncDir, GlobalStateFileName)))
+	require.NoError(t, err)
+	require.Len(t, globalState.GetModules(), 2)
+	assert.False(t, globalState.GetModules()[0].HasDeprecation())
+	assert.True(t, proto.Equal(deprecation, globalState.GetModules()[1].GetDeprecation()))
+	// deprecations survive new references and global state rebuilds
+	require.NoError(t, readWriter.AppendModuleReference(rootSyncDir, "foo", "old", "v1.1.0", "digest"))
+	_, discrepancies, err := readWriter.RebuildGlobalState(rootSyncDir)
+	require.NoError(t, err)
+	assert.Empty(t, discrepancies)
+
+	for _, testCase := range []struct {
+		name        string
+		repoName    string
+		replacement string
+		wantErrPart string
+	}{
+		{name: "unknownModule", repoName: "unknown", wantErrPart: "module foo/unknown has no synced references"},
+		{name: "selfReplacement", repoName: "new", replacement: "foo/new", wantErrPart: "it cannot replace itself"},
+		{name: "unknownReplacement", repoName: "new", replacement: "foo/unknown", wantErrPart: "replacement foo/unknown is not a managed module"},
+		{name: "invalidReplacement", repoName: "new", replacement: "foo", wantErrPart: "replacement"},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+			err := readWriter.UpdateSyncDir(ctx, rootSyncDir, func(update *SyncDirUpdate) error {
+				return update.DeprecateModule("foo", testCase.repoName, statev1beta1.Deprecation_builder{
+					Reason:        "reason",
+					Replacement:   testCase.replacement,
+					DeprecateTime: timestamppb.Now(),
+				}.Build())
+			})
+			require.ErrorContains(t, err, testCase.wantErrPart)
+		})
+	}
+}
+
+func TestDeprecateModuleV1Alpha1(t *testing.T) {
+	t.Parallel()
+	readWriter, err := NewReadWriter()
+	require.NoError(t, err)
+	rootSyncDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(rootSyncDir, "foo", "bar"), 0755))
+	require.NoError(t, readWriter.AppendModuleReference(rootSyncDir, "foo", "bar", "v1.0.0", "digest"))
+	_, err = readWriter.MigrateSyncDir(rootSyncDir, VersionV1Alpha1)
+	require.NoError(t, err)
+	err = readWriter.UpdateSyncDir(t.Context(), rootSyncDir, func(update *SyncDirUpdate) error {
+		return update.DeprecateModule("foo", "bar", statev1beta1.Deprecation_builder{
+			Reason:        "reason",
+			DeprecateTime: timestamppb.Now(),
+		}.Build())
+	})
+	require.ErrorContains(t, err, "state v1alpha1 has no deprecations")
+}
+
+func TestUndeprecateModule(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	readWriter, err := NewReadWriter()
+	require.NoError(t, err)
+	rootSyncDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(rootSyncDir, "foo", "bar"), 0755))
+	require.NoError(t, readWriter.AppendModuleReference(rootSyncDir, "foo", "bar", "v1.0.0", "digest"))
+	err = readWriter.UpdateSyncDir(ctx, rootSyncDir, func(update *SyncDirUpdate) error {
+		return update.UndeprecateModule("foo", "bar")
+	})
+	require.ErrorContains(t, err, "module is not deprecated")
+	require.NoError(t, readWriter.UpdateSyncDir(ctx, rootSyncDir, func(update *SyncDirUpdate) error {
+		return update.DeprecateModule("foo", "bar", statev1beta1.Deprecation_builder{
+			Reason:        "reason",
+			DeprecateTime: timestamppb.Now(),
+		}.Build())
+	}))
+	require.NoError(t, readWriter.UpdateSyncDir(ctx, rootSyncDir, func(update *SyncDirUpdate) error {
+		return update.UndeprecateModule("foo", "bar")
+	}))
+	assert.False(t, readModStateFile(t, readWriter, filepath.Join(rootSyncDir, "foo", "bar", ModStateFileName)).HasDeprecation())
+	globalState, err := readWriter.ReadGlobalState(openFile(t, filepath.Join(rootSyncDir, GlobalStateFileName)))
+	require.NoError(t, err)
+	assert.False(t, globalState.GetModules()[0].HasDeprecation())
+}
+
+func TestRemoveModule(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	readWriter, err := NewReadWriter()
+	require.NoError(t, err)
+	rootSyncDir := t.TempDir()
+	for _, moduleName := range []string{"foo/old", "foo/new", "bar/baz"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(rootSyncDir, moduleName, "cas"), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(rootSyncDir, moduleName, "cas", "digest"), []byte("blob"), 0600))
+		ownerName, repoName := filepath.Split(moduleName)
+		require.NoError(t, readWriter.AppendModuleReference(rootSyncDir, filepath.Clean(ownerName), repoName, "v1.0.0", "digest"))
+	}
+	require.NoError(t, readWriter.UpdateSyncDir(ctx, rootSyncDir, func(update *SyncDirUpdate) error {
+		return update.DeprecateModule("foo", "old", statev1beta1.Deprecation_builder{
+			Reason:        "reason",
+			Replacement:   "foo/new",
+			DeprecateTime: timestamppb.Now(),
+		}.Build())
+	}))
+	err = readWriter.UpdateSyncDir(ctx, rootSyncDir, func(update *SyncDirUpdate) error {
+		return update.RemoveModule("foo", "new")
+	})
+	require.ErrorContains(t, err, "it is the replacement of deprecated module foo/old")
+	err = readWriter.UpdateSyncDir(ctx, rootSyncDir, func(update *SyncDirUpdate) error {
+		return update.RemoveModule("foo", "unknown")
+	})
+	require.ErrorContains(t, err, "module foo/unknown has no synced references")
+
+	// a failed update keeps the module directory
+	updateErr := errors.New("update failed")
+	err = readWriter.UpdateSyncDir(ctx, rootSyncDir, func(update *SyncDirUpdate) error {
+		if err := update.RemoveModule("bar", "baz"); err != nil {
+			return err
+		}
+		return updateErr
+	})
+	require.ErrorIs(t, err, updateErr)
+	assert.FileExists(t, filepath.Join(rootSyncDir, "bar", "baz", "cas", "digest"))
+
+	require.NoError(t, readWriter.UpdateSyncDir(ctx, rootSyncDir, func(update *SyncDirUpdate) error {
+		if err := update.RemoveModule("foo", "old"); err != nil {
+			return err
+		}
+		if err := update.RemoveModule("bar", "baz"); err != nil {
+			return err
+		}
+		_, err := update.ModuleState("bar", "baz")
+		require.ErrorContains(t, err, "module is removed")
+		return nil
+	}))
+	assert.NoDirExists(t, filepath.Join(rootSyncDir, "foo", "old"))
+	assert.DirExists(t, filepath.Join(rootSyncDir, "foo", "new"))
+	// the owner directory is removed with its last module
+	assert.NoDirExists(t, filepath.Join(rootSyncDir, "bar"))
+	entries, err := os.ReadDir(filepath.Join(rootSyncDir, "foo"))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	globalState, err := readWriter.ReadGlobalState(openFile(t, filepath.Join(rootSyncDir, GlobalStateFileName)))
+	require.NoError(t, err)
+	require.Len(t, globalState.GetModules(), 1)
+	assert.Equal(t, "foo/new", globalState.GetModules()[0].GetModuleName())
+	_, discrepancies, err := readWriter.RebuildGlobalState(rootSyncDir)
+	require.NoError(t, err)
+	assert.Empty(t, discrepancies)
+}
@@ -0,0 +1,92 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufstate
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	statev1beta1 "github.com/bufbuild/modules/private/gen/modules/state/v1beta1"
+)
+
+// Source reads the state files of a root sync directory as of some point, e.g. in the working tree,
+// at a git revision or in a published release.
+type Source interface {
+	// ReadFile returns the content of the state file at the slash separated path relative to the root
+	// sync directory, e.g. GlobalStateFileName or ModuleStateFilePath("owner/repo"). If the source
+	// does not have the file, the returned error wraps fs.ErrNotExist.
+	ReadFile(ctx context.Context, path string) ([]byte, error)
+	// String describes the source in messages, e.g. "git revision main".
+	String() string
+}
+
+// ModuleStateFilePath returns the path of the module state file of the module, relative to the root
+// sync directory.
+func ModuleStateFilePath(moduleName string) string {
+	return path.Join(moduleName, ModStateFileName)
+}
+
+// NewDirSource returns a Source of the state files in the root sync directory on disk, e.g. in the
+// working tree.
+func NewDirSource(rootSyncDir string) Source {
+	return &dirSource{rootSyncDir: rootSyncDir}
+}
+
+// ReadSourceGlobalState reads the global state file of any version from the source.
+func (rw *ReadWriter) ReadSourceGlobalState(ctx context.Context, source Source) (*statev1beta1.GlobalState, error) {
+	data, err := source.ReadFile(ctx, GlobalStateFileName)
+	if err != nil {
+		return nil, fmt.Errorf("read global state from %s: %w", source, err)
+	}
+	globalState, err := rw.ReadGlobalState(newReadCloser(data))
+	if err != nil {
+		return nil, fmt.Errorf("read global state from %s: %w", source, err)
+	}
+	return globalState, nil
+}
+
+// ReadSourceModuleState reads the module state file of any version of the module from the source.
+// If the source does not have it, the returned error wraps fs.ErrNotExist.
+func (rw *ReadWriter) ReadSourceModuleState(ctx context.Context, source Source, moduleName string) (*statev1beta1.ModuleState, error) {
+	data, err := source.ReadFile(ctx, ModuleStateFilePath(moduleName))
+	if err != nil {
+		return nil, fmt.Errorf("read module state of %s from %s: %w", moduleName, source, err)
+	}
+	moduleState, err := rw.ReadModStateFile(newReadCloser(data))
+	if err != nil {
+		return nil, fmt.Errorf("read module state of %s from %s: %w", moduleName, source, err)
+	}
+	return moduleState, nil
+}
+
+type dirSource struct {
+	rootSyncDir string
+}
+
+func (s *dirSource) ReadFile(_ context.Context, filePath string) ([]byte, error) {
+	if !fs.ValidPath(filePath) {
+		return nil, fmt.Errorf("invalid state file path %q", filePath)
+	}
+	// os.ReadFile errors wrap fs.ErrNotExist for missing files
+	return os.ReadFile(filepath.Join(s.rootSyncDir, filepath.FromSlash(filePath)))
+}
+
+func (s *dirSource) String() string {
+	return fmt.Sprintf("directory %s", s.rootSyncDir)
+}
@@ -0,0 +1,54 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufstate
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirSource(t *testing.T) {
+	t.Parallel()
+	readWriter, err := NewReadWriter()
+	require.NoError(t, err)
+	rootSyncDir := t.TempDir()
+	source := NewDirSource(rootSyncDir)
+	_, err = readWriter.ReadSourceGlobalState(t.Context(), source)
+	require.ErrorIs(t, err, fs.ErrNotExist)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(rootSyncDir, "foo", "bar"), 0755))
+	require.NoError(t, readWriter.AppendModuleReference(rootSyncDir, "foo", "bar", "v1.0.0", "a"))
+	globalState, err := readWriter.ReadSourceGlobalState(t.Context(), source)
+	require.NoError(t, err)
+	require.Len(t, globalState.GetModules(), 1)
+	assert.Equal(t, "foo/bar", globalState.GetModules()[0].GetModuleName())
+	assert.Equal(t, "v1.0.0", globalState.GetModules()[0].GetLatestReference())
+	moduleState, err := readWriter.ReadSourceModuleState(t.Context(), source, "foo/bar")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"v1.0.0"}, NewModuleHistory(moduleState).Names())
+	_, err = readWriter.ReadSourceModuleState(t.Context(), source, "foo/baz")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	_, err = source.ReadFile(t.Context(), "../state.json")
+	require.ErrorContains(t, err, "invalid state file path")
+
+	require.NoError(t, os.WriteFile(filepath.Join(rootSyncDir, ModuleStateFilePath("foo/bar")), []byte(`{"references": [{}]}`), 0600))
+	_, err = readWriter.ReadSourceModuleState(t.Context(), source, "foo/bar")
+	require.ErrorContains(t, err, "read module state of foo/bar from directory "+rootSyncDir)
+}
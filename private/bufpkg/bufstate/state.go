@@ -15,11 +15,9 @@
 package bufstate
 
 import (
-	"fmt"
-	"os"
-	"path/filepath"
+	"context"
 
-	statev1alpha1 "github.com/bufbuild/modules/private/gen/modules/state/v1alpha1"
+	statev1beta1 "github.com/bufbuild/modules/private/gen/modules/state/v1beta1"
 )
 
 const SyncRoot = "modules/sync"
@@ -29,86 +27,36 @@ const SyncRoot = "modules/sync"
 // assumes the structure of the sync dir is
 // `root-sync-dir/owner-name/repo-name/state.json` for module state file, and
 // `root-sync-dir/state.json` for global state file.
+//
+// Both state files are written in the version they were read in. A new module
+// state file is written in the version of the global state file, and a new
+// global state file in the latest version. Both state files are updated in a
+// single UpdateSyncDir, so concurrent appends to the same root sync directory
+// are safe.
 func (rw *ReadWriter) AppendModuleReference(
 	rootSyncDir string,
 	ownerName string,
 	repoName string,
 	reference string,
 	digest string,
+	options ...AppendModuleReferenceOption,
 ) error {
-	modFilePath := filepath.Join(rootSyncDir, ownerName, repoName, ModStateFileName)
-	var modState *statev1alpha1.ModuleState
-	if _, err := os.Stat(modFilePath); err != nil {
-		if !os.IsNotExist(err) {
-			return fmt.Errorf("stat file: %w", err)
-		}
-		modState = &statev1alpha1.ModuleState{}
-	} else {
-		modStateFile, err := os.Open(modFilePath)
-		if err != nil {
-			return fmt.Errorf("open file: %w", err)
-		}
-		modState, err = rw.ReadModStateFile(modStateFile)
-		if err != nil {
-			return fmt.Errorf("read module state file: %w", err)
-		}
-	}
-	modState.SetReferences(append(modState.GetReferences(), statev1alpha1.ModuleReference_builder{Name: reference, Digest: digest}.Build()))
-	// As the state file read/write functions both close after their operations,
-	// we need to re-open another io.WriteCloser here, the easiest way is to
-	// truncate the file with Create if it exists.
-	modStateFile, err := os.Create(modFilePath)
-	if err != nil {
-		return fmt.Errorf("create file: %w", err)
-	}
-	if err := rw.WriteModStateFile(modStateFile, modState); err != nil {
-		return fmt.Errorf("write module state file: %w", err)
-	}
+	return rw.UpdateSyncDir(context.Background(), rootSyncDir, func(update *SyncDirUpdate) error {
+		return update.AppendModuleReference(ownerName, repoName, reference, digest, options...)
+	})
+}
 
-	globalFilePath := filepath.Join(rootSyncDir, GlobalStateFileName)
-	var globalState *statev1alpha1.GlobalState
-	if _, err := os.Stat(globalFilePath); err != nil {
-		if !os.IsNotExist(err) {
-			return fmt.Errorf("stat file: %w", err)
-		}
-		globalState = &statev1alpha1.GlobalState{}
-	} else {
-		globalStateFile, err := os.Open(globalFilePath)
-		if err != nil {
-			return fmt.Errorf("open file: %w", err)
-		}
-		globalState, err = rw.ReadGlobalState(globalStateFile)
-		if err != nil {
-			return fmt.Errorf("read module state file: %w", err)
-		}
-	}
-	moduleName := filepath.Join(ownerName, repoName)
-	var found bool
-	for i := range len(globalState.GetModules()) {
-		if globalState.GetModules()[i].GetModuleName() == moduleName {
-			found = true
-			globalState.GetModules()[i].SetLatestReference(reference)
-			break
-		}
-	}
-	if !found {
-		globalState.SetModules(append(
-			globalState.GetModules(),
-			statev1alpha1.GlobalStateReference_builder{
-				ModuleName:      moduleName,
-				LatestReference: reference,
-			}.Build(),
-		))
-	}
-	// As the state file read/write functions both close after their operations,
-	// we need to re-open another io.WriteCloser here, the easiest way is to
-	// truncate the file with Create if it exists.
-	globalStateFile, err := os.Create(globalFilePath)
-	if err != nil {
-		return fmt.Errorf("create file: %w", err)
-	}
-	if err := rw.WriteGlobalState(globalStateFile, globalState); err != nil {
-		return fmt.Errorf("write global state file: %w", err)
+// AppendModuleReferenceOption is an option for AppendModuleReference.
+type AppendModuleReferenceOption func(*appendModuleReferenceOptions)
+
+// AppendModuleReferenceWithSyncMetadata records how the reference was synced. Sync metadata is
+// only written to state.v1beta1 module state files.
+func AppendModuleReferenceWithSyncMetadata(syncMetadata *statev1beta1.SyncMetadata) AppendModuleReferenceOption {
+	return func(appendOptions *appendModuleReferenceOptions) {
+		appendOptions.syncMetadata = syncMetadata
 	}
-	return nil
+}
+
+type appendModuleReferenceOptions struct {
+	syncMetadata *statev1beta1.SyncMetadata
 }
@@ -0,0 +1,89 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufstate
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	statev1beta1 "github.com/bufbuild/modules/private/gen/modules/state/v1beta1"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// SupersedeModuleReference replaces the digest of an existing reference of a module, e.g. after its
+// upstream tag was recreated at another commit, in a single UpdateSyncDir. See
+// SyncDirUpdate.SupersedeModuleReference.
+func (rw *ReadWriter) SupersedeModuleReference(
+	rootSyncDir string,
+	ownerName string,
+	repoName string,
+	reference string,
+	digest string,
+	reason string,
+	supersedeTime time.Time,
+	options ...AppendModuleReferenceOption,
+) error {
+	return rw.UpdateSyncDir(context.Background(), rootSyncDir, func(update *SyncDirUpdate) error {
+		return update.SupersedeModuleReference(ownerName, repoName, reference, digest, reason, supersedeTime, options...)
+	})
+}
+
+// SupersedeModuleReference replaces the digest of an existing reference of a module in place, and
+// appends its previous digest and sync metadata to its supersessions, with the supersede time and
+// reason. The reference keeps its position, so the global state is unchanged. The sync metadata of
+// the reference is replaced by the one in the options, if any.
+//
+// The module state file must be in state.v1beta1, since state.v1alpha1 has no supersessions, and
+// the new digest must be different from the current one.
+func (u *SyncDirUpdate) SupersedeModuleReference(
+	ownerName string,
+	repoName string,
+	reference string,
+	digest string,
+	reason string,
+	supersedeTime time.Time,
+	options ...AppendModuleReferenceOption,
+) error {
+	appendOptions := &appendModuleReferenceOptions{}
+	for _, option := range options {
+		option(appendOptions)
+	}
+	moduleName := filepath.Join(ownerName, repoName)
+	modState, err := u.moduleStateUpdate(filepath.Join(u.rootSyncDir, ownerName, repoName, ModStateFileName))
+	if err != nil {
+		return err
+	}
+	if modState.version == VersionV1Alpha1 {
+		return fmt.Errorf("supersede reference %s of %s: state %s has no supersessions, migrate to %s first", reference, moduleName, VersionV1Alpha1, VersionV1Beta1)
+	}
+	moduleReference, ok := NewModuleHistory(modState.state).Reference(reference)
+	if !ok {
+		return fmt.Errorf("supersede reference %s of %s: reference not found", reference, moduleName)
+	}
+	if moduleReference.GetDigest() == digest {
+		return fmt.Errorf("supersede reference %s of %s: digest %s is unchanged", reference, moduleName, digest)
+	}
+	moduleReference.SetSupersessions(append(moduleReference.GetSupersessions(), statev1beta1.Supersession_builder{
+		PreviousDigest:       moduleReference.GetDigest(),
+		PreviousSyncMetadata: moduleReference.GetSyncMetadata(),
+		SupersedeTime:        timestamppb.New(supersedeTime.UTC()),
+		Reason:               reason,
+	}.Build()))
+	moduleReference.SetDigest(digest)
+	moduleReference.SetSyncMetadata(appendOptions.syncMetadata)
+	return nil
+}
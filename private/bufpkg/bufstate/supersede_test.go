@@ -0,0 +1,92 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufstate
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	statev1beta1 "github.com/bufbuild/modules/private/gen/modules/state/v1beta1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestSupersedeModuleReference(t *testing.T) {
+	t.Parallel()
+	readWriter, err := NewReadWriter()
+	require.NoError(t, err)
+	rootSyncDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(rootSyncDir, "foo", "bar"), 0755))
+	newSyncMetadata := func(commit string) *statev1beta1.SyncMetadata {
+		return statev1beta1.SyncMetadata_builder{
+			SyncTime: timestamppb.New(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)),
+			Commit:   commit,
+			Kind:     statev1beta1.ReferenceKind_REFERENCE_KIND_TAG,
+		}.Build()
+	}
+	firstSyncMetadata := newSyncMetadata("0123456789abcdef0123456789abcdef01234567")
+	require.NoError(t, readWriter.AppendModuleReference(
+		rootSyncDir, "foo", "bar", "v1.0.0", "a",
+		AppendModuleReferenceWithSyncMetadata(firstSyncMetadata),
+	))
+	require.NoError(t, readWriter.AppendModuleReference(rootSyncDir, "foo", "bar", "v1.1.0", "b"))
+
+	firstSupersedeTime := time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)
+	secondSyncMetadata := newSyncMetadata("89abcdef0123456789abcdef0123456789abcdef")
+	require.NoError(t, readWriter.SupersedeModuleReference(
+		rootSyncDir, "foo", "bar", "v1.0.0", "c", "tag recreated upstream", firstSupersedeTime,
+		AppendModuleReferenceWithSyncMetadata(secondSyncMetadata),
+	))
+	require.NoError(t, readWriter.SupersedeModuleReference(
+		rootSyncDir, "foo", "bar", "v1.0.0", "d", "tag recreated again", firstSupersedeTime.Add(time.Hour),
+	))
+	modFilePath := filepath.Join(rootSyncDir, "foo", "bar", ModStateFileName)
+	moduleState := readModStateFile(t, readWriter, modFilePath)
+	require.Len(t, moduleState.GetReferences(), 2)
+	reference := moduleState.GetReferences()[0]
+	assert.Equal(t, "v1.0.0", reference.GetName())
+	assert.Equal(t, "d", reference.GetDigest())
+	assert.False(t, reference.HasSyncMetadata())
+	supersessions := reference.GetSupersessions()
+	require.Len(t, supersessions, 2)
+	assert.Equal(t, "a", supersessions[0].GetPreviousDigest())
+	assert.True(t, proto.Equal(firstSyncMetadata, supersessions[0].GetPreviousSyncMetadata()))
+	assert.Equal(t, firstSupersedeTime, supersessions[0].GetSupersedeTime().AsTime())
+	assert.Equal(t, "tag recreated upstream", supersessions[0].GetReason())
+	assert.Equal(t, "c", supersessions[1].GetPreviousDigest())
+	assert.True(t, proto.Equal(secondSyncMetadata, supersessions[1].GetPreviousSyncMetadata()))
+	assert.Equal(t, "tag recreated again", supersessions[1].GetReason())
+	// the global state still points at the last reference
+	_, discrepancies, err := readWriter.RebuildGlobalState(rootSyncDir)
+	require.NoError(t, err)
+	assert.Empty(t, discrepancies)
+
+	err = readWriter.SupersedeModuleReference(rootSyncDir, "foo", "bar", "v1.0.0", "d", "reason", time.Now())
+	require.ErrorContains(t, err, "digest d is unchanged")
+	err = readWriter.SupersedeModuleReference(rootSyncDir, "foo", "bar", "v2.0.0", "e", "reason", time.Now())
+	require.ErrorContains(t, err, "reference not found")
+	err = readWriter.SupersedeModuleReference(rootSyncDir, "foo", "bar", "v1.1.0", "e", "", time.Now())
+	require.ErrorContains(t, err, "reason")
+	assert.Equal(t, "b", readModStateFile(t, readWriter, modFilePath).GetReferences()[1].GetDigest())
+
+	_, err = readWriter.MigrateSyncDir(rootSyncDir, VersionV1Alpha1)
+	require.NoError(t, err)
+	err = readWriter.SupersedeModuleReference(rootSyncDir, "foo", "bar", "v1.1.0", "e", "reason", time.Now())
+	require.ErrorContains(t, err, "state v1alpha1 has no supersessions")
+}
@@ -0,0 +1,120 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufstate
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	statev1beta1 "github.com/bufbuild/modules/private/gen/modules/state/v1beta1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestAppendModuleReferenceWithSyncMetadata(t *testing.T) {
+	t.Parallel()
+	readWriter, err := NewReadWriter()
+	require.NoError(t, err)
+	rootSyncDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(rootSyncDir, "foo", "bar"), 0755))
+	syncTime := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	require.NoError(t, readWriter.AppendModuleReference(
+		rootSyncDir, "foo", "bar", "v1.0.0", "foo",
+		AppendModuleReferenceWithSyncMetadata(statev1beta1.SyncMetadata_builder{
+			SyncTime:            timestamppb.New(syncTime),
+			Commit:              "0123456789abcdef0123456789abcdef01234567",
+			Kind:                statev1beta1.ReferenceKind_REFERENCE_KIND_TAG,
+			SourceConfigVersion: "v1",
+			ToolVersion:         "buf 1.50.0",
+		}.Build()),
+	))
+	require.NoError(t, readWriter.AppendModuleReference(rootSyncDir, "foo", "bar", "v1.1.0", "bar"))
+	modFilePath := filepath.Join(rootSyncDir, "foo", "bar", ModStateFileName)
+	moduleState := readModStateFile(t, readWriter, modFilePath)
+	require.Len(t, moduleState.GetReferences(), 2)
+	syncMetadata := moduleState.GetReferences()[0].GetSyncMetadata()
+	require.NotNil(t, syncMetadata)
+	assert.Equal(t, syncTime, syncMetadata.GetSyncTime().AsTime())
+	assert.Equal(t, "0123456789abcdef0123456789abcdef01234567", syncMetadata.GetCommit())
+	assert.Equal(t, statev1beta1.ReferenceKind_REFERENCE_KIND_TAG, syncMetadata.GetKind())
+	assert.Equal(t, "v1", syncMetadata.GetSourceConfigVersion())
+	assert.Equal(t, "buf 1.50.0", syncMetadata.GetToolVersion())
+	assert.False(t, moduleState.GetReferences()[1].HasSyncMetadata())
+
+	// invalid sync metadata leaves the state files untouched
+	err = readWriter.AppendModuleReference(
+		rootSyncDir, "foo", "bar", "v1.2.0", "baz",
+		AppendModuleReferenceWithSyncMetadata(statev1beta1.SyncMetadata_builder{
+			SyncTime: timestamppb.Now(),
+			Commit:   "main",
+		}.Build()),
+	)
+	require.ErrorContains(t, err, "commit")
+	require.Len(t, readModStateFile(t, readWriter, modFilePath).GetReferences(), 2)
+
+	// state.v1alpha1 has no sync metadata
+	_, err = readWriter.MigrateSyncDir(rootSyncDir, VersionV1Alpha1)
+	require.NoError(t, err)
+	moduleState = readModStateFile(t, readWriter, modFilePath)
+	require.Len(t, moduleState.GetReferences(), 2)
+	assert.False(t, moduleState.GetReferences()[0].HasSyncMetadata())
+}
+
+func TestInvalidSyncMetadata(t *testing.T) {
+	t.Parallel()
+	readWriter, err := NewReadWriter()
+	require.NoError(t, err)
+	newModuleState := func(syncMetadata *statev1beta1.SyncMetadata) *statev1beta1.ModuleState {
+		return statev1beta1.ModuleState_builder{
+			Version: VersionV1Beta1.String(),
+			References: []*statev1beta1.ModuleReference{
+				statev1beta1.ModuleReference_builder{Name: "v1.0.0", Digest: "foo", SyncMetadata: syncMetadata}.Build(),
+			},
+		}.Build()
+	}
+	t.Run("missingSyncTime", func(t *testing.T) {
+		t.Parallel()
+		err := readWriter.validator.Validate(newModuleState(statev1beta1.SyncMetadata_builder{}.Build()))
+		require.ErrorContains(t, err, "sync_time")
+	})
+	t.Run("invalidCommit", func(t *testing.T) {
+		t.Parallel()
+		err := readWriter.validator.Validate(newModuleState(statev1beta1.SyncMetadata_builder{
+			SyncTime: timestamppb.Now(),
+			Commit:   "main",
+		}.Build()))
+		require.ErrorContains(t, err, "commit")
+	})
+	t.Run("undefinedKind", func(t *testing.T) {
+		t.Parallel()
+		err := readWriter.validator.Validate(newModuleState(statev1beta1.SyncMetadata_builder{
+			SyncTime: timestamppb.Now(),
+			Kind:     statev1beta1.ReferenceKind(42),
+		}.Build()))
+		require.ErrorContains(t, err, "kind")
+	})
+}
+
+func readModStateFile(t *testing.T, readWriter *ReadWriter, filePath string) *statev1beta1.ModuleState {
+	t.Helper()
+	file, err := os.Open(filePath)
+	require.NoError(t, err)
+	moduleState, err := readWriter.ReadModStateFile(file)
+	require.NoError(t, err)
+	return moduleState
+}
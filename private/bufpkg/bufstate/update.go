@@ -0,0 +1,435 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufstate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/bufbuild/buf/private/pkg/filelock"
+	statev1beta1 "github.com/bufbuild/modules/private/gen/modules/state/v1beta1"
+	"go.uber.org/multierr"
+)
+
+const (
+	// LockFileName is the name of the advisory lock file in the root sync directory, held while its
+	// state files are updated.
+	LockFileName = ".state.lock"
+	// lockTimeout is how long to wait for other processes updating the same root sync directory.
+	lockTimeout = time.Minute
+	// lockRetryDelay is how often to retry the lock, updates only hold it for a few file writes.
+	lockRetryDelay = 20 * time.Millisecond
+	// stateFileMode is the mode of written state files.
+	stateFileMode = 0644
+)
+
+// UpdateSyncDir runs update on the state files of the root sync directory, and writes back all the
+// state files it changed, either all of them or none.
+//
+// The root sync directory is locked with an advisory file lock for the whole update, so concurrent
+// updates from other goroutines or processes wait for each other instead of overwriting each
+// other's changes. State files are read through the SyncDirUpdate after the lock is acquired, and
+// are only written if update returns no error. Changed files are validated and written to temporary
+// files first, and then renamed over the state files, restoring the previous ones if any rename
+// fails.
+func (rw *ReadWriter) UpdateSyncDir(
+	ctx context.Context,
+	rootSyncDir string,
+	update func(*SyncDirUpdate) error,
+) (retErr error) {
+	unlocker, err := filelock.Lock(
+		ctx,
+		filepath.Join(rootSyncDir, LockFileName),
+		filelock.LockWithTimeout(lockTimeout),
+		filelock.LockWithRetryDelay(lockRetryDelay),
+	)
+	if err != nil {
+		return fmt.Errorf("lock root sync dir: %w", err)
+	}
+	defer func() {
+		if err := unlocker.Unlock(); err != nil {
+			retErr = multierr.Append(retErr, fmt.Errorf("unlock root sync dir: %w", err))
+		}
+	}()
+	syncDirUpdate, err := rw.newSyncDirUpdate(rootSyncDir)
+	if err != nil {
+		return err
+	}
+	if err := update(syncDirUpdate); err != nil {
+		return err
+	}
+	return syncDirUpdate.commit()
+}
+
+// SyncDirUpdate is an update of the state files of a root sync directory, see UpdateSyncDir. State
+// files are read once, and the returned states are modified in place. It is not safe for concurrent
+// use.
+type SyncDirUpdate struct {
+	rw                 *ReadWriter
+	rootSyncDir        string
+	globalState        *stateFileUpdate[*statev1beta1.GlobalState]
+	modFilePathToState map[string]*stateFileUpdate[*statev1beta1.ModuleState]
+	// removedModuleDirPaths are the module directories to remove in the commit, see RemoveModule.
+	removedModuleDirPaths []string
+}
+
+// stateFileUpdate is a state file read in an update.
+type stateFileUpdate[S any] struct {
+	state   S
+	version Version
+	// data is the state file content as it was read, nil if the file does not exist.
+	data []byte
+}
+
+func (rw *ReadWriter) newSyncDirUpdate(rootSyncDir string) (*SyncDirUpdate, error) {
+	globalFilePath := filepath.Join(rootSyncDir, GlobalStateFileName)
+	globalState := &stateFileUpdate[*statev1beta1.GlobalState]{
+		state:   &statev1beta1.GlobalState{},
+		version: LatestVersion,
+	}
+	data, err := readFileIfExists(globalFilePath)
+	if err != nil {
+		return nil, err
+	}
+	if data != nil {
+		globalState.data = data
+		globalState.state, globalState.version, err = rw.ReadGlobalStateWithVersion(newReadCloser(data))
+		if err != nil {
+			return nil, fmt.Errorf("read global state file: %w", err)
+		}
+	}
+	return &SyncDirUpdate{
+		rw:                 rw,
+		rootSyncDir:        rootSyncDir,
+		globalState:        globalState,
+		modFilePathToState: make(map[string]*stateFileUpdate[*statev1beta1.ModuleState]),
+	}, nil
+}
+
+// GlobalState returns the global state. If there is no global state file, it is empty, and it is
+// written in the latest version.
+func (u *SyncDirUpdate) GlobalState() *statev1beta1.GlobalState {
+	return u.globalState.state
+}
+
+// ModuleState returns the module state of the module. If there is no module state file, it is empty,
+// and it is written in the version of the global state file.
+func (u *SyncDirUpdate) ModuleState(ownerName string, repoName string) (*statev1beta1.ModuleState, error) {
+	modState, err := u.moduleStateUpdate(filepath.Join(u.rootSyncDir, ownerName, repoName, ModStateFileName))
+	if err != nil {
+		return nil, err
+	}
+	return modState.state, nil
+}
+
+// ModuleStateVersion returns the version the module state file of the module is written in. If
+// there is no module state file, it is the version of the global state file.
+func (u *SyncDirUpdate) ModuleStateVersion(ownerName string, repoName string) (Version, error) {
+	modFilePath := filepath.Join(u.rootSyncDir, ownerName, repoName, ModStateFileName)
+	if modState, ok := u.modFilePathToState[modFilePath]; ok {
+		return modState.version, nil
+	}
+	// Read the file without adding it to the update, so that modules without a module state file are
+	// not created.
+	data, err := readFileIfExists(modFilePath)
+	if err != nil {
+		return 0, err
+	}
+	if data == nil {
+		return u.globalState.version, nil
+	}
+	version, err := DetectVersion(data)
+	if err != nil {
+		return 0, fmt.Errorf("detect module state file %s version: %w", modFilePath, err)
+	}
+	return version, nil
+}
+
+// AppendModuleReference appends a reference-digest pair at the end of the module state, and updates
+// the module's latest reference in the global state.
+func (u *SyncDirUpdate) AppendModuleReference(
+	ownerName string,
+	repoName string,
+	reference string,
+	digest string,
+	options ...AppendModuleReferenceOption,
+) error {
+	appendOptions := &appendModuleReferenceOptions{}
+	for _, option := range options {
+		option(appendOptions)
+	}
+	modState, err := u.ModuleState(ownerName, repoName)
+	if err != nil {
+		return err
+	}
+	modState.SetReferences(append(modState.GetReferences(), statev1beta1.ModuleReference_builder{
+		Name:         reference,
+		Digest:       digest,
+		SyncMetadata: appendOptions.syncMetadata,
+	}.Build()))
+
+	globalState := u.GlobalState()
+	moduleName := filepath.Join(ownerName, repoName)
+	for _, globalStateReference := range globalState.GetModules() {
+		if globalStateReference.GetModuleName() == moduleName {
+			globalStateReference.SetLatestReference(reference)
+			return nil
+		}
+	}
+	globalState.SetModules(append(
+		globalState.GetModules(),
+		statev1beta1.GlobalStateReference_builder{
+			ModuleName:      moduleName,
+			LatestReference: reference,
+			Deprecation:     modState.GetDeprecation(),
+		}.Build(),
+	))
+	return nil
+}
+
+func (u *SyncDirUpdate) moduleStateUpdate(modFilePath string) (*stateFileUpdate[*statev1beta1.ModuleState], error) {
+	if modState, ok := u.modFilePathToState[modFilePath]; ok {
+		return modState, nil
+	}
+	if slices.Contains(u.removedModuleDirPaths, filepath.Dir(modFilePath)) {
+		return nil, fmt.Errorf("module state file %s: module is removed", modFilePath)
+	}
+	modState := &stateFileUpdate[*statev1beta1.ModuleState]{
+		state:   &statev1beta1.ModuleState{},
+		version: u.globalState.version,
+	}
+	data, err := readFileIfExists(modFilePath)
+	if err != nil {
+		return nil, err
+	}
+	if data != nil {
+		modState.data = data
+		modState.state, modState.version, err = u.rw.ReadModStateFileWithVersion(newReadCloser(data))
+		if err != nil {
+			return nil, fmt.Errorf("read module state file %s: %w", modFilePath, err)
+		}
+	}
+	u.modFilePathToState[modFilePath] = modState
+	return modState, nil
+}
+
+// commit validates and marshals all the state files, and writes the ones that changed. Removed
+// module directories are moved out of the way before, and deleted after the state files are
+// written, so they are restored if writing them fails.
+func (u *SyncDirUpdate) commit() error {
+	var changedFiles []*changedFile
+	for modFilePath, modState := range u.modFilePathToState {
+		data, err := u.rw.marshalModState(modState.state, modState.version)
+		if err != nil {
+			return fmt.Errorf("module state file %s: %w", modFilePath, err)
+		}
+		if !bytes.Equal(data, modState.data) {
+			changedFiles = append(changedFiles, &changedFile{path: modFilePath, data: data, prevData: modState.data})
+		}
+	}
+	globalFilePath := filepath.Join(u.rootSyncDir, GlobalStateFileName)
+	data, err := u.rw.marshalGlobalState(u.globalState.state, u.globalState.version)
+	if err != nil {
+		return fmt.Errorf("global state file: %w", err)
+	}
+	if !bytes.Equal(data, u.globalState.data) {
+		changedFiles = append(changedFiles, &changedFile{path: globalFilePath, data: data, prevData: u.globalState.data})
+	}
+	removedDirs, err := moveRemovedDirs(u.removedModuleDirPaths)
+	if err != nil {
+		return err
+	}
+	if err := writeChangedFiles(changedFiles); err != nil {
+		for _, removedDir := range removedDirs {
+			err = multierr.Append(err, removedDir.restore())
+		}
+		return err
+	}
+	for _, removedDir := range removedDirs {
+		if err := removedDir.delete(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removedDir is a directory moved to a temporary directory next to it in a commit, until it is
+// deleted or restored.
+type removedDir struct {
+	path    string
+	tmpPath string
+}
+
+// moveRemovedDirs moves all the directories to temporary directories next to them. If any move
+// fails, the directories already moved are restored.
+func moveRemovedDirs(dirPaths []string) ([]*removedDir, error) {
+	removedDirs := make([]*removedDir, 0, len(dirPaths))
+	for _, dirPath := range dirPaths {
+		tmpDirPath, err := os.MkdirTemp(filepath.Dir(dirPath), "."+filepath.Base(dirPath)+".removed.*")
+		if err != nil {
+			err = fmt.Errorf("create temporary directory for %s: %w", dirPath, err)
+		} else if err = os.Rename(dirPath, filepath.Join(tmpDirPath, filepath.Base(dirPath))); err != nil {
+			err = multierr.Append(fmt.Errorf("move %s: %w", dirPath, err), os.Remove(tmpDirPath))
+		}
+		if err != nil {
+			for _, removedDir := range removedDirs {
+				err = multierr.Append(err, removedDir.restore())
+			}
+			return nil, err
+		}
+		removedDirs = append(removedDirs, &removedDir{path: dirPath, tmpPath: tmpDirPath})
+	}
+	return removedDirs, nil
+}
+
+// restore moves the directory back from its temporary directory.
+func (d *removedDir) restore() error {
+	if err := os.Rename(filepath.Join(d.tmpPath, filepath.Base(d.path)), d.path); err != nil {
+		return fmt.Errorf("restore %s: %w", d.path, err)
+	}
+	if err := os.Remove(d.tmpPath); err != nil {
+		return fmt.Errorf("remove temporary directory: %w", err)
+	}
+	return nil
+}
+
+// delete deletes the temporary directory of the directory, and the parent directory if it is left
+// empty.
+func (d *removedDir) delete() error {
+	if err := os.RemoveAll(d.tmpPath); err != nil {
+		return fmt.Errorf("delete %s: %w", d.path, err)
+	}
+	parentDirPath := filepath.Dir(d.path)
+	entries, err := os.ReadDir(parentDirPath)
+	if err != nil {
+		return fmt.Errorf("read directory: %w", err)
+	}
+	if len(entries) == 0 {
+		if err := os.Remove(parentDirPath); err != nil {
+			return fmt.Errorf("remove empty directory: %w", err)
+		}
+	}
+	return nil
+}
+
+// changedFile is a state file to write in a commit.
+type changedFile struct {
+	path     string
+	data     []byte
+	prevData []byte // Nil if the file did not exist.
+	tmpPath  string
+}
+
+// writeChangedFiles writes all the files to temporary files next to them, and renames them over the
+// files. If any write or rename fails, the files already renamed are restored to their previous
+// content, and the temporary files are removed.
+func writeChangedFiles(changedFiles []*changedFile) (retErr error) {
+	defer func() {
+		for _, changedFile := range changedFiles {
+			if changedFile.tmpPath != "" {
+				if err := os.Remove(changedFile.tmpPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+					retErr = multierr.Append(retErr, fmt.Errorf("remove temporary file: %w", err))
+				}
+			}
+		}
+	}()
+	for _, changedFile := range changedFiles {
+		tmpPath, err := writeTmpFile(changedFile.path, changedFile.data)
+		if err != nil {
+			return err
+		}
+		changedFile.tmpPath = tmpPath
+	}
+	for i, changedFile := range changedFiles {
+		if err := os.Rename(changedFile.tmpPath, changedFile.path); err != nil {
+			err = fmt.Errorf("rename temporary file to %s: %w", changedFile.path, err)
+			for _, renamedFile := range changedFiles[:i] {
+				err = multierr.Append(err, restoreFile(renamedFile))
+			}
+			return err
+		}
+		changedFile.tmpPath = ""
+	}
+	return nil
+}
+
+// writeTmpFile writes the data to a new temporary file in the directory of filePath, and returns
+// its path.
+func writeTmpFile(filePath string, data []byte) (_ string, retErr error) {
+	file, err := os.CreateTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+".*")
+	if err != nil {
+		return "", fmt.Errorf("create temporary file for %s: %w", filePath, err)
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			retErr = multierr.Append(retErr, fmt.Errorf("close temporary file: %w", err))
+		}
+		if retErr != nil {
+			_ = os.Remove(file.Name())
+		}
+	}()
+	if _, err := file.Write(data); err != nil {
+		return "", fmt.Errorf("write temporary file for %s: %w", filePath, err)
+	}
+	if err := file.Chmod(stateFileMode); err != nil {
+		return "", fmt.Errorf("chmod temporary file for %s: %w", filePath, err)
+	}
+	if err := file.Sync(); err != nil {
+		return "", fmt.Errorf("sync temporary file for %s: %w", filePath, err)
+	}
+	return file.Name(), nil
+}
+
+// restoreFile restores a renamed file to its previous content, or removes it if it did not exist.
+func restoreFile(changedFile *changedFile) error {
+	if changedFile.prevData == nil {
+		if err := os.Remove(changedFile.path); err != nil {
+			return fmt.Errorf("remove %s: %w", changedFile.path, err)
+		}
+		return nil
+	}
+	tmpPath, err := writeTmpFile(changedFile.path, changedFile.prevData)
+	if err != nil {
+		return fmt.Errorf("restore %s: %w", changedFile.path, err)
+	}
+	if err := os.Rename(tmpPath, changedFile.path); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("restore %s: %w", changedFile.path, err)
+	}
+	return nil
+}
+
+// readFileIfExists reads the file, and returns nil data if it does not exist.
+func readFileIfExists(filePath string) ([]byte, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read file: %w", err)
+	}
+	return data, nil
+}
+
+func newReadCloser(data []byte) io.ReadCloser {
+	return io.NopCloser(bytes.NewReader(data))
+}
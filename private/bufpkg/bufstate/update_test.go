@@ -0,0 +1,179 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufstate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/buf/private/pkg/filelock"
+	statev1beta1 "github.com/bufbuild/modules/private/gen/modules/state/v1beta1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConcurrentAppendModuleReference(t *testing.T) {
+	t.Parallel()
+	readWriter, err := NewReadWriter()
+	require.NoError(t, err)
+	rootSyncDir := t.TempDir()
+	repoNames := []string{"bar", "baz"}
+	for _, repoName := range repoNames {
+		require.NoError(t, os.MkdirAll(filepath.Join(rootSyncDir, "foo", repoName), 0755))
+	}
+	const refsPerRepo = 10
+	var wg sync.WaitGroup
+	for _, repoName := range repoNames {
+		for i := range refsPerRepo {
+			wg.Go(func() {
+				assert.NoError(t, readWriter.AppendModuleReference(rootSyncDir, "foo", repoName, fmt.Sprintf("ref%d", i), "digest"))
+			})
+		}
+	}
+	wg.Wait()
+
+	globalState, err := readWriter.ReadGlobalState(openFile(t, filepath.Join(rootSyncDir, GlobalStateFileName)))
+	require.NoError(t, err)
+	require.Len(t, globalState.GetModules(), len(repoNames))
+	for i, repoName := range repoNames {
+		moduleState := readModStateFile(t, readWriter, filepath.Join(rootSyncDir, "foo", repoName, ModStateFileName))
+		// no append was lost
+		require.Len(t, moduleState.GetReferences(), refsPerRepo)
+		assert.Equal(t, filepath.Join("foo", repoName), globalState.GetModules()[i].GetModuleName())
+		assert.Equal(t, moduleState.GetReferences()[refsPerRepo-1].GetName(), globalState.GetModules()[i].GetLatestReference())
+	}
+}
+
+func TestUpdateSyncDirAllOrNothing(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	readWriter, err := NewReadWriter()
+	require.NoError(t, err)
+	rootSyncDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(rootSyncDir, "foo", "bar"), 0755))
+	require.NoError(t, readWriter.AppendModuleReference(rootSyncDir, "foo", "bar", "v1.0.0", "foo"))
+	globalFilePath := filepath.Join(rootSyncDir, GlobalStateFileName)
+	modFilePath := filepath.Join(rootSyncDir, "foo", "bar", ModStateFileName)
+	globalData, err := os.ReadFile(globalFilePath)
+	require.NoError(t, err)
+	modData, err := os.ReadFile(modFilePath)
+	require.NoError(t, err)
+	assertUnchanged := func(t *testing.T) {
+		t.Helper()
+		data, err := os.ReadFile(globalFilePath)
+		require.NoError(t, err)
+		assert.Equal(t, string(globalData), string(data))
+		data, err = os.ReadFile(modFilePath)
+		require.NoError(t, err)
+		assert.Equal(t, string(modData), string(data))
+		entries, err := os.ReadDir(filepath.Join(rootSyncDir, "foo", "bar"))
+		require.NoError(t, err)
+		assert.Len(t, entries, 1, "temporary files left behind")
+	}
+
+	updateErr := errors.New("update failed")
+	err = readWriter.UpdateSyncDir(ctx, rootSyncDir, func(update *SyncDirUpdate) error {
+		require.NoError(t, update.AppendModuleReference("foo", "bar", "v1.1.0", "bar"))
+		return updateErr
+	})
+	require.ErrorIs(t, err, updateErr)
+	assertUnchanged(t)
+
+	// the module state is valid, but the global state is not
+	err = readWriter.UpdateSyncDir(ctx, rootSyncDir, func(update *SyncDirUpdate) error {
+		require.NoError(t, update.AppendModuleReference("foo", "bar", "v1.1.0", "bar"))
+		update.GlobalState().GetModules()[0].SetLatestReference("")
+		return nil
+	})
+	require.ErrorContains(t, err, "global state file")
+	assertUnchanged(t)
+
+	// states are read once per update
+	require.NoError(t, readWriter.UpdateSyncDir(ctx, rootSyncDir, func(update *SyncDirUpdate) error {
+		require.NoError(t, update.AppendModuleReference("foo", "bar", "v1.1.0", "bar"))
+		require.NoError(t, update.AppendModuleReference("foo", "bar", "v1.2.0", "baz"))
+		moduleState, err := update.ModuleState("foo", "bar")
+		require.NoError(t, err)
+		assert.Len(t, moduleState.GetReferences(), 3)
+		return nil
+	}))
+	moduleState := readModStateFile(t, readWriter, modFilePath)
+	require.Len(t, moduleState.GetReferences(), 3)
+	assert.Equal(t, "v1.2.0", moduleState.GetReferences()[2].GetName())
+}
+
+func TestUpdateSyncDirLocked(t *testing.T) {
+	t.Parallel()
+	readWriter, err := NewReadWriter()
+	require.NoError(t, err)
+	rootSyncDir := t.TempDir()
+	unlocker, err := filelock.Lock(t.Context(), filepath.Join(rootSyncDir, LockFileName))
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(t.Context(), 100*time.Millisecond)
+	defer cancel()
+	err = readWriter.UpdateSyncDir(ctx, rootSyncDir, func(*SyncDirUpdate) error {
+		return errors.New("should not run while locked")
+	})
+	require.ErrorContains(t, err, "lock root sync dir")
+	require.NoError(t, unlocker.Unlock())
+	require.NoError(t, readWriter.UpdateSyncDir(t.Context(), rootSyncDir, func(update *SyncDirUpdate) error {
+		update.GlobalState().SetModules([]*statev1beta1.GlobalStateReference{})
+		return nil
+	}))
+	assertFileVersion(t, filepath.Join(rootSyncDir, GlobalStateFileName), LatestVersion)
+}
+
+func openFile(t *testing.T, filePath string) *os.File {
+	t.Helper()
+	file, err := os.Open(filePath)
+	require.NoError(t, err)
+	return file
+}
+
+func TestModuleStateVersion(t *testing.T) {
+	t.Parallel()
+	readWriter, err := NewReadWriter()
+	require.NoError(t, err)
+	rootSyncDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(rootSyncDir, "foo", "bar"), 0755))
+	require.NoError(t, readWriter.AppendModuleReference(rootSyncDir, "foo", "bar", "v1.0.0", "a"))
+	moduleStateVersions := func() (Version, Version) {
+		var barVersion, bazVersion Version
+		require.NoError(t, readWriter.UpdateSyncDir(t.Context(), rootSyncDir, func(update *SyncDirUpdate) error {
+			var err error
+			if barVersion, err = update.ModuleStateVersion("foo", "bar"); err != nil {
+				return err
+			}
+			bazVersion, err = update.ModuleStateVersion("foo", "baz")
+			return err
+		}))
+		return barVersion, bazVersion
+	}
+	barVersion, bazVersion := moduleStateVersions()
+	assert.Equal(t, LatestVersion, barVersion)
+	assert.Equal(t, LatestVersion, bazVersion)
+	_, err = readWriter.MigrateSyncDir(rootSyncDir, VersionV1Alpha1)
+	require.NoError(t, err)
+	// new modules follow the version of the global state file
+	barVersion, bazVersion = moduleStateVersions()
+	assert.Equal(t, VersionV1Alpha1, barVersion)
+	assert.Equal(t, VersionV1Alpha1, bazVersion)
+}
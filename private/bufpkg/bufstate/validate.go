@@ -0,0 +1,182 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufstate
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+
+	"github.com/bufbuild/buf/private/pkg/storage"
+	"go.uber.org/multierr"
+	"golang.org/x/mod/semver"
+)
+
+// shake256DigestLength is the length in bytes of the shake256 digests of manifests.
+const shake256DigestLength = 64
+
+// ValidateSyncDirOption is an option for ValidateSyncDir.
+type ValidateSyncDirOption func(*validateSyncDirOptions)
+
+// ValidateSyncDirWithCASBuckets checks that the manifest blob of every reference exists in the CAS
+// directory of its module, read from the bucket returned by newCASBucket for the module directory.
+func ValidateSyncDirWithCASBuckets(newCASBucket func(moduleDirPath string) (storage.ReadBucket, error)) ValidateSyncDirOption {
+	return func(options *validateSyncDirOptions) {
+		options.newCASBucket = newCASBucket
+	}
+}
+
+// ValidateSyncDirWithReleaseModules checks that the modules, in the shape of <owner>/<repo>, are
+// synced with the releases strategy: all their references are semver tags, and every reference is
+// after the previous stable tag.
+func ValidateSyncDirWithReleaseModules(moduleNames ...string) ValidateSyncDirOption {
+	return func(options *validateSyncDirOptions) {
+		options.releaseModuleNames = append(options.releaseModuleNames, moduleNames...)
+	}
+}
+
+type validateSyncDirOptions struct {
+	newCASBucket       func(moduleDirPath string) (storage.ReadBucket, error)
+	releaseModuleNames []string
+}
+
+// ValidateSyncDir validates the state files of the root sync directory beyond the rules of the state
+// schema, and returns all the violations found, combined with multierr. It checks that:
+//
+//   - every digest, including the previous digests of superseded references, is a lowercase hex
+//     encoded shake256 digest.
+//   - the latest reference of every module in the global state file is the last reference in its
+//     module state file, see RebuildGlobalState.
+//   - the replacement of every deprecated module is another managed module.
+//   - with ValidateSyncDirWithCASBuckets, the manifest blob of every digest exists, so superseded
+//     references can still be diffed.
+//   - with ValidateSyncDirWithReleaseModules, the references of release modules are semver tags in
+//     increasing order.
+//
+// State files that cannot be read fail the validation right away.
+func (rw *ReadWriter) ValidateSyncDir(ctx context.Context, rootSyncDir string, options ...ValidateSyncDirOption) error {
+	validateOptions := &validateSyncDirOptions{}
+	for _, option := range options {
+		option(validateOptions)
+	}
+	// a read only update, that is never committed
+	syncDirUpdate, err := rw.newSyncDirUpdate(rootSyncDir)
+	if err != nil {
+		return err
+	}
+	if syncDirUpdate.globalState.data == nil {
+		return errors.New("global state file not found")
+	}
+	discrepancies, err := syncDirUpdate.RebuildGlobalState()
+	if err != nil {
+		return err
+	}
+	var validateErr error
+	for _, discrepancy := range discrepancies {
+		validateErr = multierr.Append(validateErr, errors.New(discrepancy.String()))
+	}
+	modFilePaths := make([]string, 0, len(syncDirUpdate.modFilePathToState))
+	for modFilePath := range syncDirUpdate.modFilePathToState {
+		modFilePaths = append(modFilePaths, modFilePath)
+	}
+	slices.Sort(modFilePaths)
+	for _, modFilePath := range modFilePaths {
+		moduleDirPath := filepath.Dir(modFilePath)
+		moduleName := filepath.Join(filepath.Base(filepath.Dir(moduleDirPath)), filepath.Base(moduleDirPath))
+		modState := syncDirUpdate.modFilePathToState[modFilePath].state
+		if replacement := modState.GetDeprecation().GetReplacement(); replacement != "" {
+			if replacement == moduleName || syncDirUpdate.globalStateReference(replacement) == nil {
+				validateErr = multierr.Append(validateErr, fmt.Errorf("%s: replacement %s is not another managed module", moduleName, replacement))
+			}
+		}
+		references := modState.GetReferences()
+		var casBucket storage.ReadBucket
+		if validateOptions.newCASBucket != nil {
+			if casBucket, err = validateOptions.newCASBucket(moduleDirPath); err != nil {
+				return fmt.Errorf("%s: new cas bucket: %w", moduleName, err)
+			}
+		}
+		isReleaseModule := slices.Contains(validateOptions.releaseModuleNames, moduleName)
+		var prevStableTag string
+		for _, reference := range references {
+			digests := []string{reference.GetDigest()}
+			for _, supersession := range reference.GetSupersessions() {
+				digests = append(digests, supersession.GetPreviousDigest())
+			}
+			for _, digest := range digests {
+				if err := validateDigest(digest); err != nil {
+					validateErr = multierr.Append(validateErr, fmt.Errorf("%s: reference %s: %w", moduleName, reference.GetName(), err))
+				} else if casBucket != nil {
+					if err := validateManifestExists(ctx, casBucket, digest); err != nil {
+						validateErr = multierr.Append(validateErr, fmt.Errorf("%s: reference %s: %w", moduleName, reference.GetName(), err))
+					}
+				}
+			}
+			if !isReleaseModule {
+				continue
+			}
+			if err := validateReleaseTag(reference.GetName(), prevStableTag); err != nil {
+				validateErr = multierr.Append(validateErr, fmt.Errorf("%s: %w", moduleName, err))
+			}
+			if semver.IsValid(reference.GetName()) && semver.Prerelease(reference.GetName()) == "" {
+				prevStableTag = reference.GetName()
+			}
+		}
+	}
+	return validateErr
+}
+
+// validateDigest validates that the digest is a lowercase hex encoded shake256 digest.
+func validateDigest(digest string) error {
+	value, err := hex.DecodeString(digest)
+	if err != nil {
+		return fmt.Errorf("digest %q is not hex encoded: %w", digest, err)
+	}
+	if len(value) != shake256DigestLength {
+		return fmt.Errorf("digest %q is %d bytes long, expected %d bytes for shake256", digest, len(value), shake256DigestLength)
+	}
+	if hex.EncodeToString(value) != digest {
+		return fmt.Errorf("digest %q is not lowercase hex encoded", digest)
+	}
+	return nil
+}
+
+// validateReleaseTag validates that the reference of a release module is a semver tag after the
+// previous stable tag of the module. Release candidates can be synced ahead of the stable tags, so
+// stable tags are only checked against the previous stable tags.
+func validateReleaseTag(reference string, prevStableTag string) error {
+	if !semver.IsValid(reference) {
+		return fmt.Errorf("reference %s is not a semver tag", reference)
+	}
+	if prevStableTag != "" && semver.Compare(prevStableTag, reference) >= 0 {
+		return fmt.Errorf("reference %s is not after the previous stable reference %s", reference, prevStableTag)
+	}
+	return nil
+}
+
+// validateManifestExists validates that the manifest blob of the digest exists in the CAS bucket.
+func validateManifestExists(ctx context.Context, casBucket storage.ReadBucket, digest string) error {
+	if _, err := casBucket.Stat(ctx, digest); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("manifest blob %s not found in the cas directory", digest)
+		}
+		return fmt.Errorf("stat manifest blob %s: %w", digest, err)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufstate
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bufbuild/buf/private/pkg/storage"
+	"github.com/bufbuild/buf/private/pkg/storage/storageos"
+	statev1beta1 "github.com/bufbuild/modules/private/gen/modules/state/v1beta1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/multierr"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestValidateSyncDir(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	readWriter, err := NewReadWriter()
+	require.NoError(t, err)
+	rootSyncDir := t.TempDir()
+	digest := func(c string) string {
+		return strings.Repeat(c, 2*shake256DigestLength)
+	}
+	for _, repoName := range []string{"commits", "releases"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(rootSyncDir, "foo", repoName, "cas"), 0755))
+	}
+	// only the manifests are checked, so any content will do
+	for _, manifestPath := range []string{
+		filepath.Join("foo", "commits", "cas", digest("a")),
+		filepath.Join("foo", "releases", "cas", digest("b")),
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(rootSyncDir, manifestPath), nil, 0600))
+	}
+	appendRefs := func(repoName string, nameDigests ...string) {
+		for i := 0; i < len(nameDigests); i += 2 {
+			require.NoError(t, readWriter.AppendModuleReference(rootSyncDir, "foo", repoName, nameDigests[i], nameDigests[i+1]))
+		}
+	}
+	appendRefs("commits", "main", digest("a"))
+	appendRefs("releases", "v1.0.0", digest("b"), "v1.1.0-rc.1", digest("b"), "v1.1.0", digest("b"))
+	options := []ValidateSyncDirOption{
+		ValidateSyncDirWithCASBuckets(func(moduleDirPath string) (storage.ReadBucket, error) {
+			return storageos.NewProvider().NewReadWriteBucket(filepath.Join(moduleDirPath, "cas"))
+		}),
+		ValidateSyncDirWithReleaseModules("foo/releases"),
+	}
+	require.NoError(t, readWriter.ValidateSyncDir(ctx, rootSyncDir, options...))
+
+	appendRefs(
+		"commits",
+		"uppercase", strings.ToUpper(digest("a")),
+		"short", digest("a")[:64],
+		"missing", digest("c"),
+	)
+	appendRefs("releases", "v1.0.1", digest("b"), "latest", digest("b"))
+	// drift the global state, deprecate a module for an unmanaged replacement, and supersede a
+	// reference without its previous manifest by hand
+	require.NoError(t, readWriter.UpdateSyncDir(ctx, rootSyncDir, func(update *SyncDirUpdate) error {
+		update.GlobalState().GetModules()[0].SetLatestReference("main")
+		deprecation := statev1beta1.Deprecation_builder{
+			Reason:        "reason",
+			Replacement:   "foo/unknown",
+			DeprecateTime: timestamppb.Now(),
+		}.Build()
+		modState, err := update.ModuleState("foo", "releases")
+		if err != nil {
+			return err
+		}
+		modState.SetDeprecation(deprecation)
+		update.GlobalState().GetModules()[1].SetDeprecation(deprecation)
+		// superseded references keep their previous manifests
+		if modState, err = update.ModuleState("foo", "commits"); err != nil {
+			return err
+		}
+		modState.GetReferences()[0].SetSupersessions([]*statev1beta1.Supersession{
+			statev1beta1.Supersession_builder{
+				PreviousDigest: digest("c"),
+				SupersedeTime:  timestamppb.Now(),
+				Reason:         "reason",
+			}.Build(),
+		})
+		return nil
+	}))
+	err = readWriter.ValidateSyncDir(ctx, rootSyncDir, options...)
+	var errStrings []string
+	for _, err := range multierr.Errors(err) {
+		errStrings = append(errStrings, err.Error())
+	}
+	assert.Equal(
+		t,
+		[]string{
+			"foo/commits: global state latest reference is main, but module state latest reference is missing",
+			"foo/commits: reference main: manifest blob " + digest("c") + " not found in the cas directory",
+			`foo/commits: reference uppercase: digest "` + strings.ToUpper(digest("a")) + `" is not lowercase hex encoded`,
+			`foo/commits: reference short: digest "` + digest("a")[:64] + `" is 32 bytes long, expected 64 bytes for shake256`,
+			"foo/commits: reference missing: manifest blob " + digest("c") + " not found in the cas directory",
+			"foo/releases: replacement foo/unknown is not another managed module",
+			"foo/releases: reference v1.0.1 is not after the previous stable reference v1.1.0",
+			"foo/releases: reference latest is not a semver tag",
+		},
+		errStrings,
+	)
+	// without options, only the state files are checked
+	require.Len(t, multierr.Errors(readWriter.ValidateSyncDir(ctx, rootSyncDir)), 4)
+}
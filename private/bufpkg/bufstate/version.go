@@ -0,0 +1,146 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufstate
+
+import (
+	"encoding/json"
+	"fmt"
+
+	statev1alpha1 "github.com/bufbuild/modules/private/gen/modules/state/v1alpha1"
+	statev1beta1 "github.com/bufbuild/modules/private/gen/modules/state/v1beta1"
+)
+
+// Version is a version of the state files schema.
+//
+// State files of all versions are read as the latest version, and written in the version they
+// were read in, so synced modules keep their version until they are explicitly migrated.
+type Version int
+
+const (
+	// VersionV1Alpha1 is the state.v1alpha1 schema. Its state files have no version field.
+	VersionV1Alpha1 Version = iota + 1
+	// VersionV1Beta1 is the state.v1beta1 schema.
+	VersionV1Beta1
+)
+
+// LatestVersion is the latest version of the state files schema, which new sync directories are
+// written in.
+const LatestVersion = VersionV1Beta1
+
+// versionField is the name of the version field of the state files, since state.v1beta1.
+const versionField = "version"
+
+//nolint:gochecknoglobals // treated as consts
+var versionToString = map[Version]string{
+	VersionV1Alpha1: "v1alpha1",
+	VersionV1Beta1:  "v1beta1",
+}
+
+// String implements fmt.Stringer.
+func (v Version) String() string {
+	if s, ok := versionToString[v]; ok {
+		return s
+	}
+	return fmt.Sprintf("unknown(%d)", int(v))
+}
+
+// ParseVersion parses a state version from its string representation.
+func ParseVersion(s string) (Version, error) {
+	for version, versionString := range versionToString {
+		if versionString == s {
+			return version, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown state version %q, expected %s or %s", s, VersionV1Alpha1, VersionV1Beta1)
+}
+
+// DetectVersion detects the version of JSON encoded state file data. State files without a version
+// field are state.v1alpha1 files.
+func DetectVersion(data []byte) (Version, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return 0, fmt.Errorf("unmarshal state fields: %w", err)
+	}
+	rawVersion, ok := fields[versionField]
+	if !ok {
+		return VersionV1Alpha1, nil
+	}
+	var versionString string
+	if err := json.Unmarshal(rawVersion, &versionString); err != nil {
+		return 0, fmt.Errorf("unmarshal state version: %w", err)
+	}
+	version, err := ParseVersion(versionString)
+	if err != nil {
+		return 0, err
+	}
+	if version == VersionV1Alpha1 {
+		return 0, fmt.Errorf("state version %s has no version field", VersionV1Alpha1)
+	}
+	return version, nil
+}
+
+func moduleStateV1Alpha1ToV1Beta1(moduleState *statev1alpha1.ModuleState) *statev1beta1.ModuleState {
+	references := make([]*statev1beta1.ModuleReference, len(moduleState.GetReferences()))
+	for i, reference := range moduleState.GetReferences() {
+		references[i] = statev1beta1.ModuleReference_builder{
+			Name:   reference.GetName(),
+			Digest: reference.GetDigest(),
+		}.Build()
+	}
+	return statev1beta1.ModuleState_builder{
+		Version:    VersionV1Beta1.String(),
+		References: references,
+	}.Build()
+}
+
+func moduleStateV1Beta1ToV1Alpha1(moduleState *statev1beta1.ModuleState) *statev1alpha1.ModuleState {
+	references := make([]*statev1alpha1.ModuleReference, len(moduleState.GetReferences()))
+	for i, reference := range moduleState.GetReferences() {
+		references[i] = statev1alpha1.ModuleReference_builder{
+			Name:   reference.GetName(),
+			Digest: reference.GetDigest(),
+		}.Build()
+	}
+	return statev1alpha1.ModuleState_builder{
+		References: references,
+	}.Build()
+}
+
+func globalStateV1Alpha1ToV1Beta1(globalState *statev1alpha1.GlobalState) *statev1beta1.GlobalState {
+	modules := make([]*statev1beta1.GlobalStateReference, len(globalState.GetModules()))
+	for i, module := range globalState.GetModules() {
+		modules[i] = statev1beta1.GlobalStateReference_builder{
+			ModuleName:      module.GetModuleName(),
+			LatestReference: module.GetLatestReference(),
+		}.Build()
+	}
+	return statev1beta1.GlobalState_builder{
+		Version: VersionV1Beta1.String(),
+		Modules: modules,
+	}.Build()
+}
+
+func globalStateV1Beta1ToV1Alpha1(globalState *statev1beta1.GlobalState) *statev1alpha1.GlobalState {
+	modules := make([]*statev1alpha1.GlobalStateReference, len(globalState.GetModules()))
+	for i, module := range globalState.GetModules() {
+		modules[i] = statev1alpha1.GlobalStateReference_builder{
+			ModuleName:      module.GetModuleName(),
+			LatestReference: module.GetLatestReference(),
+		}.Build()
+	}
+	return statev1alpha1.GlobalState_builder{
+		Modules: modules,
+	}.Build()
+}
@@ -0,0 +1,132 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bufstate
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectVersion(t *testing.T) {
+	t.Parallel()
+	version, err := DetectVersion([]byte(`{"references": []}`))
+	require.NoError(t, err)
+	assert.Equal(t, VersionV1Alpha1, version)
+	version, err = DetectVersion([]byte(`{"version": "v1beta1", "references": []}`))
+	require.NoError(t, err)
+	assert.Equal(t, VersionV1Beta1, version)
+	_, err = DetectVersion([]byte(`{"version": "v1alpha1"}`))
+	require.Error(t, err)
+	_, err = DetectVersion([]byte(`{"version": "v2"}`))
+	require.Error(t, err)
+	_, err = DetectVersion([]byte(`[]`))
+	require.Error(t, err)
+}
+
+func TestReadWriteVersions(t *testing.T) {
+	t.Parallel()
+	readWriter, err := NewReadWriter()
+	require.NoError(t, err)
+	v1alpha1Data := `{
+  "references": [
+    {
+      "name": "v1.0.0",
+      "digest": "foo"
+    }
+  ]
+}`
+	moduleState, version, err := readWriter.ReadModStateFileWithVersion(io.NopCloser(bytes.NewReader([]byte(v1alpha1Data))))
+	require.NoError(t, err)
+	assert.Equal(t, VersionV1Alpha1, version)
+	assert.Equal(t, VersionV1Beta1.String(), moduleState.GetVersion())
+	require.Len(t, moduleState.GetReferences(), 1)
+	assert.Equal(t, "foo", moduleState.GetReferences()[0].GetDigest())
+
+	var buffer closingBuffer
+	require.NoError(t, readWriter.WriteModStateFileWithVersion(&buffer, moduleState, VersionV1Alpha1))
+	assert.Equal(t, v1alpha1Data, buffer.String())
+	buffer.Reset()
+	require.NoError(t, readWriter.WriteModStateFile(&buffer, moduleState))
+	v1beta1Data := buffer.String()
+	assert.Contains(t, v1beta1Data, `"version": "v1beta1"`)
+	moduleState, version, err = readWriter.ReadModStateFileWithVersion(io.NopCloser(bytes.NewReader([]byte(v1beta1Data))))
+	require.NoError(t, err)
+	assert.Equal(t, VersionV1Beta1, version)
+	require.Len(t, moduleState.GetReferences(), 1)
+
+	_, err = readWriter.ReadModStateFile(io.NopCloser(bytes.NewReader([]byte(`{"version": "v1beta1", "references": [{"name": "v1.0.0"}]}`))))
+	require.ErrorContains(t, err, "references[0].digest: value is required")
+	_, err = readWriter.ReadGlobalState(io.NopCloser(bytes.NewReader([]byte(`{"version": "v1beta1", "modules": [{"module_name": "foo/bar", "latest_reference": "v1.0.0"}]}`))))
+	require.NoError(t, err)
+}
+
+func TestAppendModuleReferenceKeepsVersions(t *testing.T) {
+	t.Parallel()
+	readWriter, err := NewReadWriter()
+	require.NoError(t, err)
+	rootSyncDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(rootSyncDir, "foo", "bar"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(rootSyncDir, "foo", "baz"), 0755))
+	// a new sync dir is written in the latest version
+	require.NoError(t, readWriter.AppendModuleReference(rootSyncDir, "foo", "bar", "v1.0.0", "foo"))
+	assertFileVersion(t, filepath.Join(rootSyncDir, GlobalStateFileName), LatestVersion)
+	assertFileVersion(t, filepath.Join(rootSyncDir, "foo", "bar", ModStateFileName), LatestVersion)
+
+	migratedFilePaths, err := readWriter.MigrateSyncDir(rootSyncDir, VersionV1Alpha1)
+	require.NoError(t, err)
+	assert.Len(t, migratedFilePaths, 2)
+	// existing and new files keep the version of the sync dir
+	require.NoError(t, readWriter.AppendModuleReference(rootSyncDir, "foo", "bar", "v1.1.0", "bar"))
+	require.NoError(t, readWriter.AppendModuleReference(rootSyncDir, "foo", "baz", "v1.0.0", "baz"))
+	assertFileVersion(t, filepath.Join(rootSyncDir, GlobalStateFileName), VersionV1Alpha1)
+	assertFileVersion(t, filepath.Join(rootSyncDir, "foo", "bar", ModStateFileName), VersionV1Alpha1)
+	assertFileVersion(t, filepath.Join(rootSyncDir, "foo", "baz", ModStateFileName), VersionV1Alpha1)
+
+	migratedFilePaths, err = readWriter.MigrateSyncDir(rootSyncDir, VersionV1Beta1)
+	require.NoError(t, err)
+	assert.Len(t, migratedFilePaths, 3)
+	migratedFilePaths, err = readWriter.MigrateSyncDir(rootSyncDir, VersionV1Beta1)
+	require.NoError(t, err)
+	assert.Empty(t, migratedFilePaths)
+	moduleStateFile, err := os.Open(filepath.Join(rootSyncDir, "foo", "bar", ModStateFileName))
+	require.NoError(t, err)
+	moduleState, err := readWriter.ReadModStateFile(moduleStateFile)
+	require.NoError(t, err)
+	require.Len(t, moduleState.GetReferences(), 2)
+	assert.Equal(t, "v1.1.0", moduleState.GetReferences()[1].GetName())
+}
+
+func assertFileVersion(t *testing.T, filePath string, expectedVersion Version) {
+	t.Helper()
+	data, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+	version, err := DetectVersion(data)
+	require.NoError(t, err)
+	assert.Equal(t, expectedVersion, version)
+}
+
+type closingBuffer struct {
+	bytes.Buffer
+}
+
+func (*closingBuffer) Close() error {
+	return nil
+}
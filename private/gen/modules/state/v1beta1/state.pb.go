@@ -0,0 +1,947 @@
+// Copyright 2021-2025 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: state/v1beta1/state.proto
+
+package v1beta1
+
+import (
+	_ "buf.build/gen/go/bufbuild/protovalidate/protocolbuffers/go/buf/validate"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// ReferenceKind is the kind of git reference a managed module reference is.
+type ReferenceKind int32
+
+const (
+	ReferenceKind_REFERENCE_KIND_UNSPECIFIED ReferenceKind = 0
+	ReferenceKind_REFERENCE_KIND_TAG         ReferenceKind = 1
+	ReferenceKind_REFERENCE_KIND_COMMIT      ReferenceKind = 2
+)
+
+// Enum value maps for ReferenceKind.
+var (
+	ReferenceKind_name = map[int32]string{
+		0: "REFERENCE_KIND_UNSPECIFIED",
+		1: "REFERENCE_KIND_TAG",
+		2: "REFERENCE_KIND_COMMIT",
+	}
+	ReferenceKind_value = map[string]int32{
+		"REFERENCE_KIND_UNSPECIFIED": 0,
+		"REFERENCE_KIND_TAG":         1,
+		"REFERENCE_KIND_COMMIT":      2,
+	}
+)
+
+func (x ReferenceKind) Enum() *ReferenceKind {
+	p := new(ReferenceKind)
+	*p = x
+	return p
+}
+
+func (x ReferenceKind) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (ReferenceKind) Descriptor() protoreflect.EnumDescriptor {
+	return file_state_v1beta1_state_proto_enumTypes[0].Descriptor()
+}
+
+func (ReferenceKind) Type() protoreflect.EnumType {
+	return &file_state_v1beta1_state_proto_enumTypes[0]
+}
+
+func (x ReferenceKind) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// GlobalState is a sorted array managed modules, each one with the latest
+// reference from its local array of references. This is kept updated in a
+// global state file at the root sync directory.
+type GlobalState struct {
+	state              protoimpl.MessageState   `protogen:"opaque.v1"`
+	xxx_hidden_Version string                   `protobuf:"bytes,1,opt,name=version,proto3"`
+	xxx_hidden_Modules *[]*GlobalStateReference `protobuf:"bytes,2,rep,name=modules,proto3"`
+	unknownFields      protoimpl.UnknownFields
+	sizeCache          protoimpl.SizeCache
+}
+
+func (x *GlobalState) Reset() {
+	*x = GlobalState{}
+	mi := &file_state_v1beta1_state_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GlobalState) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GlobalState) ProtoMessage() {}
+
+func (x *GlobalState) ProtoReflect() protoreflect.Message {
+	mi := &file_state_v1beta1_state_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+func (x *GlobalState) GetVersion() string {
+	if x != nil {
+		return x.xxx_hidden_Version
+	}
+	return ""
+}
+
+func (x *GlobalState) GetModules() []*GlobalStateReference {
+	if x != nil {
+		if x.xxx_hidden_Modules != nil {
+			return *x.xxx_hidden_Modules
+		}
+	}
+	return nil
+}
+
+func (x *GlobalState) SetVersion(v string) {
+	x.xxx_hidden_Version = v
+}
+
+func (x *GlobalState) SetModules(v []*GlobalStateReference) {
+	x.xxx_hidden_Modules = &v
+}
+
+type GlobalState_builder struct {
+	_ [0]func() // Prevents comparability and use of unkeyed literals for the builder.
+
+	// The version of the state schema, which is always "v1beta1". State files
+	// without a version are state.v1alpha1 files.
+	Version string
+	Modules []*GlobalStateReference
+}
+
+func (b0 GlobalState_builder) Build() *GlobalState {
+	m0 := &GlobalState{}
+	b, x := &b0, m0
+	_, _ = b, x
+	x.xxx_hidden_Version = b.Version
+	x.xxx_hidden_Modules = &b.Modules
+	return m0
+}
+
+// GlobalReference is a single managed module reference with the latest
+// reference from its local array of references.
+type GlobalStateReference struct {
+	state                      protoimpl.MessageState `protogen:"opaque.v1"`
+	xxx_hidden_ModuleName      string                 `protobuf:"bytes,1,opt,name=module_name,json=moduleName,proto3"`
+	xxx_hidden_LatestReference string                 `protobuf:"bytes,2,opt,name=latest_reference,json=latestReference,proto3"`
+	xxx_hidden_Deprecation     *Deprecation           `protobuf:"bytes,3,opt,name=deprecation,proto3"`
+	unknownFields              protoimpl.UnknownFields
+	sizeCache                  protoimpl.SizeCache
+}
+
+func (x *GlobalStateReference) Reset() {
+	*x = GlobalStateReference{}
+	mi := &file_state_v1beta1_state_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GlobalStateReference) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GlobalStateReference) ProtoMessage() {}
+
+func (x *GlobalStateReference) ProtoReflect() protoreflect.Message {
+	mi := &file_state_v1beta1_state_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+func (x *GlobalStateReference) GetModuleName() string {
+	if x != nil {
+		return x.xxx_hidden_ModuleName
+	}
+	return ""
+}
+
+func (x *GlobalStateReference) GetLatestReference() string {
+	if x != nil {
+		return x.xxx_hidden_LatestReference
+	}
+	return ""
+}
+
+func (x *GlobalStateReference) GetDeprecation() *Deprecation {
+	if x != nil {
+		return x.xxx_hidden_Deprecation
+	}
+	return nil
+}
+
+func (x *GlobalStateReference) SetModuleName(v string) {
+	x.xxx_hidden_ModuleName = v
+}
+
+func (x *GlobalStateReference) SetLatestReference(v string) {
+	x.xxx_hidden_LatestReference = v
+}
+
+func (x *GlobalStateReference) SetDeprecation(v *Deprecation) {
+	x.xxx_hidden_Deprecation = v
+}
+
+func (x *GlobalStateReference) HasDeprecation() bool {
+	if x == nil {
+		return false
+	}
+	return x.xxx_hidden_Deprecation != nil
+}
+
+func (x *GlobalStateReference) ClearDeprecation() {
+	x.xxx_hidden_Deprecation = nil
+}
+
+type GlobalStateReference_builder struct {
+	_ [0]func() // Prevents comparability and use of unkeyed literals for the builder.
+
+	ModuleName      string
+	LatestReference string
+	// The deprecation of the module, mirrored from its module state.
+	Deprecation *Deprecation
+}
+
+func (b0 GlobalStateReference_builder) Build() *GlobalStateReference {
+	m0 := &GlobalStateReference{}
+	b, x := &b0, m0
+	_, _ = b, x
+	x.xxx_hidden_ModuleName = b.ModuleName
+	x.xxx_hidden_LatestReference = b.LatestReference
+	x.xxx_hidden_Deprecation = b.Deprecation
+	return m0
+}
+
+// ModuleState is an array of references that will be synced to a BSR cluster for a
+// managed module. This is kept updated in a state file at the managed module
+// directory.
+type ModuleState struct {
+	state                  protoimpl.MessageState `protogen:"opaque.v1"`
+	xxx_hidden_Version     string                 `protobuf:"bytes,1,opt,name=version,proto3"`
+	xxx_hidden_References  *[]*ModuleReference    `protobuf:"bytes,2,rep,name=references,proto3"`
+	xxx_hidden_Deprecation *Deprecation           `protobuf:"bytes,3,opt,name=deprecation,proto3"`
+	unknownFields          protoimpl.UnknownFields
+	sizeCache              protoimpl.SizeCache
+}
+
+func (x *ModuleState) Reset() {
+	*x = ModuleState{}
+	mi := &file_state_v1beta1_state_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ModuleState) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ModuleState) ProtoMessage() {}
+
+func (x *ModuleState) ProtoReflect() protoreflect.Message {
+	mi := &file_state_v1beta1_state_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+func (x *ModuleState) GetVersion() string {
+	if x != nil {
+		return x.xxx_hidden_Version
+	}
+	return ""
+}
+
+func (x *ModuleState) GetReferences() []*ModuleReference {
+	if x != nil {
+		if x.xxx_hidden_References != nil {
+			return *x.xxx_hidden_References
+		}
+	}
+	return nil
+}
+
+func (x *ModuleState) GetDeprecation() *Deprecation {
+	if x != nil {
+		return x.xxx_hidden_Deprecation
+	}
+	return nil
+}
+
+func (x *ModuleState) SetVersion(v string) {
+	x.xxx_hidden_Version = v
+}
+
+func (x *ModuleState) SetReferences(v []*ModuleReference) {
+	x.xxx_hidden_References = &v
+}
+
+func (x *ModuleState) SetDeprecation(v *Deprecation) {
+	x.xxx_hidden_Deprecation = v
+}
+
+func (x *ModuleState) HasDeprecation() bool {
+	if x == nil {
+		return false
+	}
+	return x.xxx_hidden_Deprecation != nil
+}
+
+func (x *ModuleState) ClearDeprecation() {
+	x.xxx_hidden_Deprecation = nil
+}
+
+type ModuleState_builder struct {
+	_ [0]func() // Prevents comparability and use of unkeyed literals for the builder.
+
+	// The version of the state schema, which is always "v1beta1". State files
+	// without a version are state.v1alpha1 files.
+	Version    string
+	References []*ModuleReference
+	// Set if the module is deprecated. Deprecated modules keep their synced
+	// references, but are no longer recommended.
+	Deprecation *Deprecation
+}
+
+func (b0 ModuleState_builder) Build() *ModuleState {
+	m0 := &ModuleState{}
+	b, x := &b0, m0
+	_, _ = b, x
+	x.xxx_hidden_Version = b.Version
+	x.xxx_hidden_References = &b.References
+	x.xxx_hidden_Deprecation = b.Deprecation
+	return m0
+}
+
+// ModuleReference is a single git reference of a managed module that will be
+// synced to a BSR cluster.
+type ModuleReference struct {
+	state                    protoimpl.MessageState `protogen:"opaque.v1"`
+	xxx_hidden_Name          string                 `protobuf:"bytes,1,opt,name=name,proto3"`
+	xxx_hidden_Digest        string                 `protobuf:"bytes,2,opt,name=digest,proto3"`
+	xxx_hidden_SyncMetadata  *SyncMetadata          `protobuf:"bytes,3,opt,name=sync_metadata,json=syncMetadata,proto3"`
+	xxx_hidden_Supersessions *[]*Supersession       `protobuf:"bytes,4,rep,name=supersessions,proto3"`
+	unknownFields            protoimpl.UnknownFields
+	sizeCache                protoimpl.SizeCache
+}
+
+func (x *ModuleReference) Reset() {
+	*x = ModuleReference{}
+	mi := &file_state_v1beta1_state_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ModuleReference) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ModuleReference) ProtoMessage() {}
+
+func (x *ModuleReference) ProtoReflect() protoreflect.Message {
+	mi := &file_state_v1beta1_state_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+func (x *ModuleReference) GetName() string {
+	if x != nil {
+		return x.xxx_hidden_Name
+	}
+	return ""
+}
+
+func (x *ModuleReference) GetDigest() string {
+	if x != nil {
+		return x.xxx_hidden_Digest
+	}
+	return ""
+}
+
+func (x *ModuleReference) GetSyncMetadata() *SyncMetadata {
+	if x != nil {
+		return x.xxx_hidden_SyncMetadata
+	}
+	return nil
+}
+
+func (x *ModuleReference) GetSupersessions() []*Supersession {
+	if x != nil {
+		if x.xxx_hidden_Supersessions != nil {
+			return *x.xxx_hidden_Supersessions
+		}
+	}
+	return nil
+}
+
+func (x *ModuleReference) SetName(v string) {
+	x.xxx_hidden_Name = v
+}
+
+func (x *ModuleReference) SetDigest(v string) {
+	x.xxx_hidden_Digest = v
+}
+
+func (x *ModuleReference) SetSyncMetadata(v *SyncMetadata) {
+	x.xxx_hidden_SyncMetadata = v
+}
+
+func (x *ModuleReference) SetSupersessions(v []*Supersession) {
+	x.xxx_hidden_Supersessions = &v
+}
+
+func (x *ModuleReference) HasSyncMetadata() bool {
+	if x == nil {
+		return false
+	}
+	return x.xxx_hidden_SyncMetadata != nil
+}
+
+func (x *ModuleReference) ClearSyncMetadata() {
+	x.xxx_hidden_SyncMetadata = nil
+}
+
+type ModuleReference_builder struct {
+	_ [0]func() // Prevents comparability and use of unkeyed literals for the builder.
+
+	Name   string
+	Digest string
+	// How the reference was synced. References synced before state.v1beta1 have
+	// no sync metadata.
+	SyncMetadata *SyncMetadata
+	// The previous digests of the reference, oldest first, if it was superseded,
+	// e.g. because its upstream tag was recreated at another commit.
+	Supersessions []*Supersession
+}
+
+func (b0 ModuleReference_builder) Build() *ModuleReference {
+	m0 := &ModuleReference{}
+	b, x := &b0, m0
+	_, _ = b, x
+	x.xxx_hidden_Name = b.Name
+	x.xxx_hidden_Digest = b.Digest
+	x.xxx_hidden_SyncMetadata = b.SyncMetadata
+	x.xxx_hidden_Supersessions = &b.Supersessions
+	return m0
+}
+
+// Supersession is a previous digest of a managed module reference, that was
+// superseded by a new digest.
+type Supersession struct {
+	state                           protoimpl.MessageState `protogen:"opaque.v1"`
+	xxx_hidden_PreviousDigest       string                 `protobuf:"bytes,1,opt,name=previous_digest,json=previousDigest,proto3"`
+	xxx_hidden_PreviousSyncMetadata *SyncMetadata          `protobuf:"bytes,2,opt,name=previous_sync_metadata,json=previousSyncMetadata,proto3"`
+	xxx_hidden_SupersedeTime        *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=supersede_time,json=supersedeTime,proto3"`
+	xxx_hidden_Reason               string                 `protobuf:"bytes,4,opt,name=reason,proto3"`
+	unknownFields                   protoimpl.UnknownFields
+	sizeCache                       protoimpl.SizeCache
+}
+
+func (x *Supersession) Reset() {
+	*x = Supersession{}
+	mi := &file_state_v1beta1_state_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Supersession) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Supersession) ProtoMessage() {}
+
+func (x *Supersession) ProtoReflect() protoreflect.Message {
+	mi := &file_state_v1beta1_state_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+func (x *Supersession) GetPreviousDigest() string {
+	if x != nil {
+		return x.xxx_hidden_PreviousDigest
+	}
+	return ""
+}
+
+func (x *Supersession) GetPreviousSyncMetadata() *SyncMetadata {
+	if x != nil {
+		return x.xxx_hidden_PreviousSyncMetadata
+	}
+	return nil
+}
+
+func (x *Supersession) GetSupersedeTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.xxx_hidden_SupersedeTime
+	}
+	return nil
+}
+
+func (x *Supersession) GetReason() string {
+	if x != nil {
+		return x.xxx_hidden_Reason
+	}
+	return ""
+}
+
+func (x *Supersession) SetPreviousDigest(v string) {
+	x.xxx_hidden_PreviousDigest = v
+}
+
+func (x *Supersession) SetPreviousSyncMetadata(v *SyncMetadata) {
+	x.xxx_hidden_PreviousSyncMetadata = v
+}
+
+func (x *Supersession) SetSupersedeTime(v *timestamppb.Timestamp) {
+	x.xxx_hidden_SupersedeTime = v
+}
+
+func (x *Supersession) SetReason(v string) {
+	x.xxx_hidden_Reason = v
+}
+
+func (x *Supersession) HasPreviousSyncMetadata() bool {
+	if x == nil {
+		return false
+	}
+	return x.xxx_hidden_PreviousSyncMetadata != nil
+}
+
+func (x *Supersession) HasSupersedeTime() bool {
+	if x == nil {
+		return false
+	}
+	return x.xxx_hidden_SupersedeTime != nil
+}
+
+func (x *Supersession) ClearPreviousSyncMetadata() {
+	x.xxx_hidden_PreviousSyncMetadata = nil
+}
+
+func (x *Supersession) ClearSupersedeTime() {
+	x.xxx_hidden_SupersedeTime = nil
+}
+
+type Supersession_builder struct {
+	_ [0]func() // Prevents comparability and use of unkeyed literals for the builder.
+
+	// The digest of the reference before it was superseded.
+	PreviousDigest string
+	// The sync metadata of the reference before it was superseded, if any.
+	PreviousSyncMetadata *SyncMetadata
+	// When the reference was superseded.
+	SupersedeTime *timestamppb.Timestamp
+	// Why the reference was superseded, e.g. "tag recreated upstream".
+	Reason string
+}
+
+func (b0 Supersession_builder) Build() *Supersession {
+	m0 := &Supersession{}
+	b, x := &b0, m0
+	_, _ = b, x
+	x.xxx_hidden_PreviousDigest = b.PreviousDigest
+	x.xxx_hidden_PreviousSyncMetadata = b.PreviousSyncMetadata
+	x.xxx_hidden_SupersedeTime = b.SupersedeTime
+	x.xxx_hidden_Reason = b.Reason
+	return m0
+}
+
+// SyncMetadata is how a reference of a managed module was synced from its
+// upstream repository.
+type SyncMetadata struct {
+	state                          protoimpl.MessageState `protogen:"opaque.v1"`
+	xxx_hidden_SyncTime            *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=sync_time,json=syncTime,proto3"`
+	xxx_hidden_Commit              string                 `protobuf:"bytes,2,opt,name=commit,proto3"`
+	xxx_hidden_Kind                ReferenceKind          `protobuf:"varint,3,opt,name=kind,proto3,enum=state.v1beta1.ReferenceKind"`
+	xxx_hidden_SourceConfigVersion string                 `protobuf:"bytes,4,opt,name=source_config_version,json=sourceConfigVersion,proto3"`
+	xxx_hidden_ToolVersion         string                 `protobuf:"bytes,5,opt,name=tool_version,json=toolVersion,proto3"`
+	unknownFields                  protoimpl.UnknownFields
+	sizeCache                      protoimpl.SizeCache
+}
+
+func (x *SyncMetadata) Reset() {
+	*x = SyncMetadata{}
+	mi := &file_state_v1beta1_state_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SyncMetadata) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SyncMetadata) ProtoMessage() {}
+
+func (x *SyncMetadata) ProtoReflect() protoreflect.Message {
+	mi := &file_state_v1beta1_state_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+func (x *SyncMetadata) GetSyncTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.xxx_hidden_SyncTime
+	}
+	return nil
+}
+
+func (x *SyncMetadata) GetCommit() string {
+	if x != nil {
+		return x.xxx_hidden_Commit
+	}
+	return ""
+}
+
+func (x *SyncMetadata) GetKind() ReferenceKind {
+	if x != nil {
+		return x.xxx_hidden_Kind
+	}
+	return ReferenceKind_REFERENCE_KIND_UNSPECIFIED
+}
+
+func (x *SyncMetadata) GetSourceConfigVersion() string {
+	if x != nil {
+		return x.xxx_hidden_SourceConfigVersion
+	}
+	return ""
+}
+
+func (x *SyncMetadata) GetToolVersion() string {
+	if x != nil {
+		return x.xxx_hidden_ToolVersion
+	}
+	return ""
+}
+
+func (x *SyncMetadata) SetSyncTime(v *timestamppb.Timestamp) {
+	x.xxx_hidden_SyncTime = v
+}
+
+func (x *SyncMetadata) SetCommit(v string) {
+	x.xxx_hidden_Commit = v
+}
+
+func (x *SyncMetadata) SetKind(v ReferenceKind) {
+	x.xxx_hidden_Kind = v
+}
+
+func (x *SyncMetadata) SetSourceConfigVersion(v string) {
+	x.xxx_hidden_SourceConfigVersion = v
+}
+
+func (x *SyncMetadata) SetToolVersion(v string) {
+	x.xxx_hidden_ToolVersion = v
+}
+
+func (x *SyncMetadata) HasSyncTime() bool {
+	if x == nil {
+		return false
+	}
+	return x.xxx_hidden_SyncTime != nil
+}
+
+func (x *SyncMetadata) ClearSyncTime() {
+	x.xxx_hidden_SyncTime = nil
+}
+
+type SyncMetadata_builder struct {
+	_ [0]func() // Prevents comparability and use of unkeyed literals for the builder.
+
+	// When the reference was synced.
+	SyncTime *timestamppb.Timestamp
+	// The upstream git commit SHA that the reference resolved to.
+	Commit string
+	// Whether the reference is a git tag or a git commit.
+	Kind ReferenceKind
+	// The version of the upstream buf configuration the reference was synced
+	// from, e.g. "v1" or "v2".
+	SourceConfigVersion string
+	// The version of the tool that validated the reference, e.g. "buf 1.50.0".
+	ToolVersion string
+}
+
+func (b0 SyncMetadata_builder) Build() *SyncMetadata {
+	m0 := &SyncMetadata{}
+	b, x := &b0, m0
+	_, _ = b, x
+	x.xxx_hidden_SyncTime = b.SyncTime
+	x.xxx_hidden_Commit = b.Commit
+	x.xxx_hidden_Kind = b.Kind
+	x.xxx_hidden_SourceConfigVersion = b.SourceConfigVersion
+	x.xxx_hidden_ToolVersion = b.ToolVersion
+	return m0
+}
+
+// Deprecation is why a managed module is deprecated, and what to use instead.
+type Deprecation struct {
+	state                    protoimpl.MessageState `protogen:"opaque.v1"`
+	xxx_hidden_Reason        string                 `protobuf:"bytes,1,opt,name=reason,proto3"`
+	xxx_hidden_Replacement   string                 `protobuf:"bytes,2,opt,name=replacement,proto3"`
+	xxx_hidden_DeprecateTime *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=deprecate_time,json=deprecateTime,proto3"`
+	unknownFields            protoimpl.UnknownFields
+	sizeCache                protoimpl.SizeCache
+}
+
+func (x *Deprecation) Reset() {
+	*x = Deprecation{}
+	mi := &file_state_v1beta1_state_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Deprecation) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Deprecation) ProtoMessage() {}
+
+func (x *Deprecation) ProtoReflect() protoreflect.Message {
+	mi := &file_state_v1beta1_state_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+func (x *Deprecation) GetReason() string {
+	if x != nil {
+		return x.xxx_hidden_Reason
+	}
+	return ""
+}
+
+func (x *Deprecation) GetReplacement() string {
+	if x != nil {
+		return x.xxx_hidden_Replacement
+	}
+	return ""
+}
+
+func (x *Deprecation) GetDeprecateTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.xxx_hidden_DeprecateTime
+	}
+	return nil
+}
+
+func (x *Deprecation) SetReason(v string) {
+	x.xxx_hidden_Reason = v
+}
+
+func (x *Deprecation) SetReplacement(v string) {
+	x.xxx_hidden_Replacement = v
+}
+
+func (x *Deprecation) SetDeprecateTime(v *timestamppb.Timestamp) {
+	x.xxx_hidden_DeprecateTime = v
+}
+
+func (x *Deprecation) HasDeprecateTime() bool {
+	if x == nil {
+		return false
+	}
+	return x.xxx_hidden_DeprecateTime != nil
+}
+
+func (x *Deprecation) ClearDeprecateTime() {
+	x.xxx_hidden_DeprecateTime = nil
+}
+
+type Deprecation_builder struct {
+	_ [0]func() // Prevents comparability and use of unkeyed literals for the builder.
+
+	// Why the module is deprecated, e.g. "the upstream repository is archived".
+	Reason string
+	// The managed module that replaces the deprecated module, in the shape of
+	// <owner>/<repo>, if any.
+	Replacement string
+	// When the module was deprecated.
+	DeprecateTime *timestamppb.Timestamp
+}
+
+func (b0 Deprecation_builder) Build() *Deprecation {
+	m0 := &Deprecation{}
+	b, x := &b0, m0
+	_, _ = b, x
+	x.xxx_hidden_Reason = b.Reason
+	x.xxx_hidden_Replacement = b.Replacement
+	x.xxx_hidden_DeprecateTime = b.DeprecateTime
+	return m0
+}
+
+var File_state_v1beta1_state_proto protoreflect.FileDescriptor
+
+const file_state_v1beta1_state_proto_rawDesc = "" +
+	"\n" +
+	"\x19state/v1beta1/state.proto\x12\rstate.v1beta1\x1a\x1bbuf/validate/validate.proto\x1a\x1fgoogle/protobuf/timestamp.proto\"\xaa\x03\n" +
+	"\vGlobalState\x12(\n" +
+	"\aversion\x18\x01 \x01(\tB\x0e\xbaH\vr\t\n" +
+	"\av1beta1R\aversion\x12=\n" +
+	"\amodules\x18\x02 \x03(\v2#.state.v1beta1.GlobalStateReferenceR\amodules:\xb1\x02\xbaH\xad\x02\x1a\xaa\x02\n" +
+	" module_state.unique_module_names\x1a\x85\x02this.modules.map(i, i.module_name).unique() ? '' : 'module name ' + (this.modules.map(reference, reference.module_name).filter(module_name, !this.modules.map(reference, reference.module_name).exists_one(x, x == module_name)))[0] + ' has appeared multiple times'\"\xb0\x01\n" +
+	"\x14GlobalStateReference\x12'\n" +
+	"\vmodule_name\x18\x01 \x01(\tB\x06\xbaH\x03\xc8\x01\x01R\n" +
+	"moduleName\x121\n" +
+	"\x10latest_reference\x18\x02 \x01(\tB\x06\xbaH\x03\xc8\x01\x01R\x0flatestReference\x12<\n" +
+	"\vdeprecation\x18\x03 \x01(\v2\x1a.state.v1beta1.DeprecationR\vdeprecation\"\xcb\x03\n" +
+	"\vModuleState\x12(\n" +
+	"\aversion\x18\x01 \x01(\tB\x0e\xbaH\vr\t\n" +
+	"\av1beta1R\aversion\x12>\n" +
+	"\n" +
+	"references\x18\x02 \x03(\v2\x1e.state.v1beta1.ModuleReferenceR\n" +
+	"references\x12<\n" +
+	"\vdeprecation\x18\x03 \x01(\v2\x1a.state.v1beta1.DeprecationR\vdeprecation:\x93\x02\xbaH\x8f\x02\x1a\x8c\x02\n" +
+	"\x1emodule_state.unique_references\x1a\xe9\x01this.references.map(i, i.name).unique() ? '' : 'reference ' + (this.references.map(reference, reference.name).filter(name, !this.references.map(reference, reference.name).exists_one(x, x == name)))[0] + ' has appeared multiple times'\"\xd2\x01\n" +
+	"\x0fModuleReference\x12\x1a\n" +
+	"\x04name\x18\x01 \x01(\tB\x06\xbaH\x03\xc8\x01\x01R\x04name\x12\x1e\n" +
+	"\x06digest\x18\x02 \x01(\tB\x06\xbaH\x03\xc8\x01\x01R\x06digest\x12@\n" +
+	"\rsync_metadata\x18\x03 \x01(\v2\x1b.state.v1beta1.SyncMetadataR\fsyncMetadata\x12A\n" +
+	"\rsupersessions\x18\x04 \x03(\v2\x1b.state.v1beta1.SupersessionR\rsupersessions\"\xfd\x01\n" +
+	"\fSupersession\x12/\n" +
+	"\x0fprevious_digest\x18\x01 \x01(\tB\x06\xbaH\x03\xc8\x01\x01R\x0epreviousDigest\x12Q\n" +
+	"\x16previous_sync_metadata\x18\x02 \x01(\v2\x1b.state.v1beta1.SyncMetadataR\x14previousSyncMetadata\x12I\n" +
+	"\x0esupersede_time\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampB\x06\xbaH\x03\xc8\x01\x01R\rsupersedeTime\x12\x1e\n" +
+	"\x06reason\x18\x04 \x01(\tB\x06\xbaH\x03\xc8\x01\x01R\x06reason\"\xa3\x02\n" +
+	"\fSyncMetadata\x12?\n" +
+	"\tsync_time\x18\x01 \x01(\v2\x1a.google.protobuf.TimestampB\x06\xbaH\x03\xc8\x01\x01R\bsyncTime\x12?\n" +
+	"\x06commit\x18\x02 \x01(\tB'\xbaH$\xd8\x01\x01r\x1f2\x1d^([0-9a-f]{40}|[0-9a-f]{64})$R\x06commit\x12:\n" +
+	"\x04kind\x18\x03 \x01(\x0e2\x1c.state.v1beta1.ReferenceKindB\b\xbaH\x05\x82\x01\x02\x10\x01R\x04kind\x122\n" +
+	"\x15source_config_version\x18\x04 \x01(\tR\x13sourceConfigVersion\x12!\n" +
+	"\ftool_version\x18\x05 \x01(\tR\vtoolVersion\"\xb3\x01\n" +
+	"\vDeprecation\x12\x1e\n" +
+	"\x06reason\x18\x01 \x01(\tB\x06\xbaH\x03\xc8\x01\x01R\x06reason\x129\n" +
+	"\vreplacement\x18\x02 \x01(\tB\x17\xbaH\x14\xd8\x01\x01r\x0f2\r^[^/]+/[^/]+$R\vreplacement\x12I\n" +
+	"\x0edeprecate_time\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampB\x06\xbaH\x03\xc8\x01\x01R\rdeprecateTime*b\n" +
+	"\rReferenceKind\x12\x1e\n" +
+	"\x1aREFERENCE_KIND_UNSPECIFIED\x10\x00\x12\x16\n" +
+	"\x12REFERENCE_KIND_TAG\x10\x01\x12\x19\n" +
+	"\x15REFERENCE_KIND_COMMIT\x10\x02BLZJbuf.build/gen/go/bufbuild/managed-modules/protocolbuffers/go/state/v1beta1b\x06proto3"
+
+var file_state_v1beta1_state_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_state_v1beta1_state_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
+var file_state_v1beta1_state_proto_goTypes = []any{
+	(ReferenceKind)(0),            // 0: state.v1beta1.ReferenceKind
+	(*GlobalState)(nil),           // 1: state.v1beta1.GlobalState
+	(*GlobalStateReference)(nil),  // 2: state.v1beta1.GlobalStateReference
+	(*ModuleState)(nil),           // 3: state.v1beta1.ModuleState
+	(*ModuleReference)(nil),       // 4: state.v1beta1.ModuleReference
+	(*Supersession)(nil),          // 5: state.v1beta1.Supersession
+	(*SyncMetadata)(nil),          // 6: state.v1beta1.SyncMetadata
+	(*Deprecation)(nil),           // 7: state.v1beta1.Deprecation
+	(*timestamppb.Timestamp)(nil), // 8: google.protobuf.Timestamp
+}
+var file_state_v1beta1_state_proto_depIdxs = []int32{
+	2,  // 0: state.v1beta1.GlobalState.modules:type_name -> state.v1beta1.GlobalStateReference
+	7,  // 1: state.v1beta1.GlobalStateReference.deprecation:type_name -> state.v1beta1.Deprecation
+	4,  // 2: state.v1beta1.ModuleState.references:type_name -> state.v1beta1.ModuleReference
+	7,  // 3: state.v1beta1.ModuleState.deprecation:type_name -> state.v1beta1.Deprecation
+	6,  // 4: state.v1beta1.ModuleReference.sync_metadata:type_name -> state.v1beta1.SyncMetadata
+	5,  // 5: state.v1beta1.ModuleReference.supersessions:type_name -> state.v1beta1.Supersession
+	6,  // 6: state.v1beta1.Supersession.previous_sync_metadata:type_name -> state.v1beta1.SyncMetadata
+	8,  // 7: state.v1beta1.Supersession.supersede_time:type_name -> google.protobuf.Timestamp
+	8,  // 8: state.v1beta1.SyncMetadata.sync_time:type_name -> google.protobuf.Timestamp
+	0,  // 9: state.v1beta1.SyncMetadata.kind:type_name -> state.v1beta1.ReferenceKind
+	8,  // 10: state.v1beta1.Deprecation.deprecate_time:type_name -> google.protobuf.Timestamp
+	11, // [11:11] is the sub-list for method output_type
+	11, // [11:11] is the sub-list for method input_type
+	11, // [11:11] is the sub-list for extension type_name
+	11, // [11:11] is the sub-list for extension extendee
+	0,  // [0:11] is the sub-list for field type_name
+}
+
+func init() { file_state_v1beta1_state_proto_init() }
+func file_state_v1beta1_state_proto_init() {
+	if File_state_v1beta1_state_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_state_v1beta1_state_proto_rawDesc), len(file_state_v1beta1_state_proto_rawDesc)),
+			NumEnums:      1,
+			NumMessages:   7,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_state_v1beta1_state_proto_goTypes,
+		DependencyIndexes: file_state_v1beta1_state_proto_depIdxs,
+		EnumInfos:         file_state_v1beta1_state_proto_enumTypes,
+		MessageInfos:      file_state_v1beta1_state_proto_msgTypes,
+	}.Build()
+	File_state_v1beta1_state_proto = out.File
+	file_state_v1beta1_state_proto_goTypes = nil
+	file_state_v1beta1_state_proto_depIdxs = nil
+}
@@ -88,13 +88,22 @@ process_ref() {
 
   # process the prepared module: convert it to CAS from the tmp mod directory and put blob files in
   # the cas path in the repo, and update the state file.
+  local -r mod_commit="$(git rev-parse HEAD)"
+  local mod_ref_kind="commit"
+  if [ "${sync_strategy}" == "releases" ]; then
+    mod_ref_kind="tag"
+  fi
   pushd "${repo_root}" > /dev/null
   go run "${repo_root}/cmd/modprocessor" \
     --root-sync-dir="${all_mods_sync_path}" \
     --src-dir="${mod_tmp_path}" \
     --owner="${owner}" \
     --repo="${repo}" \
-    --ref="${mod_ref}"
+    --ref="${mod_ref}" \
+    --commit="${mod_commit}" \
+    --ref-kind="${mod_ref_kind}" \
+    --source-config-version="${source_config_version}" \
+    --tool-version="buf $(buf --version)"
   popd > /dev/null
 }
 